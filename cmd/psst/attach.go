@@ -0,0 +1,188 @@
+package psst
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// AttachCmd manages the files attached to the entries.
+func AttachCmd() *cobra.Command {
+	attachCmd := &cobra.Command{
+		Use:   "attach",
+		Short: "Manage the files attached to an entry",
+		Long: fmt.Sprintf(`Attach small files, such as recovery codes, license keys or certificates, to an
+entry. Attachments are encrypted in chunks, up to %d MiB each, and decrypted one
+chunk at a time. They are deleted with their entry.`, vault.MaxAttachmentSize>>20),
+	}
+	attachCmd.AddCommand(attachAddCmd(), attachGetCmd(), attachRemoveCmd(), attachListCmd())
+	return attachCmd
+}
+
+// attachAddCmd attaches a file to an entry.
+func attachAddCmd() *cobra.Command {
+	addCmd := &cobra.Command{
+		Use:   "add <service> <file>",
+		Short: "Attach a file to an entry",
+		Long: `Attach a file to the entry of the service, or the standard input if the file is
+"-". The attachment is named after the file, unless set with --name.`,
+		Example: `  psst attach add github ~/Downloads/github-recovery-codes.txt
+  gpg --export-secret-keys alice | psst attach add gpg - --name secret-keys.gpg`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			service, path := args[0], args[1]
+			name, _ := cmd.Flags().GetString("name")
+			if name == "" {
+				if path == "-" {
+					return errors.New("--name is required to attach the standard input")
+				}
+				name = filepath.Base(path)
+			}
+
+			in := cmd.InOrStdin()
+			if path != "-" {
+				//nolint:gosec // the path is provided by the user
+				f, err := os.Open(path)
+				if err != nil {
+					return fmt.Errorf("failed to open attachment: %w", err)
+				}
+				defer func() {
+					_ = f.Close()
+				}()
+				in = f
+			}
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			attachment, err := vaultManager.AddAttachment(service, name, in)
+			if err != nil {
+				return attachmentError(err, service, name)
+			}
+			log.Printf("Attached %s (%d bytes) to service %s\n", attachment.Name, attachment.Size, service)
+			return nil
+		},
+	}
+	addCmd.Flags().String("name", "", "Name of the attachment (default: the name of the file)")
+	return addCmd
+}
+
+// attachGetCmd decrypts an attachment to a file or to the standard output.
+func attachGetCmd() *cobra.Command {
+	getCmd := &cobra.Command{
+		Use:   "get <service> <name>",
+		Short: "Decrypt an attachment",
+		Long: `Decrypt an attachment of the entry of the service to the standard output, or to a
+new file readable only by the current user with --out. The file is removed if
+the attachment turns out to be corrupt.`,
+		Example: `  psst attach get github github-recovery-codes.txt
+  psst attach get vpn client.p12 --out ~/client.p12`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			service, name := args[0], args[1]
+			outPath, _ := cmd.Flags().GetString("out")
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			if outPath == "" || outPath == "-" {
+				return attachmentError(vaultManager.ReadAttachment(service, name, cmd.OutOrStdout()), service, name)
+			}
+			//nolint:gosec // the path is provided by the user
+			f, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+			if err != nil {
+				return fmt.Errorf("failed to create output file: %w", err)
+			}
+			err = vaultManager.ReadAttachment(service, name, f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(outPath)
+				return attachmentError(err, service, name)
+			}
+			log.Printf("Decrypted %s to %s\n", name, outPath)
+			return nil
+		},
+	}
+	getCmd.Flags().StringP("out", "o", "", "File to decrypt the attachment to (default: standard output)")
+	return getCmd
+}
+
+// attachRemoveCmd removes an attachment from an entry.
+func attachRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "rm <service> <name>",
+		Short:   "Remove an attachment from an entry",
+		Long:    `Remove an attachment from the entry of the service.`,
+		Example: `  psst attach rm github github-recovery-codes.txt`,
+		Args:    cobra.ExactArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			service, name := args[0], args[1]
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			if err := vaultManager.DeleteAttachment(service, name); err != nil {
+				return attachmentError(err, service, name)
+			}
+			log.Printf("Removed attachment %s from service %s\n", name, service)
+			return nil
+		},
+	}
+}
+
+// attachListCmd lists the attachments of an entry.
+func attachListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "ls <service>",
+		Short:   "List the attachments of an entry",
+		Long:    `List the attachments of the entry of the service, with their size and the date they were added.`,
+		Example: `  psst attach ls github`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			service := args[0]
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			attachments, err := vaultManager.Attachments(service)
+			if err != nil {
+				return attachmentError(err, service, "")
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			for _, attachment := range attachments {
+				_, _ = fmt.Fprintf(w, "%s\t%d\t%s\n",
+					attachment.Name, attachment.Size, attachment.CreatedAt.Local().Format(time.DateTime))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// attachmentError describes the errors of the attachment commands about a missing entry or attachment.
+func attachmentError(err error, service, name string) error {
+	switch {
+	case errors.Is(err, vault.ErrNotFound):
+		return fmt.Errorf("no password entry for service %s", service)
+	case errors.Is(err, vault.ErrAttachmentNotFound):
+		return fmt.Errorf("no attachment %s for service %s", name, service)
+	case errors.Is(err, vault.ErrAttachmentExists):
+		return fmt.Errorf("service %s already has an attachment %s, remove it first", service, name)
+	}
+	return err
+}
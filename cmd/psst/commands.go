@@ -1,16 +1,19 @@
 package psst
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
-	"syscall"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
-	"golang.org/x/term"
 
 	"github.com/CanobbioE/please-safely-store-this/internal/pkg/db"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/gitmirror"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
 	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
 )
 
@@ -24,39 +27,122 @@ func AddCmd() *cobra.Command {
 	addCmd := &cobra.Command{
 		Use:   "add",
 		Short: "Add a new password entry",
-		Long:  `Add a new password entry to the vault.`,
-		Run: func(cmd *cobra.Command, _ []string) {
+		Long: `Add a new password entry to the vault.
+
+The password is prompted for unless set with --password. Custom fields, such as
+security questions or account numbers, are added with --field name=value and
+typed with --field name:type=value, where the type is one of text (default),
+hidden, url, email, date (YYYY-MM-DD) or totp (an otpauth:// URI or a base32
+secret). --hidden-field name=value is a shorthand for the hidden type.
+
+Entries are logins unless set otherwise with --type:
+  note      a secure note, whose body is set with --notes, or read from the
+            standard input with --notes -, and stored encrypted
+  card      a payment card: number, expiry (MM/YY), cvv and holder fields
+  identity  an identity: name, address, email, phone and birthdate fields
+  wifi      a Wi-Fi network: ssid, security (WPA, WEP or nopass) and
+            hidden-ssid (true or false) fields, and the network password
+The hidden fields of the type, such as the card number, are prompted for when
+not set, so that they don't end up in the shell history.`,
+		Example: `  psst add --service bank --username alice --field account=12345678 --hidden-field pin=1234
+  psst add --service forum --field "recovery:email=alice@example.com" --field since:date=2020-01-31
+  psst add --service visa --type card --field expiry=04/28 --field "holder=Alice Smith"
+  psst add --service home-wifi --type wifi --field ssid=Home --field security=WPA
+  psst add --service recovery --type note --notes - < recovery.txt`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
 			service, _ := cmd.Flags().GetString("service")
 			if service == "" {
 				log.Println("Error: Service name required")
-				return
+				return nil
 			}
+			kind, _ := cmd.Flags().GetString("type")
 			username, _ := cmd.Flags().GetString("username")
 			password, _ := cmd.Flags().GetString("password")
+			entryURL, _ := cmd.Flags().GetString("url")
+			notes, _ := cmd.Flags().GetString("notes")
 			tags, _ := cmd.Flags().GetStringSlice("tags")
+			fieldFlags, _ := cmd.Flags().GetStringArray("field")
+			hiddenFlags, _ := cmd.Flags().GetStringArray("hidden-field")
 
-			// TODO:
-			// connect to db
-			// check if service already exists
-			// If password flag not set, prompt for password
-			// Check password strength if configured
-			// Create new password entry
-			// Save to database
-			// If configured to show tags, display them
+			schema := vault.SchemaNamed(kind)
+			if schema == nil || schema.Kind == model.KindSSHKey {
+				return fmt.Errorf("unknown entry type %q, expected one of %s", kind, strings.Join(entryTypes(), ", "))
+			}
+			var fields []model.EntryField
+			for _, flag := range fieldFlags {
+				field, err := parseFieldFlag(flag, schema, model.FieldTypeText)
+				if err != nil {
+					return err
+				}
+				fields = append(fields, field)
+			}
+			for _, flag := range hiddenFlags {
+				field, err := parseFieldFlag(flag, nil, model.FieldTypeHidden)
+				if err != nil {
+					return err
+				}
+				if field.Type != model.FieldTypeHidden {
+					return fmt.Errorf("--hidden-field %s cannot set a type", flag)
+				}
+				fields = append(fields, field)
+			}
+			if notes == "-" {
+				body, err := io.ReadAll(cmd.InOrStdin())
+				if err != nil {
+					return fmt.Errorf("failed to read notes: %w", err)
+				}
+				notes = string(body)
+			}
+			if schema.Kind == model.KindNote && notes != "" {
+				fields = append(fields, model.EntryField{Name: vault.FieldNoteBody, Type: model.FieldTypeHidden, Value: notes})
+				notes = ""
+			}
 
-			if password == "" {
-				log.Println("Password flag not set. Interactive password entry will be implemented in Phase 3.")
-				return
+			if err := unlockVault(); err != nil {
+				return err
 			}
+			defer closeVaultManager()
 
-			log.Printf("Adding password for service: %s, username: %s (Implementation pending)\n", service, username)
-			log.Printf("Tags: %v\n", tags)
+			if err := checkNewService(service); err != nil {
+				return err
+			}
+			entry := &model.PasswordEntry{
+				Service:  service,
+				Kind:     schema.Kind,
+				Username: username,
+				Password: password,
+				URL:      entryURL,
+				Notes:    notes,
+				Tags:     tags,
+				Fields:   fields,
+			}
+			if err := promptHiddenFields(schema, entry); err != nil {
+				return err
+			}
+			if password == "" && schema.NeedsPassword(entry) {
+				var err error
+				if entry.Password, err = passwordReader("Enter password: "); err != nil {
+					return fmt.Errorf("failed to read password: %w", err)
+				}
+			}
+			// TODO: check the password strength if configured.
+
+			if err := vaultManager.Create(entry); err != nil {
+				return err
+			}
+			log.Printf("Added %s entry for service %s\n", schema.Name, service)
+			return nil
 		},
 	}
 	addCmd.Flags().String("service", "", "Service name (required)")
+	addCmd.Flags().String("type", "login", "Type of the entry: "+strings.Join(entryTypes(), ", "))
 	addCmd.Flags().String("username", "", "Username for the service")
 	addCmd.Flags().String("password", "", "Password for the service (if not provided, will prompt)")
+	addCmd.Flags().String("url", "", "URL of the service")
+	addCmd.Flags().String("notes", "", "Notes about the entry, or - to read them from the standard input")
 	addCmd.Flags().StringSlice("tags", []string{}, "Tags for categorization (comma-separated)")
+	addCmd.Flags().StringArray("field", nil, "Custom field as name=value or name:type=value (repeatable)")
+	addCmd.Flags().StringArray("hidden-field", nil, "Custom hidden field as name=value (repeatable)")
 	err := addCmd.MarkFlagRequired("service")
 	if err != nil {
 		log.Println(err)
@@ -64,22 +150,95 @@ func AddCmd() *cobra.Command {
 	return addCmd
 }
 
+// parseFieldFlag parses a custom field flag formatted as name=value or name:type=value. Untyped fields get the type
+// of the field of the schema with the same name, if any, or defaultType.
+func parseFieldFlag(flag string, schema *vault.Schema, defaultType string) (model.EntryField, error) {
+	key, value, ok := strings.Cut(flag, "=")
+	if !ok || key == "" {
+		return model.EntryField{}, fmt.Errorf("invalid field %q, expected name=value or name:type=value", flag)
+	}
+	name, fieldType, typed := strings.Cut(key, ":")
+	if !typed {
+		fieldType = defaultType
+		if schema != nil && schema.Field(name) != nil {
+			fieldType = schema.Field(name).Type
+		}
+	}
+	return model.EntryField{Name: name, Type: fieldType, Value: value}, nil
+}
+
+// checkNewService returns an error if the vault already has an entry for the service.
+func checkNewService(service string) error {
+	_, err := vaultManager.Read(service)
+	if err == nil {
+		return fmt.Errorf("an entry for service %s already exists", service)
+	}
+	if !errors.Is(err, vault.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// markUsed records that the user read a secret of the entries of the services. The secrets are already read, so
+// failing to record it only logs a warning.
+func markUsed(services ...string) {
+	if err := vaultManager.MarkUsed(services...); err != nil {
+		log.Printf("Warning: %s\n", err)
+	}
+}
+
 // GetCmd retrieves a password from the vault.
 func GetCmd() *cobra.Command {
 	getCmd := &cobra.Command{
 		Use:   "get",
 		Short: "Retrieve a password",
-		Long:  `Retrieve a password from the vault.`,
-		Run: func(cmd *cobra.Command, _ []string) {
+		Long: `Retrieve a password from the vault.
+
+Select another field of the entry with --field: username, url, notes, otp or
+the name of a custom field. The current code is printed for totp fields, and
+the string encoded in the QR code phones scan to join a Wi-Fi network for the
+qr field of Wi-Fi entries.
+
+Without --field, the entries other than logins are printed whole: the body of
+notes and the fields of cards, identities and Wi-Fi networks.`,
+		Example: `  psst get --service gmail
+  psst get --service bank --field pin
+  psst get --service home-wifi --field qr | qrencode -t ansiutf8`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
 			service, _ := cmd.Flags().GetString("service")
 			if service == "" {
 				log.Println("Error: Service name required")
-				return
+				return nil
+			}
+			field, _ := cmd.Flags().GetString("field")
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			var value string
+			entry, err := vaultManager.Read(service)
+			if err == nil {
+				if cmd.Flags().Changed("field") || entry.Kind == "" || entry.Kind == model.KindSSHKey {
+					value, err = vaultManager.ReadField(service, field)
+				} else {
+					value = renderEntry(entry)
+				}
+			}
+			if errors.Is(err, vault.ErrNotFound) {
+				return fmt.Errorf("no password entry for service %s", service)
+			}
+			if err != nil {
+				return err
 			}
-			log.Printf("Getting password for service: %s (Implementation pending)\n", service)
+			markUsed(service)
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), value)
+			return err
 		},
 	}
 	getCmd.Flags().String("service", "", "Service name (required)")
+	getCmd.Flags().String("field", vault.FieldPassword, "Field of the entry to retrieve")
 	err := getCmd.MarkFlagRequired("service")
 	if err != nil {
 		log.Println(err)
@@ -92,12 +251,38 @@ func ListCmd() *cobra.Command {
 	listCmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all password entries",
-		Long:  `List all password entries in the vault.`,
-		Run: func(_ *cobra.Command, _ []string) {
-			log.Println("Listing all passwords (Implementation pending)")
+		Long: `List all password entries in the vault, sorted by service, with their type and
+a summary: the username of logins, the last digits of cards, the name of
+identities and the SSID of Wi-Fi networks.`,
+		Example: `  psst list
+  psst list --type card`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			kind, _ := cmd.Flags().GetString("type")
+			schema := vault.SchemaNamed(kind)
+			if kind != "" && schema == nil {
+				return fmt.Errorf("unknown entry type %q, expected one of %s", kind, strings.Join(entryTypes(), ", "))
+			}
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			entries, err := vaultManager.List()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			for _, entry := range entries {
+				if schema != nil && entry.Kind != schema.Kind {
+					continue
+				}
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Service, entryType(entry), entrySummary(entry))
+			}
+			return w.Flush()
 		},
 	}
-
+	listCmd.Flags().String("type", "", "Only list the entries of a type: "+strings.Join(entryTypes(), ", "))
 	return listCmd
 }
 
@@ -145,14 +330,31 @@ func DeleteCmd() *cobra.Command {
 	deleteCmd := &cobra.Command{
 		Use:   "delete",
 		Short: "Delete a password entry",
-		Long:  `Delete a password entry from the vault.`,
-		Run: func(cmd *cobra.Command, _ []string) {
+		Long: `Delete a password entry from the vault.
+
+The deletion is recorded, so that the entry is also deleted from the vaults
+this vault is merged into with 'psst sync merge'.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
 			service, _ := cmd.Flags().GetString("service")
 			if service == "" {
 				log.Println("Error: Service name required")
-				return
+				return nil
 			}
-			log.Printf("Deleting password for service: %s (Implementation pending)\n", service)
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			err := vaultManager.Delete(&model.PasswordEntry{Service: service})
+			if errors.Is(err, vault.ErrNotFound) {
+				return fmt.Errorf("no password entry for service %s", service)
+			}
+			if err != nil {
+				return err
+			}
+			log.Printf("Deleted password entry for service %s\n", service)
+			return nil
 		},
 	}
 	deleteCmd.Flags().String("service", "", "Service name (required)")
@@ -170,18 +372,7 @@ func InitCmd() *cobra.Command {
 		Short: "Initialize the password vault",
 		Long:  `Initialize a new password vault with a principal password.`,
 		Run: func(_ *cobra.Command, _ []string) {
-			initVaultManager()
-			defer vaultManager.Close()
-			readPwd := func(prompt string) string {
-				log.Print(prompt)
-				p, err := term.ReadPassword(syscall.Stdin)
-				if err != nil {
-					log.Printf("Error reading password: %s\n", err)
-					return ""
-				}
-				log.Println()
-				return string(p)
-			}
+			defer closeVaultManager()
 
 			// If the vault already exists, prompt for confirmation to overwrite it.
 			_, err := os.Stat(cfg.DBPath)
@@ -212,13 +403,21 @@ func InitCmd() *cobra.Command {
 			// Prompt for the principal password and confirm it
 			// Retry until the password is at least 8 characters long and matches the confirmation
 		promptPwd:
-			password := readPwd("Enter principal password: ")
+			password, err := passwordReader("Enter principal password: ")
+			if err != nil {
+				log.Printf("Error reading password: %s\n", err)
+				return
+			}
 			if len(password) < minPrincipalPasswordLength {
 				log.Printf("Password must be at least %d characters long!\n", minPrincipalPasswordLength)
 				goto promptPwd
 			}
 
-			confirmPassword := readPwd("Confirm principal password: ")
+			confirmPassword, err := passwordReader("Confirm principal password: ")
+			if err != nil {
+				log.Printf("Error reading password: %s\n", err)
+				return
+			}
 			if password != confirmPassword {
 				log.Println("Passwords do not match! Please try again.")
 				goto promptPwd
@@ -258,4 +457,11 @@ func initVaultManager() {
 	}
 
 	vaultManager = vault.NewManager(v)
+	// The vault is mirrored to git once enabled with 'psst git init'
+	if cfg.GitDir == "" {
+		return
+	}
+	if repo, err := gitmirror.Open(cfg.GitDir); err == nil {
+		vaultManager.SetMirror(repo)
+	}
 }
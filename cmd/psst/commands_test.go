@@ -1,15 +1,98 @@
 package psst_test
 
 import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 
 	"github.com/CanobbioE/please-safely-store-this/cmd/psst"
 	"github.com/CanobbioE/please-safely-store-this/internal/pkg/config"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/db"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/lansync"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/merge"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/otp"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
 )
 
+const testPassword = "password123456"
+
+// setupVault initializes a vault in a temporary directory containing the given entries
+// and configures the commands to use it, unlocking it with testPassword.
+func setupVault(t *testing.T, entries ...*model.PasswordEntry) string {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "psst.db")
+	d, err := db.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := vault.NewManager(d)
+	defer m.Close()
+	if err = m.Init(testPassword); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err = m.Create(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	psst.SetCfg(&config.Config{DBPath: dbPath})
+	psst.SetPasswordReader(func(string) (string, error) {
+		return testPassword, nil
+	})
+	return dbPath
+}
+
+// readVault returns the entries stored in the vault at dbPath.
+func readVault(t *testing.T, dbPath string) map[string]*model.PasswordEntry {
+	t.Helper()
+	return readMemberVault(t, dbPath, testPassword)
+}
+
+// readMemberVault returns the entries stored in the vault at dbPath, unlocked with the password of a member.
+func readMemberVault(t *testing.T, dbPath, password string) map[string]*model.PasswordEntry {
+	t.Helper()
+	d, err := db.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := vault.NewManager(d)
+	defer m.Close()
+	unlocked, err := m.Unlock(password)
+	if !unlocked || err != nil {
+		t.Fatalf("failed to unlock vault: %v", err)
+	}
+	list, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]*model.PasswordEntry{}
+	for _, entry := range list {
+		entries[entry.Service] = entry
+	}
+	return entries
+}
+
 // TODO: expand test cases.
 
 func TestCommands(t *testing.T) {
@@ -29,6 +112,7 @@ func TestCommands(t *testing.T) {
 				"--password", "secret123",
 				"--tags", "email,important",
 			},
+			preRun: func(t *testing.T) { setupVault(t) },
 		},
 		{
 			name: "AddCmd does nothing if service is empty",
@@ -51,19 +135,41 @@ func TestCommands(t *testing.T) {
 			expectedErr: `required flag(s) "service" not set`,
 		},
 		{
-			name: "AddCmd does nothing if password is missing",
+			name: "AddCmd prompts for the password if missing",
 			cmd:  psst.AddCmd(),
 			args: []string{
 				"--service", "gmail",
 				"--username", "user@example.com",
 				"--tags", "email,important",
 			},
+			preRun: func(t *testing.T) { setupVault(t) },
+		},
+		{
+			name:        "AddCmd fails if the service is already in the vault",
+			cmd:         psst.AddCmd(),
+			args:        []string{"--service", "gmail", "--password", "secret456"},
+			expectedErr: "an entry for service gmail already exists",
+			preRun: func(t *testing.T) {
+				setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+			},
 		},
 		// get
 		{
 			name: "GetCmd successfully gets a password",
 			cmd:  psst.GetCmd(),
 			args: []string{"--service", "gmail"},
+			preRun: func(t *testing.T) {
+				setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+			},
+		},
+		{
+			name:        "GetCmd fails if the service is not in the vault",
+			cmd:         psst.GetCmd(),
+			args:        []string{"--service", "github"},
+			expectedErr: "no password entry for service github",
+			preRun: func(t *testing.T) {
+				setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+			},
 		},
 		{
 			name: "GetCmd does nothing if service is empty",
@@ -79,6 +185,15 @@ func TestCommands(t *testing.T) {
 		{
 			name: "ListCmd successfully list all passwords",
 			cmd:  psst.ListCmd(),
+			preRun: func(t *testing.T) {
+				setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+			},
+		},
+		{
+			name:        "ListCmd fails with an unknown type",
+			cmd:         psst.ListCmd(),
+			args:        []string{"--type", "passport"},
+			expectedErr: `unknown entry type "passport"`,
 		},
 		// update
 		{
@@ -106,6 +221,18 @@ func TestCommands(t *testing.T) {
 			name: "DeleteCmd successfully deletes a password",
 			cmd:  psst.DeleteCmd(),
 			args: []string{"--service", "gmail"},
+			preRun: func(t *testing.T) {
+				setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+			},
+		},
+		{
+			name:        "DeleteCmd fails if the service is not in the vault",
+			cmd:         psst.DeleteCmd(),
+			args:        []string{"--service", "github"},
+			expectedErr: "no password entry for service github",
+			preRun: func(t *testing.T) {
+				setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+			},
 		},
 		{
 			name: "DeleteCmd does nothing if service is empty",
@@ -125,6 +252,9 @@ func TestCommands(t *testing.T) {
 				psst.SetCfg(&config.Config{
 					DBPath: t.TempDir() + "/psst.db",
 				})
+				psst.SetPasswordReader(func(string) (string, error) {
+					return testPassword, nil
+				})
 			},
 		},
 	}
@@ -147,3 +277,1635 @@ func TestCommands(t *testing.T) {
 		})
 	}
 }
+
+func TestExportCmd(t *testing.T) {
+	setupVault(t,
+		&model.PasswordEntry{Service: "gmail", Username: "user@example.com", Password: "secret123", Tags: []string{"email"}},
+		&model.PasswordEntry{Service: "github", Password: "secret456", Tags: []string{"work"}},
+	)
+
+	tests := []struct {
+		name        string
+		confirm     string
+		expectedErr string
+		args        []string
+		want        []string
+		notWant     []string
+	}{
+		{
+			name:    "exports all entries to CSV",
+			confirm: "export plaintext\n",
+			want:    []string{"gmail,user@example.com,secret123", "github,,secret456"},
+		},
+		{
+			name:    "exports entries filtered by tag",
+			confirm: "export plaintext\n",
+			args:    []string{"--tag", "work"},
+			want:    []string{"github,,secret456"},
+			notWant: []string{"gmail"},
+		},
+		{
+			name:    "does not export without confirmation",
+			confirm: "yes\n",
+		},
+		{
+			name:        "fails with an unsupported format",
+			args:        []string{"--format", "xml"},
+			expectedErr: `unsupported export format "xml"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "export.csv")
+			cmd := psst.ExportCmd()
+			cmd.SetArgs(append([]string{"--format", "csv", "--out", out}, tt.args...))
+			cmd.SetIn(strings.NewReader(tt.confirm))
+			err := cmd.Execute()
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("Expected error containing [%s], got [%v]", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got [%v]", err)
+			}
+
+			info, err := os.Stat(out)
+			if tt.want == nil {
+				if !os.IsNotExist(err) {
+					t.Fatalf("Expected no export file, got [%v]", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0o600 {
+				t.Fatalf("Expected permissions 0600, got [%v]", info.Mode().Perm())
+			}
+			data, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Fatalf("Expected export to contain [%s], got:\n%s", want, data)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(string(data), notWant) {
+					t.Fatalf("Expected export not to contain [%s], got:\n%s", notWant, data)
+				}
+			}
+		})
+	}
+}
+
+func TestExportCmd_Pass(t *testing.T) {
+	setupVault(t,
+		&model.PasswordEntry{Service: "gmail", Username: "user@example.com", Password: "secret123", Tags: []string{"email"}},
+		&model.PasswordEntry{Service: "github", Password: "secret456"},
+	)
+	out := filepath.Join(t.TempDir(), "pass-staging")
+	cmd := psst.ExportCmd()
+	cmd.SetArgs([]string{"--format", "pass", "--out", out})
+	cmd.SetIn(strings.NewReader("export plaintext\n"))
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+
+	want := map[string]string{
+		"email/gmail": "secret123\nusername: user@example.com\n",
+		"github":      "secret456\n",
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Fatalf("Expected %s to contain [%q], got [%q]", name, content, data)
+		}
+	}
+
+	cmd = psst.ExportCmd()
+	cmd.SetArgs([]string{"--format", "pass", "--out", out})
+	cmd.SetIn(strings.NewReader("export plaintext\n"))
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "failed to create export directory") {
+		t.Fatalf("Expected error when the directory exists, got [%v]", err)
+	}
+}
+
+// plainDecrypter stubs gpg, reading the password store files as plain text.
+type plainDecrypter struct{}
+
+func (plainDecrypter) Decrypt(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func TestImportCmd(t *testing.T) {
+	setupVault(t,
+		&model.PasswordEntry{Service: "gmail", Username: "user@example.com", Password: "secret123", Tags: []string{"email"}},
+		&model.PasswordEntry{Service: "github", Password: "secret456", Tags: []string{"work"}},
+	)
+	bitwardenPath := "../../internal/pkg/importer/testdata/bitwarden.json"
+	kdbxPath := "../../internal/pkg/importer/kdbx/testdata/argon2id.kdbx"
+	storePath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(storePath, "work"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(storePath, "work", "jira.gpg"), []byte("j1r4\nuser: dev\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	psst.SetPassDecrypter(plainDecrypter{})
+	bundlePath := filepath.Join(t.TempDir(), "vault.psst")
+	exportCmd := psst.ExportCmd()
+	exportCmd.SetArgs([]string{"--out", bundlePath})
+	if err := exportCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		want map[string]string
+		// passwords are the answers to the password prompts other than the principal password.
+		passwords   map[string]string
+		name        string
+		expectedErr string
+		args        []string
+	}{
+		{
+			name: "imports new entries and skips existing services",
+			args: []string{bundlePath},
+			want: map[string]string{"gmail": "secret123", "github": "old-secret"},
+		},
+		{
+			name: "imports new entries and overwrites existing services",
+			args: []string{"--on-conflict", "overwrite", bundlePath},
+			want: map[string]string{"gmail": "secret123", "github": "secret456"},
+		},
+		{
+			name: "imports new entries and renames conflicting ones",
+			args: []string{"--on-conflict", "rename", bundlePath},
+			want: map[string]string{"gmail": "secret123", "github": "old-secret", "github (2)": "secret456"},
+		},
+		{
+			name: "previews a Bitwarden import without changing the vault",
+			args: []string{"--format", "bitwarden", "--dry-run", bitwardenPath},
+			want: map[string]string{"github": "old-secret"},
+		},
+		{
+			name: "imports login items from a Bitwarden export",
+			args: []string{"--format", "bitwarden", bitwardenPath},
+			want: map[string]string{
+				"github":                    "old-secret",
+				"GitHub":                    "gh-secret",
+				"Google":                    "g-secret-1",
+				"Google (work@example.com)": "g-secret-2",
+			},
+		},
+		{
+			name:      "imports the entries of a KeePass database",
+			args:      []string{"--format", "kdbx", kdbxPath},
+			passwords: map[string]string{"Enter KeePass password: ": "correct horse battery staple"},
+			want:      map[string]string{"github": "old-secret", "Email": "m41l-p4ss", "Bastion": "r00t & br4nch"},
+		},
+		{
+			name: "imports the entries of a KeePass database protected by a key file",
+			args: []string{
+				"--format", "kdbx",
+				"--keyfile", "../../internal/pkg/importer/kdbx/testdata/keepass.keyx",
+				"../../internal/pkg/importer/kdbx/testdata/keyfile.kdbx",
+			},
+			passwords: map[string]string{"Enter KeePass password: ": ""},
+			want:      map[string]string{"github": "old-secret", "Email": "m41l-p4ss", "Bastion": "r00t & br4nch"},
+		},
+		{
+			name: "imports logins from a Chrome export",
+			args: []string{"--format", "chrome", "../../internal/pkg/importer/testdata/chrome.csv"},
+			want: map[string]string{
+				"github":                        "old-secret",
+				"google.com (me@gmail.com)":     "g-secret",
+				"google.com (work@example.com)": "g-work",
+				"example.co.uk":                 "uk-secret",
+				"192.168.1.1":                   "router",
+				"com.example.app":               "app-secret",
+			},
+		},
+		{
+			name: "imports a password store",
+			args: []string{"--format", "pass", storePath},
+			want: map[string]string{"github": "old-secret", "jira": "j1r4"},
+		},
+		{
+			name:        "fails with a wrong KeePass password",
+			args:        []string{"--format", "kdbx", kdbxPath},
+			passwords:   map[string]string{"Enter KeePass password: ": "wrong password"},
+			expectedErr: "wrong password or key file",
+		},
+		{
+			name:        "fails with a key file and a format other than kdbx",
+			args:        []string{"--format", "bitwarden", "--keyfile", "keepass.keyx", bitwardenPath},
+			expectedErr: "--keyfile is only supported by the kdbx format",
+		},
+		{
+			name:        "fails with an unsupported conflict strategy",
+			args:        []string{"--on-conflict", "merge", bundlePath},
+			expectedErr: `unsupported conflict strategy "merge"`,
+		},
+		{
+			name:        "fails with an unsupported format",
+			args:        []string{"--format", "xml", bundlePath},
+			expectedErr: `unsupported import format "xml"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := setupVault(t, &model.PasswordEntry{Service: "github", Password: "old-secret"})
+			psst.SetPasswordReader(func(prompt string) (string, error) {
+				if password, ok := tt.passwords[prompt]; ok {
+					return password, nil
+				}
+				return testPassword, nil
+			})
+			cmd := psst.ImportCmd()
+			cmd.SetArgs(tt.args)
+			err := cmd.Execute()
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("Expected error containing [%s], got [%v]", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got [%v]", err)
+			}
+
+			entries := readVault(t, dbPath)
+			if len(entries) != len(tt.want) {
+				t.Fatalf("Expected %d entries, got %d", len(tt.want), len(entries))
+			}
+			for service, password := range tt.want {
+				if entries[service] == nil || entries[service].Password != password {
+					t.Fatalf("Expected %s password [%s], got [%+v]", service, password, entries[service])
+				}
+			}
+		})
+	}
+}
+
+func TestImportCmd_DryRun(t *testing.T) {
+	storePath := t.TempDir()
+	files := map[string]string{"github.gpg": "new-secret\n", "gmail.gpg": "secret123\n", "jira.gpg": "j1r4\n"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(storePath, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	psst.SetPassDecrypter(plainDecrypter{})
+	dbPath := setupVault(t,
+		&model.PasswordEntry{Service: "github", Password: "old-secret"},
+		&model.PasswordEntry{Service: "gmail", Password: "secret123"},
+	)
+
+	var out strings.Builder
+	cmd := psst.ImportCmd()
+	cmd.SetArgs([]string{"--format", "pass", "--on-conflict", "rename", "--dry-run", storePath})
+	cmd.SetOut(&out)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+
+	want := "~ github: password changed, import as \"github (2)\"\n= gmail\n+ jira\n"
+	if out.String() != want {
+		t.Fatalf("Expected plan:\n%s\ngot:\n%s", want, out.String())
+	}
+	if entries := readVault(t, dbPath); len(entries) != 2 || entries["github"].Password != "old-secret" {
+		t.Fatalf("Expected the vault to be unchanged, got %+v", entries)
+	}
+}
+
+// editVault unlocks the vault at dbPath with password and calls edit with it.
+func editVault(t *testing.T, dbPath, password string, edit func(m *vault.Manager) error) {
+	t.Helper()
+	d, err := db.NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := vault.NewManager(d)
+	defer m.Close()
+	unlocked, err := m.Unlock(password)
+	if !unlocked || err != nil {
+		t.Fatalf("failed to unlock vault: %v", err)
+	}
+	if err = edit(m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// copyVault copies the vault at dbPath to a new temporary file, as done to move a vault to another device.
+func copyVault(t *testing.T, dbPath string) string {
+	t.Helper()
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+	if err = os.WriteFile(otherPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return otherPath
+}
+
+// syncMerge runs 'psst sync merge' on otherPath with the given input and returns its output.
+func syncMerge(t *testing.T, otherPath, input string) (string, error) {
+	t.Helper()
+	var out strings.Builder
+	cmd := psst.SyncCmd()
+	cmd.SetArgs([]string{"merge", otherPath})
+	cmd.SetIn(strings.NewReader(input))
+	cmd.SetOut(&out)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestSyncMergeCmd(t *testing.T) {
+	dbPath := setupVault(t,
+		&model.PasswordEntry{Service: "github", Password: "gh-secret"},
+		&model.PasswordEntry{Service: "gmail", Password: "secret123"},
+		&model.PasswordEntry{Service: "jira", Password: "j1r4"},
+	)
+	otherPath := copyVault(t, dbPath)
+
+	// The first merge of a copy finds nothing to change and records the sync.
+	if out, err := syncMerge(t, otherPath, ""); err != nil || out != "" {
+		t.Fatalf("Expected no changes, got [%s] and error [%v]", out, err)
+	}
+	var deviceIDs []string
+	for _, path := range []string{dbPath, otherPath} {
+		editVault(t, path, testPassword, func(m *vault.Manager) error {
+			deviceIDs = append(deviceIDs, m.DeviceID())
+			return nil
+		})
+	}
+	if deviceIDs[0] == deviceIDs[1] {
+		t.Fatal("Expected the copies of the vault to have different device IDs")
+	}
+
+	editVault(t, otherPath, testPassword, func(m *vault.Manager) error {
+		if err := m.Create(&model.PasswordEntry{Service: "slack", Password: "s1ack"}); err != nil {
+			return err
+		}
+		if err := m.Update(&model.PasswordEntry{Service: "gmail", Password: "new-secret"}); err != nil {
+			return err
+		}
+		return m.Delete(&model.PasswordEntry{Service: "jira"})
+	})
+	editVault(t, dbPath, testPassword, func(m *vault.Manager) error {
+		return m.Update(&model.PasswordEntry{Service: "github", Password: "gh-local"})
+	})
+
+	out, err := syncMerge(t, otherPath, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+	if want := "- jira\n~ gmail\n+ slack\n"; out != want {
+		t.Fatalf("Expected changes:\n%s\ngot:\n%s", want, out)
+	}
+	entries := readVault(t, dbPath)
+	want := map[string]string{"github": "gh-local", "gmail": "new-secret", "slack": "s1ack"}
+	if len(entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %d", len(want), len(entries))
+	}
+	for service, password := range want {
+		if entries[service] == nil || entries[service].Password != password {
+			t.Fatalf("Expected %s password [%s], got [%+v]", service, password, entries[service])
+		}
+	}
+
+	// Merging again finds nothing new, even though the vaults still differ.
+	if out, err = syncMerge(t, otherPath, ""); err != nil || out != "" {
+		t.Fatalf("Expected no changes, got [%s] and error [%v]", out, err)
+	}
+}
+
+func TestSyncMergeCmd_Conflict(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		expectedErr  string
+		wantPassword string
+	}{
+		{
+			name:         "keeps the version of the other vault",
+			input:        "o\n",
+			wantPassword: "other-secret",
+		},
+		{
+			name:         "keeps the version of this vault",
+			input:        "maybe\nt\n",
+			wantPassword: "local-secret",
+		},
+		{
+			name:         "leaves the vault unchanged when cancelled",
+			expectedErr:  "merge cancelled",
+			wantPassword: "local-secret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+			otherPath := copyVault(t, dbPath)
+			if _, err := syncMerge(t, otherPath, ""); err != nil {
+				t.Fatal(err)
+			}
+			editVault(t, dbPath, testPassword, func(m *vault.Manager) error {
+				return m.Update(&model.PasswordEntry{Service: "gmail", Password: "local-secret"})
+			})
+			editVault(t, otherPath, testPassword, func(m *vault.Manager) error {
+				return m.Update(&model.PasswordEntry{Service: "gmail", Password: "other-secret"})
+			})
+
+			_, err := syncMerge(t, otherPath, tt.input)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("Expected error containing [%s], got [%v]", tt.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Expected no error, got [%v]", err)
+			}
+			if entries := readVault(t, dbPath); entries["gmail"].Password != tt.wantPassword {
+				t.Fatalf("Expected password [%s], got [%s]", tt.wantPassword, entries["gmail"].Password)
+			}
+		})
+	}
+}
+
+func TestSyncMergeCmd_AfterImport(t *testing.T) {
+	dbPath := setupVault(t, &model.PasswordEntry{Service: "github", Password: "gh-secret"})
+	bundlePath := filepath.Join(t.TempDir(), "vault.psst")
+	exportCmd := psst.ExportCmd()
+	exportCmd.SetArgs([]string{"--out", bundlePath})
+	if err := exportCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	editVault(t, dbPath, testPassword, func(m *vault.Manager) error {
+		return m.Update(&model.PasswordEntry{Service: "github", Password: "gh-changed"})
+	})
+	otherPath := copyVault(t, dbPath)
+	if _, err := syncMerge(t, otherPath, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bundle entry was modified before the sync, overwriting with it is still a change of this vault.
+	importCmd := psst.ImportCmd()
+	importCmd.SetArgs([]string{"--on-conflict", "overwrite", bundlePath})
+	if err := importCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	editVault(t, otherPath, testPassword, func(m *vault.Manager) error {
+		return m.Update(&model.PasswordEntry{Service: "github", Password: "gh-other"})
+	})
+
+	if _, err := syncMerge(t, otherPath, "t\n"); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+	if entries := readVault(t, dbPath); entries["github"].Password != "gh-secret" {
+		t.Fatalf("Expected the imported password to be kept, got [%s]", entries["github"].Password)
+	}
+}
+
+func TestSyncMergeCmd_Errors(t *testing.T) {
+	const otherPassword = "other-password123"
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, dbPath string) string
+		expectedErr string
+		want        map[string]string
+	}{
+		{
+			name: "prompts for the password of a vault with a different one",
+			setup: func(t *testing.T, _ string) string {
+				otherPath := filepath.Join(t.TempDir(), "other.db")
+				d, err := db.NewDatabase(otherPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m := vault.NewManager(d)
+				defer m.Close()
+				if err = m.Init(otherPassword); err != nil {
+					t.Fatal(err)
+				}
+				if err = m.Create(&model.PasswordEntry{Service: "slack", Password: "s1ack"}); err != nil {
+					t.Fatal(err)
+				}
+				return otherPath
+			},
+			want: map[string]string{"gmail": "secret123", "slack": "s1ack"},
+		},
+		{
+			name:        "fails to merge the vault with itself",
+			setup:       func(_ *testing.T, dbPath string) string { return dbPath },
+			expectedErr: "cannot merge the vault with itself",
+		},
+		{
+			name:        "fails if the vault to merge doesn't exist",
+			setup:       func(t *testing.T, _ string) string { return filepath.Join(t.TempDir(), "missing.db") },
+			expectedErr: "failed to open vault to merge",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+			otherPath := tt.setup(t, dbPath)
+			psst.SetPasswordReader(func(prompt string) (string, error) {
+				if prompt == "Enter password of the vault to merge: " {
+					return otherPassword, nil
+				}
+				return testPassword, nil
+			})
+
+			_, err := syncMerge(t, otherPath, "")
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("Expected error containing [%s], got [%v]", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got [%v]", err)
+			}
+			entries := readVault(t, dbPath)
+			if len(entries) != len(tt.want) {
+				t.Fatalf("Expected %d entries, got %d", len(tt.want), len(entries))
+			}
+			for service, password := range tt.want {
+				if entries[service] == nil || entries[service].Password != password {
+					t.Fatalf("Expected %s password [%s], got [%+v]", service, password, entries[service])
+				}
+			}
+		})
+	}
+}
+
+// serveChanges serves served once on a loopback address with code, as 'psst sync serve' does, and returns the
+// address along with a channel receiving the time the changes were requested since.
+func serveChanges(t *testing.T, code string, served merge.Snapshot) (string, <-chan time.Time) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	requested := make(chan time.Time, 1)
+	go func() {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer conn.Close()
+		_ = lansync.Serve(conn, code, "server-device", func(since time.Time) (merge.Snapshot, error) {
+			requested <- since
+			return served.Since(since), nil
+		})
+	}()
+	return listener.Addr().String(), requested
+}
+
+func TestSyncPullCmd(t *testing.T) {
+	const code = "1234-5678"
+	dbPath := setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+	psst.SetPasswordReader(func(prompt string) (string, error) {
+		if strings.Contains(prompt, "code") {
+			return code, nil
+		}
+		return testPassword, nil
+	})
+	served := merge.Snapshot{Entries: []*model.PasswordEntry{
+		{UUID: "4f1c", Service: "slack", Password: "s1ack", ModifiedAt: time.Now().UTC()},
+	}}
+
+	pull := func(addr string) (string, error) {
+		var out strings.Builder
+		cmd := psst.SyncCmd()
+		cmd.SetArgs([]string{"pull", addr})
+		cmd.SetOut(&out)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	addr, requested := serveChanges(t, code, served)
+	out, err := pull(addr)
+	if err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+	if since := <-requested; !since.IsZero() || out != "+ slack\n" {
+		t.Fatalf("Expected the whole vault to be pulled, got [%s] since [%v]", out, since)
+	}
+	if entries := readVault(t, dbPath); len(entries) != 2 || entries["slack"].Password != "s1ack" {
+		t.Fatalf("Expected the pulled entry to be added, got %+v", entries)
+	}
+
+	// The next pull only asks for the changes since the first one.
+	addr, requested = serveChanges(t, code, served)
+	if out, err = pull(addr); err != nil || out != "" {
+		t.Fatalf("Expected no changes, got [%s] and error [%v]", out, err)
+	}
+	if since := <-requested; since.IsZero() {
+		t.Fatal("Expected the changes since the last pull to be requested")
+	}
+
+	psst.SetPasswordReader(func(prompt string) (string, error) {
+		if strings.Contains(prompt, "code") {
+			return "1234-0000", nil
+		}
+		return testPassword, nil
+	})
+	addr, _ = serveChanges(t, code, served)
+	if _, err = pull(addr); err == nil || !strings.Contains(err.Error(), "wrong code") {
+		t.Fatalf("Expected a wrong code error, got [%v]", err)
+	}
+}
+
+func TestSyncServeCmd(t *testing.T) {
+	setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"})
+	r, w := io.Pipe()
+	log.SetOutput(w)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	done := make(chan error, 1)
+	go func() {
+		cmd := psst.SyncCmd()
+		cmd.SetArgs([]string{"serve", "--addr", "127.0.0.1:0", "--timeout", "10s"})
+		done <- cmd.Execute()
+		_ = w.Close()
+	}()
+
+	var addr, code string
+	scanner := bufio.NewScanner(r)
+	for (addr == "" || code == "") && scanner.Scan() {
+		if m := regexp.MustCompile(`Serving the vault on (\S+)`).FindStringSubmatch(scanner.Text()); m != nil {
+			addr = m[1]
+		}
+		if m := regexp.MustCompile(`the code (\d{4}-\d{4})`).FindStringSubmatch(scanner.Text()); m != nil {
+			code = m[1]
+		}
+	}
+	// Keep draining the log, so that the command doesn't block on it.
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	deviceID, changes, err := lansync.Pull(conn, code, func(string) (time.Time, error) { return time.Time{}, nil })
+	if err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+	if err = <-done; err != nil {
+		t.Fatalf("Expected no error serving the vault, got [%v]", err)
+	}
+	if deviceID == "" || len(changes.Entries) != 1 || changes.Entries[0].Password != "secret123" ||
+		changes.Entries[0].UUID == "" {
+		t.Fatalf("Expected the vault entries to be served, got device [%s] and %+v", deviceID, changes)
+	}
+}
+
+// runGit runs git with args in dir and returns its output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// useGitVault configures the commands to use the vault at dbPath, mirrored to gitDir.
+func useGitVault(dbPath, gitDir string) {
+	psst.SetCfg(&config.Config{DBPath: dbPath, GitDir: gitDir})
+}
+
+// gitRun runs 'psst git' with args and input, and returns its output.
+func gitRun(t *testing.T, input string, args ...string) (string, error) {
+	t.Helper()
+	var out strings.Builder
+	cmd := psst.GitCmd()
+	cmd.SetArgs(args)
+	cmd.SetIn(strings.NewReader(input))
+	cmd.SetOut(&out)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestGitCmd(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	remote := t.TempDir()
+	runGit(t, remote, "init", "--quiet", "--bare")
+
+	dbPath := setupVault(t,
+		&model.PasswordEntry{Service: "github", Password: "gh-secret"},
+		&model.PasswordEntry{Service: "gmail", Password: "secret123"},
+	)
+	otherPath := copyVault(t, dbPath)
+	gitDir, otherGitDir := t.TempDir(), t.TempDir()
+
+	useGitVault(dbPath, gitDir)
+	if _, err := gitRun(t, "", "init", "--remote", remote); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+	if _, err := gitRun(t, "", "push"); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+	files := runGit(t, gitDir, "ls-files")
+	if strings.Count(files, "entries/") != 2 || strings.Contains(files, "gmail") {
+		t.Fatalf("Expected one file per entry named after its UUID, got:\n%s", files)
+	}
+
+	// The other device starts mirroring its copy of the vault and pulls: nothing changes.
+	useGitVault(otherPath, otherGitDir)
+	if _, err := gitRun(t, "", "init", "--remote", remote); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+	if out, err := gitRun(t, "", "pull"); err != nil || out != "" {
+		t.Fatalf("Expected no changes, got [%s] and error [%v]", out, err)
+	}
+	// Every change is committed.
+	commits := runGit(t, otherGitDir, "rev-list", "--count", "HEAD")
+	deleteCmd := psst.DeleteCmd()
+	deleteCmd.SetArgs([]string{"--service", "gmail"})
+	if err := deleteCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGit(t, otherGitDir, "rev-list", "--count", "HEAD"); got == commits {
+		t.Fatal("Expected the deletion to be committed")
+	}
+	if _, err := gitRun(t, "", "push"); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+
+	// This device must pull before pushing. Its change, made without mirroring, is committed by the pull.
+	useGitVault(dbPath, gitDir)
+	editVault(t, dbPath, testPassword, func(m *vault.Manager) error {
+		return m.Update(&model.PasswordEntry{Service: "github", Password: "gh-new"})
+	})
+	if _, err := gitRun(t, "", "push"); err == nil || !strings.Contains(err.Error(), "psst git pull") {
+		t.Fatalf("Expected the push to be rejected, got [%v]", err)
+	}
+	out, err := gitRun(t, "", "pull")
+	if err != nil || out != "- gmail\n" {
+		t.Fatalf("Expected gmail to be deleted, got [%s] and error [%v]", out, err)
+	}
+	if _, err = gitRun(t, "", "push"); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+
+	useGitVault(otherPath, otherGitDir)
+	if out, err = gitRun(t, "", "pull"); err != nil || out != "~ github\n" {
+		t.Fatalf("Expected github to be updated, got [%s] and error [%v]", out, err)
+	}
+	entries := readVault(t, otherPath)
+	if len(entries) != 1 || entries["github"] == nil || entries["github"].Password != "gh-new" {
+		t.Fatalf("Expected only the updated github entry, got %+v", entries)
+	}
+}
+
+func TestGitCmd_Errors(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	tests := []struct {
+		name        string
+		args        []string
+		expectedErr string
+		preRun      func(t *testing.T)
+	}{
+		{
+			name:        "fails to push if git isn't initialized",
+			args:        []string{"push"},
+			expectedErr: "please run 'psst git init' first",
+			preRun: func(t *testing.T) {
+				useGitVault(setupVault(t), t.TempDir())
+			},
+		},
+		{
+			name:        "fails to init without a git directory",
+			args:        []string{"init"},
+			expectedErr: "please set git_dir in the config",
+			preRun: func(t *testing.T) {
+				setupVault(t)
+			},
+		},
+		{
+			name:        "fails to pull the mirror of another vault",
+			args:        []string{"pull"},
+			expectedErr: "the mirror belongs to another vault",
+			preRun: func(t *testing.T) {
+				remote := t.TempDir()
+				runGit(t, remote, "init", "--quiet", "--bare")
+				useGitVault(setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"}), t.TempDir())
+				for _, args := range [][]string{{"init", "--remote", remote}, {"push"}} {
+					if _, err := gitRun(t, "", args...); err != nil {
+						t.Fatal(err)
+					}
+				}
+				// A vault initialized separately, sharing the remote by mistake
+				useGitVault(setupVault(t, &model.PasswordEntry{Service: "gmail", Password: "secret123"}), t.TempDir())
+				if _, err := gitRun(t, "", "init", "--remote", remote); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.preRun(t)
+			_, err := gitRun(t, "", tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Fatalf("Expected error containing [%s], got [%v]", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+// runCmd runs cmd with args and returns its output.
+func runCmd(cmd *cobra.Command, args ...string) (string, error) {
+	var out strings.Builder
+	cmd.SetArgs(args)
+	cmd.SetOut(&out)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestShareReceiveCmd(t *testing.T) {
+	recipientPath := setupVault(t, &model.PasswordEntry{Service: "github", Password: "own-secret"})
+	recipientKey, err := runCmd(psst.IdentityCmd(), "export")
+	if err != nil || !strings.HasPrefix(recipientKey, "psst-pub-") {
+		t.Fatalf("Expected the public key of the recipient, got [%s] and error [%v]", recipientKey, err)
+	}
+
+	setupVault(t, &model.PasswordEntry{Service: "github", Username: "team", Password: "gh-secret"})
+	sharedPath := filepath.Join(t.TempDir(), "github.psst-share")
+	recipientKey = strings.TrimSpace(recipientKey)
+	if _, err = runCmd(psst.ShareCmd(), "github", "--to", recipientKey, "--out", sharedPath); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+	if _, err = runCmd(psst.ShareCmd(), "jira", "--to", recipientKey, "--out", sharedPath+"2"); err == nil ||
+		!strings.Contains(err.Error(), "no password entry for service jira") {
+		t.Fatalf("Expected error sharing a missing entry, got [%v]", err)
+	}
+	// The entry was sealed for the recipient only.
+	if _, err = runCmd(psst.ReceiveCmd(), sharedPath); err == nil ||
+		!strings.Contains(err.Error(), "not shared with this vault") {
+		t.Fatalf("Expected error receiving an entry shared with another vault, got [%v]", err)
+	}
+
+	psst.SetCfg(&config.Config{DBPath: recipientPath})
+	if _, err = runCmd(psst.ReceiveCmd(), sharedPath); err == nil ||
+		!strings.Contains(err.Error(), "use --as") {
+		t.Fatalf("Expected error receiving an existing service, got [%v]", err)
+	}
+	if _, err = runCmd(psst.ReceiveCmd(), sharedPath, "--as", "github-team"); err != nil {
+		t.Fatalf("Expected no error, got [%v]", err)
+	}
+	entries := readVault(t, recipientPath)
+	if entries["github"].Password != "own-secret" || entries["github-team"] == nil ||
+		entries["github-team"].Password != "gh-secret" || entries["github-team"].Username != "team" {
+		t.Fatalf("Expected the shared entry to be added as github-team, got %+v", entries)
+	}
+}
+
+func TestMemberCmd(t *testing.T) {
+	personalPath := setupVault(t)
+	personalKey, err := runCmd(psst.IdentityCmd(), "export")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	teamPath := setupVault(t, &model.PasswordEntry{Service: "github", Password: "gh-secret"})
+	password := testPassword
+	psst.SetPasswordReader(func(prompt string) (string, error) {
+		if strings.Contains(prompt, "alice") {
+			return "alice-password", nil
+		}
+		return password, nil
+	})
+	if _, err = runCmd(psst.MemberCmd(), "add", "alice"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = runCmd(psst.MemberCmd(), "add", "bob", "--public-key", strings.TrimSpace(personalKey)); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = runCmd(psst.MemberCmd(), "add", "alice"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected error adding an existing member, got [%v]", err)
+	}
+	out, err := runCmd(psst.MemberCmd(), "list")
+	if err != nil || !strings.Contains(out, "alice") || !strings.Contains(out, "bob") || !strings.Contains(out, "owner") {
+		t.Fatalf("Expected the three members, got [%s] and error [%v]", out, err)
+	}
+
+	if _, err = runCmd(psst.MemberCmd(), "remove", "owner"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = runCmd(psst.MemberCmd(), "list"); err == nil ||
+		!strings.Contains(err.Error(), "wrong principal password") {
+		t.Fatalf("Expected the removed member not to unlock the vault, got [%v]", err)
+	}
+
+	// bob unlocks the vault with the identity of their own vault.
+	psst.SetCfg(&config.Config{DBPath: teamPath, IdentityVault: personalPath})
+	if out, err = runCmd(psst.MemberCmd(), "list"); err != nil || strings.Contains(out, "owner") {
+		t.Fatalf("Expected the remaining members, got [%s] and error [%v]", out, err)
+	}
+	if _, err = runCmd(psst.MemberCmd(), "remove", "bob"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = runCmd(psst.MemberCmd(), "list"); err == nil || !strings.Contains(err.Error(), "not a member") {
+		t.Fatalf("Expected the removed identity not to unlock the vault, got [%v]", err)
+	}
+
+	psst.SetCfg(&config.Config{DBPath: teamPath})
+	password = "alice-password"
+	if entries := readMemberVault(t, teamPath, password); entries["github"] == nil ||
+		entries["github"].Password != "gh-secret" {
+		t.Fatalf("Expected the entries to be re-encrypted for the remaining members, got %+v", entries)
+	}
+	if _, err = runCmd(psst.MemberCmd(), "remove", "alice"); err == nil ||
+		!strings.Contains(err.Error(), "last member") {
+		t.Fatalf("Expected error removing the last member, got [%v]", err)
+	}
+}
+
+func TestExecCmd(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	dbPath := setupVault(t,
+		&model.PasswordEntry{Service: "stripe", Username: "dev@example.com", Password: "sk-secret",
+			Fields: []model.EntryField{{Name: "account-id", Type: model.FieldTypeText, Value: "acct_42"}}},
+		&model.PasswordEntry{Service: "paypal", Password: "pp-secret"},
+	)
+
+	tests := []struct {
+		name     string
+		want     string
+		wantErr  string
+		args     []string
+		wantCode int
+	}{
+		{
+			name: "sets the referenced fields",
+			args: []string{"--env", "API_KEY=stripe", "-e", "API_USER=stripe:username", "--",
+				"sh", "-c", `echo "$API_KEY $API_USER"`},
+			want: "sk-secret dev@example.com\n",
+		},
+		{
+			name: "sets the fields of secret references",
+			args: []string{"-e", "API_USER=psst://stripe/username", "--", "sh", "-c", `echo "$API_USER"`},
+			want: "dev@example.com\n",
+		},
+		{
+			name: "sets custom fields",
+			args: []string{"-e", "ACCOUNT=stripe:account-id", "-e", "ID=psst://stripe/account-id", "--", "sh", "-c",
+				`echo "$ACCOUNT $ID"`},
+			want: "acct_42 acct_42\n",
+		},
+		{
+			name: "masks the secrets",
+			args: []string{"--mask", "-e", "API_KEY=stripe:password", "sh", "-c", `echo "key=$API_KEY"`},
+			want: "key=******\n",
+		},
+		{
+			name:     "passes the exit code on",
+			args:     []string{"-e", "API_KEY=stripe", "--", "sh", "-c", "exit 3"},
+			wantCode: 3,
+		},
+		{name: "missing entry", args: []string{"-e", "API_KEY=jira", "--", "true"},
+			wantErr: "no password entry for service jira"},
+		{name: "unknown field", args: []string{"-e", "API_KEY=stripe:pin", "--", "true"},
+			wantErr: "unknown entry field"},
+		{name: "invalid reference", args: []string{"-e", "API_KEY", "--", "true"}, wantErr: "invalid --env"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runCmd(psst.ExecCmd(), tt.args...)
+			var exitErr *psst.ExitError
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing [%s], got [%v]", tt.wantErr, err)
+				}
+			case tt.wantCode != 0:
+				if !errors.As(err, &exitErr) || exitErr.Code != tt.wantCode {
+					t.Fatalf("Expected exit code %d, got [%v]", tt.wantCode, err)
+				}
+			case err != nil || out != tt.want:
+				t.Fatalf("Expected [%s], got [%s] and error [%v]", tt.want, out, err)
+			}
+		})
+	}
+
+	entries := readVault(t, dbPath)
+	if entries["stripe"].LastUsedAt.IsZero() || !entries["paypal"].LastUsedAt.IsZero() {
+		t.Fatalf("Expected only the referenced entry to be marked used, got %+v", entries)
+	}
+}
+
+func TestInjectCmd(t *testing.T) {
+	dbPath := setupVault(t,
+		&model.PasswordEntry{Service: "prod db", Username: "admin", Password: "db-secret",
+			Fields: []model.EntryField{{Name: "host", Type: model.FieldTypeText, Value: "db.internal"}}},
+		&model.PasswordEntry{Service: "staging db", Password: "staging-secret"},
+	)
+	dir := t.TempDir()
+	template := filepath.Join(dir, "config.tpl")
+	text := "user: psst://prod%20db/username\npass: psst://prod%20db\nhost: psst://prod%20db/host\n"
+	if err := os.WriteFile(template, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rendered := filepath.Join(dir, "config.yml")
+	if _, err := runCmd(psst.InjectCmd(), "-i", template, "-o", rendered); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	data, err := os.ReadFile(rendered)
+	if err != nil || string(data) != "user: admin\npass: db-secret\nhost: db.internal\n" {
+		t.Fatalf("Expected the references to be replaced, got [%s] and error [%v]", data, err)
+	}
+	entries := readVault(t, dbPath)
+	if entries["prod db"].LastUsedAt.IsZero() || !entries["staging db"].LastUsedAt.IsZero() {
+		t.Fatalf("Expected only the referenced entry to be marked used, got %+v", entries)
+	}
+	if _, err = runCmd(psst.InjectCmd(), "-i", template, "-o", rendered); err == nil {
+		t.Fatal("Expected error overwriting an existing file, got nil")
+	}
+
+	// Without references, the vault isn't unlocked.
+	psst.SetPasswordReader(func(string) (string, error) {
+		return "", errors.New("unexpected password prompt")
+	})
+	cmd := psst.InjectCmd()
+	cmd.SetIn(strings.NewReader("port: 5432\n"))
+	if out, injectErr := runCmd(cmd); injectErr != nil || out != "port: 5432\n" {
+		t.Fatalf("Expected the template unchanged, got [%s] and error [%v]", out, injectErr)
+	}
+	cmd = psst.InjectCmd()
+	cmd.SetIn(strings.NewReader("psst://prod%20db/pin"))
+	if _, err = runCmd(cmd); err == nil {
+		t.Fatal("Expected error unlocking the vault, got nil")
+	}
+}
+
+func TestGitCredentialCmd(t *testing.T) {
+	dbPath := setupVault(t, &model.PasswordEntry{Service: "github", Username: "dev", Password: "gh-pat",
+		URL: "https://github.com"})
+
+	// credential runs the helper with a fake git request.
+	credential := func(t *testing.T, operation, request string) string {
+		t.Helper()
+		cmd := psst.GitCredentialCmd()
+		cmd.SetIn(strings.NewReader(request))
+		out, err := runCmd(cmd, operation)
+		if err != nil {
+			t.Fatalf("Unexpected error running %s: %v", operation, err)
+		}
+		return out
+	}
+
+	if got := credential(t, "get", "protocol=https\nhost=github.com\n\n"); got != "username=dev\npassword=gh-pat\n" {
+		t.Fatalf("Expected the github credential, got [%s]", got)
+	}
+	if got := credential(t, "get", "protocol=https\nhost=gitlab.com\n\n"); got != "" {
+		t.Fatalf("Expected no credential for an unknown host, got [%s]", got)
+	}
+
+	credential(t, "store", "protocol=https\nhost=gitlab.com\nusername=dev\npassword=gl-pat\n\n")
+	gitlab := readVault(t, dbPath)["git:gitlab.com"]
+	if gitlab == nil || gitlab.Password != "gl-pat" || gitlab.URL != "https://gitlab.com" ||
+		!slices.Contains(gitlab.Tags, "git") {
+		t.Fatalf("Expected a new git:gitlab.com entry tagged git, got %+v", gitlab)
+	}
+	credential(t, "store", "protocol=https\nhost=gitlab.com\nusername=dev\npassword=gl-pat2\n\n")
+	if gitlab = readVault(t, dbPath)["git:gitlab.com"]; gitlab.Password != "gl-pat2" {
+		t.Fatalf("Expected the git:gitlab.com password to be updated, got [%s]", gitlab.Password)
+	}
+
+	// Entries not stored by git are never changed, a new one is stored instead.
+	credential(t, "store", "protocol=https\nhost=github.com\nusername=dev\npassword=gh-token\n\n")
+	entries := readVault(t, dbPath)
+	if entries["github"].Password != "gh-pat" || len(entries["github"].Tags) != 0 {
+		t.Fatalf("Expected the github entry to be untouched, got %+v", entries["github"])
+	}
+	if stored := entries["git:github.com"]; stored == nil || stored.Password != "gh-token" ||
+		!slices.Contains(stored.Tags, "git") {
+		t.Fatalf("Expected a new git:github.com entry tagged git, got %+v", stored)
+	}
+
+	// Entries not stored by git, or whose password changed since, are never erased.
+	credential(t, "erase", "protocol=https\nhost=github.com\nusername=dev\npassword=gh-pat\n\n")
+	credential(t, "erase", "protocol=https\nhost=gitlab.com\nusername=dev\npassword=old\n\n")
+	if entries = readVault(t, dbPath); entries["github"] == nil || entries["git:gitlab.com"] == nil {
+		t.Fatalf("Expected both entries to be kept, got %+v", entries)
+	}
+	credential(t, "erase", "protocol=https\nhost=gitlab.com\nusername=dev\npassword=gl-pat2\n\n")
+	if entries = readVault(t, dbPath); entries["git:gitlab.com"] != nil {
+		t.Fatal("Expected the rejected git:gitlab.com entry to be erased")
+	}
+}
+
+func TestDockerCredentialCmd(t *testing.T) {
+	dbPath := setupVault(t, &model.PasswordEntry{Service: "ghcr", Username: "dev", Password: "ghcr-token",
+		URL: "https://ghcr.io/", Tags: []string{"docker"}})
+
+	// credential runs the helper with a fake docker request.
+	credential := func(t *testing.T, operation, request string) (string, error) {
+		t.Helper()
+		cmd := psst.DockerCredentialCmd()
+		cmd.SetIn(strings.NewReader(request))
+		return runCmd(cmd, operation)
+	}
+
+	out, err := credential(t, "get", "ghcr.io\n")
+	if err != nil || out != `{"ServerURL":"ghcr.io","Username":"dev","Secret":"ghcr-token"}`+"\n" {
+		t.Fatalf("Expected the ghcr.io credential, got [%s] %v", out, err)
+	}
+	out, err = credential(t, "get", "https://index.docker.io/v1/")
+	var exitErr *psst.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 || out != "credentials not found in native keychain\n" {
+		t.Fatalf("Expected the not found reply for an unknown registry, got [%s] %v", out, err)
+	}
+
+	store := `{"ServerURL":"https://index.docker.io/v1/","Username":"me","Secret":"hub-token"}`
+	if _, err = credential(t, "store", store); err != nil {
+		t.Fatalf("Unexpected error storing: %v", err)
+	}
+	hub := readVault(t, dbPath)["docker:index.docker.io/v1"]
+	if hub == nil || hub.Password != "hub-token" || hub.URL != "https://index.docker.io/v1/" ||
+		!slices.Contains(hub.Tags, "docker") {
+		t.Fatalf("Expected a new docker hub entry tagged docker, got %+v", hub)
+	}
+
+	out, err = credential(t, "list", "")
+	if err != nil || out != `{"https://ghcr.io/":"dev","https://index.docker.io/v1/":"me"}`+"\n" {
+		t.Fatalf("Expected both registries to be listed, got [%s] %v", out, err)
+	}
+
+	if _, err = credential(t, "erase", "https://ghcr.io"); err != nil {
+		t.Fatalf("Unexpected error erasing: %v", err)
+	}
+	if entries := readVault(t, dbPath); entries["ghcr"] != nil || entries["docker:index.docker.io/v1"] == nil {
+		t.Fatalf("Expected only the ghcr.io entry to be erased, got %+v", entries)
+	}
+}
+
+func TestSSHAgentCmd(t *testing.T) {
+	dbPath := setupVault(t)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = runCmd(psst.SSHKeyCmd(), "add", "deploy", keyPath); err != nil {
+		t.Fatalf("Unexpected error adding the key: %v", err)
+	}
+	if _, err = runCmd(psst.SSHKeyCmd(), "add", "deploy", keyPath); err == nil {
+		t.Fatal("Expected adding a key for an existing service to fail")
+	}
+	if entry := readVault(t, dbPath)["deploy"]; entry == nil || entry.Kind != model.KindSSHKey {
+		t.Fatalf("Expected an ssh-key entry, got %+v", entry)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := runCmd(psst.SSHKeyCmd(), "public", "deploy")
+	if want := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(signer.PublicKey())), "\n") + " deploy\n"; err != nil ||
+		out != want {
+		t.Fatalf("Expected the authorized key [%s], got [%s] %v", want, out, err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agentCmd := psst.SSHAgentCmd()
+	agentCmd.SetContext(ctx)
+	done := make(chan error, 1)
+	go func() {
+		_, runErr := runCmd(agentCmd, "--socket", socket)
+		done <- runErr
+	}()
+
+	var conn net.Conn
+	for range 100 {
+		if conn, err = net.Dial("unix", socket); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Expected the agent to listen on %s: %v", socket, err)
+	}
+	defer func() { _ = conn.Close() }()
+	client := agent.NewClient(conn)
+
+	keys, err := client.List()
+	if err != nil || len(keys) != 1 || keys[0].Comment != "deploy" {
+		t.Fatalf("Expected the deploy key, got %v %v", keys, err)
+	}
+	signature, err := client.Sign(keys[0], []byte("challenge"))
+	if err != nil {
+		t.Fatalf("Unexpected error signing: %v", err)
+	}
+	if err = signer.PublicKey().Verify([]byte("challenge"), signature); err != nil {
+		t.Fatalf("Expected a valid signature: %v", err)
+	}
+
+	if err = client.Lock(nil); err != nil {
+		t.Fatalf("Unexpected error locking: %v", err)
+	}
+	if _, err = client.Sign(keys[0], []byte("challenge")); err == nil {
+		t.Fatal("Expected a locked agent not to sign")
+	}
+	if err = client.Unlock([]byte("wrong password")); err == nil {
+		t.Fatal("Expected unlocking with a wrong password to fail")
+	}
+	if err = client.Unlock([]byte(testPassword)); err != nil {
+		t.Fatalf("Unexpected error unlocking: %v", err)
+	}
+	if keys, err = client.List(); err != nil || len(keys) != 1 {
+		t.Fatalf("Expected the deploy key once unlocked, got %v %v", keys, err)
+	}
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatalf("Unexpected error stopping the agent: %v", err)
+	}
+	if _, err = os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("Expected the socket to be removed, got %v", err)
+	}
+}
+
+func TestOTPCmd(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+	dbPath := setupVault(t,
+		&model.PasswordEntry{Service: "github", Password: "gh-secret"},
+		&model.PasswordEntry{
+			Service: "vpn", Password: "vpn-secret", OTP: "otpauth://hotp/vpn?secret=" + secret + "&counter=0",
+		},
+	)
+	psst.SetPasswordReader(func(prompt string) (string, error) {
+		if strings.Contains(prompt, "otpauth") {
+			return secret, nil
+		}
+		return testPassword, nil
+	})
+	key, err := otp.Parse("otpauth://totp/github?secret=" + secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = runCmd(psst.OTPCmd(), "github"); err == nil || !strings.Contains(err.Error(), "no OTP secret") {
+		t.Fatalf("Expected an entry without OTP secret to fail, got %v", err)
+	}
+	if _, err = runCmd(psst.OTPCmd(), "github", "--set"); err != nil {
+		t.Fatalf("Unexpected error setting the secret: %v", err)
+	}
+	if got := readVault(t, dbPath)["github"].OTP; got != key.URI() {
+		t.Fatalf("Expected the TOTP URI %s, got %s", key.URI(), got)
+	}
+
+	before, _ := key.TOTP(time.Now())
+	out, err := runCmd(psst.OTPCmd(), "github")
+	after, _ := key.TOTP(time.Now())
+	if err != nil || (out != before+"\n" && out != after+"\n") {
+		t.Fatalf("Expected the TOTP code %s, got [%s] %v", before, out, err)
+	}
+
+	// Every HOTP code increments the counter.
+	hotp := &otp.Key{Algorithm: otp.AlgorithmSHA1, Secret: key.Secret, Digits: otp.DefaultDigits}
+	for counter := range uint64(2) {
+		if out, err = runCmd(psst.OTPCmd(), "vpn"); err != nil || out != hotp.HOTP(counter)+"\n" {
+			t.Fatalf("Expected the HOTP code %s, got [%s] %v", hotp.HOTP(counter), out, err)
+		}
+	}
+	if got := readVault(t, dbPath)["vpn"].OTP; !strings.Contains(got, "counter=2") {
+		t.Fatalf("Expected the HOTP counter to be saved, got %s", got)
+	}
+
+	if _, err = runCmd(psst.OTPCmd(), "github", "--remove"); err != nil {
+		t.Fatalf("Unexpected error removing the secret: %v", err)
+	}
+	if got := readVault(t, dbPath)["github"].OTP; got != "" {
+		t.Fatalf("Expected the OTP secret to be removed, got %s", got)
+	}
+}
+
+func TestAddCmdFields(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+	dbPath := setupVault(t)
+
+	_, err := runCmd(psst.AddCmd(),
+		"--service", "bank", "--password", "secret123",
+		"--field", "account=12345678",
+		"--field", "recovery:email=alice@example.com",
+		"--field", "token:totp="+secret,
+		"--hidden-field", "pin=1234",
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error adding the entry: %v", err)
+	}
+	want := []model.EntryField{
+		{Name: "account", Type: model.FieldTypeText, Value: "12345678"},
+		{Name: "recovery", Type: model.FieldTypeEmail, Value: "alice@example.com"},
+		{Name: "token", Type: model.FieldTypeTOTP, Value: "otpauth://totp/bank?secret=" + secret},
+		{Name: "pin", Type: model.FieldTypeHidden, Value: "1234"},
+	}
+	if got := readVault(t, dbPath)["bank"].Fields; !slices.Equal(got, want) {
+		t.Fatalf("Expected the fields %v, got %v", want, got)
+	}
+
+	for field, value := range map[string]string{"password": "secret123", "pin": "1234", "recovery": "alice@example.com"} {
+		if out, getErr := runCmd(psst.GetCmd(), "--service", "bank", "--field", field); getErr != nil || out != value+"\n" {
+			t.Fatalf("Expected field %s to be %s, got [%s] %v", field, value, out, getErr)
+		}
+	}
+	out, err := runCmd(psst.GetCmd(), "--service", "bank", "--field", "token")
+	if err != nil || len(out) != otp.DefaultDigits+1 {
+		t.Fatalf("Expected the TOTP code of the token field, got [%s] %v", out, err)
+	}
+	if _, err = runCmd(psst.GetCmd(), "--service", "bank", "--field", "missing"); !errors.Is(err, vault.ErrUnknownField) {
+		t.Fatalf("Expected ErrUnknownField, got %v", err)
+	}
+
+	invalid := [][]string{
+		{"--field", "novalue"},
+		{"--field", "since:date=31/01/2020"},
+		{"--field", "site:url=example.com"},
+		{"--field", "kind:color=red"},
+		{"--field", "password=shadowed"},
+		{"--field", "pin=1", "--hidden-field", "pin=2"},
+		{"--hidden-field", "pin:text=1234"},
+	}
+	for _, args := range invalid {
+		if _, err = runCmd(psst.AddCmd(), append([]string{"--service", "other", "--password", "x"}, args...)...); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestAttachCmd(t *testing.T) {
+	dbPath := setupVault(t, &model.PasswordEntry{Service: "github", Password: "gh-secret"})
+	dir := t.TempDir()
+	codes := strings.Repeat("1234-5678\n", 10000)
+	codesPath := filepath.Join(dir, "recovery-codes.txt")
+	if err := os.WriteFile(codesPath, []byte(codes), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runCmd(psst.AttachCmd(), "add", "github", codesPath); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	addStdin := psst.AttachCmd()
+	addStdin.SetIn(strings.NewReader("license-key"))
+	if _, err := runCmd(addStdin, "add", "github", "-", "--name", "license"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err := runCmd(psst.AttachCmd(), "add", "github", codesPath); err == nil ||
+		!strings.Contains(err.Error(), "already has an attachment") {
+		t.Fatalf("Expected adding an existing attachment to fail, got %v", err)
+	}
+	if _, err := runCmd(psst.AttachCmd(), "add", "gitlab", codesPath); err == nil ||
+		!strings.Contains(err.Error(), "no password entry for service gitlab") {
+		t.Fatalf("Expected attaching to a missing entry to fail, got %v", err)
+	}
+
+	out, err := runCmd(psst.AttachCmd(), "ls", "github")
+	if err != nil || !regexp.MustCompile(`(?m)^license\s+11\s`).MatchString(out) ||
+		!regexp.MustCompile(`(?m)^recovery-codes\.txt\s+100000\s`).MatchString(out) {
+		t.Fatalf("Expected both attachments with their size, got [%s] %v", out, err)
+	}
+	if out, err = runCmd(psst.AttachCmd(), "get", "github", "license"); err != nil || out != "license-key" {
+		t.Fatalf("Expected the license to be printed, got [%s] %v", out, err)
+	}
+
+	// The attachments survive the rotation of the vault key.
+	psst.SetPasswordReader(func(prompt string) (string, error) {
+		if strings.Contains(prompt, "alice") {
+			return "alice-password", nil
+		}
+		return testPassword, nil
+	})
+	if _, err = runCmd(psst.MemberCmd(), "add", "alice"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = runCmd(psst.MemberCmd(), "remove", "owner"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	psst.SetPasswordReader(func(string) (string, error) { return "alice-password", nil })
+
+	outPath := filepath.Join(dir, "decrypted.txt")
+	if _, err = runCmd(psst.AttachCmd(), "get", "github", "recovery-codes.txt", "--out", outPath); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if got, readErr := os.ReadFile(outPath); readErr != nil || string(got) != codes {
+		t.Fatalf("Expected the decrypted file to match the attachment, got %d bytes %v", len(got), readErr)
+	}
+	if _, err = runCmd(psst.AttachCmd(), "get", "github", "license", "--out", outPath); err == nil {
+		t.Fatal("Expected decrypting over an existing file to fail")
+	}
+
+	if _, err = runCmd(psst.AttachCmd(), "rm", "github", "license"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = runCmd(psst.AttachCmd(), "get", "github", "license"); err == nil ||
+		!strings.Contains(err.Error(), "no attachment license for service github") {
+		t.Fatalf("Expected the removed attachment to be missing, got %v", err)
+	}
+
+	// Attachments are authenticated by the vault MAC: deleting one outside psst is detected.
+	conn, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec("DELETE FROM attachments WHERE name = 'recovery-codes.txt'")
+	if closeErr := conn.Close(); err != nil || closeErr != nil {
+		t.Fatal(err, closeErr)
+	}
+	if _, err = runCmd(psst.AttachCmd(), "ls", "github"); !errors.Is(err, vault.ErrIntegrity) {
+		t.Fatalf("Expected the deleted attachment to fail the integrity check, got %v", err)
+	}
+}
+
+func TestAddCmdTypes(t *testing.T) {
+	dbPath := setupVault(t, &model.PasswordEntry{Service: "gmail", Username: "alice@example.com", Password: "secret"})
+	psst.SetPasswordReader(func(prompt string) (string, error) {
+		switch {
+		case strings.Contains(prompt, "card number"):
+			return "4111 1111 1111 1111", nil
+		case strings.Contains(prompt, "card cvv"):
+			return "123", nil
+		}
+		return testPassword, nil
+	})
+
+	addNote := psst.AddCmd()
+	addNote.SetIn(strings.NewReader("line one\nline two\n"))
+	valid := [][]string{
+		{"--service", "recovery", "--type", "note", "--notes", "-"},
+		{"--service", "visa", "--type", "card", "--field", "expiry=4/2028", "--field", "holder=Alice Smith"},
+		{"--service", "me", "--type", "identity", "--field", "name=Alice Smith", "--field", "email=alice@example.com"},
+		{"--service", "home", "--type", "wifi", "--field", "ssid=Home;Net", "--field", "security=wpa2"},
+		{"--service", "cafe", "--type", "wifi", "--field", "ssid=Cafe", "--field", "security=nopass"},
+	}
+	for i, args := range valid {
+		cmd := psst.AddCmd()
+		if i == 0 {
+			cmd = addNote
+		}
+		if _, err := runCmd(cmd, args...); err != nil {
+			t.Fatalf("Unexpected error adding %v: %v", args, err)
+		}
+	}
+	entries := readVault(t, dbPath)
+	if got := entries["visa"]; got.Kind != model.KindCard || got.Password != "" ||
+		!slices.Contains(got.Fields, model.EntryField{Name: "number", Type: "hidden", Value: "4111111111111111"}) ||
+		!slices.Contains(got.Fields, model.EntryField{Name: "expiry", Type: "text", Value: "04/28"}) {
+		t.Fatalf("Expected the card number and expiry to be normalized, got %+v", got)
+	}
+	if got := entries["recovery"]; got.Notes != "" || !slices.Contains(got.Fields,
+		model.EntryField{Name: "body", Type: model.FieldTypeHidden, Value: "line one\nline two\n"}) {
+		t.Fatalf("Expected the body of the note to be a hidden field, got %+v", got)
+	}
+	if got := entries["cafe"]; got.Password != "" {
+		t.Fatalf("Expected an open network not to be prompted for a password, got %q", got.Password)
+	}
+
+	gets := map[string]string{
+		"recovery": "line one\nline two\n",
+		"visa":     "number: 4111111111111111\nexpiry: 04/28\ncvv: 123\nholder: Alice Smith\n",
+		"home": "password: " + testPassword + "\nssid: Home;Net\nsecurity: WPA\n" +
+			`qr: WIFI:T:WPA;S:Home\;Net;P:` + testPassword + ";;\n",
+		"gmail": "secret\n",
+	}
+	for service, want := range gets {
+		if out, err := runCmd(psst.GetCmd(), "--service", service); err != nil || out != want {
+			t.Fatalf("Expected %s to render as [%q], got [%q] %v", service, want, out, err)
+		}
+	}
+	if out, err := runCmd(psst.GetCmd(), "--service", "cafe", "--field", "qr"); err != nil ||
+		out != "WIFI:T:nopass;S:Cafe;;\n" {
+		t.Fatalf("Expected the QR string of the open network, got [%q] %v", out, err)
+	}
+
+	out, err := runCmd(psst.ListCmd())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, line := range []string{
+		`(?m)^gmail\s+login\s+alice@example\.com$`, `(?m)^visa\s+card\s+\*\*\*\* 1111  04/28$`,
+		`(?m)^me\s+identity\s+Alice Smith$`, `(?m)^home\s+wifi\s+Home;Net$`, `(?m)^recovery\s+note\s*$`,
+	} {
+		if !regexp.MustCompile(line).MatchString(out) {
+			t.Fatalf("Expected the list to match %s, got [%s]", line, out)
+		}
+	}
+	if out, err = runCmd(psst.ListCmd(), "--type", "wifi"); err != nil || strings.Contains(out, "visa") ||
+		!strings.Contains(out, "cafe") {
+		t.Fatalf("Expected only the Wi-Fi networks, got [%s] %v", out, err)
+	}
+
+	invalid := [][]string{
+		{"--type", "passport"},
+		{"--type", "ssh-key"},
+		{"--type", "note"},
+		{"--type", "card", "--field", "number=4111111111111112", "--field", "expiry=04/28"},
+		{"--type", "card", "--field", "expiry=13/28"},
+		{"--type", "card", "--field", "expiry=04/28", "--hidden-field", "cvv=12"},
+		{"--type", "card", "--field", "number:text=4111111111111111", "--field", "expiry=04/28"},
+		{"--type", "identity", "--field", "email=alice@example.com"},
+		{"--type", "wifi", "--field", "ssid=x", "--field", "security=WPA4"},
+		{"--type", "wifi", "--field", "ssid=x", "--field", "security=nopass", "--password", "x"},
+	}
+	for _, args := range invalid {
+		if _, err = runCmd(psst.AddCmd(), append([]string{"--service", "other"}, args...)...); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestSearchCmd(t *testing.T) {
+	dbPath := setupVault(t,
+		&model.PasswordEntry{Service: "gmail", Username: "alice@gmail.com", Password: "s1", Tags: []string{"email"}},
+		&model.PasswordEntry{Service: "github", Username: "alice", Password: "s2", Tags: []string{"work"}},
+		&model.PasswordEntry{Service: "bank", Password: "s3", Notes: "recovery codes in the safe"},
+	)
+
+	tests := []struct {
+		name        string
+		expectedErr string
+		args        []string
+		want        []string
+	}{
+		{name: "ranks the best match first", args: []string{"gml"}, want: []string{"gmail"}},
+		{name: "matches every word", args: []string{"alice", "hub"}, want: []string{"github"}},
+		{name: "filters by tag", args: []string{"--tag", "work"}, want: []string{"github"}},
+		{name: "searches notes", args: []string{"safe", "--notes"}, want: []string{"bank"}},
+		{name: "finds nothing", args: []string{"safe"}},
+		{name: "requires a query", expectedErr: "a query or a --tag is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runCmd(psst.SearchCmd(), tt.args...)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("Expected error containing [%s], got [%v]", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+				if fields := strings.Fields(line); len(fields) > 0 {
+					got = append(got, fields[0])
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Expected %v, got [%s]", tt.want, out)
+			}
+		})
+	}
+
+	before := readVault(t, dbPath)["github"]
+	if _, err := runCmd(psst.GetCmd(), "--service", "github"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	after := readVault(t, dbPath)["github"]
+	if !after.LastUsedAt.After(before.LastUsedAt) || !after.ModifiedAt.Equal(before.ModifiedAt) {
+		t.Fatalf("Expected only the last use to be updated, got %+v, was %+v", after, before)
+	}
+	out, err := runCmd(psst.SearchCmd(), "a")
+	if err != nil || !strings.HasPrefix(out, "github") {
+		t.Fatalf("Expected the read entry first, got [%s] %v", out, err)
+	}
+}
@@ -0,0 +1,148 @@
+package psst
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/credhelper"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// gitCredentialTag tags the entries stored by git through the credential helper.
+const gitCredentialTag = "git"
+
+// GitCredentialCmd implements the git credential helper protocol.
+func GitCredentialCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "git-credential <get|store|erase>",
+		Short: "Serve the credentials of git remotes from the vault",
+		Long: `Act as a git credential helper, reading the credential request of git on the
+standard input. Enable it with:
+
+  git config --global credential.helper '!psst git-credential'
+
+get     Reply with the username and password of the entry whose URL matches
+        the remote, if any. An entry URL with a path, e.g.
+        https://github.com/team/repo, is only used for that repository when
+        credential.useHttpPath is set.
+store   Save the credential git used successfully, in the matching entry tagged
+        "git" or in a new one named git:<host>. Other entries are never changed.
+erase   Delete the entry holding a credential rejected by the remote, only if
+        it is tagged "git" and still has the rejected password.
+
+The principal password is prompted for on the terminal.`,
+		Example:   `  printf 'protocol=https\nhost=github.com\n\n' | psst git-credential get`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"get", "store", "erase"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req, err := credhelper.ParseGitRequest(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			if req.Protocol == "" || req.Host == "" {
+				return nil
+			}
+			switch args[0] {
+			case "get":
+				return gitCredentialGet(cmd, req)
+			case "store":
+				return gitCredentialStore(req)
+			case "erase":
+				return gitCredentialErase(req)
+			}
+			// Other operations are ignored, as required by the protocol.
+			return nil
+		},
+	}
+}
+
+// gitCredentialEntries unlocks the vault and lists its entries.
+// On success, the vault manager must be closed by the caller with closeVaultManager.
+func gitCredentialEntries() ([]*model.PasswordEntry, error) {
+	if err := unlockVault(); err != nil {
+		return nil, err
+	}
+	entries, err := vaultManager.List()
+	if err != nil {
+		closeVaultManager()
+		return nil, err
+	}
+	return entries, nil
+}
+
+// gitCredentialGet replies to git with the credential of the entry matching the request, if any.
+func gitCredentialGet(cmd *cobra.Command, req *credhelper.GitRequest) error {
+	entries, err := gitCredentialEntries()
+	if err != nil {
+		return err
+	}
+	defer closeVaultManager()
+
+	entry := credhelper.FindEntry(entries, req)
+	if entry == nil {
+		return nil
+	}
+	username := entry.Username
+	if username == "" {
+		username = req.Username
+	}
+	return credhelper.WriteGitCredential(cmd.OutOrStdout(), username, entry.Password)
+}
+
+// gitCredentialStore saves the credential of the request in the matching entry stored by git, or in a new one.
+// Entries not tagged gitCredentialTag are left untouched even if they match, since git may store a credential
+// other than theirs, e.g. a token instead of the account password.
+func gitCredentialStore(req *credhelper.GitRequest) error {
+	if req.Username == "" || req.Password == "" {
+		return nil
+	}
+	entries, err := gitCredentialEntries()
+	if err != nil {
+		return err
+	}
+	defer closeVaultManager()
+
+	if entry := credhelper.FindEntry(gitStoredEntries(entries), req); entry != nil {
+		if entry.Password == req.Password && entry.Username == req.Username {
+			return nil
+		}
+		entry.Username, entry.Password = req.Username, req.Password
+		return vaultManager.Update(entry)
+	}
+
+	service := gitCredentialTag + ":" + req.Host
+	if slices.ContainsFunc(entries, func(e *model.PasswordEntry) bool { return e.Service == service }) {
+		service = fmt.Sprintf("%s:%s@%s", gitCredentialTag, req.Username, req.Host)
+	}
+	return vaultManager.Create(&model.PasswordEntry{
+		Service:  service,
+		Username: req.Username,
+		Password: req.Password,
+		URL:      req.URL(),
+		Tags:     []string{gitCredentialTag},
+	})
+}
+
+// gitCredentialErase deletes the entry stored by git holding the rejected credential of the request, if any.
+func gitCredentialErase(req *credhelper.GitRequest) error {
+	entries, err := gitCredentialEntries()
+	if err != nil {
+		return err
+	}
+	defer closeVaultManager()
+
+	entry := credhelper.FindEntry(gitStoredEntries(entries), req)
+	if entry == nil || (req.Password != "" && entry.Password != req.Password) {
+		return nil
+	}
+	return vaultManager.Delete(entry)
+}
+
+// gitStoredEntries returns the entries stored by git, tagged gitCredentialTag.
+func gitStoredEntries(entries []*model.PasswordEntry) []*model.PasswordEntry {
+	return slices.DeleteFunc(slices.Clone(entries), func(e *model.PasswordEntry) bool {
+		return !slices.Contains(e.Tags, gitCredentialTag)
+	})
+}
@@ -0,0 +1,174 @@
+package psst
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/credhelper"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// DockerCredentialHelper is the name docker runs the psst credential helper with. When psst is run through a link
+// with that name, it acts as the 'psst docker-credential' command.
+const DockerCredentialHelper = "docker-credential-psst"
+
+// errDockerNotFound is returned when the vault has no credentials for a registry.
+var errDockerNotFound = errors.New(credhelper.DockerNotFound)
+
+// DockerCredentialCmd implements the docker credential helper protocol.
+func DockerCredentialCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "docker-credential <get|store|erase|list>",
+		Short: "Serve the credentials of docker registries from the vault",
+		Long: `Act as a docker credential helper, so that registry tokens are kept in the vault
+instead of ~/.docker/config.json. Docker runs the helper as ` + DockerCredentialHelper + `:
+link it to psst and enable it in ~/.docker/config.json:
+
+  ln -s "$(command -v psst)" ~/.local/bin/` + DockerCredentialHelper + `
+  { "credsStore": "psst" }
+
+get     Reply with the credential of the registry whose URL is read.
+store   Save the credential read, as JSON, in an entry tagged "docker".
+erase   Delete the credential of the registry whose URL is read.
+list    List the registries with a credential, and their usernames.
+
+Registries are matched with the URL of the entries tagged "docker". The
+principal password is prompted for on the terminal.`,
+		Example:   `  echo ghcr.io | psst docker-credential get`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"get", "store", "erase", "list"},
+		// Docker reads the standard output, which must only hold the reply.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			switch args[0] {
+			case "get":
+				err = dockerCredentialGet(cmd)
+			case "store":
+				err = dockerCredentialStore(cmd)
+			case "erase":
+				err = dockerCredentialErase(cmd)
+			case "list":
+				err = dockerCredentialList(cmd)
+			default:
+				err = fmt.Errorf("unknown credential action %s", args[0])
+			}
+			if err == nil {
+				return nil
+			}
+			// Docker reads the errors of helpers on their standard output.
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), err)
+			return &ExitError{Code: 1}
+		},
+	}
+}
+
+// readServerURL reads the registry server URL sent by docker.
+func readServerURL(cmd *cobra.Command) (string, error) {
+	data, err := io.ReadAll(cmd.InOrStdin())
+	if err != nil {
+		return "", fmt.Errorf("failed to read server URL: %w", err)
+	}
+	server := strings.TrimSpace(string(data))
+	if server == "" {
+		return "", errors.New("no server URL")
+	}
+	return server, nil
+}
+
+// dockerCredentialGet writes the credential of the registry read from the standard input.
+func dockerCredentialGet(cmd *cobra.Command) error {
+	server, err := readServerURL(cmd)
+	if err != nil {
+		return err
+	}
+	entries, err := gitCredentialEntries()
+	if err != nil {
+		return err
+	}
+	defer closeVaultManager()
+
+	entry := credhelper.FindDockerEntry(entries, server)
+	if entry == nil {
+		return errDockerNotFound
+	}
+	return json.NewEncoder(cmd.OutOrStdout()).Encode(credhelper.DockerCredentials{
+		ServerURL: server,
+		Username:  entry.Username,
+		Secret:    entry.Password,
+	})
+}
+
+// dockerCredentialStore saves the credential read from the standard input, in the entry of its registry or in a new
+// one.
+func dockerCredentialStore(cmd *cobra.Command) error {
+	var creds credhelper.DockerCredentials
+	if err := json.NewDecoder(cmd.InOrStdin()).Decode(&creds); err != nil {
+		return fmt.Errorf("failed to read credentials: %w", err)
+	}
+	if strings.TrimSpace(creds.ServerURL) == "" {
+		return errors.New("no server URL")
+	}
+	entries, err := gitCredentialEntries()
+	if err != nil {
+		return err
+	}
+	defer closeVaultManager()
+
+	if entry := credhelper.FindDockerEntry(entries, creds.ServerURL); entry != nil {
+		if entry.Username == creds.Username && entry.Password == creds.Secret {
+			return nil
+		}
+		entry.Username, entry.Password = creds.Username, creds.Secret
+		return vaultManager.Update(entry)
+	}
+	return vaultManager.Create(&model.PasswordEntry{
+		Service:  credhelper.DockerTag + ":" + credhelper.NormalizeServerURL(creds.ServerURL),
+		Username: creds.Username,
+		Password: creds.Secret,
+		URL:      creds.ServerURL,
+		Tags:     []string{credhelper.DockerTag},
+	})
+}
+
+// dockerCredentialErase deletes the credential of the registry read from the standard input.
+func dockerCredentialErase(cmd *cobra.Command) error {
+	server, err := readServerURL(cmd)
+	if err != nil {
+		return err
+	}
+	entries, err := gitCredentialEntries()
+	if err != nil {
+		return err
+	}
+	defer closeVaultManager()
+
+	entry := credhelper.FindDockerEntry(entries, server)
+	if entry == nil {
+		return errDockerNotFound
+	}
+	return vaultManager.Delete(entry)
+}
+
+// dockerCredentialList writes the URLs of the registries with a credential and their usernames.
+func dockerCredentialList(cmd *cobra.Command) error {
+	entries, err := gitCredentialEntries()
+	if err != nil {
+		return err
+	}
+	defer closeVaultManager()
+
+	registries := map[string]string{}
+	for _, entry := range entries {
+		if entry.URL != "" && slices.Contains(entry.Tags, credhelper.DockerTag) {
+			registries[entry.URL] = entry.Username
+		}
+	}
+	return json.NewEncoder(cmd.OutOrStdout()).Encode(registries)
+}
@@ -0,0 +1,183 @@
+package psst
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/mask"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/secretref"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// forwardedSignals are the signals received by psst that are forwarded to the command it runs.
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// ExitError is returned when a command run by psst fails with a non-zero exit code, for psst to exit with it.
+type ExitError struct {
+	Code int
+}
+
+// Error implements error.
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.Code)
+}
+
+// envReference is an environment variable whose value is read from an entry field.
+type envReference struct {
+	name    string
+	service string
+	field   string
+}
+
+// ExecCmd runs a command with secrets from the vault in its environment.
+func ExecCmd() *cobra.Command {
+	execCmd := &cobra.Command{
+		Use:   "exec --env NAME=<reference>... -- <command> [args...]",
+		Short: "Run a command with secrets from the vault in its environment",
+		Long: `Unlock the vault, read the entry field referenced by every --env flag and run the
+command with those environment variables set, so that secrets never end up in
+the shell history or in .env files. A field is referenced either as
+<service>[:<field>] or as psst://<service>[/<field>], see 'psst inject'. The
+field defaults to the password, it can be one of: password, username, url, notes,
+otp, the current two-factor code, or the name of a custom field of the entry,
+e.g. github:otp or psst://bank/pin.
+
+The vault is locked again before the command starts. Interrupt and termination
+signals are forwarded to the command, and psst exits with its exit code.
+
+With --mask, every secret written by the command to its standard output or
+error is replaced with ` + mask.Replacement + `. Masking holds back any output ending
+with the beginning of a secret until the command writes more or exits.`,
+		Example: `  psst exec --env DB_PASS=prod-db --env API_KEY=stripe:password -- ./deploy.sh
+  psst exec --mask -e STRIPE_USER=psst://stripe/username -- env
+  psst exec -e GH_OTP=github:otp -e ACCOUNT=stripe:account-id -- ./release.sh`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			envs, _ := cmd.Flags().GetStringArray("env")
+			masked, _ := cmd.Flags().GetBool("mask")
+			references, err := parseEnvReferences(envs)
+			if err != nil {
+				return err
+			}
+			env, secrets, err := resolveEnvReferences(references)
+			if err != nil {
+				return err
+			}
+
+			//nolint:gosec // runs the command the user asked for
+			child := exec.Command(args[0], args[1:]...)
+			child.Env = append(os.Environ(), env...)
+			child.Stdin = cmd.InOrStdin()
+			child.Stdout, child.Stderr = cmd.OutOrStdout(), cmd.ErrOrStderr()
+			if masked {
+				stdout, stderr := mask.NewWriter(child.Stdout, secrets), mask.NewWriter(child.Stderr, secrets)
+				defer func() {
+					_ = stdout.Close()
+					_ = stderr.Close()
+				}()
+				child.Stdout, child.Stderr = stdout, stderr
+			}
+			return runChild(child)
+		},
+	}
+	execCmd.Flags().StringArrayP("env", "e", nil,
+		"Environment variable to set, as NAME=<reference> (can be repeated)")
+	execCmd.Flags().Bool("mask", false, "Mask the secrets in the output of the command")
+	// Flags after the command are the command's own.
+	execCmd.Flags().SetInterspersed(false)
+	return execCmd
+}
+
+// parseEnvReferences parses the --env flags of exec.
+func parseEnvReferences(envs []string) ([]envReference, error) {
+	references := make([]envReference, 0, len(envs))
+	for _, env := range envs {
+		name, reference, ok := strings.Cut(env, "=")
+		if !ok || name == "" || reference == "" {
+			return nil, fmt.Errorf("invalid --env %s, expected NAME=<reference>", env)
+		}
+		if strings.HasPrefix(reference, secretref.Scheme) {
+			ref, err := secretref.Parse(reference)
+			if err != nil {
+				return nil, err
+			}
+			references = append(references, envReference{name: name, service: ref.Service, field: ref.Field})
+			continue
+		}
+		service, field := reference, vault.FieldPassword
+		if i := strings.LastIndex(reference, ":"); i >= 0 {
+			service, field = reference[:i], reference[i+1:]
+		}
+		references = append(references, envReference{name: name, service: service, field: field})
+	}
+	return references, nil
+}
+
+// resolveEnvReferences unlocks the vault and reads the referenced fields, locking the vault again before returning.
+// It returns the environment variables, as NAME=value, and the secrets they contain.
+func resolveEnvReferences(references []envReference) (env, secrets []string, err error) {
+	if len(references) == 0 {
+		return nil, nil, nil
+	}
+	if err = unlockVault(); err != nil {
+		return nil, nil, err
+	}
+	defer closeVaultManager()
+
+	services := make([]string, 0, len(references))
+	for _, reference := range references {
+		value, readErr := vaultManager.ReadField(reference.service, reference.field)
+		if errors.Is(readErr, vault.ErrNotFound) {
+			return nil, nil, fmt.Errorf("no password entry for service %s", reference.service)
+		}
+		if readErr != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", reference.name, readErr)
+		}
+		env = append(env, reference.name+"="+value)
+		secrets = append(secrets, value)
+		services = append(services, reference.service)
+	}
+	markUsed(services...)
+	return env, secrets, nil
+}
+
+// runChild runs child, forwarding the signals received in the meantime, and returns an ExitError if it fails.
+// A command killed by a signal exits with 128 plus the signal number, as in a shell.
+func runChild(child *exec.Cmd) error {
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", child.Path, err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				_ = child.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := child.Wait()
+	close(done)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	code := exitErr.ExitCode()
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		code = 128 + int(status.Signal())
+	}
+	return &ExitError{Code: code}
+}
@@ -0,0 +1,161 @@
+package psst
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/bundle"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/export"
+)
+
+const (
+	// exportFormatCSV exports the vault as plain text CSV.
+	exportFormatCSV = "csv"
+	// exportFormatPsst exports the vault as an encrypted .psst bundle.
+	exportFormatPsst = "psst"
+	// exportFormatPass exports the vault as a plain text staging tree for pass.
+	exportFormatPass = "pass"
+
+	// plaintextConfirmationPhrase must be typed by the user before a plain text export is written.
+	plaintextConfirmationPhrase = "export plaintext"
+)
+
+// ExportCmd exports the vault entries to a file.
+func ExportCmd() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export password entries",
+		Long: `Export password entries from the vault.
+
+The psst format is an encrypted bundle sealed with an export passphrase, which
+can be imported in another vault without sharing the principal password.
+
+The CSV and pass formats store every password in plain text: anyone who can
+read the exported files can read your passwords. Exporting to them requires
+typing a confirmation phrase.
+
+The CSV format has a row per entry, with its kind, its OTP secret and its
+custom fields as a JSON array besides the login fields.
+
+The pass format creates the --out directory and writes a file per entry, in
+directories named after its tags. The first line of a file is the password,
+followed by the username, the URL and the notes. Once encrypted with pass, e.g.
+with 'pass insert -m', the tree can be deleted.
+
+Exported files are created readable only by the current user. Without --out
+the export is written to stdout, which is refused when stdout is a terminal
+unless --force is given.`,
+		Example: `  psst export --format psst --out vault.psst
+  psst export --format csv --out passwords.csv
+  psst export --format csv --tag work --out work.csv
+  psst export --format pass --out ./pass-staging`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			out, _ := cmd.Flags().GetString("out")
+			tag, _ := cmd.Flags().GetString("tag")
+			force, _ := cmd.Flags().GetBool("force")
+
+			switch format {
+			case exportFormatCSV, exportFormatPsst:
+			case exportFormatPass:
+				if out == "" {
+					return errors.New("the pass format requires --out, the directory to create")
+				}
+			default:
+				return fmt.Errorf("unsupported export format %q", format)
+			}
+			if out == "" && isTerminal(cmd.OutOrStdout()) && !force {
+				return errors.New("refusing to write the export to a terminal, use --out or --force")
+			}
+
+			if format == exportFormatCSV || format == exportFormatPass {
+				log.Printf("WARNING: the %s export contains your passwords in plain text.\n", strings.ToUpper(format))
+				log.Println("Anyone with access to the exported data can read all of them.")
+				if !confirmPhrase(cmd.InOrStdin(), plaintextConfirmationPhrase) {
+					log.Println("Export cancelled.")
+					return nil
+				}
+			}
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			entries, err := vaultManager.List()
+			if err != nil {
+				return fmt.Errorf("failed to list password entries: %w", err)
+			}
+			entries = export.FilterByTag(entries, tag)
+
+			write := func(w io.Writer) error {
+				return export.WriteCSV(w, entries)
+			}
+			if format == exportFormatPsst {
+				var passphrase string
+				if passphrase, err = readNewPassphrase("export passphrase"); err != nil {
+					return err
+				}
+				write = func(w io.Writer) error {
+					return bundle.Write(w, entries, passphrase)
+				}
+			}
+
+			switch {
+			case format == exportFormatPass:
+				err = writeExportDir(out, func(dir string) error {
+					return export.WritePassTree(dir, entries)
+				})
+			case out == "":
+				return write(cmd.OutOrStdout())
+			default:
+				err = writeExportFile(out, write)
+			}
+			if err != nil {
+				return err
+			}
+			log.Printf("Exported %d entries to %s\n", len(entries), out)
+			return nil
+		},
+	}
+	exportCmd.Flags().String("format", exportFormatPsst, "Export format (psst, csv, pass)")
+	exportCmd.Flags().StringP("out", "o", "", "Output file, or directory for the pass format (default is stdout)")
+	exportCmd.Flags().String("tag", "", "Only export entries with this tag")
+	exportCmd.Flags().Bool("force", false, "Allow writing the export to a terminal")
+	return exportCmd
+}
+
+// writeExportFile creates path, readable and writable only by the current user, and writes to it with write.
+// An existing file is never overwritten.
+func writeExportFile(path string, write func(io.Writer) error) error {
+	//nolint:gosec // the path is provided by the user
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	if err = write(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	return f.Close()
+}
+
+// writeExportDir creates the directory at path, accessible only by the current user, and writes to it with write.
+// An existing directory is never written to, and the directory is removed if the export fails.
+func writeExportDir(path string, write func(dir string) error) error {
+	if err := os.Mkdir(path, 0o700); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	if err := write(path); err != nil {
+		_ = os.RemoveAll(path)
+		return fmt.Errorf("failed to write export directory: %w", err)
+	}
+	return nil
+}
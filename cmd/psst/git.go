@@ -0,0 +1,229 @@
+package psst
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/gitmirror"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/merge"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// defaultGitRemote is the git remote pushed to and pulled from when none is given.
+const defaultGitRemote = "origin"
+
+// GitCmd mirrors the vault to a git repository.
+func GitCmd() *cobra.Command {
+	gitCmd := &cobra.Command{
+		Use:   "git",
+		Short: "Mirror the vault to a git repository",
+		Long: `Mirror the vault to a git repository, giving it a history and replicating it
+through a git remote.
+
+Once enabled with 'psst git init', every change to the vault is exported to the
+git repository set by git_dir in the config and committed. Each entry and each
+deleted entry is a file named after the entry UUID, sealed with a key derived
+from the principal password: the remote never sees services, usernames or
+passwords, and commit messages don't name the changed entries.
+
+The repository can be shared by the copies of the vault used on other devices:
+'psst git push' publishes the changes of this vault and 'psst git pull' merges
+the changes published by the others. The git command must be installed.`,
+	}
+	gitCmd.AddCommand(gitInitCmd())
+	gitCmd.AddCommand(gitPushCmd())
+	gitCmd.AddCommand(gitPullCmd())
+	return gitCmd
+}
+
+// gitInitCmd enables mirroring the vault to git.
+func gitInitCmd() *cobra.Command {
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Start mirroring the vault to a git repository",
+		Long: `Create the git repository set by git_dir in the config, if needed, and commit
+the current content of the vault. From then on, every change to the vault is
+committed.
+
+The remote to push to and pull from is set with --remote, or later with git
+itself, e.g. 'git -C ~/.psst/git remote add origin <url>'. On another device,
+run 'psst git init' with the same remote, then 'psst git pull'.`,
+		Example: `  psst git init
+  psst git init --remote git@github.com:me/psst-vault.git`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			remote, _ := cmd.Flags().GetString("remote")
+			if cfg.GitDir == "" {
+				return errors.New("no git repository set, please set git_dir in the config")
+			}
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			repo, err := gitmirror.Init(cfg.GitDir)
+			if err != nil {
+				return err
+			}
+			if remote != "" {
+				if err = repo.AddRemote(defaultGitRemote, remote); err != nil {
+					return err
+				}
+			}
+			files, err := vaultManager.MirrorFiles()
+			if err != nil {
+				return err
+			}
+			if err = repo.Update(files, "Export the vault"); err != nil {
+				return err
+			}
+			log.Printf("The vault is mirrored to the git repository in %s\n", repo.Dir())
+			return nil
+		},
+	}
+	initCmd.Flags().String("remote", "", "URL of the git remote to push to and pull from")
+	return initCmd
+}
+
+// gitPushCmd pushes the git mirror of the vault.
+func gitPushCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "push [remote]",
+		Short: "Push the changes of the vault to a git remote",
+		Long: `Push the git mirror of the vault to the branch with the same name of the git
+remote, origin by default. If the remote has changes not pulled yet, the push
+is rejected: run 'psst git pull' first.
+
+Once pushed, the remote holds nothing this vault doesn't have: the push is
+recorded as a sync, so that the next pull only considers the changes pushed
+since then.`,
+		Example: `  psst git push`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			remote := gitRemote(args)
+			repo, err := openGitRepo()
+			if err != nil {
+				return err
+			}
+			if err = unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			// Changes made while the mirror was failing are committed before pushing.
+			files, err := vaultManager.MirrorFiles()
+			if err != nil {
+				return err
+			}
+			if err = repo.Update(files, "Export the vault"); err != nil {
+				return err
+			}
+			if err = repo.Push(remote); err != nil {
+				if errors.Is(err, gitmirror.ErrRejected) {
+					return fmt.Errorf("%w, please run 'psst git pull' first", err)
+				}
+				return err
+			}
+			if err = vaultManager.ApplySync(nil, nil, gitPeerID(remote)); err != nil {
+				return fmt.Errorf("pushed the vault, but failed to record the sync: %w", err)
+			}
+			log.Printf("Pushed the vault to %s\n", remote)
+			return nil
+		},
+	}
+}
+
+// gitPullCmd merges the changes pushed to a git remote into the vault.
+func gitPullCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "pull [remote]",
+		Short: "Merge the changes pushed to a git remote into the vault",
+		Long: `Fetch the branch with the same name of the git remote, origin by default, and
+merge the vault it mirrors into this vault.
+
+The files are merged entry by entry as with 'psst sync merge', not by git:
+entries changed both in this vault and on the remote since the last pull are
+conflicts, for each of them you are asked which version to keep. The result is
+committed as a merge of the remote branch, ready to be pushed.`,
+		Example: `  psst git pull`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			remote := gitRemote(args)
+			repo, err := openGitRepo()
+			if err != nil {
+				return err
+			}
+			if err = unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			if err = repo.Fetch(remote); err != nil {
+				return err
+			}
+			branch, err := repo.Branch()
+			if err != nil {
+				return err
+			}
+			ref := remote + "/" + branch
+			files, found, err := repo.Files("refs/remotes/" + ref)
+			if err != nil {
+				return err
+			}
+			if !found {
+				log.Printf("Nothing to pull, %s doesn't exist yet\n", ref)
+				return nil
+			}
+			entries, tombstones, err := vaultManager.ReadMirrorFiles(files)
+			if errors.Is(err, vault.ErrMirrorVault) {
+				return fmt.Errorf("cannot pull %s: %w", ref, err)
+			}
+			if err != nil {
+				return err
+			}
+
+			other := merge.Snapshot{Entries: entries, Tombstones: tombstones}
+			if err = mergeSnapshot(cmd, ref, gitPeerID(remote), other); err != nil {
+				return err
+			}
+			// The merge is already committed by the mirror, unless it failed: commit it again to be sure
+			// the merge commit doesn't record a stale vault.
+			if files, err = vaultManager.MirrorFiles(); err != nil {
+				return err
+			}
+			if err = repo.Update(files, "Merge the changes of "+ref); err != nil {
+				return err
+			}
+			return repo.MergeOurs("refs/remotes/" + ref)
+		},
+	}
+}
+
+// gitRemote returns the remote given as argument, or the default one.
+func gitRemote(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return defaultGitRemote
+}
+
+// gitPeerID returns the peer ID recording the last sync with a git remote.
+func gitPeerID(remote string) string {
+	return "git:" + remote
+}
+
+// openGitRepo opens the git repository mirroring the vault.
+func openGitRepo() (*gitmirror.Repo, error) {
+	if cfg.GitDir == "" {
+		return nil, errors.New("no git repository set, please set git_dir in the config")
+	}
+	repo, err := gitmirror.Open(cfg.GitDir)
+	if errors.Is(err, gitmirror.ErrNotInitialized) {
+		return nil, errors.New("the vault isn't mirrored to git, please run 'psst git init' first")
+	}
+	return repo, err
+}
@@ -0,0 +1,262 @@
+package psst
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/bundle"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/importer"
+)
+
+// Import formats, besides the .psst bundle.
+const (
+	// importFormatBitwarden imports an unencrypted Bitwarden JSON export.
+	importFormatBitwarden = "bitwarden"
+	// importFormatKDBX imports a KeePass KDBX 4 database.
+	importFormatKDBX = "kdbx"
+	// importFormatChrome imports a Chrome passwords CSV export.
+	importFormatChrome = "chrome"
+	// importFormatFirefox imports a Firefox passwords CSV export.
+	importFormatFirefox = "firefox"
+	// importFormatPass imports a password store managed by pass.
+	importFormatPass = "pass"
+)
+
+// passDecrypter decrypts the files of a password store.
+var passDecrypter importer.Decrypter = &importer.GPG{}
+
+// SetPassDecrypter sets the decrypter used to import password stores.
+// This is used for testing purposes.
+func SetPassDecrypter(d importer.Decrypter) {
+	passDecrypter = d
+}
+
+// importOptions holds the flags used by some of the import formats.
+type importOptions struct {
+	keyFile string
+}
+
+// importers maps each import format to the function reading its entries from a file.
+var importers = map[string]func(path string, opts importOptions) (*importer.Result, error){
+	exportFormatPsst:      readBundle,
+	importFormatBitwarden: parseFile(importer.ParseBitwarden),
+	importFormatKDBX:      readKDBX,
+	importFormatChrome:    parseFile(importer.ParseBrowserCSV),
+	importFormatFirefox:   parseFile(importer.ParseBrowserCSV),
+	importFormatPass:      readPassStore,
+}
+
+// ImportCmd imports password entries from a file.
+func ImportCmd() *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import password entries",
+		Long: `Import password entries from a file into the vault.
+
+Supported formats:
+  psst       encrypted bundle created with 'psst export --format psst'
+  bitwarden  unencrypted Bitwarden JSON export, only login items are imported
+  kdbx       KeePass or KeePassXC KDBX 4 database, groups are imported as tags
+  chrome     Chrome passwords CSV export
+  firefox    Firefox passwords CSV export
+  pass       password store directory, decrypted with gpg, directories are
+             imported as tags
+
+Browser logins are named after the registrable domain of their URL, e.g.
+google.com for https://accounts.google.com. Logins of the same domain and
+username are merged, while different usernames of the same domain are imported
+as "<domain> (<username>)".
+
+Before importing, each entry is compared with the vault entry of the same
+service and the import plan is printed: "+" marks new entries, "=" entries
+identical to the vault ones and "~" conflicting entries, with the fields that
+differ. Conflicts are resolved with --on-conflict:
+  skip       keep the vault entry (default)
+  overwrite  replace the vault entry with the imported one
+  rename     import the entry as "<service> (2)"
+  newest     keep the most recently modified entry
+
+Only the psst format carries the kind of the entries, and only some formats
+their OTP secret or custom fields: the vault entries keep those the format
+can't carry when overwritten, and entries other than logins, such as cards,
+are only overwritten by the psst format.
+
+The plan is applied in a single transaction: either every entry is imported or
+none is. Items that can't be imported are reported and skipped. With --dry-run,
+only the plan is printed and the vault isn't changed.`,
+		Example: `  psst import --format psst vault.psst
+  psst import --format bitwarden --dry-run bitwarden_export.json
+  psst import --format kdbx --keyfile keepass.keyx passwords.kdbx
+  psst import --format chrome --dry-run "Chrome Passwords.csv"
+  psst import --format bitwarden --on-conflict newest bitwarden_export.json
+  psst import --format pass ~/.password-store`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			onConflict, _ := cmd.Flags().GetString("on-conflict")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			keyFile, _ := cmd.Flags().GetString("keyfile")
+
+			read, ok := importers[format]
+			if !ok {
+				return fmt.Errorf("unsupported import format %q", format)
+			}
+			if keyFile != "" && format != importFormatKDBX {
+				return errors.New("--keyfile is only supported by the kdbx format")
+			}
+			strategy, err := importer.ParseStrategy(onConflict)
+			if err != nil {
+				return err
+			}
+
+			if err = unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			result, err := read(args[0], importOptions{keyFile: keyFile})
+			if err != nil {
+				return err
+			}
+			for _, itemErr := range result.Errors {
+				log.Printf("Warning: %s\n", itemErr)
+			}
+			return importEntries(cmd.OutOrStdout(), result, strategy, dryRun)
+		},
+	}
+	importCmd.Flags().String("format", exportFormatPsst, "Import format (psst, bitwarden, kdbx, chrome, firefox, pass)")
+	importCmd.Flags().String("keyfile", "", "Key file protecting the KeePass database")
+	importCmd.Flags().String("on-conflict", string(importer.StrategySkip),
+		"How to import entries conflicting with the vault ones (skip, overwrite, rename, newest)")
+	importCmd.Flags().Bool("dry-run", false, "Preview the import without changing the vault")
+	return importCmd
+}
+
+// importEntries plans the import of the entries of result into the unlocked vault, resolving conflicts with strategy,
+// and prints the plan to w. Unless dryRun is true, the plan is then applied in a single transaction.
+func importEntries(w io.Writer, result *importer.Result, strategy importer.Strategy, dryRun bool) error {
+	existing, err := vaultManager.List()
+	if err != nil {
+		return fmt.Errorf("failed to list password entries: %w", err)
+	}
+
+	plan := importer.NewPlan(result.Entries, existing, strategy, result.Extras)
+	for _, itemErr := range plan.Errors {
+		log.Printf("Warning: %s\n", itemErr)
+	}
+	if err = printPlan(w, plan); err != nil {
+		return err
+	}
+
+	added, updated, skipped := plan.Count(importer.ActionAdd), plan.Count(importer.ActionUpdate),
+		plan.Count(importer.ActionSkip)
+	if dryRun {
+		log.Printf("Dry run, nothing imported: %d to add, %d to update, %d to skip\n", added, updated, skipped)
+		return nil
+	}
+	if err = vaultManager.ImportAll(plan.Entries(time.Now().UTC())); err != nil {
+		return fmt.Errorf("failed to import entries: %w", err)
+	}
+	log.Printf("Imported %d entries: %d added, %d updated, %d skipped\n", len(plan.Items), added, updated, skipped)
+	return nil
+}
+
+// printPlan writes a line per item of the plan: "+" for new entries, "=" for entries identical to the vault ones
+// and "~" for conflicting entries, followed by the differing fields and how the conflict is resolved.
+func printPlan(w io.Writer, plan *importer.Plan) error {
+	for _, item := range plan.Items {
+		var err error
+		switch item.Status {
+		case importer.StatusNew:
+			_, err = fmt.Fprintf(w, "+ %s\n", item.Service)
+		case importer.StatusIdentical:
+			_, err = fmt.Fprintf(w, "= %s\n", item.Service)
+		case importer.StatusConflict:
+			_, err = fmt.Fprintf(w, "~ %s: %s changed, %s\n",
+				item.Service, strings.Join(item.Diff, ", "), describeAction(item))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to print import plan: %w", err)
+		}
+	}
+	return nil
+}
+
+// describeAction describes how the conflict of item is resolved.
+func describeAction(item *importer.PlanItem) string {
+	switch {
+	case item.Action == importer.ActionUpdate:
+		return "overwrite"
+	case item.Action == importer.ActionAdd && item.Entry.Service != item.Service:
+		return "import as " + strconv.Quote(item.Entry.Service)
+	default:
+		return "skip"
+	}
+}
+
+// parseFile adapts a parser reading from an io.Reader to read from the file at path.
+func parseFile(
+	parse func(io.Reader) (*importer.Result, error),
+) func(path string, opts importOptions) (*importer.Result, error) {
+	return func(path string, _ importOptions) (*importer.Result, error) {
+		//nolint:gosec // the path is provided by the user
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open import file: %w", err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Printf("failed to close import file: %v", err)
+			}
+		}()
+		return parse(f)
+	}
+}
+
+// readBundle reads the entries of a .psst bundle, asking for its export passphrase.
+func readBundle(path string, opts importOptions) (*importer.Result, error) {
+	passphrase, err := passwordReader("Enter export passphrase: ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export passphrase: %w", err)
+	}
+	return parseFile(func(r io.Reader) (*importer.Result, error) {
+		entries, err := bundle.Read(r, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return &importer.Result{Entries: entries, Extras: importer.ExtrasAll}, nil
+	})(path, opts)
+}
+
+// readKDBX reads the entries of a KeePass database, asking for its password.
+// The password can be left empty when the database is only protected by a key file.
+func readKDBX(path string, opts importOptions) (*importer.Result, error) {
+	var keyFile []byte
+	if opts.keyFile != "" {
+		var err error
+		//nolint:gosec // the path is provided by the user
+		if keyFile, err = os.ReadFile(opts.keyFile); err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+	}
+	password, err := passwordReader("Enter KeePass password: ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KeePass password: %w", err)
+	}
+	return parseFile(func(r io.Reader) (*importer.Result, error) {
+		return importer.ParseKDBX(r, password, keyFile)
+	})(path, opts)
+}
+
+// readPassStore reads the entries of the password store at path, decrypting its files with passDecrypter.
+func readPassStore(path string, _ importOptions) (*importer.Result, error) {
+	return importer.ParsePass(path, passDecrypter)
+}
@@ -0,0 +1,108 @@
+package psst
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/secretref"
+)
+
+// InjectCmd renders a template, replacing the secret references it contains with their values.
+func InjectCmd() *cobra.Command {
+	injectCmd := &cobra.Command{
+		Use:   "inject",
+		Short: "Replace the secret references of a file with their values",
+		Long: `Replace every secret reference of a text file with the value of the entry field
+it references, so that configuration files can be committed with references
+instead of secrets. A reference is written as:
+
+  psst://<service>[/<field>]
+
+The field defaults to the password, it can be one of: password, username, url,
+notes, otp, the current two-factor code, e.g. psst://github/otp, or the name of
+a custom field of the entry, e.g. psst://bank/pin. Characters other than
+letters, digits and "-._~@:+" are percent-encoded, e.g. psst://my%20bank/pin.
+
+The template is read from the standard input and written to the standard output
+unless --in and --out are given. The output file is created readable only by
+the current user. An existing file is never overwritten. The vault is only
+unlocked if the template contains references.`,
+		Example: `  psst inject -i config.tpl -o config.yml
+  echo "DB_PASS=psst://prod-db" | psst inject > .env
+  echo "DB_HOST=psst://prod-db/host" | psst inject >> .env`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			in, _ := cmd.Flags().GetString("in")
+			out, _ := cmd.Flags().GetString("out")
+			template, err := readTemplate(cmd, in)
+			if err != nil {
+				return err
+			}
+
+			rendered := template
+			if refs := secretref.Find(template); len(refs) > 0 {
+				if err = unlockVault(); err != nil {
+					return err
+				}
+				rendered, err = secretref.Render(vaultManager, template)
+				if err == nil {
+					markUsed(referencedServices(refs)...)
+				}
+				closeVaultManager()
+				if err != nil {
+					return err
+				}
+			}
+
+			if out == "" {
+				_, err = io.WriteString(cmd.OutOrStdout(), rendered)
+				return err
+			}
+			if err = writeExportFile(out, func(w io.Writer) error {
+				_, writeErr := io.WriteString(w, rendered)
+				return writeErr
+			}); err != nil {
+				return err
+			}
+			log.Printf("Rendered %s\n", out)
+			return nil
+		},
+	}
+	injectCmd.Flags().StringP("in", "i", "", "Template to read (default is the standard input)")
+	injectCmd.Flags().StringP("out", "o", "", "File to write (default is the standard output)")
+	return injectCmd
+}
+
+// referencedServices returns the services of the secret references refs, found in a template.
+func referencedServices(refs []string) []string {
+	services := make([]string, 0, len(refs))
+	for _, text := range refs {
+		// Render already parsed every reference.
+		if ref, err := secretref.Parse(text); err == nil {
+			services = append(services, ref.Service)
+		}
+	}
+	return services
+}
+
+// readTemplate reads the template at path, or the standard input of cmd if path is empty.
+func readTemplate(cmd *cobra.Command, path string) (string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		//nolint:gosec // the path is provided by the user
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read template: %w", err)
+	}
+	return string(data), nil
+}
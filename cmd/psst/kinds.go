@@ -0,0 +1,106 @@
+package psst
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// entryTypes returns the names of the types of entries added with psst add.
+func entryTypes() []string {
+	var names []string
+	for _, schema := range vault.Schemas() {
+		if schema.Kind != model.KindSSHKey {
+			names = append(names, schema.Name)
+		}
+	}
+	return names
+}
+
+// entryType returns the name of the type of the entry.
+func entryType(entry *model.PasswordEntry) string {
+	if schema := vault.SchemaOf(entry.Kind); schema != nil {
+		return schema.Name
+	}
+	return entry.Kind
+}
+
+// promptHiddenFields prompts for the hidden fields of the schema the entry doesn't have, except for the body of notes,
+// which is set with --notes. Optional fields left empty are omitted.
+func promptHiddenFields(schema *vault.Schema, entry *model.PasswordEntry) error {
+	for _, field := range schema.Fields {
+		if field.Type != model.FieldTypeHidden || field.Name == vault.FieldNoteBody || entryField(entry, field.Name) != "" {
+			continue
+		}
+		value, err := passwordReader(fmt.Sprintf("Enter %s %s: ", schema.Name, field.Name))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", field.Name, err)
+		}
+		if value != "" {
+			entry.Fields = append(entry.Fields, model.EntryField{Name: field.Name, Type: field.Type, Value: value})
+		}
+	}
+	return nil
+}
+
+// entryField returns the value of the custom field of the entry with the given name, empty if the entry has none.
+func entryField(entry *model.PasswordEntry, name string) string {
+	for _, field := range entry.Fields {
+		if field.Name == name {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// entrySummary returns a one line summary of the entry, with no secret: the username of logins, the last digits of
+// cards, the name of identities and the SSID of Wi-Fi networks.
+func entrySummary(entry *model.PasswordEntry) string {
+	switch entry.Kind {
+	case model.KindNote:
+		return ""
+	case model.KindCard:
+		number := entryField(entry, vault.FieldCardNumber)
+		return fmt.Sprintf("**** %s  %s", number[max(len(number)-4, 0):], entryField(entry, vault.FieldCardExpiry))
+	case model.KindIdentity:
+		return entryField(entry, vault.FieldIdentityName)
+	case model.KindWiFi:
+		return entryField(entry, vault.FieldWiFiSSID)
+	}
+	return entry.Username
+}
+
+// renderEntry renders the entries other than logins whole: the body of notes, and the fields of the other kinds,
+// those of their schema first, followed by the Wi-Fi QR code string of Wi-Fi networks.
+func renderEntry(entry *model.PasswordEntry) string {
+	if entry.Kind == model.KindNote {
+		return strings.TrimRight(entryField(entry, vault.FieldNoteBody), "\n")
+	}
+	var lines []string
+	add := func(name, value string) {
+		if value != "" {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	add(vault.FieldUsername, entry.Username)
+	add(vault.FieldPassword, entry.Password)
+	add(vault.FieldURL, entry.URL)
+	schema := vault.SchemaOf(entry.Kind)
+	if schema != nil {
+		for _, field := range schema.Fields {
+			add(field.Name, entryField(entry, field.Name))
+		}
+	}
+	for _, field := range entry.Fields {
+		if schema == nil || schema.Field(field.Name) == nil {
+			add(field.Name, field.Value)
+		}
+	}
+	add(vault.FieldNotes, entry.Notes)
+	if entry.Kind == model.KindWiFi {
+		add(vault.FieldWiFiQR, vault.WiFiQR(entry))
+	}
+	return strings.Join(lines, "\n")
+}
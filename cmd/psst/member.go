@@ -0,0 +1,168 @@
+package psst
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/share"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// MemberCmd manages the members able to unlock the vault.
+func MemberCmd() *cobra.Command {
+	memberCmd := &cobra.Command{
+		Use:   "member",
+		Short: "Manage the people able to unlock the vault",
+		Long: `A vault can be shared by a team: every member unlocks it either with their own
+password or with the identity of their own vault, without knowing the password
+of the others. The vault key is wrapped separately for every member.
+
+The principal password chosen with 'psst init' is the password of the first
+member, named "owner". To unlock the vault with the identity of your own vault,
+set identity_vault in the config to the path of your vault: the principal
+password then unlocks your vault, whose identity unlocks this one.`,
+	}
+	memberCmd.AddCommand(memberAddCmd(), memberRemoveCmd(), memberListCmd())
+	return memberCmd
+}
+
+// memberAddCmd adds a member to the vault.
+func memberAddCmd() *cobra.Command {
+	addCmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Add a member to the vault",
+		Long: `Add a member to the vault. The member unlocks the vault with the password
+prompted for, to be chosen by them, or with the identity of their own vault if
+its public key, printed by their 'psst identity export', is given.`,
+		Example: `  psst member add alice
+  psst member add bob --public-key psst-pub-3q2h...`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			publicKey, _ := cmd.Flags().GetString("public-key")
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			var err error
+			if publicKey != "" {
+				key, parseErr := share.ParsePublicKey(publicKey)
+				if parseErr != nil {
+					return parseErr
+				}
+				err = vaultManager.AddIdentityMember(name, key)
+			} else {
+				password, readErr := readNewPassphrase(fmt.Sprintf("password of %s", name))
+				if readErr != nil {
+					return readErr
+				}
+				err = vaultManager.AddPasswordMember(name, password)
+			}
+			if errors.Is(err, vault.ErrMemberExists) {
+				return fmt.Errorf("member %s already exists", name)
+			}
+			if err != nil {
+				return err
+			}
+			log.Printf("Added %s to the vault members\n", name)
+			return nil
+		},
+	}
+	addCmd.Flags().String("public-key", "", "Public key of the member vault, see 'psst identity export'")
+	return addCmd
+}
+
+// memberRemoveCmd removes a member from the vault and rotates the vault key.
+func memberRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a member from the vault",
+		Long: `Remove a member from the vault, then rotate the vault key and identity: every
+password is re-encrypted with a new key, wrapped for the remaining members only
+by a new identity keypair. The password of every remaining password member is
+prompted for, to bind their key to the new identity.
+
+The removed member can still open any copy of the vault made before, including
+its git mirror history, and knows every password they have seen: change the
+passwords they had access to. Copies of the vault keep the old key and members,
+so replace them with this vault: mirrors pushed by a copy can't be pulled here
+anymore.
+
+Entries shared with the previous public key of the vault can't be received
+anymore, and the vaults this one is an identity member of must add it again:
+send them the new public key, printed once the member is removed.`,
+		Example: `  psst member remove alice`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			name := args[0]
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			members, err := vaultManager.Members()
+			if err != nil {
+				return err
+			}
+			passwords := make(map[string]string)
+			for _, member := range members {
+				if member.Name == name || member.Kind != model.MemberPassword {
+					continue
+				}
+				password, readErr := passwordReader(fmt.Sprintf("Enter password of %s: ", member.Name))
+				if readErr != nil {
+					return fmt.Errorf("failed to read the password of %s: %w", member.Name, readErr)
+				}
+				passwords[member.Name] = password
+			}
+
+			err = vaultManager.RemoveMember(name, passwords)
+			if errors.Is(err, vault.ErrMemberNotFound) {
+				return fmt.Errorf("no member named %s", name)
+			}
+			if err != nil {
+				return err
+			}
+			publicKey, err := vaultManager.IdentityPublicKey()
+			if err != nil {
+				return err
+			}
+			log.Printf("Removed %s from the vault members and rotated the vault key\n", name)
+			log.Printf("The public key of the vault is now %s\n", share.EncodePublicKey(publicKey))
+			return nil
+		},
+	}
+}
+
+// memberListCmd lists the vault members.
+func memberListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "List the vault members",
+		Long:    `List the vault members, how they unlock the vault and their public key.`,
+		Example: `  psst member list`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			members, err := vaultManager.Members()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			for _, member := range members {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", member.Name, member.Kind, member.PublicKey)
+			}
+			return w.Flush()
+		},
+	}
+}
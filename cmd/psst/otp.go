@@ -0,0 +1,133 @@
+package psst
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/clipboard"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/otp"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// OTPCmd generates the one-time passwords of the entries with an OTP secret.
+func OTPCmd() *cobra.Command {
+	otpCmd := &cobra.Command{
+		Use:   "otp <service>",
+		Short: "Generate the one-time password of an entry",
+		Long: `Print the current code of the TOTP or HOTP secret of the entry of the service,
+and how long it remains valid. HOTP codes remain valid until used: the counter
+of the secret is incremented every time a code is generated, also through
+psst://<service>/otp references, which modifies the entry. When merging vaults
+with 'psst sync', the highest counter of the two is kept, so generating codes in
+both vaults isn't a conflict and no code is ever generated twice.
+
+Set the secret of an entry with --set: paste the otpauth:// URI, encoded in the
+QR code shown when enabling two-factor authentication, or the bare base32
+secret of a TOTP key. Remove it with --remove.`,
+		Example: `  psst otp github
+  psst otp github --copy
+  psst otp github --set`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			service := args[0]
+			set, _ := cmd.Flags().GetBool("set")
+			remove, _ := cmd.Flags().GetBool("remove")
+			copyCode, _ := cmd.Flags().GetBool("copy")
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			var err error
+			switch {
+			case set:
+				err = setOTP(service)
+			case remove:
+				err = removeOTP(service)
+			default:
+				err = printOTP(cmd, service, copyCode)
+			}
+			if errors.Is(err, vault.ErrNotFound) {
+				return fmt.Errorf("no password entry for service %s", service)
+			}
+			if errors.Is(err, vault.ErrNoOTP) {
+				return fmt.Errorf("the entry of service %s has no OTP secret, set one with --set", service)
+			}
+			return err
+		},
+	}
+	otpCmd.Flags().BoolP("copy", "c", false, "Copy the code to the clipboard instead of printing it")
+	otpCmd.Flags().Bool("set", false, "Set the OTP secret of the entry, prompted for")
+	otpCmd.Flags().Bool("remove", false, "Remove the OTP secret of the entry")
+	otpCmd.MarkFlagsMutuallyExclusive("copy", "set", "remove")
+	return otpCmd
+}
+
+// printOTP prints, or copies, the current code of the OTP secret of the service.
+func printOTP(cmd *cobra.Command, service string, copyCode bool) error {
+	code, remaining, err := vaultManager.OTPCode(service, time.Now())
+	if err != nil {
+		return err
+	}
+	validity := "until used"
+	if remaining > 0 {
+		validity = fmt.Sprintf("for %s", remaining)
+	}
+	if copyCode {
+		if err = clipboard.Copy(code); err != nil {
+			return err
+		}
+		log.Printf("Copied the code of %s to the clipboard, valid %s\n", service, validity)
+		return nil
+	}
+	if _, err = fmt.Fprintln(cmd.OutOrStdout(), code); err != nil {
+		return err
+	}
+	log.Printf("Valid %s\n", validity)
+	return nil
+}
+
+// setOTP sets the OTP secret of the service to the URI or base32 secret prompted for.
+func setOTP(service string) error {
+	entry, err := vaultManager.Read(service)
+	if err != nil {
+		return err
+	}
+	secret, err := passwordReader("Enter otpauth:// URI or base32 secret: ")
+	if err != nil {
+		return fmt.Errorf("failed to read OTP secret: %w", err)
+	}
+	key, err := otp.ParseSecret(service, secret)
+	if err != nil {
+		return err
+	}
+	entry.OTP = key.URI()
+	if err = vaultManager.Update(entry); err != nil {
+		return err
+	}
+	log.Printf("Set the %s secret of service %s\n", strings.ToUpper(key.Type), service)
+	return nil
+}
+
+// removeOTP removes the OTP secret of the service.
+func removeOTP(service string) error {
+	entry, err := vaultManager.Read(service)
+	if err != nil {
+		return err
+	}
+	if entry.OTP == "" {
+		return vault.ErrNoOTP
+	}
+	entry.OTP = ""
+	if err = vaultManager.Update(entry); err != nil {
+		return err
+	}
+	log.Printf("Removed the OTP secret of service %s\n", service)
+	return nil
+}
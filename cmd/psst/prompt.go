@@ -0,0 +1,167 @@
+package psst
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/db"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// passwordReader reads a password from the terminal without echoing it.
+// When the standard input isn't a terminal, e.g. when it is a template or a git credential request, the password is
+// read from the controlling terminal instead, if any.
+var passwordReader = func(prompt string) (string, error) {
+	fd := int(syscall.Stdin)
+	if !term.IsTerminal(fd) {
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			defer func() { _ = tty.Close() }()
+			fd = int(tty.Fd())
+		}
+	}
+	log.Print(prompt)
+	p, err := term.ReadPassword(fd)
+	log.Println()
+	if err != nil {
+		return "", err
+	}
+	return string(p), nil
+}
+
+// SetPasswordReader sets the function used to read passwords, principal password included.
+// This is used for testing purposes.
+func SetPasswordReader(r func(prompt string) (string, error)) {
+	passwordReader = r
+}
+
+// unlockVault connects to the vault and unlocks it with the principal password.
+// On success, the vault manager must be closed by the caller with closeVaultManager.
+func unlockVault() error {
+	_, err := unlockVaultPassword()
+	return err
+}
+
+// unlockVaultPassword is like unlockVault, but also returns the principal password.
+func unlockVaultPassword() (string, error) {
+	if _, err := os.Stat(cfg.DBPath); err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.New("vault not found, please initialize it with the 'init' command")
+		}
+		return "", fmt.Errorf("failed to check vault file: %w", err)
+	}
+
+	initVaultManager()
+	if vaultManager == nil {
+		return "", errors.New("failed to connect to the vault")
+	}
+
+	password, err := passwordReader("Enter principal password: ")
+	if err != nil {
+		closeVaultManager()
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+
+	unlocked, err := unlockMember(password)
+	if errors.Is(err, vault.ErrIntegrity) {
+		closeVaultManager()
+		return "", fmt.Errorf("%w: the vault file may have been modified outside psst, restore it from a backup", err)
+	}
+	if !unlocked {
+		closeVaultManager()
+		if err != nil {
+			return "", fmt.Errorf("failed to unlock vault: %w", err)
+		}
+		return "", errors.New("wrong principal password")
+	}
+	if err != nil {
+		log.Printf("Warning: %s\n", err)
+	}
+	return password, nil
+}
+
+// unlockMember unlocks the vault with password as a password member or, if identity_vault is set in the config,
+// as the identity member of the vault at that path, itself unlocked with password.
+func unlockMember(password string) (bool, error) {
+	if cfg.IdentityVault == "" {
+		return vaultManager.Unlock(password)
+	}
+	if _, err := os.Stat(cfg.IdentityVault); err != nil {
+		return false, fmt.Errorf("failed to open identity vault: %w", err)
+	}
+	d, err := db.NewDatabase(cfg.IdentityVault)
+	if err != nil {
+		return false, fmt.Errorf("failed to open identity vault: %w", err)
+	}
+	personal := vault.NewManager(d)
+	defer personal.Close()
+
+	unlocked, err := personal.Unlock(password)
+	if !unlocked {
+		if err != nil {
+			return false, fmt.Errorf("failed to unlock identity vault: %w", err)
+		}
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("Warning: %s\n", err)
+	}
+	if unlocked, err = vaultManager.UnlockWithIdentity(personal); !unlocked && err == nil {
+		return false, fmt.Errorf("the identity of %s is not a member of the vault", cfg.IdentityVault)
+	}
+	return unlocked, err
+}
+
+// readNewPassphrase reads a new passphrase, described by name, and its confirmation.
+// The passphrase must be at least minPrincipalPasswordLength characters long.
+func readNewPassphrase(name string) (string, error) {
+	passphrase, err := passwordReader(fmt.Sprintf("Enter %s: ", name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if len(passphrase) < minPrincipalPasswordLength {
+		return "", fmt.Errorf("%s must be at least %d characters long", name, minPrincipalPasswordLength)
+	}
+	confirmation, err := passwordReader(fmt.Sprintf("Confirm %s: ", name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if passphrase != confirmation {
+		return "", fmt.Errorf("%s does not match", name)
+	}
+	return passphrase, nil
+}
+
+// closeVaultManager closes and forgets the vault manager, if any.
+func closeVaultManager() {
+	if vaultManager == nil {
+		return
+	}
+	vaultManager.Close()
+	vaultManager = nil
+}
+
+// confirmPhrase asks the user to type phrase to confirm a sensitive operation.
+// It returns true only if the line read from r matches phrase exactly.
+func confirmPhrase(r io.Reader, phrase string) bool {
+	log.Printf("Type '%s' to continue: ", phrase)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Error reading confirmation: %s\n", err)
+		return false
+	}
+	return strings.TrimSpace(line) == phrase
+}
+
+// isTerminal reports whether w writes to a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
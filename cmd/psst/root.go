@@ -25,6 +25,9 @@ func RootCmd() *cobra.Command {
 		Long: `A secure command-line password manager that stores your passwords
 locally in an encrypted database. Your passwords never leave your machine
 except when you explicitly export them.`,
+		// Errors are printed by the caller of Execute.
+		SilenceErrors: true,
+		SilenceUsage:  true,
 	}
 	cmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.psst/config.yaml)")
 	cmd.PersistentFlags().BoolP("verbose", "v", false, "enable verbose output")
@@ -34,6 +37,23 @@ except when you explicitly export them.`,
 	cmd.AddCommand(UpdateCmd())
 	cmd.AddCommand(DeleteCmd())
 	cmd.AddCommand(InitCmd())
+	cmd.AddCommand(ExportCmd())
+	cmd.AddCommand(ImportCmd())
+	cmd.AddCommand(SyncCmd())
+	cmd.AddCommand(GitCmd())
+	cmd.AddCommand(IdentityCmd())
+	cmd.AddCommand(ShareCmd())
+	cmd.AddCommand(ReceiveCmd())
+	cmd.AddCommand(MemberCmd())
+	cmd.AddCommand(ExecCmd())
+	cmd.AddCommand(InjectCmd())
+	cmd.AddCommand(GitCredentialCmd())
+	cmd.AddCommand(DockerCredentialCmd())
+	cmd.AddCommand(SSHKeyCmd())
+	cmd.AddCommand(SSHAgentCmd())
+	cmd.AddCommand(OTPCmd())
+	cmd.AddCommand(AttachCmd())
+	cmd.AddCommand(SearchCmd())
 	return cmd
 }
 
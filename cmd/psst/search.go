@@ -0,0 +1,64 @@
+package psst
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// SearchCmd searches the entries of the vault.
+func SearchCmd() *cobra.Command {
+	searchCmd := &cobra.Command{
+		Use:   "search [query]",
+		Short: "Search the password entries",
+		Long: `Search the entries whose service, username, URL or tags fuzzy match every word
+of the query, and their notes with --notes. Characters of a word match in order,
+even if not consecutive: "gml" matches gmail. The best matches are listed first,
+recently used entries ranking higher: reading a secret of an entry with psst
+get, exec or inject counts as using it.
+
+Without a query, the entries with the tags of --tag are listed.`,
+		Example: `  psst search google
+  psst search git --tag work
+  psst search "recovery codes" --notes`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := strings.Join(args, " ")
+			tags, _ := cmd.Flags().GetStringSlice("tag")
+			notes, _ := cmd.Flags().GetBool("notes")
+			limit, _ := cmd.Flags().GetInt("limit")
+			if strings.TrimSpace(query) == "" && len(tags) == 0 {
+				return errors.New("a query or a --tag is required")
+			}
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			results, err := vaultManager.Search(query, vault.SearchOptions{Tags: tags, Notes: notes, Limit: limit})
+			if err != nil {
+				return err
+			}
+			if len(results) == 0 {
+				log.Println("No entries found")
+				return nil
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			for _, result := range results {
+				entry := result.Entry
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Service, entryType(entry), entrySummary(entry))
+			}
+			return w.Flush()
+		},
+	}
+	searchCmd.Flags().StringSlice("tag", nil, "Only search the entries with all these tags (comma-separated)")
+	searchCmd.Flags().Bool("notes", false, "Also search the notes of the entries")
+	searchCmd.Flags().Int("limit", 20, "Maximum number of results, 0 for all")
+	return searchCmd
+}
@@ -0,0 +1,158 @@
+package psst
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/share"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// IdentityCmd manages the identity of the vault, used to share entries.
+func IdentityCmd() *cobra.Command {
+	identityCmd := &cobra.Command{
+		Use:   "identity",
+		Short: "Manage the identity used to share entries",
+		Long: `Every vault has an X25519 identity keypair. Its public key is given to the
+people sharing entries with you, with 'psst share', while its private key never
+leaves the vault, encrypted with the vault key.`,
+	}
+	identityCmd.AddCommand(identityExportCmd())
+	return identityCmd
+}
+
+// identityExportCmd prints the identity public key of the vault.
+func identityExportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "export",
+		Short:   "Print the public key of the vault",
+		Long:    `Print the identity public key of the vault, to give to the people sharing entries with you.`,
+		Example: `  psst identity export`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			key, err := vaultManager.IdentityPublicKey()
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), share.EncodePublicKey(key))
+			return nil
+		},
+	}
+}
+
+// ShareCmd seals an entry for another vault.
+func ShareCmd() *cobra.Command {
+	shareCmd := &cobra.Command{
+		Use:   "share <service>",
+		Short: "Share an entry with someone else",
+		Long: `Seal the entry of a service for the owner of another vault, identified by the
+public key printed by their 'psst identity export'. The sealed file can only be
+opened by their vault, with 'psst receive', and proves it was sealed by this
+vault. It can be sent through any channel: no password is shared and no server
+is involved.
+
+The file is created readable only by the current user. An existing file is never
+overwritten.`,
+		Example: `  psst share github --to psst-pub-3q2h... --out github` + share.Extension,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			service := args[0]
+			to, _ := cmd.Flags().GetString("to")
+			out, _ := cmd.Flags().GetString("out")
+			recipient, err := share.ParsePublicKey(to)
+			if err != nil {
+				return err
+			}
+
+			if err = unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			data, err := vaultManager.ShareEntry(service, recipient)
+			if errors.Is(err, vault.ErrNotFound) {
+				return fmt.Errorf("no password entry for service %s", service)
+			}
+			if err != nil {
+				return err
+			}
+			if err = writeExportFile(out, func(w io.Writer) error {
+				_, writeErr := w.Write(data)
+				return writeErr
+			}); err != nil {
+				return err
+			}
+			log.Printf("Shared %s in %s\n", service, out)
+			return nil
+		},
+	}
+	shareCmd.Flags().String("to", "", "Public key of the recipient, see 'psst identity export'")
+	shareCmd.Flags().StringP("out", "o", "", "File to write the shared entry to")
+	for _, flag := range []string{"to", "out"} {
+		if err := shareCmd.MarkFlagRequired(flag); err != nil {
+			log.Println(err)
+		}
+	}
+	return shareCmd
+}
+
+// ReceiveCmd adds an entry shared by someone else to the vault.
+func ReceiveCmd() *cobra.Command {
+	receiveCmd := &cobra.Command{
+		Use:   "receive <file>",
+		Short: "Add an entry shared with you to the vault",
+		Long: `Open an entry shared with this vault by 'psst share' and add it to the vault.
+The public key of the vault that shared it is printed: check it is the one of
+the person you expect.
+
+An entry with the same service is never replaced: receive the entry under
+another service name with --as.`,
+		Example: `  psst receive github` + share.Extension + `
+  psst receive github` + share.Extension + ` --as github-team`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			as, _ := cmd.Flags().GetString("as")
+			//nolint:gosec // the path is provided by the user
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read shared entry: %w", err)
+			}
+
+			if err = unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			entry, sender, err := vaultManager.OpenSharedEntry(data)
+			if err != nil {
+				return err
+			}
+			if as != "" {
+				entry.Service = as
+			}
+			if _, err = vaultManager.Read(entry.Service); err == nil {
+				return fmt.Errorf("service %s already exists in the vault, use --as to receive it under another name",
+					entry.Service)
+			} else if !errors.Is(err, vault.ErrNotFound) {
+				return err
+			}
+			if err = vaultManager.Import(entry); err != nil {
+				return err
+			}
+			log.Printf("Received %s, shared by %s\n", entry.Service, share.EncodePublicKey(sender))
+			return nil
+		},
+	}
+	receiveCmd.Flags().String("as", "", "Service name to receive the entry under")
+	return receiveCmd
+}
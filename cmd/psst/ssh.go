@@ -0,0 +1,302 @@
+package psst
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/sshagent"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// SSHKeyCmd manages the SSH keys stored in the vault.
+func SSHKeyCmd() *cobra.Command {
+	keyCmd := &cobra.Command{
+		Use:   "ssh-key",
+		Short: "Manage the SSH keys stored in the vault",
+		Long: `SSH private keys are stored in entries of the ssh-key kind, encrypted like
+passwords, and served to ssh by 'psst ssh-agent'. They are deleted like any
+other entry, with 'psst delete'.`,
+	}
+	keyCmd.AddCommand(sshKeyAddCmd(), sshKeyListCmd(), sshKeyPublicCmd())
+	return keyCmd
+}
+
+// sshKeyAddCmd stores an SSH private key in a new entry.
+func sshKeyAddCmd() *cobra.Command {
+	addCmd := &cobra.Command{
+		Use:   "add <service> <private-key-file>",
+		Short: "Store an SSH private key in the vault",
+		Long: `Store the SSH private key read from a file, or from the standard input if the
+file is "-", in a new entry for the service. The key, in OpenSSH or PEM format,
+must not be protected by a passphrase: the vault encrypts it. Once stored, the
+key file can be deleted.`,
+		Example: `  psst ssh-key add deploy ~/.ssh/id_ed25519_deploy --tags work`,
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			service, path := args[0], args[1]
+			username, _ := cmd.Flags().GetString("username")
+			tags, _ := cmd.Flags().GetStringSlice("tags")
+
+			var (
+				key []byte
+				err error
+			)
+			if path == "-" {
+				key, err = io.ReadAll(cmd.InOrStdin())
+			} else {
+				//nolint:gosec // the path is provided by the user
+				key, err = os.ReadFile(path)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read SSH key: %w", err)
+			}
+			entry := &model.PasswordEntry{
+				Service:  service,
+				Kind:     model.KindSSHKey,
+				Username: username,
+				Password: string(key),
+				Tags:     tags,
+			}
+			signer, err := sshagent.ParseKey(entry)
+			if err != nil {
+				return err
+			}
+
+			if err = unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			if err = checkNewService(service); err != nil {
+				return err
+			}
+			if err = vaultManager.Create(entry); err != nil {
+				return err
+			}
+			log.Printf("Stored the %s key %s for service %s\n",
+				signer.PublicKey().Type(), ssh.FingerprintSHA256(signer.PublicKey()), service)
+			return nil
+		},
+	}
+	addCmd.Flags().String("username", "", "Username the key authenticates, if any")
+	addCmd.Flags().StringSlice("tags", []string{}, "Tags for categorization (comma-separated)")
+	return addCmd
+}
+
+// sshKeyListCmd lists the SSH keys stored in the vault.
+func sshKeyListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "List the SSH keys stored in the vault",
+		Long:    `List the SSH keys stored in the vault, with their type and fingerprint.`,
+		Example: `  psst ssh-key list`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			entries, err := vaultManager.SSHKeys()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			for _, entry := range entries {
+				signer, parseErr := sshagent.ParseKey(entry)
+				if parseErr != nil {
+					log.Printf("Warning: %s\n", parseErr)
+					continue
+				}
+				pub := signer.PublicKey()
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Service, pub.Type(), ssh.FingerprintSHA256(pub))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// sshKeyPublicCmd prints the public key of an SSH key stored in the vault.
+func sshKeyPublicCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "public <service>",
+		Short: "Print the public key of an SSH key",
+		Long: `Print the public key of the SSH key of the service in the authorized_keys
+format, e.g. to register it as a deploy key.`,
+		Example: `  psst ssh-key public deploy >> ~/.ssh/authorized_keys`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			service := args[0]
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			entry, err := vaultManager.Read(service)
+			if errors.Is(err, vault.ErrNotFound) {
+				return fmt.Errorf("no SSH key for service %s", service)
+			}
+			if err != nil {
+				return err
+			}
+			if entry.Kind != model.KindSSHKey {
+				return fmt.Errorf("the entry of service %s is not an SSH key", service)
+			}
+			signer, err := sshagent.ParseKey(entry)
+			if err != nil {
+				return err
+			}
+			line := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(signer.PublicKey())), "\n")
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", line, service)
+			return err
+		},
+	}
+}
+
+// SSHAgentCmd serves the SSH keys stored in the vault to ssh.
+func SSHAgentCmd() *cobra.Command {
+	agentCmd := &cobra.Command{
+		Use:   "ssh-agent",
+		Short: "Serve the SSH keys of the vault to ssh",
+		Long: `Run an SSH agent serving the SSH keys of the vault, stored with 'psst ssh-key
+add', until interrupted. The agent listens on a unix socket, next to the vault
+unless --socket is given: point ssh to it by setting SSH_AUTH_SOCK, as printed
+by the agent.
+
+Keys are decrypted on every use, and only while the vault is unlocked:
+'ssh-add -x' locks the agent and closes the vault, 'ssh-add -X' unlocks it
+again with the principal password. With --confirm, every use of a key must be
+approved on the terminal the agent runs in.
+
+Keys can't be added to or removed from the agent with ssh-add, they are managed
+in the vault.`,
+		Example: `  psst ssh-agent --confirm
+  SSH_AUTH_SOCK=~/.psst/agent.sock ssh git@github.com`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			socket, _ := cmd.Flags().GetString("socket")
+			confirm, _ := cmd.Flags().GetBool("confirm")
+			if socket == "" {
+				socket = filepath.Join(filepath.Dir(cfg.DBPath), "agent.sock")
+			}
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			listener, err := listenAgent(socket)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = listener.Close() }()
+
+			opts := sshagent.Options{Lock: closeVaultManager, Unlock: unlockAgent}
+			if confirm {
+				opts.Confirm = confirmKeyUse
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", socket)
+			log.Println("Serving the SSH keys of the vault, press Ctrl+C to stop")
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return serveAgent(ctx, listener, sshagent.New(vaultManager, opts))
+		},
+	}
+	agentCmd.Flags().String("socket", "", "Path of the agent socket (default next to the vault)")
+	agentCmd.Flags().Bool("confirm", false, "Confirm every use of a key on the terminal")
+	return agentCmd
+}
+
+// listenAgent listens on the unix socket at path, accessible only to the current user.
+// A socket left by an agent that didn't stop cleanly is replaced.
+func listenAgent(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("an agent is already listening on %s", path)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale agent socket: %w", err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err = os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to restrict access to %s: %w", path, err)
+	}
+	return listener, nil
+}
+
+// serveAgent serves a on the connections accepted by listener until ctx is done.
+// The agent is locked on return, closing the vault once the request in progress, if any, is handled.
+func serveAgent(ctx context.Context, listener net.Listener, a *sshagent.Agent) error {
+	defer func() { _ = a.Lock(nil) }()
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept agent connection: %w", err)
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+			_ = agent.ServeAgent(a, conn)
+		}()
+	}
+}
+
+// unlockAgent unlocks the vault again with the principal password given to 'ssh-add -X'.
+func unlockAgent(passphrase []byte) (sshagent.Keys, error) {
+	initVaultManager()
+	if vaultManager == nil {
+		return nil, errors.New("failed to connect to the vault")
+	}
+	unlocked, err := unlockMember(string(passphrase))
+	if !unlocked {
+		closeVaultManager()
+		if err == nil {
+			err = errors.New("wrong principal password")
+		}
+		return nil, err
+	}
+	return vaultManager, nil
+}
+
+// confirmKeyUse asks on the terminal whether the SSH key of entry can be used. The use is denied without a terminal.
+func confirmKeyUse(entry *model.PasswordEntry) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		log.Printf("Denied the use of the SSH key %s, no terminal to confirm it: %s\n", entry.Service, err)
+		return false
+	}
+	defer func() { _ = tty.Close() }()
+	_, _ = fmt.Fprintf(tty, "Allow the use of the SSH key %s? [y/N] ", entry.Service)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	return strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+}
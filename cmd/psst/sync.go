@@ -0,0 +1,391 @@
+package psst
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/db"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/lansync"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/merge"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/vault"
+)
+
+// lansyncTimeout bounds connecting to and exchanging the vault with another machine of the local network.
+const lansyncTimeout = time.Minute
+
+// SyncCmd synchronizes the vault with the vaults used on other devices.
+func SyncCmd() *cobra.Command {
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Synchronize the vault with other vaults",
+		Long: `Synchronize the vault with the vaults used on other devices.
+
+Vaults are synchronized either by copying the vault file of the other device,
+e.g. with a USB drive, and merging it into this vault, or directly over the
+local network, by serving the vault on the other device and pulling it on this
+one. Changes only flow into this vault: to synchronize both devices, repeat the
+sync the other way around.`,
+	}
+	syncCmd.AddCommand(syncMergeCmd())
+	syncCmd.AddCommand(syncServeCmd())
+	syncCmd.AddCommand(syncPullCmd())
+	return syncCmd
+}
+
+// syncMergeCmd merges another vault file into the vault.
+func syncMergeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "merge <vault-file>",
+		Short: "Merge another vault into this vault",
+		Long: `Merge the entries of another vault file into this vault. The other vault is
+only read, merge this vault into it to synchronize it too.
+
+The other vault is unlocked with the principal password of this vault, or with
+its own password, prompted for if different.
+
+Entries are matched across vaults by a UUID assigned when they are created, so
+that renamed entries are still matched. Entries changed or deleted only in the
+other vault since the last merge are changed or deleted in this vault, while
+entries changed in both vaults, or changed in one and deleted in the other, are
+conflicts: for each of them you are asked which version to keep.
+
+Every merge is applied in a single transaction and the time of the merge is
+recorded, so that the next merge of the same vault only considers the changes
+made since then. Vaults are told apart by their device ID: a vault copied from
+the vault to merge gets a new device ID on its first merge.`,
+		Example: `  psst sync merge /media/usb/psst.db`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			password, err := unlockVaultPassword()
+			if err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			other, err := openOtherVault(args[0], password)
+			if err != nil {
+				return err
+			}
+			defer other.Close()
+
+			if err = checkDeviceID(other.DeviceID()); err != nil {
+				return err
+			}
+			remote, err := snapshot(other)
+			if err != nil {
+				return err
+			}
+			return mergeSnapshot(cmd, args[0], other.DeviceID(), remote)
+		},
+	}
+}
+
+// syncServeCmd serves the vault to another machine of the local network.
+func syncServeCmd() *cobra.Command {
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve this vault to another machine of the local network",
+		Long: `Serve this vault to 'psst sync pull', run on another machine of the local
+network.
+
+A one-time code is shown, to be typed on the other machine: the connection is
+authenticated with it through a password-authenticated key exchange, so no
+certificate is needed and the code can't be guessed offline. The code is valid
+for a single connection, after which the command exits.
+
+The other machine only gets the changes made since it last pulled this vault,
+which isn't changed: pull the other machine vault from this one to synchronize
+it too.`,
+		Example: `  psst sync serve
+  psst sync serve --addr 192.168.1.10:7557 --timeout 10m`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			addr, _ := cmd.Flags().GetString("addr")
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			listener, err := (&net.ListenConfig{}).Listen(cmd.Context(), "tcp", addr)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s: %w", addr, err)
+			}
+			defer listener.Close()
+			code, err := lansync.NewCode()
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Serving the vault on %s\n", listener.Addr())
+			log.Printf("On the other machine, run 'psst sync pull <address of this machine>' and enter the code %s\n",
+				code)
+			if err = listener.(*net.TCPListener).SetDeadline(time.Now().Add(timeout)); err != nil {
+				return fmt.Errorf("failed to set timeout: %w", err)
+			}
+			conn, err := listener.Accept()
+			if err != nil {
+				return fmt.Errorf("no machine connected: %w", err)
+			}
+			defer conn.Close()
+			if err = conn.SetDeadline(time.Now().Add(lansyncTimeout)); err != nil {
+				return fmt.Errorf("failed to set timeout: %w", err)
+			}
+
+			err = lansync.Serve(conn, code, vaultManager.DeviceID(), func(since time.Time) (merge.Snapshot, error) {
+				local, snapshotErr := snapshot(vaultManager)
+				return local.Since(since), snapshotErr
+			})
+			if err != nil {
+				return fmt.Errorf("failed to serve the vault to %s: %w", conn.RemoteAddr(), err)
+			}
+			log.Printf("Served the vault to %s\n", conn.RemoteAddr())
+			return nil
+		},
+	}
+	serveCmd.Flags().String("addr", ":"+strconv.Itoa(lansync.DefaultPort), "Address to listen on")
+	serveCmd.Flags().Duration("timeout", 5*time.Minute, "How long to wait for the other machine to connect")
+	return serveCmd
+}
+
+// syncPullCmd pulls the vault served by another machine of the local network.
+func syncPullCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "pull <host[:port]>",
+		Short: "Pull the vault served by another machine of the local network",
+		Long: fmt.Sprintf(`Pull the changes of the vault served by 'psst sync serve' on another machine of
+the local network, and merge them into this vault. The code shown by 'psst sync
+serve' is prompted for. The port defaults to %d.
+
+The changes are merged as with 'psst sync merge': entries changed in both vaults
+are conflicts, for each of them you are asked which version to keep.`, lansync.DefaultPort),
+		Example: `  psst sync pull 192.168.1.10
+  psst sync pull laptop.local:7557`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr := args[0]
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				addr = net.JoinHostPort(addr, strconv.Itoa(lansync.DefaultPort))
+			}
+
+			if err := unlockVault(); err != nil {
+				return err
+			}
+			defer closeVaultManager()
+
+			code, err := passwordReader("Enter the code shown by 'psst sync serve': ")
+			if err != nil {
+				return fmt.Errorf("failed to read code: %w", err)
+			}
+			conn, err := (&net.Dialer{Timeout: lansyncTimeout}).DialContext(cmd.Context(), "tcp", addr)
+			if err != nil {
+				return fmt.Errorf("failed to connect to %s: %w", addr, err)
+			}
+			defer conn.Close()
+			if err = conn.SetDeadline(time.Now().Add(lansyncTimeout)); err != nil {
+				return fmt.Errorf("failed to set timeout: %w", err)
+			}
+
+			peerID, changes, err := lansync.Pull(conn, code, lastSyncWith)
+			if err != nil {
+				return fmt.Errorf("failed to pull the vault from %s: %w", addr, err)
+			}
+			// Conflicts are resolved once disconnected, so that the other machine isn't kept waiting.
+			_ = conn.Close()
+			return mergeSnapshot(cmd, addr, peerID, changes)
+		},
+	}
+}
+
+// checkDeviceID gives the vault a new device ID if it is a copy of the vault with the device ID peerID.
+// A vault copied to set up another device shares the device ID of the original vault: the copy gets a new one,
+// so that the sync of each device is tracked separately.
+func checkDeviceID(peerID string) error {
+	if peerID != vaultManager.DeviceID() {
+		return nil
+	}
+	if err := vaultManager.ResetDeviceID(); err != nil {
+		return err
+	}
+	log.Println("This vault is a copy of the other vault, it was assigned a new device ID.")
+	return nil
+}
+
+// lastSyncWith returns the last time the vault with the device ID peerID was merged, once checked that this vault
+// isn't a copy of it, see checkDeviceID.
+func lastSyncWith(peerID string) (time.Time, error) {
+	if err := checkDeviceID(peerID); err != nil {
+		return time.Time{}, err
+	}
+	return vaultManager.LastSync(peerID)
+}
+
+// mergeSnapshot merges other, the snapshot of the vault with the device ID peerID read from source, into the vault.
+// Conflicts are resolved by the user and the changes are printed before being applied.
+func mergeSnapshot(cmd *cobra.Command, source, peerID string, other merge.Snapshot) error {
+	local, err := snapshot(vaultManager)
+	if err != nil {
+		return err
+	}
+	lastSync, err := vaultManager.LastSync(peerID)
+	if err != nil {
+		return err
+	}
+
+	result := merge.Vaults(local, other, lastSync)
+	if err = resolveConflicts(cmd.InOrStdin(), result); err != nil {
+		return err
+	}
+
+	added, updated, deleted := printMerge(cmd.OutOrStdout(), local, result)
+	if err = vaultManager.ApplySync(result.Save, result.Delete, peerID); err != nil {
+		return err
+	}
+	log.Printf("Merged %s: %d entries added, %d updated, %d deleted\n", source, added, updated, deleted)
+	return nil
+}
+
+// openOtherVault opens and unlocks the vault at path, to be merged into the vault.
+// The vault is unlocked with password, or with a password read from the user if different.
+// On success, the returned vault manager must be closed by the caller.
+func openOtherVault(path, password string) (*vault.Manager, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vault to merge: %w", err)
+	}
+	if localInfo, statErr := os.Stat(cfg.DBPath); statErr == nil && os.SameFile(info, localInfo) {
+		return nil, errors.New("cannot merge the vault with itself")
+	}
+
+	d, err := db.NewDatabase(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vault to merge: %w", err)
+	}
+	other := vault.NewManager(d)
+
+	unlocked, err := other.Unlock(password)
+	if !unlocked && err == nil {
+		password, err = passwordReader("Enter password of the vault to merge: ")
+		if err != nil {
+			other.Close()
+			return nil, fmt.Errorf("failed to read password: %w", err)
+		}
+		unlocked, err = other.Unlock(password)
+	}
+	if errors.Is(err, vault.ErrIntegrity) {
+		other.Close()
+		return nil, fmt.Errorf("%w: the vault to merge may have been modified outside psst", err)
+	}
+	if !unlocked {
+		other.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlock vault to merge: %w", err)
+		}
+		return nil, errors.New("wrong password for the vault to merge")
+	}
+	if err != nil {
+		log.Printf("Warning: %s\n", err)
+	}
+	return other, nil
+}
+
+// snapshot returns the entries and tombstones of an unlocked vault.
+func snapshot(m *vault.Manager) (merge.Snapshot, error) {
+	entries, err := m.List()
+	if err != nil {
+		return merge.Snapshot{}, fmt.Errorf("failed to list password entries: %w", err)
+	}
+	tombstones, err := m.Tombstones()
+	if err != nil {
+		return merge.Snapshot{}, err
+	}
+	return merge.Snapshot{Entries: entries, Tombstones: tombstones}, nil
+}
+
+// resolveConflicts asks the user how to resolve each conflict of result, reading the answers from r.
+func resolveConflicts(r io.Reader, result *merge.Result) error {
+	in := bufio.NewReader(r)
+	for _, conflict := range result.Conflicts {
+		keepOther, err := resolveConflict(in, conflict)
+		if err != nil {
+			return err
+		}
+		result.Resolve(conflict, keepOther)
+	}
+	return nil
+}
+
+// resolveConflict asks the user which version of a conflicting entry to keep, reading the answer from in.
+// It returns true to keep the version of the other vault.
+func resolveConflict(in *bufio.Reader, conflict *merge.Conflict) (bool, error) {
+	log.Printf("Conflict on %s: %s\n", conflict.Service, conflict.Reason)
+	log.Printf("  this vault:  %s\n", describeVersion(conflict.Local))
+	log.Printf("  other vault: %s\n", describeVersion(conflict.Other))
+	for {
+		log.Print("Keep the version of [t]his vault or of the [o]ther vault? ")
+		line, err := in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "t", "this":
+			return false, nil
+		case "o", "other":
+			return true, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return false, errors.New("merge cancelled, the vault wasn't changed")
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read answer: %w", err)
+		}
+	}
+}
+
+// describeVersion describes a version of a conflicting entry, nil if it was deleted.
+func describeVersion(entry *model.PasswordEntry) string {
+	if entry == nil {
+		return "deleted"
+	}
+	description := "modified " + entry.ModifiedAt.Local().Format("2006-01-02 15:04")
+	if entry.Username != "" {
+		description += ", username " + entry.Username
+	}
+	return description
+}
+
+// printMerge writes the changes a merge makes to the local vault to w: "+" marks added entries, "~" updated
+// entries and "-" deleted entries. It returns the number of entries of each kind.
+func printMerge(w io.Writer, local merge.Snapshot, result *merge.Result) (added, updated, deleted int) {
+	existing := make(map[string]bool, len(local.Entries))
+	for _, entry := range local.Entries {
+		existing[entry.UUID] = true
+	}
+
+	for _, tombstone := range result.Delete {
+		// Tombstones of entries unknown to this vault are only recorded, to propagate the deletion
+		if existing[tombstone.UUID] {
+			_, _ = fmt.Fprintf(w, "- %s\n", tombstone.Service)
+			deleted++
+		}
+	}
+	for _, entry := range result.Save {
+		if existing[entry.UUID] {
+			_, _ = fmt.Fprintf(w, "~ %s\n", entry.Service)
+			updated++
+		} else {
+			_, _ = fmt.Fprintf(w, "+ %s\n", entry.Service)
+			added++
+		}
+	}
+	return added, updated, deleted
+}
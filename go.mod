@@ -3,10 +3,12 @@ module github.com/CanobbioE/please-safely-store-this
 go 1.23.6
 
 require (
+	github.com/gtank/ristretto255 v0.1.2
 	github.com/mattn/go-sqlite3 v1.14.27
 	github.com/spf13/cobra v1.9.1
 	go.uber.org/mock v0.5.1
 	golang.org/x/crypto v0.37.0
+	golang.org/x/net v0.39.0
 	golang.org/x/term v0.31.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,229 @@
+// Package bundle reads and writes .psst bundles: portable, self-contained encrypted exports of vault entries.
+//
+// A bundle is sealed with an export passphrase chosen when exporting, so that a vault can be moved between
+// machines without sharing the principal password. The format is described in docs/bundle.md:
+//
+//	magic       8 bytes  "PSSTBNDL"
+//	version     2 bytes  big endian, currently 2
+//	header len  4 bytes  big endian
+//	header      JSON object with the KDF parameters, salt, cipher and nonce
+//	payload     AES-256-GCM ciphertext of the JSON encoded entries
+//
+// The magic, version and header are authenticated as additional data of the payload.
+package bundle
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+const (
+	// Magic identifies a .psst bundle.
+	Magic = "PSSTBNDL"
+	// Version is the version of the bundle format written by Write.
+	// Version 2 added the kind, OTP and custom fields of the entries to the payload.
+	Version uint16 = 2
+	// minVersion is the oldest version of the bundle format that can still be read.
+	minVersion uint16 = 1
+	// Extension is the conventional file extension of a bundle.
+	Extension = ".psst"
+
+	kdfArgon2id  = "argon2id"
+	cipherAESGCM = "aes-256-gcm"
+
+	keyLen  = 32
+	saltLen = 16
+
+	// maxHeaderLen bounds the header read from untrusted bundles.
+	maxHeaderLen = 64 * 1024
+	// maxMemory bounds the Argon2id memory, in KiB, requested by untrusted bundles.
+	// It is checked before deriving the key, so a crafted header can't exhaust the memory of the reader.
+	maxMemory = 1024 * 1024
+	// maxTime bounds the Argon2id iterations requested by untrusted bundles.
+	maxTime = 64
+)
+
+var (
+	// ErrInvalidBundle is returned when the data is not a well-formed bundle.
+	ErrInvalidBundle = errors.New("invalid psst bundle")
+	// ErrDecrypt is returned when a bundle can't be decrypted, either because the passphrase is wrong
+	// or because the bundle has been modified.
+	ErrDecrypt = errors.New("failed to decrypt psst bundle: wrong passphrase or corrupted bundle")
+)
+
+// KDFParams holds the Argon2id parameters used to derive the bundle key from the export passphrase.
+type KDFParams struct {
+	Memory  uint32 `json:"memory"`
+	Time    uint32 `json:"time"`
+	Threads uint8  `json:"threads"`
+}
+
+// DefaultKDFParams are the parameters used by Write.
+var DefaultKDFParams = KDFParams{Memory: 64 * 1024, Time: 3, Threads: 4}
+
+// Header is the plain text header of a bundle.
+type Header struct {
+	CreatedAt time.Time `json:"created_at"`
+	KDF       string    `json:"kdf"`
+	Cipher    string    `json:"cipher"`
+	Salt      []byte    `json:"salt"`
+	Nonce     []byte    `json:"nonce"`
+	Params    KDFParams `json:"kdf_params"`
+}
+
+// payload is the encrypted content of a bundle.
+type payload struct {
+	Entries []*model.PasswordEntry `json:"entries"`
+}
+
+// Write seals the entries with a key derived from passphrase and writes the bundle to w.
+func Write(w io.Writer, entries []*model.PasswordEntry, passphrase string) error {
+	header := Header{
+		CreatedAt: time.Now().UTC(),
+		KDF:       kdfArgon2id,
+		Cipher:    cipherAESGCM,
+		Salt:      make([]byte, saltLen),
+		Params:    DefaultKDFParams,
+	}
+	if _, err := io.ReadFull(rand.Reader, header.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	gcm := newGCM(passphrase, &header)
+	header.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, header.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode header: %w", err)
+	}
+	plaintext, err := json.Marshal(payload{Entries: entries})
+	if err != nil {
+		return fmt.Errorf("failed to encode entries: %w", err)
+	}
+
+	prefix := encodePrefix(headerData)
+	ciphertext := gcm.Seal(nil, header.Nonce, plaintext, prefix)
+	if _, err = w.Write(prefix); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if _, err = w.Write(ciphertext); err != nil {
+		return fmt.Errorf("failed to write payload: %w", err)
+	}
+	return nil
+}
+
+// Read reads a bundle from r and decrypts its entries with passphrase.
+func Read(r io.Reader, passphrase string) ([]*model.PasswordEntry, error) {
+	header, prefix, err := ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload: %w", err)
+	}
+
+	gcm := newGCM(passphrase, header)
+	if len(header.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrInvalidBundle)
+	}
+	plaintext, err := gcm.Open(nil, header.Nonce, ciphertext, prefix)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	var p payload
+	if err = json.Unmarshal(plaintext, &p); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode entries: %w", ErrInvalidBundle, err)
+	}
+	return p.Entries, nil
+}
+
+// ReadHeader reads and validates the plain text part of a bundle.
+// Besides the header, it returns the raw bytes read, which authenticate the payload.
+func ReadHeader(r io.Reader) (header *Header, prefix []byte, err error) {
+	fixed := make([]byte, len(Magic)+2+4)
+	if _, err = io.ReadFull(r, fixed); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+	if string(fixed[:len(Magic)]) != Magic {
+		return nil, nil, fmt.Errorf("%w: not a psst bundle", ErrInvalidBundle)
+	}
+	switch v := binary.BigEndian.Uint16(fixed[len(Magic):]); {
+	case v > Version:
+		return nil, nil, fmt.Errorf("%w: version %d was written by a newer psst, which supports up to version %d: "+
+			"upgrade psst to import it", ErrInvalidBundle, v, Version)
+	case v < minVersion:
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBundle, v)
+	}
+	headerLen := binary.BigEndian.Uint32(fixed[len(Magic)+2:])
+	if headerLen > maxHeaderLen {
+		return nil, nil, fmt.Errorf("%w: header too large", ErrInvalidBundle)
+	}
+
+	headerData := make([]byte, headerLen)
+	if _, err = io.ReadFull(r, headerData); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+	header = &Header{}
+	if err = json.NewDecoder(bytes.NewReader(headerData)).Decode(header); err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to decode header: %w", ErrInvalidBundle, err)
+	}
+	if err = header.validate(); err != nil {
+		return nil, nil, err
+	}
+	return header, append(fixed, headerData...), nil
+}
+
+// validate checks that the header only requests supported algorithms with sane parameters.
+func (h *Header) validate() error {
+	switch {
+	case h.KDF != kdfArgon2id:
+		return fmt.Errorf("%w: unsupported KDF %q", ErrInvalidBundle, h.KDF)
+	case h.Cipher != cipherAESGCM:
+		return fmt.Errorf("%w: unsupported cipher %q", ErrInvalidBundle, h.Cipher)
+	case len(h.Salt) < saltLen:
+		return fmt.Errorf("%w: salt too short", ErrInvalidBundle)
+	case h.Params.Memory > maxMemory:
+		return fmt.Errorf("%w: KDF memory of %d KiB exceeds the limit of %d KiB",
+			ErrInvalidBundle, h.Params.Memory, maxMemory)
+	case h.Params.Memory == 0,
+		h.Params.Time == 0 || h.Params.Time > maxTime,
+		h.Params.Threads == 0:
+		return fmt.Errorf("%w: invalid KDF parameters", ErrInvalidBundle)
+	}
+	return nil
+}
+
+// encodePrefix encodes the magic, version and header of a bundle.
+func encodePrefix(headerData []byte) []byte {
+	prefix := []byte(Magic)
+	prefix = binary.BigEndian.AppendUint16(prefix, Version)
+	prefix = binary.BigEndian.AppendUint32(prefix, uint32(len(headerData)))
+	return append(prefix, headerData...)
+}
+
+// newGCM derives the bundle key from passphrase and returns the AES-GCM cipher using it.
+func newGCM(passphrase string, header *Header) cipher.AEAD {
+	p := header.Params
+	key := argon2.IDKey([]byte(passphrase), header.Salt, p.Time, p.Memory, p.Threads, keyLen)
+	// A 32 bytes key is always valid for AES-256 and the standard nonce size is always valid for GCM.
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	return gcm
+}
@@ -0,0 +1,140 @@
+package bundle_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/bundle"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+func TestWriteRead(t *testing.T) {
+	entries := []*model.PasswordEntry{
+		{
+			Service:    "gmail",
+			Username:   "user@example.com",
+			Password:   "secret123",
+			URL:        "https://mail.google.com",
+			Notes:      "personal",
+			Tags:       []string{"email", "important"},
+			CreatedAt:  time.Date(2025, 4, 14, 10, 30, 0, 0, time.UTC),
+			ModifiedAt: time.Date(2025, 4, 15, 10, 30, 0, 0, time.UTC),
+		},
+		{Service: "github", Password: "secret456"},
+	}
+
+	var buf bytes.Buffer
+	if err := bundle.Write(&buf, entries, "export passphrase"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	data := buf.Bytes()
+	if bytes.Contains(data, []byte("secret123")) {
+		t.Fatal("Expected passwords to be encrypted")
+	}
+
+	tamper := func(data []byte, i int) []byte {
+		tampered := bytes.Clone(data)
+		tampered[i] ^= 0xff
+		return tampered
+	}
+	headerStart := len(bundle.Magic) + 2 + 4
+	withHeader := func(header string, version uint16) []byte {
+		forged := []byte(bundle.Magic)
+		forged = binary.BigEndian.AppendUint16(forged, version)
+		forged = binary.BigEndian.AppendUint32(forged, uint32(len(header)))
+		return append(forged, header...)
+	}
+
+	tests := []struct {
+		wantErr    error
+		name       string
+		wantMsg    string
+		passphrase string
+		data       []byte
+	}{
+		{
+			name:       "reads back the entries",
+			data:       data,
+			passphrase: "export passphrase",
+		},
+		{
+			name:       "fails with the wrong passphrase",
+			data:       data,
+			passphrase: "wrong passphrase",
+			wantErr:    bundle.ErrDecrypt,
+		},
+		{
+			name:       "fails with a modified payload",
+			data:       tamper(data, len(data)-1),
+			passphrase: "export passphrase",
+			wantErr:    bundle.ErrDecrypt,
+		},
+		{
+			name:       "fails with a modified header",
+			data:       bytes.Replace(data, []byte(`"created_at":"2`), []byte(`"created_at":"1`), 1),
+			passphrase: "export passphrase",
+			wantErr:    bundle.ErrDecrypt,
+		},
+		{
+			name:       "fails with an unknown magic",
+			data:       tamper(data, 0),
+			passphrase: "export passphrase",
+			wantErr:    bundle.ErrInvalidBundle,
+		},
+		{
+			name:       "fails with an unsupported version",
+			data:       tamper(data, len(bundle.Magic)),
+			passphrase: "export passphrase",
+			wantErr:    bundle.ErrInvalidBundle,
+		},
+		{
+			name:       "fails with a newer version",
+			data:       withHeader(`{}`, bundle.Version+1),
+			passphrase: "export passphrase",
+			wantErr:    bundle.ErrInvalidBundle,
+			wantMsg:    "upgrade psst",
+		},
+		{
+			name:       "fails with a malformed header",
+			data:       tamper(data, headerStart),
+			passphrase: "export passphrase",
+			wantErr:    bundle.ErrInvalidBundle,
+		},
+		{
+			name: "fails with too much KDF memory",
+			data: withHeader(`{"kdf":"argon2id","cipher":"aes-256-gcm","salt":"AAAAAAAAAAAAAAAAAAAAAA==",`+
+				`"nonce":"AAAAAAAAAAAAAAAA","kdf_params":{"memory":4194304,"time":1,"threads":1}}`, bundle.Version),
+			passphrase: "export passphrase",
+			wantErr:    bundle.ErrInvalidBundle,
+		},
+		{
+			name:       "fails with a truncated bundle",
+			data:       data[:headerStart],
+			passphrase: "export passphrase",
+			wantErr:    bundle.ErrInvalidBundle,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bundle.Read(bytes.NewReader(tt.data), tt.passphrase)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), tt.wantMsg) {
+					t.Fatalf("Expected error [%v] containing %q, got [%v]", tt.wantErr, tt.wantMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if !reflect.DeepEqual(got, entries) {
+				t.Fatalf("Expected %+v, got %+v", entries, got)
+			}
+		})
+	}
+}
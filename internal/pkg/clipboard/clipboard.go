@@ -0,0 +1,48 @@
+// Package clipboard copies text to the system clipboard, through the clipboard command of the platform.
+package clipboard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ErrUnavailable is returned when no clipboard command is installed.
+var ErrUnavailable = errors.New("no clipboard command found, install wl-clipboard, xclip or xsel")
+
+// commands lists, in order of preference, the commands copying their standard input to the clipboard.
+func commands() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	}
+	var cmds [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmds = append(cmds, []string{"wl-copy"})
+	}
+	return append(cmds, []string{"xclip", "-selection", "clipboard"}, []string{"xsel", "--clipboard", "--input"})
+}
+
+// Copy copies text to the clipboard with the first clipboard command found.
+func Copy(text string) error {
+	for _, command := range commands() {
+		path, err := exec.LookPath(command[0])
+		if err != nil {
+			continue
+		}
+		// The output isn't captured: xclip keeps serving the clipboard in the background, holding its output open.
+		//nolint:gosec // the command is one of the known clipboard commands
+		cmd := exec.Command(path, command[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("failed to copy to the clipboard with %s: %w", command[0], err)
+		}
+		return nil
+	}
+	return ErrUnavailable
+}
@@ -0,0 +1,35 @@
+package clipboard_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/clipboard"
+)
+
+func TestCopy(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake clipboard command is a shell script")
+	}
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	t.Setenv("WAYLAND_DISPLAY", "")
+	if err := clipboard.Copy("secret"); !errors.Is(err, clipboard.ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable without clipboard command, got %v", err)
+	}
+
+	copied := filepath.Join(t.TempDir(), "clipboard")
+	script := "#!/bin/sh\n[ \"$*\" = \"--clipboard --input\" ] && exec /bin/cat > " + copied + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "xsel"), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := clipboard.Copy("secret"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if got, err := os.ReadFile(copied); err != nil || string(got) != "secret" {
+		t.Fatalf("Expected the text to be copied with xsel, got [%s] %v", got, err)
+	}
+}
@@ -14,6 +14,8 @@ import (
 type Config struct {
 	DBPath              string        `yaml:"db_path"`
 	BackupDir           string        `yaml:"backup_dir"`
+	GitDir              string        `yaml:"git_dir"`
+	IdentityVault       string        `yaml:"identity_vault"`
 	AutoLockTimeout     time.Duration `yaml:"auto_lock_timeout"`
 	ClipboardTimeout    time.Duration `yaml:"clipboard_timeout"`
 	BackupCount         int           `yaml:"backup_count"`
@@ -37,6 +39,7 @@ func DefaultConfig() *Config {
 		ClipboardTimeout:    30 * time.Second,
 		ShowPasswords:       false,
 		BackupDir:           filepath.Join(home, ".psst", "backups"),
+		GitDir:              filepath.Join(home, ".psst", "git"),
 		BackupCount:         5,
 		PasswordLength:      16,
 		UseSpecialChars:     true,
@@ -33,6 +33,7 @@ func TestDefaultConfig(t *testing.T) {
 		MinPasswordStrength: 2,
 		DBPath:              filepath.Join(home, ".psst", "vault.db"),
 		BackupDir:           filepath.Join(home, ".psst", "backups"),
+		GitDir:              filepath.Join(home, ".psst", "git"),
 	})
 }
 
@@ -49,6 +50,8 @@ func TestLoadConfig(t *testing.T) {
 			want: &config.Config{
 				DBPath:              "mock_db_path",
 				BackupDir:           "mock_backup_dir",
+				GitDir:              "mock_git_dir",
+				IdentityVault:       "mock_identity_vault",
 				AutoLockTimeout:     1 * time.Minute,
 				ClipboardTimeout:    1 * time.Second,
 				BackupCount:         42,
@@ -0,0 +1,163 @@
+// Package credhelper implements the protocols of git and docker credential helpers, mapping the credentials they ask
+// for to the vault entries with a matching URL.
+package credhelper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// ErrInvalidValue is returned when writing a value git can't read back, because it contains a newline or a NUL.
+var ErrInvalidValue = errors.New("credential values can't contain newlines or NUL characters")
+
+// GitRequest is a credential description sent by git to a credential helper, see gitcredentials(7).
+// Attributes unknown to psst are ignored.
+type GitRequest struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+}
+
+// ParseGitRequest reads a credential description, made of key=value lines up to an empty line or the end of r.
+// A url attribute sets the protocol, the host and the path, and possibly the username and the password, unless the
+// description sets them too.
+func ParseGitRequest(r io.Reader) (*GitRequest, error) {
+	req := &GitRequest{}
+	var rawURL string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid credential attribute %q", line)
+		}
+		switch key {
+		case "protocol":
+			req.Protocol = value
+		case "host":
+			req.Host = value
+		case "path":
+			req.Path = value
+		case "username":
+			req.Username = value
+		case "password":
+			req.Password = value
+		case "url":
+			rawURL = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read credential request: %w", err)
+	}
+	if rawURL != "" {
+		if err := req.setURL(rawURL); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// setURL sets the attributes of the request that are still empty from u.
+func (r *GitRequest) setURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid credential url: %w", err)
+	}
+	for _, attr := range []struct {
+		dst *string
+		src string
+	}{
+		{&r.Protocol, u.Scheme},
+		{&r.Host, u.Host},
+		{&r.Path, strings.TrimPrefix(u.Path, "/")},
+		{&r.Username, u.User.Username()},
+	} {
+		if *attr.dst == "" {
+			*attr.dst = attr.src
+		}
+	}
+	if password, ok := u.User.Password(); ok && r.Password == "" {
+		r.Password = password
+	}
+	return nil
+}
+
+// URL returns the URL described by the request, without credentials.
+func (r *GitRequest) URL() string {
+	u := url.URL{Scheme: r.Protocol, Host: r.Host}
+	if r.Path != "" {
+		u.Path = "/" + r.Path
+	}
+	return u.String()
+}
+
+// WriteGitCredential writes a username and a password as a credential description, in reply to a get request.
+func WriteGitCredential(w io.Writer, username, password string) error {
+	for _, value := range []string{username, password} {
+		if strings.ContainsAny(value, "\n\x00") {
+			return ErrInvalidValue
+		}
+	}
+	_, err := fmt.Fprintf(w, "username=%s\npassword=%s\n", username, password)
+	return err
+}
+
+// FindEntry returns the entry whose URL matches the protocol, the host and the path of the request, or nil.
+// If the request has a username, only entries with that username or without one match.
+//
+// An entry URL without a scheme matches https. An entry URL with a path only matches requests for that path or below
+// it, and is preferred over the entries for the whole host; requests without a path, as sent by git unless
+// credential.useHttpPath is set, match every entry of the host.
+func FindEntry(entries []*model.PasswordEntry, req *GitRequest) *model.PasswordEntry {
+	var (
+		best     *model.PasswordEntry
+		bestPath = -1
+	)
+	for _, entry := range entries {
+		if req.Username != "" && entry.Username != "" && entry.Username != req.Username {
+			continue
+		}
+		n, ok := matchURL(entry.URL, req)
+		if ok && n > bestPath {
+			best, bestPath = entry, n
+		}
+	}
+	return best
+}
+
+// matchURL reports whether the entry URL matches the request, and the length of the path it matched.
+func matchURL(entryURL string, req *GitRequest) (int, bool) {
+	if entryURL == "" || req.Host == "" {
+		return 0, false
+	}
+	if !strings.Contains(entryURL, "://") {
+		entryURL = "https://" + entryURL
+	}
+	u, err := url.Parse(entryURL)
+	if err != nil {
+		return 0, false
+	}
+	if !strings.EqualFold(u.Scheme, req.Protocol) || !strings.EqualFold(u.Host, req.Host) {
+		return 0, false
+	}
+	path := strings.Trim(u.Path, "/")
+	if path == "" || req.Path == "" {
+		return 0, true
+	}
+	reqPath := strings.Trim(req.Path, "/")
+	if reqPath != path && !strings.HasPrefix(reqPath, path+"/") {
+		return 0, false
+	}
+	return len(path), true
+}
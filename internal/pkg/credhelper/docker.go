@@ -0,0 +1,49 @@
+package credhelper
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+const (
+	// DockerTag tags the entries holding the credentials of docker registries.
+	DockerTag = "docker"
+	// DockerNotFound is the message docker expects from a helper without credentials for a registry.
+	DockerNotFound = "credentials not found in native keychain"
+)
+
+// DockerCredentials is the credential of a registry in the docker credential helper protocol,
+// see https://github.com/docker/docker-credential-helpers.
+type DockerCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// NormalizeServerURL returns the host and the path of the URL of a registry, so that https://ghcr.io/ and ghcr.io
+// designate the same registry. The scheme is ignored as registries are reached over https.
+func NormalizeServerURL(server string) string {
+	server = strings.TrimSpace(server)
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return strings.TrimRight(server, "/")
+	}
+	return strings.ToLower(u.Host) + strings.TrimRight(u.Path, "/")
+}
+
+// FindDockerEntry returns the entry tagged DockerTag whose URL designates the registry server, or nil.
+func FindDockerEntry(entries []*model.PasswordEntry, server string) *model.PasswordEntry {
+	server = NormalizeServerURL(server)
+	for _, entry := range entries {
+		if slices.Contains(entry.Tags, DockerTag) && entry.URL != "" && NormalizeServerURL(entry.URL) == server {
+			return entry
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package credhelper_test
+
+import (
+	"testing"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/credhelper"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+func TestFindDockerEntry(t *testing.T) {
+	entries := []*model.PasswordEntry{
+		{Service: "ghcr", URL: "https://ghcr.io", Tags: []string{"docker"}},
+		{Service: "hub", URL: "https://index.docker.io/v1/", Tags: []string{"docker"}},
+		{Service: "registry", URL: "registry.example.com:5000", Tags: []string{"docker"}},
+		{Service: "quay", URL: "https://quay.io"},
+	}
+	tests := []struct {
+		name   string
+		server string
+		want   string
+	}{
+		{name: "exact URL", server: "https://ghcr.io", want: "ghcr"},
+		{name: "host only", server: "ghcr.io", want: "ghcr"},
+		{name: "trailing slash and case", server: "https://GHCR.io/", want: "ghcr"},
+		{name: "path", server: "https://index.docker.io/v1/", want: "hub"},
+		{name: "other path", server: "https://index.docker.io/v2/", want: ""},
+		{name: "port", server: "registry.example.com:5000", want: "registry"},
+		{name: "other port", server: "registry.example.com", want: ""},
+		{name: "untagged entry", server: "quay.io", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := credhelper.FindDockerEntry(entries, tt.server)
+			switch {
+			case got == nil && tt.want != "":
+				t.Errorf("FindDockerEntry() = nil, want %s", tt.want)
+			case got != nil && got.Service != tt.want:
+				t.Errorf("FindDockerEntry() = %s, want %q", got.Service, tt.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -36,7 +37,12 @@ func NewDatabase(dbPath string) (*Database, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	return &Database{db: db}, nil
+	d := &Database{db: db}
+	if err := d.upgradeSchema(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return d, nil
 }
 
 // Close closes the database connection.
@@ -57,7 +63,11 @@ func (d *Database) Initialize() error {
             notes TEXT,
             created_at TIMESTAMP NOT NULL,
             modified_at TIMESTAMP NOT NULL,
-            last_used_at TIMESTAMP
+            last_used_at TIMESTAMP,
+            uuid TEXT,
+            kind TEXT NOT NULL DEFAULT '',
+            otp TEXT NOT NULL DEFAULT '',
+            synced_at TIMESTAMP NOT NULL DEFAULT '0001-01-01 00:00:00+00:00'
         );
     `)
 	if err != nil {
@@ -98,40 +108,165 @@ func (d *Database) Initialize() error {
 		return fmt.Errorf("failed to create indexes: %w", err)
 	}
 
+	if err = d.createSyncTables(); err != nil {
+		return err
+	}
+	if err = d.createFieldsTable(); err != nil {
+		return err
+	}
+	if err = d.createAttachmentTables(); err != nil {
+		return err
+	}
+	return d.createMembersTable()
+}
+
+// upgradeSchema adds the columns and tables introduced after the database was created.
+// Databases that are not initialized yet are left untouched.
+func (d *Database) upgradeSchema() error {
+	var tables int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'password_entries'").
+		Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("failed to check database schema: %w", err)
+	}
+	if tables == 0 {
+		return nil
+	}
+
+	// Entries created before vaults could be merged have no UUID, one is assigned when the vault is migrated
+	if err = d.addColumn("password_entries", "uuid", "TEXT"); err != nil {
+		return err
+	}
+	// Entries created before entries had a kind are logins
+	if err = d.addColumn("password_entries", "kind", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err = d.addColumn("password_entries", "otp", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	if err = d.createSyncTables(); err != nil {
+		return err
+	}
+	// Entries and tombstones recorded before merges were stamped count as never merged, as the zero time
+	if err = d.addColumn("password_entries", "synced_at", zeroTimestampColumn); err != nil {
+		return err
+	}
+	if err = d.addColumn("deleted_entries", "synced_at", zeroTimestampColumn); err != nil {
+		return err
+	}
+	if err = d.createFieldsTable(); err != nil {
+		return err
+	}
+	if err = d.createAttachmentTables(); err != nil {
+		return err
+	}
+	return d.createMembersTable()
+}
+
+// zeroTimestampColumn defines a timestamp column defaulting to the zero time, as stored by the sqlite driver.
+const zeroTimestampColumn = "TIMESTAMP NOT NULL DEFAULT '0001-01-01 00:00:00+00:00'"
+
+// addColumn adds the column with the given name and definition to table, unless it exists.
+func (d *Database) addColumn(table, name, definition string) error {
+	var columns int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, name).
+		Scan(&columns)
+	if err != nil {
+		return fmt.Errorf("failed to check database schema: %w", err)
+	}
+	if columns > 0 {
+		return nil
+	}
+	if _, err = d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition)); err != nil {
+		return fmt.Errorf("failed to add %s column: %w", name, err)
+	}
+	return nil
+}
+
+// createSyncTables creates the tables and indexes used to merge vaults.
+func (d *Database) createSyncTables() error {
+	// Create deleted_entries table, recording a tombstone per deleted entry
+	_, err := d.db.Exec(`
+        CREATE TABLE IF NOT EXISTS deleted_entries (
+            uuid TEXT PRIMARY KEY,
+            service TEXT NOT NULL,
+            deleted_at TIMESTAMP NOT NULL,
+            synced_at TIMESTAMP NOT NULL DEFAULT '0001-01-01 00:00:00+00:00'
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create deleted_entries table: %w", err)
+	}
+
+	// Create sync peers table, recording when the vault of each device was last merged
+	_, err = d.db.Exec(`
+        CREATE TABLE IF NOT EXISTS sync_peers (
+            device_id TEXT PRIMARY KEY,
+            last_sync TIMESTAMP NOT NULL
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create sync_peers table: %w", err)
+	}
+
+	_, err = d.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_password_entries_uuid ON password_entries(uuid);")
+	if err != nil {
+		return fmt.Errorf("failed to create indexes: %w", err)
+	}
+
 	return nil
 }
 
 // SavePasswordEntry saves a password entry to the database.
 func (d *Database) SavePasswordEntry(entry *model.PasswordEntry) error {
+	return d.SavePasswordEntries([]*model.PasswordEntry{entry})
+}
+
+// SavePasswordEntries saves the password entries to the database in a single transaction.
+// Either all the entries are saved or none is.
+func (d *Database) SavePasswordEntries(entries []*model.PasswordEntry) error {
 	tx, err := d.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer func() {
-		if err = tx.Rollback(); err != nil {
-			log.Printf("failed to rollback transaction: %v", err)
+	defer rollback(tx)
+
+	for _, entry := range entries {
+		if err = saveEntry(tx, entry); err != nil {
+			return err
 		}
-	}()
+	}
 
+	return tx.Commit()
+}
+
+// saveEntry inserts or updates entry and its tags within tx.
+// New entries, without an ID, get the ID assigned by the database.
+// Updated entries without a UUID keep the existing one. Saving an entry removes its tombstone, if any.
+func saveEntry(tx *sql.Tx, entry *model.PasswordEntry) error {
 	// Insert or update password entry
 	var result sql.Result
+	var err error
 	if entry.ID == 0 {
 		// New entry
 		result, err = tx.Exec(`
             INSERT INTO password_entries 
-            (service, username, password, url, notes, created_at, modified_at, last_used_at)
-            VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+            (service, username, password, url, notes, created_at, modified_at, last_used_at, uuid, kind, otp,
+            synced_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?)
         `, entry.Service, entry.Username, entry.Password, entry.URL, entry.Notes,
-			entry.CreatedAt, entry.ModifiedAt, entry.LastUsedAt)
+			entry.CreatedAt, entry.ModifiedAt, entry.LastUsedAt, entry.UUID, entry.Kind, entry.OTP, entry.SyncedAt)
 	} else {
 		// Update existing entry
 		result, err = tx.Exec(`
             UPDATE password_entries SET
-            username = ?, password = ?, url = ?, notes = ?,
-            modified_at = ?, last_used_at = ?
+            service = ?, username = ?, password = ?, url = ?, notes = ?,
+            modified_at = ?, last_used_at = ?, uuid = COALESCE(NULLIF(?, ''), uuid), kind = ?, otp = ?,
+            synced_at = ?
             WHERE id = ?
-        `, entry.Username, entry.Password, entry.URL, entry.Notes,
-			entry.ModifiedAt, entry.LastUsedAt, entry.ID)
+        `, entry.Service, entry.Username, entry.Password, entry.URL, entry.Notes,
+			entry.ModifiedAt, entry.LastUsedAt, entry.UUID, entry.Kind, entry.OTP, entry.SyncedAt, entry.ID)
 	}
 
 	if err != nil {
@@ -146,6 +281,12 @@ func (d *Database) SavePasswordEntry(entry *model.PasswordEntry) error {
 		}
 	}
 
+	if entry.UUID != "" {
+		if _, err = tx.Exec("DELETE FROM deleted_entries WHERE uuid = ?", entry.UUID); err != nil {
+			return fmt.Errorf("failed to delete tombstone: %w", err)
+		}
+	}
+
 	// Delete existing tags
 	_, err = tx.Exec("DELETE FROM tags WHERE entry_id = ?", entry.ID)
 	if err != nil {
@@ -163,7 +304,24 @@ func (d *Database) SavePasswordEntry(entry *model.PasswordEntry) error {
 		}
 	}
 
-	return tx.Commit()
+	return saveFields(tx, entry)
+}
+
+// saveFields replaces the custom fields of entry within tx, keeping their order.
+func saveFields(tx *sql.Tx, entry *model.PasswordEntry) error {
+	if _, err := tx.Exec("DELETE FROM entry_fields WHERE entry_id = ?", entry.ID); err != nil {
+		return fmt.Errorf("failed to delete existing fields: %w", err)
+	}
+	for i, field := range entry.Fields {
+		_, err := tx.Exec(
+			"INSERT INTO entry_fields (entry_id, position, name, type, value) VALUES (?, ?, ?, ?, ?)",
+			entry.ID, i, field.Name, field.Type, field.Value,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert field: %w", err)
+		}
+	}
+	return nil
 }
 
 // GetPasswordEntry retrieves a password entry by service name.
@@ -172,12 +330,13 @@ func (d *Database) GetPasswordEntry(service string) (*model.PasswordEntry, error
 
 	// Get password entry
 	err := d.db.QueryRow(`
-        SELECT id, service, username, password, url, notes, created_at, modified_at, last_used_at
+        SELECT id, service, username, password, url, notes, created_at, modified_at, last_used_at,
+        COALESCE(uuid, ''), kind, otp, synced_at
         FROM password_entries
         WHERE service = ?
     `, service).Scan(
 		&entry.ID, &entry.Service, &entry.Username, &entry.Password, &entry.URL, &entry.Notes,
-		&entry.CreatedAt, &entry.ModifiedAt, &entry.LastUsedAt,
+		&entry.CreatedAt, &entry.ModifiedAt, &entry.LastUsedAt, &entry.UUID, &entry.Kind, &entry.OTP, &entry.SyncedAt,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -186,6 +345,10 @@ func (d *Database) GetPasswordEntry(service string) (*model.PasswordEntry, error
 		return nil, fmt.Errorf("failed to get password entry: %w", err)
 	}
 
+	if entry.Fields, err = d.getFields(entry.ID); err != nil {
+		return nil, err
+	}
+
 	// Get tags
 	rows, err := d.db.Query("SELECT tag FROM tags WHERE entry_id = ?", entry.ID)
 	if err != nil {
@@ -219,7 +382,8 @@ func (d *Database) GetPasswordEntry(service string) (*model.PasswordEntry, error
 func (d *Database) ListPasswordEntries() ([]*model.PasswordEntry, error) {
 	// Get password entries
 	rows, err := d.db.Query(`
-        SELECT id, service, username, password, url, notes, created_at, modified_at, last_used_at
+        SELECT id, service, username, password, url, notes, created_at, modified_at, last_used_at,
+        COALESCE(uuid, ''), kind, otp, synced_at
         FROM password_entries
         ORDER BY service
     `)
@@ -237,7 +401,8 @@ func (d *Database) ListPasswordEntries() ([]*model.PasswordEntry, error) {
 		var entry model.PasswordEntry
 		if err = rows.Scan(
 			&entry.ID, &entry.Service, &entry.Username, &entry.Password, &entry.URL, &entry.Notes,
-			&entry.CreatedAt, &entry.ModifiedAt, &entry.LastUsedAt,
+			&entry.CreatedAt, &entry.ModifiedAt, &entry.LastUsedAt, &entry.UUID, &entry.Kind, &entry.OTP,
+			&entry.SyncedAt,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan password entry: %w", err)
 		}
@@ -253,6 +418,9 @@ func (d *Database) ListPasswordEntries() ([]*model.PasswordEntry, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to get tags: %w", err)
 		}
+		if entries[i].Fields, err = d.getFields(entries[i].ID); err != nil {
+			return nil, err
+		}
 	}
 
 	return entries, nil
@@ -285,22 +453,55 @@ func (d *Database) getTags(entryID int64) ([]string, error) {
 	return tags, nil
 }
 
+// getFields retrieves the custom fields of the entry with the given ID, in order.
+func (d *Database) getFields(entryID int64) ([]model.EntryField, error) {
+	rows, err := d.db.Query("SELECT name, type, value FROM entry_fields WHERE entry_id = ? ORDER BY position", entryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fields: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var fields []model.EntryField
+	for rows.Next() {
+		var field model.EntryField
+		if err := rows.Scan(&field.Name, &field.Type, &field.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan field: %w", err)
+		}
+		fields = append(fields, field)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to query fields: %w", rows.Err())
+	}
+	return fields, nil
+}
+
+// TouchPasswordEntry sets the last time the password entry with the given ID was used, leaving its modification
+// time untouched.
+func (d *Database) TouchPasswordEntry(id int64, usedAt time.Time) error {
+	if _, err := d.db.Exec("UPDATE password_entries SET last_used_at = ? WHERE id = ?", usedAt, id); err != nil {
+		return fmt.Errorf("failed to update last use of password entry: %w", err)
+	}
+	return nil
+}
+
 // DeletePasswordEntry deletes a password entry by service name.
+// A tombstone is recorded for entries with a UUID, so that the deletion can be merged into other vaults.
 func (d *Database) DeletePasswordEntry(service string) error {
 	// Start transaction
 	tx, err := d.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer func() {
-		if err = tx.Rollback(); err != nil {
-			log.Printf("failed to rollback transaction: %v", err)
-		}
-	}()
+	defer rollback(tx)
 
 	// Get entry ID
 	var id int64
-	err = tx.QueryRow("SELECT id FROM password_entries WHERE service = ?", service).Scan(&id)
+	var uuid string
+	err = tx.QueryRow("SELECT id, COALESCE(uuid, '') FROM password_entries WHERE service = ?", service).Scan(&id, &uuid)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil // Entry not found, nothing to delete
@@ -308,31 +509,175 @@ func (d *Database) DeletePasswordEntry(service string) error {
 		return fmt.Errorf("failed to get entry ID: %w", err)
 	}
 
+	if err = deleteEntry(tx, id); err != nil {
+		return err
+	}
+	if uuid != "" {
+		tombstone := &model.Tombstone{UUID: uuid, Service: service, DeletedAt: time.Now().UTC()}
+		if err = saveTombstone(tx, tombstone); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// deleteEntry deletes the entry with the given ID, its tags, its fields and its attachments within tx.
+func deleteEntry(tx *sql.Tx, id int64) error {
 	// Delete tags
-	_, err = tx.Exec("DELETE FROM tags WHERE entry_id = ?", id)
+	_, err := tx.Exec("DELETE FROM tags WHERE entry_id = ?", id)
 	if err != nil {
 		return fmt.Errorf("failed to delete tags: %w", err)
 	}
+	if _, err = tx.Exec("DELETE FROM entry_fields WHERE entry_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete fields: %w", err)
+	}
+	_, err = tx.Exec(
+		"DELETE FROM attachment_chunks WHERE attachment_id IN (SELECT id FROM attachments WHERE entry_id = ?)", id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete attachment chunks: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM attachments WHERE entry_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
 
 	// Delete entry
 	_, err = tx.Exec("DELETE FROM password_entries WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("failed to delete password entry: %w", err)
 	}
+	return nil
+}
+
+// saveTombstone records tombstone within tx. An existing tombstone of the same entry is kept.
+func saveTombstone(tx *sql.Tx, tombstone *model.Tombstone) error {
+	_, err := tx.Exec(`
+        INSERT INTO deleted_entries (uuid, service, deleted_at, synced_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (uuid) DO NOTHING
+    `, tombstone.UUID, tombstone.Service, tombstone.DeletedAt, tombstone.SyncedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save tombstone: %w", err)
+	}
+	return nil
+}
+
+// ListTombstones lists the tombstones of the deleted entries.
+func (d *Database) ListTombstones() ([]*model.Tombstone, error) {
+	rows, err := d.db.Query("SELECT uuid, service, deleted_at, synced_at FROM deleted_entries ORDER BY uuid")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tombstones: %w", err)
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var tombstones []*model.Tombstone
+	for rows.Next() {
+		var tombstone model.Tombstone
+		if err = rows.Scan(&tombstone.UUID, &tombstone.Service, &tombstone.DeletedAt, &tombstone.SyncedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tombstone: %w", err)
+		}
+		tombstones = append(tombstones, &tombstone)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate tombstones: %w", err)
+	}
+
+	return tombstones, nil
+}
+
+// SaveSync applies the outcome of merging the vault with the device ID peerID in a single transaction:
+// the entries with the given tombstones are deleted, the entries are saved, matching existing entries by UUID,
+// and syncedAt is recorded as the last time the peer was merged.
+func (d *Database) SaveSync(
+	entries []*model.PasswordEntry, tombstones []*model.Tombstone, peerID string, syncedAt time.Time,
+) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer rollback(tx)
+
+	// Deletions come first, so that a deleted entry can't clash with the service of a saved one
+	for _, tombstone := range tombstones {
+		var id int64
+		err = tx.QueryRow("SELECT id FROM password_entries WHERE uuid = ?", tombstone.UUID).Scan(&id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return fmt.Errorf("failed to get entry ID: %w", err)
+		default:
+			if err = deleteEntry(tx, id); err != nil {
+				return err
+			}
+		}
+		if err = saveTombstone(tx, tombstone); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		err = tx.QueryRow("SELECT id FROM password_entries WHERE uuid = ?", entry.UUID).Scan(&entry.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			entry.ID = 0
+		} else if err != nil {
+			return fmt.Errorf("failed to get entry ID: %w", err)
+		}
+		if err = saveEntry(tx, entry); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO sync_peers (device_id, last_sync) VALUES (?, ?)
+        ON CONFLICT (device_id) DO UPDATE SET last_sync = excluded.last_sync
+    `, peerID, syncedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save last sync: %w", err)
+	}
 
 	return tx.Commit()
 }
 
+// GetLastSync retrieves the last time the vault with the device ID peerID was merged.
+// The zero time is returned if it was never merged.
+func (d *Database) GetLastSync(peerID string) (time.Time, error) {
+	var lastSync time.Time
+	err := d.db.QueryRow("SELECT last_sync FROM sync_peers WHERE device_id = ?", peerID).Scan(&lastSync)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("failed to get last sync: %w", err)
+	}
+	return lastSync, nil
+}
+
 // SaveVaultMetadata saves vault metadata to the database.
 func (d *Database) SaveVaultMetadata(v *model.VaultMetadata) error {
+	return saveVaultMetadata(d.db, v)
+}
+
+// execer executes statements, either directly on the database or within a transaction.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// saveVaultMetadata saves vault metadata with e.
+func saveVaultMetadata(e execer, v *model.VaultMetadata) error {
 	// Insert or update vault metadata
-	_, err := d.db.Exec(`
+	_, err := e.Exec(`
         INSERT OR REPLACE INTO vault_metadata (key, value) VALUES 
         ('master_hash', ?),
         ('created_at', ?),
         ('last_access', ?),
-        ('version', ?)
-    `, v.MasterHash, v.CreatedAt, v.LastAccess, v.Version)
+        ('version', ?),
+        ('vault_id', ?),
+        ('device_id', ?),
+        ('identity_key', ?),
+        ('mac', ?)
+    `, v.MasterHash, v.CreatedAt.Format(time.RFC3339Nano), v.LastAccess.Format(time.RFC3339Nano),
+		v.Version, v.VaultID, v.DeviceID, v.IdentityKey, v.MAC)
 
 	if err != nil {
 		return fmt.Errorf("failed to save vault metadata: %w", err)
@@ -357,7 +702,7 @@ func (d *Database) GetVaultMetadata() (*model.VaultMetadata, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get created_at: %w", err)
 	}
-	metadata.CreatedAt, err = time.Parse(time.RFC3339, createdAtStr)
+	metadata.CreatedAt, err = parseTimestamp(createdAtStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse created_at: %w", err)
 	}
@@ -367,7 +712,7 @@ func (d *Database) GetVaultMetadata() (*model.VaultMetadata, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get last_access: %w", err)
 	}
-	metadata.LastAccess, err = time.Parse(time.RFC3339, lastAccessStr)
+	metadata.LastAccess, err = parseTimestamp(lastAccessStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse last_access: %w", err)
 	}
@@ -378,5 +723,409 @@ func (d *Database) GetVaultMetadata() (*model.VaultMetadata, error) {
 		return nil, fmt.Errorf("failed to get version: %w", err)
 	}
 
+	// Get vault_id, vaults created before version 0.0.2 don't have one
+	err = d.db.QueryRow("SELECT value FROM vault_metadata WHERE key = 'vault_id'").Scan(&metadata.VaultID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get vault_id: %w", err)
+	}
+
+	// Get device_id, vaults created before version 0.0.5 don't have one
+	err = d.db.QueryRow("SELECT value FROM vault_metadata WHERE key = 'device_id'").Scan(&metadata.DeviceID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get device_id: %w", err)
+	}
+
+	// Get identity_key, vaults created before version 0.0.6 don't have one
+	err = d.db.QueryRow("SELECT value FROM vault_metadata WHERE key = 'identity_key'").Scan(&metadata.IdentityKey)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get identity_key: %w", err)
+	}
+
+	// Get mac, vaults created before version 0.0.3 don't have one
+	err = d.db.QueryRow("SELECT value FROM vault_metadata WHERE key = 'mac'").Scan(&metadata.MAC)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get mac: %w", err)
+	}
+
 	return &metadata, nil
 }
+
+// rollback rolls back tx, unless it has already been committed.
+func rollback(tx *sql.Tx) {
+	if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		log.Printf("failed to rollback transaction: %v", err)
+	}
+}
+
+// legacyTimestampFormat is the format used by the sqlite driver when a time.Time
+// is stored in a TEXT column, as vault_metadata did before timestamps were formatted explicitly.
+const legacyTimestampFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// parseTimestamp parses a timestamp stored in vault_metadata.
+func parseTimestamp(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return t, nil
+	}
+	if legacy, legacyErr := time.Parse(legacyTimestampFormat, value); legacyErr == nil {
+		return legacy, nil
+	}
+	return time.Time{}, err
+}
+
+// createFieldsTable creates the table of the custom fields of the entries.
+func (d *Database) createFieldsTable() error {
+	_, err := d.db.Exec(`
+        CREATE TABLE IF NOT EXISTS entry_fields (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            entry_id INTEGER NOT NULL,
+            position INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            type TEXT NOT NULL,
+            value TEXT NOT NULL,
+            FOREIGN KEY (entry_id) REFERENCES password_entries(id) ON DELETE CASCADE,
+            UNIQUE (entry_id, name)
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create entry_fields table: %w", err)
+	}
+	return nil
+}
+
+// createAttachmentTables creates the tables of the attachments of the entries and of their encrypted chunks.
+func (d *Database) createAttachmentTables() error {
+	_, err := d.db.Exec(`
+        CREATE TABLE IF NOT EXISTS attachments (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            entry_id INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            size INTEGER NOT NULL DEFAULT 0,
+            chunks INTEGER NOT NULL DEFAULT 0,
+            key TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP NOT NULL,
+            FOREIGN KEY (entry_id) REFERENCES password_entries(id) ON DELETE CASCADE,
+            UNIQUE (entry_id, name)
+        );
+
+        CREATE TABLE IF NOT EXISTS attachment_chunks (
+            attachment_id INTEGER NOT NULL,
+            seq INTEGER NOT NULL,
+            data BLOB NOT NULL,
+            PRIMARY KEY (attachment_id, seq),
+            FOREIGN KEY (attachment_id) REFERENCES attachments(id) ON DELETE CASCADE
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create attachment tables: %w", err)
+	}
+	return nil
+}
+
+// createMembersTable creates the table of the vault members.
+func (d *Database) createMembersTable() error {
+	_, err := d.db.Exec(`
+        CREATE TABLE IF NOT EXISTS vault_members (
+            name TEXT PRIMARY KEY,
+            kind TEXT NOT NULL,
+            public_key TEXT NOT NULL,
+            salt TEXT NOT NULL DEFAULT '',
+            private_key TEXT NOT NULL DEFAULT '',
+            wrapped_key TEXT NOT NULL,
+            added_at TIMESTAMP NOT NULL
+        );
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create vault_members table: %w", err)
+	}
+	return nil
+}
+
+// SaveMember creates or replaces a vault member, identified by its name.
+func (d *Database) SaveMember(member *model.VaultMember) error {
+	return saveMember(d.db, member)
+}
+
+// saveMember creates or replaces a vault member with e.
+func saveMember(e execer, member *model.VaultMember) error {
+	_, err := e.Exec(`
+        INSERT OR REPLACE INTO vault_members (name, kind, public_key, salt, private_key, wrapped_key, added_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, member.Name, member.Kind, member.PublicKey, member.Salt, member.PrivateKey, member.WrappedKey, member.AddedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save member %s: %w", member.Name, err)
+	}
+	return nil
+}
+
+// ListMembers lists the vault members, sorted by name.
+func (d *Database) ListMembers() ([]*model.VaultMember, error) {
+	rows, err := d.db.Query(`
+        SELECT name, kind, public_key, salt, private_key, wrapped_key, added_at
+        FROM vault_members ORDER BY name
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query members: %w", err)
+	}
+	defer func() {
+		if err = rows.Close(); err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var members []*model.VaultMember
+	for rows.Next() {
+		var member model.VaultMember
+		err = rows.Scan(&member.Name, &member.Kind, &member.PublicKey, &member.Salt, &member.PrivateKey,
+			&member.WrappedKey, &member.AddedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan member: %w", err)
+		}
+		members = append(members, &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate members: %w", err)
+	}
+
+	return members, nil
+}
+
+// DeleteMember deletes the vault member with the given name.
+func (d *Database) DeleteMember(name string) error {
+	if _, err := d.db.Exec("DELETE FROM vault_members WHERE name = ?", name); err != nil {
+		return fmt.Errorf("failed to delete member %s: %w", name, err)
+	}
+	return nil
+}
+
+// SaveRekey replaces, in a single transaction, the password, the OTP secret and the fields of every entry and the key
+// of every attachment, matched by ID, the vault members and the vault metadata, after the vault data key was rotated.
+func (d *Database) SaveRekey(
+	entries []*model.PasswordEntry, attachments []*model.Attachment, members []*model.VaultMember,
+	meta *model.VaultMetadata,
+) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer rollback(tx)
+
+	for _, entry := range entries {
+		_, err = tx.Exec("UPDATE password_entries SET password = ?, otp = ? WHERE id = ?",
+			entry.Password, entry.OTP, entry.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update password entry: %w", err)
+		}
+		if err = saveFields(tx, entry); err != nil {
+			return err
+		}
+	}
+	for _, attachment := range attachments {
+		if _, err = tx.Exec("UPDATE attachments SET key = ? WHERE id = ?", attachment.Key, attachment.ID); err != nil {
+			return fmt.Errorf("failed to update attachment key: %w", err)
+		}
+	}
+	if _, err = tx.Exec("DELETE FROM vault_members"); err != nil {
+		return fmt.Errorf("failed to delete members: %w", err)
+	}
+	for _, member := range members {
+		if err = saveMember(tx, member); err != nil {
+			return err
+		}
+	}
+	if err = saveVaultMetadata(tx, meta); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveMigration updates, in a single transaction, the entries and the attachment keys, matched by ID, rewritten by a
+// migration and the vault metadata, holding the migrated version and its MAC, so that an interrupted migration leaves
+// the vault untouched.
+func (d *Database) SaveMigration(
+	entries []*model.PasswordEntry, attachments []*model.Attachment, meta *model.VaultMetadata,
+) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer rollback(tx)
+
+	for _, entry := range entries {
+		if err = saveEntry(tx, entry); err != nil {
+			return err
+		}
+	}
+	for _, attachment := range attachments {
+		if _, err = tx.Exec("UPDATE attachments SET key = ? WHERE id = ?", attachment.Key, attachment.ID); err != nil {
+			return fmt.Errorf("failed to update attachment key: %w", err)
+		}
+	}
+	if err = saveVaultMetadata(tx, meta); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveAttachment stores a new attachment of the entry attachment.EntryID in a single transaction, assigning its ID.
+// next is called for the encrypted chunks of the attachment, in order, until it returns io.EOF, after setting the
+// size, the number of chunks and the key of attachment: only then are they stored. The chunks are never all held in
+// memory. Nothing is stored if next returns any other error.
+func (d *Database) SaveAttachment(attachment *model.Attachment, next func() ([]byte, error)) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer rollback(tx)
+
+	result, err := tx.Exec("INSERT INTO attachments (entry_id, name, created_at) VALUES (?, ?, ?)",
+		attachment.EntryID, attachment.Name, attachment.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert attachment: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get attachment ID: %w", err)
+	}
+
+	for seq := 0; ; seq++ {
+		chunk, nextErr := next()
+		if errors.Is(nextErr, io.EOF) {
+			break
+		}
+		if nextErr != nil {
+			return nextErr
+		}
+		if _, err = tx.Exec("INSERT INTO attachment_chunks (attachment_id, seq, data) VALUES (?, ?, ?)",
+			id, seq, chunk); err != nil {
+			return fmt.Errorf("failed to insert attachment chunk: %w", err)
+		}
+	}
+	_, err = tx.Exec("UPDATE attachments SET size = ?, chunks = ?, key = ? WHERE id = ?",
+		attachment.Size, attachment.Chunks, attachment.Key, id)
+	if err != nil {
+		return fmt.Errorf("failed to update attachment: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	attachment.ID = id
+	return nil
+}
+
+// GetAttachment retrieves the attachment of the entry with the given ID by name, or nil if it does not exist.
+func (d *Database) GetAttachment(entryID int64, name string) (*model.Attachment, error) {
+	var attachment model.Attachment
+	err := d.db.QueryRow(`
+        SELECT id, entry_id, name, size, chunks, key, created_at
+        FROM attachments
+        WHERE entry_id = ? AND name = ?
+    `, entryID, name).Scan(
+		&attachment.ID, &attachment.EntryID, &attachment.Name, &attachment.Size, &attachment.Chunks,
+		&attachment.Key, &attachment.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// ListAttachments retrieves the attachments of the entry with the given ID, sorted by name.
+func (d *Database) ListAttachments(entryID int64) ([]*model.Attachment, error) {
+	return d.queryAttachments(`
+        SELECT id, entry_id, name, size, chunks, key, created_at
+        FROM attachments
+        WHERE entry_id = ?
+        ORDER BY name
+    `, entryID)
+}
+
+// ListAllAttachments retrieves the attachments of every entry, sorted by entry ID and name, without their chunks.
+func (d *Database) ListAllAttachments() ([]*model.Attachment, error) {
+	return d.queryAttachments(`
+        SELECT id, entry_id, name, size, chunks, key, created_at
+        FROM attachments
+        ORDER BY entry_id, name
+    `)
+}
+
+// queryAttachments retrieves the attachments selected by query.
+func (d *Database) queryAttachments(query string, args ...any) ([]*model.Attachment, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	var attachments []*model.Attachment
+	for rows.Next() {
+		var attachment model.Attachment
+		if err := rows.Scan(&attachment.ID, &attachment.EntryID, &attachment.Name, &attachment.Size,
+			&attachment.Chunks, &attachment.Key, &attachment.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, &attachment)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", rows.Err())
+	}
+	return attachments, nil
+}
+
+// ReadAttachmentChunks calls fn with the encrypted chunks of the attachment with the given ID, in order, one at a
+// time, stopping at the first error returned by fn.
+func (d *Database) ReadAttachmentChunks(attachmentID int64, fn func(seq int, chunk []byte) error) error {
+	rows, err := d.db.Query("SELECT seq, data FROM attachment_chunks WHERE attachment_id = ? ORDER BY seq",
+		attachmentID)
+	if err != nil {
+		return fmt.Errorf("failed to query attachment chunks: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("failed to close rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var seq int
+		var chunk []byte
+		if err := rows.Scan(&seq, &chunk); err != nil {
+			return fmt.Errorf("failed to scan attachment chunk: %w", err)
+		}
+		if err := fn(seq, chunk); err != nil {
+			return err
+		}
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("failed to query attachment chunks: %w", rows.Err())
+	}
+	return nil
+}
+
+// DeleteAttachment deletes the attachment with the given ID and its chunks.
+func (d *Database) DeleteAttachment(attachmentID int64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer rollback(tx)
+
+	if _, err = tx.Exec("DELETE FROM attachment_chunks WHERE attachment_id = ?", attachmentID); err != nil {
+		return fmt.Errorf("failed to delete attachment chunks: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM attachments WHERE id = ?", attachmentID); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,83 @@
+// Package export writes vault entries to formats that can be read outside psst.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// CSVHeader is the header row of a CSV export.
+var CSVHeader = []string{
+	"service", "username", "password", "url", "notes", "tags", "created_at", "modified_at", "last_used_at", "kind",
+	"otp", "fields",
+}
+
+// WriteCSV writes the entries to w as CSV, passwords, OTP secrets and custom fields included in plain text.
+// Tags are joined by commas, timestamps are formatted as RFC 3339 and left empty when unset, and the custom fields
+// are a JSON array of model.EntryField, left empty when the entry has none.
+func WriteCSV(w io.Writer, entries []*model.PasswordEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	for _, entry := range entries {
+		record := []string{
+			entry.Service,
+			entry.Username,
+			entry.Password,
+			entry.URL,
+			entry.Notes,
+			strings.Join(entry.Tags, ","),
+			formatTime(entry.CreatedAt),
+			formatTime(entry.ModifiedAt),
+			formatTime(entry.LastUsedAt),
+			entry.Kind,
+			entry.OTP,
+			"",
+		}
+		if len(entry.Fields) > 0 {
+			fields, err := json.Marshal(entry.Fields)
+			if err != nil {
+				return fmt.Errorf("failed to encode the fields of entry %s: %w", entry.Service, err)
+			}
+			record[len(record)-1] = string(fields)
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("failed to write entry %s: %w", entry.Service, err)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// FilterByTag returns the entries tagged with tag.
+// If tag is empty, all entries are returned.
+func FilterByTag(entries []*model.PasswordEntry, tag string) []*model.PasswordEntry {
+	if tag == "" {
+		return entries
+	}
+
+	var filtered []*model.PasswordEntry
+	for _, entry := range entries {
+		if slices.Contains(entry.Tags, tag) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
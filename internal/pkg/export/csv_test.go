@@ -0,0 +1,81 @@
+package export_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/export"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+func TestWriteCSV(t *testing.T) {
+	created := time.Date(2025, 4, 14, 10, 30, 0, 0, time.UTC)
+	entries := []*model.PasswordEntry{
+		{
+			Service:    "gmail",
+			Username:   "user@example.com",
+			Password:   `p4ss,"word"`,
+			URL:        "https://mail.google.com",
+			Notes:      "personal\nmail",
+			Tags:       []string{"email", "important"},
+			CreatedAt:  created,
+			ModifiedAt: created,
+		},
+		{
+			Service:  "github",
+			Password: "secret",
+			Tags:     []string{"work"},
+			OTP:      "otpauth://totp/github?secret=JBSWY3DPEHPK3PXP",
+		},
+		{
+			Service: "visa",
+			Kind:    model.KindCard,
+			Fields: []model.EntryField{
+				{Name: "number", Type: model.FieldTypeHidden, Value: "4111111111111111"},
+				{Name: "expiry", Type: model.FieldTypeText, Value: "12/30"},
+			},
+			Tags: []string{"bank"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		want    string
+		entries []*model.PasswordEntry
+	}{
+		{
+			name:    "writes all entries",
+			entries: entries,
+			want: "service,username,password,url,notes,tags,created_at,modified_at,last_used_at,kind,otp,fields\n" +
+				`gmail,user@example.com,"p4ss,""word""",https://mail.google.com,"personal` + "\n" +
+				`mail","email,important",2025-04-14T10:30:00Z,2025-04-14T10:30:00Z,,,,` + "\n" +
+				"github,,secret,,,work,,,,,otpauth://totp/github?secret=JBSWY3DPEHPK3PXP,\n" +
+				`visa,,,,,bank,,,,card,,"[{""name"":""number"",""type"":""hidden"",""value"":""4111111111111111""},` +
+				`{""name"":""expiry"",""type"":""text"",""value"":""12/30""}]"` + "\n",
+		},
+		{
+			name:    "writes entries filtered by tag",
+			entries: export.FilterByTag(entries, "work"),
+			want: "service,username,password,url,notes,tags,created_at,modified_at,last_used_at,kind,otp,fields\n" +
+				"github,,secret,,,work,,,,,otpauth://totp/github?secret=JBSWY3DPEHPK3PXP,\n",
+		},
+		{
+			name:    "writes only the header without entries",
+			entries: export.FilterByTag(entries, "missing"),
+			want:    "service,username,password,url,notes,tags,created_at,modified_at,last_used_at,kind,otp,fields\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := export.WriteCSV(&buf, tt.entries); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if buf.String() != tt.want {
+				t.Fatalf("Expected:\n%s\ngot:\n%s", tt.want, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// WritePassTree writes the entries to dir as a plain text staging tree for pass, passwords included in plain text.
+//
+// Each entry is written to a file named after its service, in nested directories named after its tags, following
+// the layout read by the pass importer. The first line of a file is the password, followed by the username, the URL
+// and the custom fields as "key: value" lines, by the otpauth:// URI and by the notes. The files can be encrypted
+// into a password store with pass insert -m.
+// Directories are created readable only by the current user, existing files are never overwritten.
+func WritePassTree(dir string, entries []*model.PasswordEntry) error {
+	for _, entry := range entries {
+		parts := make([]string, 0, len(entry.Tags)+1)
+		for _, tag := range entry.Tags {
+			parts = append(parts, passPathName(tag))
+		}
+		path := filepath.Join(dir, filepath.Join(parts...), passPathName(entry.Service))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", entry.Service, err)
+		}
+		//nolint:gosec // the path is built from the output directory provided by the user
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create file for %s: %w", entry.Service, err)
+		}
+		if _, err = f.WriteString(formatPassFile(entry)); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to write file for %s: %w", entry.Service, err)
+		}
+		if err = f.Close(); err != nil {
+			return fmt.Errorf("failed to write file for %s: %w", entry.Service, err)
+		}
+	}
+	return nil
+}
+
+// formatPassFile formats an entry as the content of a pass file.
+func formatPassFile(entry *model.PasswordEntry) string {
+	var b strings.Builder
+	b.WriteString(entry.Password + "\n")
+	if entry.Username != "" {
+		b.WriteString("username: " + entry.Username + "\n")
+	}
+	if entry.URL != "" {
+		b.WriteString("url: " + entry.URL + "\n")
+	}
+	for _, field := range entry.Fields {
+		b.WriteString(field.Name + ": " + field.Value + "\n")
+	}
+	if entry.OTP != "" {
+		b.WriteString(entry.OTP + "\n")
+	}
+	if entry.Notes != "" {
+		b.WriteString(strings.TrimRight(entry.Notes, "\n") + "\n")
+	}
+	return b.String()
+}
+
+// passPathName makes a service or a tag usable as a file or directory name: path separators are replaced and
+// names that would be hidden or refer to a parent directory are prefixed with an underscore.
+func passPathName(name string) string {
+	name = strings.NewReplacer("/", "_", `\`, "_", "\x00", "").Replace(strings.TrimSpace(name))
+	if name == "" || strings.HasPrefix(name, ".") {
+		name = "_" + name
+	}
+	return name
+}
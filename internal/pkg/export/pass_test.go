@@ -0,0 +1,61 @@
+package export_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/export"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+func TestWritePassTree(t *testing.T) {
+	entries := []*model.PasswordEntry{
+		{
+			Service:  "gmail",
+			Username: "user@example.com",
+			Password: "secret123",
+			URL:      "https://mail.google.com",
+			Notes:    "personal\nmail\n",
+			Tags:     []string{"email", "google"},
+		},
+		{
+			Service:  "github",
+			Password: "secret456",
+			OTP:      "otpauth://totp/github?secret=JBSWY3DPEHPK3PXP",
+			Fields:   []model.EntryField{{Name: "pin", Type: model.FieldTypeHidden, Value: "1234"}},
+		},
+		{Service: "../escape", Password: "secret789", Tags: []string{".git"}},
+	}
+	dir := t.TempDir()
+	if err := export.WritePassTree(dir, entries); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	want := map[string]string{
+		"email/google/gmail": "secret123\nusername: user@example.com\nurl: https://mail.google.com\npersonal\nmail\n",
+		"github":             "secret456\npin: 1234\notpauth://totp/github?secret=JBSWY3DPEHPK3PXP\n",
+		"_.git/_.._escape":   "secret789\n",
+	}
+	for name, content := range want {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Fatalf("Expected %s to contain [%q], got [%q]", name, content, got)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Fatalf("Expected %s permissions [0600], got [%o]", name, info.Mode().Perm())
+		}
+	}
+
+	if err := export.WritePassTree(dir, entries[1:2]); err == nil {
+		t.Fatal("Expected an error when a file already exists")
+	}
+}
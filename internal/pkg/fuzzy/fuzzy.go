@@ -0,0 +1,77 @@
+// Package fuzzy scores how well a query matches a text, to rank search results.
+package fuzzy
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Scores of the kinds of matches. Subsequence matches score between MinScore and substring matches.
+const (
+	ExactScore     = 100
+	PrefixScore    = 80
+	WordScore      = 70
+	SubstringScore = 60
+	MinScore       = 10
+)
+
+const (
+	// maxLengthPenalty is the maximum penalty of prefix and substring matches in longer texts.
+	maxLengthPenalty = 10
+	// maxSubsequenceScore keeps subsequence matches below the substring matches of the longest texts.
+	maxSubsequenceScore = SubstringScore - maxLengthPenalty - 1
+)
+
+// Score returns how well query matches text, ignoring case, and whether it matches at all: the characters of the
+// query must appear in the text in order. Exact matches score highest, followed by prefixes, substrings starting a
+// word, other substrings and, last, subsequences, scoring higher when their characters are consecutive or start
+// words. Among prefixes and substrings, those of shorter texts score higher.
+func Score(query, text string) (int, bool) {
+	q, t := strings.ToLower(query), strings.ToLower(text)
+	if q == "" {
+		return 0, false
+	}
+	penalty := min((len(t)-len(q))/4, maxLengthPenalty)
+	switch i := strings.Index(t, q); {
+	case t == q:
+		return ExactScore, true
+	case i == 0:
+		return PrefixScore - penalty, true
+	case i > 0 && isWordStart([]rune(t), len([]rune(t[:i]))):
+		return WordScore - penalty, true
+	case i > 0:
+		return SubstringScore - penalty, true
+	}
+	return subsequenceScore([]rune(q), []rune(t))
+}
+
+// subsequenceScore scores the leftmost match of the characters of q in t, in order.
+func subsequenceScore(q, t []rune) (int, bool) {
+	var points, matched int
+	last := -2
+	for i := 0; i < len(t) && matched < len(q); i++ {
+		if t[i] != q[matched] {
+			continue
+		}
+		points++
+		if i == last+1 {
+			points += 2
+		}
+		if isWordStart(t, i) {
+			points += 2
+		}
+		last = i
+		matched++
+	}
+	if matched < len(q) {
+		return 0, false
+	}
+	// Every character scores at most 5 points, when consecutive to the previous one and starting a word.
+	maxPoints := 5 * len(q)
+	return MinScore + (maxSubsequenceScore-MinScore)*points/maxPoints, true
+}
+
+// isWordStart reports whether the character at index i of t starts a word.
+func isWordStart(t []rune, i int) bool {
+	return i == 0 || !unicode.IsLetter(t[i-1]) && !unicode.IsDigit(t[i-1])
+}
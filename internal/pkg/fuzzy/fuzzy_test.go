@@ -0,0 +1,40 @@
+package fuzzy_test
+
+import (
+	"testing"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/fuzzy"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		query string
+		text  string
+		want  int
+		match bool
+	}{
+		{query: "github", text: "GitHub", want: fuzzy.ExactScore, match: true},
+		{query: "git", text: "github", want: fuzzy.PrefixScore, match: true},
+		{query: "hub", text: "git-hub", want: fuzzy.WordScore - 1, match: true},
+		{query: "hub", text: "github", want: fuzzy.SubstringScore, match: true},
+		{query: "gh", text: "github", want: 25, match: true},
+		{query: "gh", text: "git-hub", want: 33, match: true},
+		{query: "hg", text: "github"},
+		{query: "", text: "github"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query+" in "+tt.text, func(t *testing.T) {
+			got, match := fuzzy.Score(tt.query, tt.text)
+			if got != tt.want || match != tt.match {
+				t.Fatalf("Score(%q, %q) = %d, %v, want %d, %v", tt.query, tt.text, got, match, tt.want, tt.match)
+			}
+		})
+	}
+
+	// Matches in shorter texts rank higher, but never below a worse kind of match.
+	short, _ := fuzzy.Score("mail", "mail.google.com")
+	long, _ := fuzzy.Score("mail", "mail.google.com/mail/u/0/#inbox?compose=new")
+	if short <= long || long <= fuzzy.WordScore {
+		t.Fatalf("Expected the shorter prefix to rank higher, got %d and %d", short, long)
+	}
+}
@@ -0,0 +1,259 @@
+// Package gitmirror keeps the mirror files of a vault in a git repository, giving the vault a history and
+// replicating it through any git remote. Every operation shells out to the git command.
+package gitmirror
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNotInitialized is returned by Open when the directory isn't a git repository.
+var ErrNotInitialized = errors.New("git repository not initialized")
+
+// ErrRejected is returned by Push when the remote has changes that must be pulled first.
+var ErrRejected = errors.New("push rejected by the remote")
+
+// Repo is a git repository holding the mirror files of a vault.
+// The files at its root and in its directories are entirely managed by Update.
+type Repo struct {
+	dir string
+}
+
+// Init creates the git repository in dir, creating dir if needed, and returns it.
+// Initializing an existing repository is safe.
+func Init(dir string) (*Repo, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create git repository: %w", err)
+	}
+	r := &Repo{dir: dir}
+	if _, err := r.git(nil, "init", "--quiet"); err != nil {
+		return nil, err
+	}
+	// Commits are made automatically: fall back to a psst identity if git has none configured.
+	if out, err := r.git(nil, "config", "user.email"); err != nil || len(bytes.TrimSpace(out)) == 0 {
+		if _, err = r.git(nil, "config", "user.name", "psst"); err != nil {
+			return nil, err
+		}
+		if _, err = r.git(nil, "config", "user.email", "psst@localhost"); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Open opens the git repository in dir.
+// If dir isn't a git repository, an error wrapping ErrNotInitialized is returned.
+func Open(dir string) (*Repo, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w in %s", ErrNotInitialized, dir)
+		}
+		return nil, fmt.Errorf("failed to open git repository: %w", err)
+	}
+	return &Repo{dir: dir}, nil
+}
+
+// Dir returns the directory of the repository.
+func (r *Repo) Dir() string {
+	return r.dir
+}
+
+// Update replaces the files of the working tree with files, keyed by their slash-separated path, and commits them
+// with message. Nothing is committed if the files didn't change.
+func (r *Repo) Update(files map[string][]byte, message string) error {
+	if err := r.removeStale(files); err != nil {
+		return err
+	}
+	for name, content := range files {
+		file := filepath.Join(r.dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		if err := os.WriteFile(file, content, 0o600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	if _, err := r.git(nil, "add", "--all"); err != nil {
+		return err
+	}
+	if _, err := r.git(nil, "diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	_, err := r.git(nil, "commit", "--quiet", "--message", message)
+	return err
+}
+
+// removeStale removes the files of the working tree missing from files, ignoring the git directory.
+func (r *Repo) removeStale(files map[string][]byte) error {
+	return filepath.WalkDir(r.dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name, err := filepath.Rel(r.dir, file)
+		if err != nil {
+			return err
+		}
+		if _, ok := files[filepath.ToSlash(name)]; ok {
+			return nil
+		}
+		if err = os.Remove(file); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// AddRemote adds the remote name with the given URL.
+func (r *Repo) AddRemote(name, url string) error {
+	_, err := r.git(nil, "remote", "add", name, url)
+	return err
+}
+
+// Branch returns the name of the current branch.
+func (r *Repo) Branch() (string, error) {
+	out, err := r.git(nil, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Fetch fetches the branches of remote.
+func (r *Repo) Fetch(remote string) error {
+	_, err := r.git(nil, "fetch", "--quiet", remote)
+	return err
+}
+
+// Files returns the files of the commit ref, keyed by their slash-separated path.
+// It returns false if ref doesn't exist, e.g. a branch never pushed to the remote.
+func (r *Repo) Files(ref string) (map[string][]byte, bool, error) {
+	if _, err := r.git(nil, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		return nil, false, nil
+	}
+	out, err := r.git(nil, "ls-tree", "-r", "-z", ref)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Each line is "<mode> <type> <object>\t<path>"
+	var names, objects []string
+	var batch bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		meta, name, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		names = append(names, path.Clean(name))
+		objects = append(objects, fields[2])
+		batch.WriteString(fields[2] + "\n")
+	}
+	if len(objects) == 0 {
+		return map[string][]byte{}, true, nil
+	}
+
+	out, err = r.git(&batch, "cat-file", "--batch")
+	if err != nil {
+		return nil, false, err
+	}
+	files := make(map[string][]byte, len(names))
+	in := bufio.NewReader(bytes.NewReader(out))
+	for i, name := range names {
+		content, readErr := readBatchObject(in, objects[i])
+		if readErr != nil {
+			return nil, false, fmt.Errorf("failed to read %s: %w", name, readErr)
+		}
+		files[name] = content
+	}
+	return files, true, nil
+}
+
+// readBatchObject reads the object from the output of git cat-file --batch:
+// a "<object> <type> <size>" header line, followed by the content and a new line.
+func readBatchObject(in *bufio.Reader, object string) ([]byte, error) {
+	header, err := in.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 3 || fields[0] != object {
+		return nil, fmt.Errorf("unexpected git output %q", strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("unexpected git output %q", strings.TrimSpace(header))
+	}
+	content := make([]byte, size+1)
+	if _, err = io.ReadFull(in, content); err != nil {
+		return nil, err
+	}
+	return content[:size], nil
+}
+
+// MergeOurs records ref as merged into the current branch, keeping the working tree as it is.
+// It is used once the files of ref are merged into the working tree, so that the next push is a fast-forward.
+func (r *Repo) MergeOurs(ref string) error {
+	_, err := r.git(nil, "merge", "--quiet", "--no-edit", "--allow-unrelated-histories", "--strategy", "ours",
+		"--message", "Merge "+ref, ref)
+	return err
+}
+
+// Push pushes the current branch to the branch with the same name of remote.
+// If the remote branch has changes not merged yet, an error wrapping ErrRejected is returned.
+func (r *Repo) Push(remote string) error {
+	branch, err := r.Branch()
+	if err != nil {
+		return err
+	}
+	_, err = r.git(nil, "push", "--quiet", remote, "HEAD:refs/heads/"+branch)
+	if err != nil && strings.Contains(err.Error(), "[rejected]") {
+		return fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	return err
+}
+
+// git runs git with args in the repository, reading stdin if not nil, and returns its output.
+// On failure, the returned error includes what git printed on the standard error.
+func (r *Repo) git(stdin io.Reader, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := gitMessage(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s failed: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// gitMessage returns the error message printed by git, without its hints.
+func gitMessage(stderr string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		if !strings.HasPrefix(line, "hint:") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,148 @@
+package gitmirror_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/gitmirror"
+)
+
+// git runs git with args in dir and returns its trimmed output.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// initRepo initializes a repository in a temporary directory with the bare repository remote as origin.
+func initRepo(t *testing.T, remote string) *gitmirror.Repo {
+	t.Helper()
+	repo, err := gitmirror.Init(t.TempDir())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err = repo.AddRemote("origin", remote); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	return repo
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	if _, err := gitmirror.Open(dir); !errors.Is(err, gitmirror.ErrNotInitialized) {
+		t.Fatalf("Expected ErrNotInitialized, got [%v]", err)
+	}
+	if _, err := gitmirror.Init(dir); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	repo, err := gitmirror.Open(dir)
+	if err != nil || repo.Dir() != dir {
+		t.Fatalf("Expected the repository in %s, got [%v]", dir, err)
+	}
+}
+
+func TestRepo_Update(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo, err := gitmirror.Init(t.TempDir())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	updates := []struct {
+		files       map[string][]byte
+		wantCommits string
+	}{
+		{files: map[string][]byte{"VAULT": []byte("v"), "entries/a": []byte("a"), "entries/b": []byte("b")},
+			wantCommits: "1"},
+		// Unchanged files aren't committed again.
+		{files: map[string][]byte{"VAULT": []byte("v"), "entries/a": []byte("a"), "entries/b": []byte("b")},
+			wantCommits: "1"},
+		{files: map[string][]byte{"VAULT": []byte("v"), "entries/a": []byte("a2"), "deleted/b": []byte("b")},
+			wantCommits: "2"},
+	}
+	for _, update := range updates {
+		if err = repo.Update(update.files, "Update"); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if got := git(t, repo.Dir(), "rev-list", "--count", "HEAD"); got != update.wantCommits {
+			t.Fatalf("Expected %s commits, got %s", update.wantCommits, got)
+		}
+	}
+	if got := git(t, repo.Dir(), "ls-files"); got != "VAULT\ndeleted/b\nentries/a" {
+		t.Fatalf("Expected the stale files to be removed, got:\n%s", got)
+	}
+	if _, err = os.Stat(filepath.Join(repo.Dir(), "entries", "b")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected entries/b to be removed, got [%v]", err)
+	}
+}
+
+func TestRepo_PushPull(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	remote := t.TempDir()
+	git(t, remote, "init", "--quiet", "--bare")
+	local, other := initRepo(t, remote), initRepo(t, remote)
+
+	if err := local.Update(map[string][]byte{"entries/a": []byte("a")}, "Add a"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err := local.Push("origin"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if err := other.Update(map[string][]byte{"entries/b": []byte("b")}, "Add b"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err := other.Push("origin"); !errors.Is(err, gitmirror.ErrRejected) {
+		t.Fatalf("Expected ErrRejected pushing without pulling first, got [%v]", err)
+	}
+
+	branch, err := other.Branch()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	ref := "refs/remotes/origin/" + branch
+	if _, found, _ := other.Files(ref); found {
+		t.Fatal("Expected no files before fetching")
+	}
+	if err = other.Fetch("origin"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	files, found, err := other.Files(ref)
+	if err != nil || !found {
+		t.Fatalf("Expected the files of %s, got [%v]", ref, err)
+	}
+	if len(files) != 1 || string(files["entries/a"]) != "a" {
+		t.Fatalf("Expected the pushed file, got %v", files)
+	}
+
+	// The files of the remote are merged by the caller, then the merge is recorded so that the push succeeds.
+	merged := map[string][]byte{"entries/a": []byte("a"), "entries/b": []byte("b")}
+	if err = other.Update(merged, "Merge"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err = other.MergeOurs(ref); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err = other.Push("origin"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if got := git(t, remote, "ls-tree", "-r", "--name-only", branch); got != "entries/a\nentries/b" {
+		t.Fatalf("Expected the merged files on the remote, got:\n%s", got)
+	}
+}
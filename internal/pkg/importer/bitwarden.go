@@ -0,0 +1,156 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// Bitwarden item types, only logins can be imported.
+const (
+	bitwardenLogin      = 1
+	bitwardenSecureNote = 2
+	bitwardenCard       = 3
+	bitwardenIdentity   = 4
+)
+
+// Bitwarden custom field types.
+const (
+	bitwardenFieldText    = 0
+	bitwardenFieldHidden  = 1
+	bitwardenFieldBoolean = 2
+	bitwardenFieldLinked  = 3
+)
+
+// bitwardenExport is an unencrypted Bitwarden JSON export.
+type bitwardenExport struct {
+	Folders []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items     []bitwardenItem `json:"items"`
+	Encrypted bool            `json:"encrypted"`
+}
+
+type bitwardenItem struct {
+	CreationDate *time.Time          `json:"creationDate"`
+	RevisionDate *time.Time          `json:"revisionDate"`
+	FolderID     *string             `json:"folderId"`
+	Login        *bitwardenLoginData `json:"login"`
+	Notes        *string             `json:"notes"`
+	Name         string              `json:"name"`
+	Fields       []struct {
+		Value *string `json:"value"`
+		Name  string  `json:"name"`
+		Type  int     `json:"type"`
+	} `json:"fields"`
+	Type     int  `json:"type"`
+	Favorite bool `json:"favorite"`
+}
+
+type bitwardenLoginData struct {
+	Username *string `json:"username"`
+	Password *string `json:"password"`
+	URIs     []struct {
+		URI *string `json:"uri"`
+	} `json:"uris"`
+}
+
+// ParseBitwarden parses an unencrypted Bitwarden JSON export.
+//
+// Login items are mapped to entries: the first URI becomes the URL, the other URIs are appended to the notes, the
+// folder becomes a tag and the custom fields become custom fields of the entry, hidden ones of type
+// model.FieldTypeHidden, which are stored encrypted. Items that are not logins are reported as errors.
+func ParseBitwarden(r io.Reader) (*Result, error) {
+	var export bitwardenExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("failed to decode Bitwarden export: %w", err)
+	}
+	if export.Encrypted {
+		return nil, errors.New("encrypted Bitwarden exports are not supported, export the vault as unencrypted JSON")
+	}
+
+	folders := map[string]string{}
+	for _, folder := range export.Folders {
+		folders[folder.ID] = folder.Name
+	}
+
+	result := &Result{Extras: ExtrasFields}
+	for i := range export.Items {
+		item := &export.Items[i]
+		if item.Type != bitwardenLogin {
+			result.fail(item.Name, fmt.Errorf("unsupported item type %s", bitwardenTypeName(item.Type)))
+			continue
+		}
+		if item.Login == nil {
+			result.fail(item.Name, errors.New("missing login data"))
+			continue
+		}
+
+		entry := &model.PasswordEntry{
+			Service:  item.Name,
+			Username: deref(item.Login.Username),
+			Password: deref(item.Login.Password),
+			Notes:    deref(item.Notes),
+		}
+		if item.CreationDate != nil {
+			entry.CreatedAt = item.CreationDate.UTC()
+		}
+		if item.RevisionDate != nil {
+			entry.ModifiedAt = item.RevisionDate.UTC()
+		}
+		for _, uri := range item.Login.URIs {
+			switch {
+			case deref(uri.URI) == "":
+			case entry.URL == "":
+				entry.URL = *uri.URI
+			default:
+				appendNote(entry, "URL: "+*uri.URI)
+			}
+		}
+		if item.FolderID != nil && folders[*item.FolderID] != "" {
+			entry.Tags = append(entry.Tags, folders[*item.FolderID])
+		}
+		if item.Favorite {
+			entry.Tags = append(entry.Tags, "favorite")
+		}
+		for _, field := range item.Fields {
+			switch field.Type {
+			case bitwardenFieldText, bitwardenFieldBoolean:
+				addField(entry, field.Name, model.FieldTypeText, deref(field.Value))
+			case bitwardenFieldHidden:
+				addField(entry, field.Name, model.FieldTypeHidden, deref(field.Value))
+			case bitwardenFieldLinked:
+				// Linked fields only point to other fields of the login.
+			}
+		}
+
+		result.add(item.Name, entry)
+	}
+	return result, nil
+}
+
+func bitwardenTypeName(t int) string {
+	switch t {
+	case bitwardenSecureNote:
+		return "secure note"
+	case bitwardenCard:
+		return "card"
+	case bitwardenIdentity:
+		return "identity"
+	default:
+		return strconv.Itoa(t)
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
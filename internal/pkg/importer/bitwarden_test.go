@@ -0,0 +1,103 @@
+package importer_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/importer"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+func TestParseBitwarden(t *testing.T) {
+	data, err := os.ReadFile("testdata/bitwarden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := importer.ParseBitwarden(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	want := []*model.PasswordEntry{
+		{
+			Service:  "GitHub",
+			Username: "dev@example.com",
+			Password: "gh-secret",
+			URL:      "https://github.com/login",
+			Notes:    "2FA enabled\nURL: https://gist.github.com",
+			Tags:     []string{"Work", "favorite"},
+			Fields: []model.EntryField{
+				{Name: "team", Type: model.FieldTypeText, Value: "platform"},
+				{Name: "recovery code", Type: model.FieldTypeHidden, Value: "abcd-efgh"},
+			},
+			CreatedAt:  time.Date(2023, 5, 20, 18, 0, 0, 0, time.UTC),
+			ModifiedAt: time.Date(2024, 11, 2, 9, 15, 0, 0, time.UTC),
+		},
+		{
+			Service:  "Google",
+			Username: "personal@example.com",
+			Password: "g-secret-1",
+			URL:      "https://accounts.google.com",
+			Fields: []model.EntryField{
+				{Name: "password (2)", Type: model.FieldTypeHidden, Value: "1234"},
+				{Name: "password (3)", Type: model.FieldTypeHidden, Value: "5678"},
+			},
+		},
+		{
+			Service:  "Google (work@example.com)",
+			Username: "work@example.com",
+			Password: "g-secret-2",
+			Tags:     []string{"Work"},
+		},
+	}
+	if !reflect.DeepEqual(result.Entries, want) {
+		t.Fatalf("Expected entries:\n%+v\ngot:\n%+v", want, result.Entries)
+	}
+
+	wantErrors := []string{
+		`item "": missing service name`,
+		`item "Wi-Fi notes": unsupported item type secure note`,
+		`item "Visa": unsupported item type card`,
+	}
+	if len(result.Errors) != len(wantErrors) {
+		t.Fatalf("Expected %d errors, got %v", len(wantErrors), result.Errors)
+	}
+	for i, want := range wantErrors {
+		if result.Errors[i].Error() != want {
+			t.Fatalf("Expected error [%s], got [%s]", want, result.Errors[i])
+		}
+	}
+}
+
+func TestParseBitwarden_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		expectedErr string
+	}{
+		{
+			name:        "fails with malformed JSON",
+			data:        `{"items": [`,
+			expectedErr: "failed to decode Bitwarden export",
+		},
+		{
+			name:        "fails with an encrypted export",
+			data:        `{"encrypted": true, "items": []}`,
+			expectedErr: "encrypted Bitwarden exports are not supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := importer.ParseBitwarden(strings.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Fatalf("Expected error containing [%s], got [%v]", tt.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// Columns of the browser exports. Chrome exports name, url, username, password and note,
+// Firefox exports url, username and password together with the times in milliseconds since the Unix epoch.
+const (
+	browserColumnURL                 = "url"
+	browserColumnUsername            = "username"
+	browserColumnPassword            = "password"
+	browserColumnNote                = "note"
+	browserColumnTimeCreated         = "timecreated"
+	browserColumnTimePasswordChanged = "timepasswordchanged"
+	browserColumnTimeLastUsed        = "timelastused"
+)
+
+// browserLogin is a row of a browser export.
+type browserLogin struct {
+	entry  *model.PasswordEntry
+	item   string
+	domain string
+}
+
+// ParseBrowserCSV parses the passwords CSV export of Chrome or Firefox.
+//
+// The service of each login is the registrable domain of its URL, e.g. google.com for
+// https://accounts.google.com/signin. Logins of the same domain and username are merged into a single entry, the
+// URLs besides the first one being appended to the notes. When the same domain is used by different usernames, each
+// entry is named after both, e.g. "google.com (me@example.com)", so that the service names don't depend on the order
+// of the rows. Rows that can't be imported are reported as errors, identified by their line number.
+func ParseBrowserCSV(r io.Reader) (*Result, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range []string{browserColumnURL, browserColumnUsername, browserColumnPassword} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("not a browser password export: missing column %q", name)
+		}
+	}
+
+	result := &Result{}
+	var logins []*browserLogin
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("failed to read CSV: %w", err)
+			}
+			result.fail("line "+strconv.Itoa(parseErr.StartLine), parseErr.Err)
+			continue
+		}
+
+		line, _ := cr.FieldPos(0)
+		item := "line " + strconv.Itoa(line)
+		login, err := parseBrowserRecord(record, columns)
+		if err != nil {
+			result.fail(item, err)
+			continue
+		}
+		login.item = item
+		logins = append(logins, login)
+	}
+
+	for _, group := range groupByDomain(logins) {
+		for _, login := range mergeLogins(result, group) {
+			result.add(login.item, login.entry)
+		}
+	}
+	return result, nil
+}
+
+func parseBrowserRecord(record []string, columns map[string]int) (*browserLogin, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	rawURL := strings.TrimSpace(field(browserColumnURL))
+	if rawURL == "" {
+		return nil, errors.New("missing URL")
+	}
+	if field(browserColumnPassword) == "" {
+		return nil, errors.New("missing password")
+	}
+	domain, err := registrableDomain(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &model.PasswordEntry{
+		Service:    domain,
+		Username:   field(browserColumnUsername),
+		Password:   field(browserColumnPassword),
+		URL:        rawURL,
+		Notes:      field(browserColumnNote),
+		CreatedAt:  parseMillis(field(browserColumnTimeCreated)),
+		ModifiedAt: parseMillis(field(browserColumnTimePasswordChanged)),
+		LastUsedAt: parseMillis(field(browserColumnTimeLastUsed)),
+	}
+	return &browserLogin{entry: entry, domain: domain}, nil
+}
+
+// registrableDomain returns the domain under which rawURL is registered, e.g. example.co.uk for
+// https://www.login.example.co.uk. IP addresses and hosts that are public suffixes themselves are returned as is,
+// Android apps, stored by Chrome as android://<hash>@<package>/, are identified by their package name.
+func registrableDomain(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q", rawURL)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	switch {
+	case u.Scheme == "android" && host != "":
+		return host, nil
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", fmt.Errorf("unsupported URL %q", rawURL)
+	case host == "":
+		return "", fmt.Errorf("invalid URL %q", rawURL)
+	case net.ParseIP(host) != nil:
+		return host, nil
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host, nil
+	}
+	return domain, nil
+}
+
+// groupByDomain groups the logins by domain, in order of first appearance.
+func groupByDomain(logins []*browserLogin) [][]*browserLogin {
+	var groups [][]*browserLogin
+	index := map[string]int{}
+	for _, login := range logins {
+		i, ok := index[login.domain]
+		if !ok {
+			i = len(groups)
+			index[login.domain] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], login)
+	}
+	return groups
+}
+
+// mergeLogins merges the logins of a domain sharing the same username and names them after the domain.
+// If the same username has different passwords, the most recently changed one is kept and the other is reported.
+func mergeLogins(result *Result, group []*browserLogin) []*browserLogin {
+	var merged []*browserLogin
+	for _, login := range group {
+		i := slices.IndexFunc(merged, func(m *browserLogin) bool {
+			return m.entry.Username == login.entry.Username
+		})
+		if i < 0 {
+			merged = append(merged, login)
+			continue
+		}
+
+		kept, other := merged[i], login
+		if kept.entry.Password != other.entry.Password {
+			if other.entry.ModifiedAt.After(kept.entry.ModifiedAt) {
+				kept, other = other, kept
+			}
+			result.fail(other.item, fmt.Errorf("conflicting password for %s, kept the one from %s",
+				describeLogin(other), kept.item))
+		}
+		mergeLogin(kept.entry, other.entry)
+		merged[i] = kept
+	}
+
+	for _, login := range merged {
+		if len(merged) > 1 && login.entry.Username != "" {
+			login.entry.Service = fmt.Sprintf("%s (%s)", login.domain, login.entry.Username)
+		}
+	}
+	return merged
+}
+
+// mergeLogin adds the URL and the notes of other to entry, if they differ, and keeps the earliest creation time.
+func mergeLogin(entry, other *model.PasswordEntry) {
+	if other.URL != entry.URL && !strings.Contains(entry.Notes, "URL: "+other.URL) {
+		appendNote(entry, "URL: "+other.URL)
+	}
+	if other.Notes != "" && !strings.Contains(entry.Notes, other.Notes) {
+		appendNote(entry, other.Notes)
+	}
+	if !other.CreatedAt.IsZero() && (entry.CreatedAt.IsZero() || other.CreatedAt.Before(entry.CreatedAt)) {
+		entry.CreatedAt = other.CreatedAt
+	}
+	if other.LastUsedAt.After(entry.LastUsedAt) {
+		entry.LastUsedAt = other.LastUsedAt
+	}
+}
+
+func describeLogin(login *browserLogin) string {
+	if login.entry.Username == "" {
+		return login.domain
+	}
+	return fmt.Sprintf("%s on %s", login.entry.Username, login.domain)
+}
+
+// parseMillis parses a time in milliseconds since the Unix epoch, returning the zero time if s isn't one.
+func parseMillis(s string) time.Time {
+	ms, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
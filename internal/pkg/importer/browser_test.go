@@ -0,0 +1,109 @@
+package importer_test
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/importer"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+func TestParseBrowserCSV(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		want       []*model.PasswordEntry
+		wantErrors []string
+	}{
+		{
+			name: "parses a Chrome export",
+			file: "testdata/chrome.csv",
+			want: []*model.PasswordEntry{
+				{
+					Service:  "google.com (me@gmail.com)",
+					Username: "me@gmail.com",
+					Password: "g-secret",
+					URL:      "https://accounts.google.com/signin",
+					Notes:    "URL: https://mail.google.com/\nWork inbox",
+				},
+				{
+					Service:  "google.com (work@example.com)",
+					Username: "work@example.com",
+					Password: "g-work",
+					URL:      "https://www.google.com/",
+				},
+				{Service: "example.co.uk", Username: "alice", Password: "uk-secret", URL: "https://login.example.co.uk/"},
+				{Service: "192.168.1.1", Username: "admin", Password: "router", URL: "http://192.168.1.1/"},
+				{
+					Service:  "com.example.app",
+					Username: "app-user",
+					Password: "app-secret",
+					URL:      "android://aGFzaA==@com.example.app/",
+				},
+			},
+			wantErrors: []string{
+				`item "line 7": missing URL`,
+				`item "line 8": missing password`,
+				`item "line 10": unsupported URL "ftp://files.example.com/"`,
+				`item "line 11": wrong number of fields`,
+			},
+		},
+		{
+			name: "parses a Firefox export",
+			file: "testdata/firefox.csv",
+			want: []*model.PasswordEntry{
+				{
+					Service:    "github.com",
+					Username:   "dev",
+					Password:   "new-gh",
+					URL:        "https://gist.github.com",
+					Notes:      "URL: https://github.com",
+					CreatedAt:  time.UnixMilli(1600000000000).UTC(),
+					ModifiedAt: time.UnixMilli(1700000000000).UTC(),
+					LastUsedAt: time.UnixMilli(1700000000000).UTC(),
+				},
+			},
+			wantErrors: []string{
+				`item "line 4": unsupported URL "chrome://FirefoxAccounts"`,
+				`item "line 2": conflicting password for dev on github.com, kept the one from line 3`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = f.Close() })
+
+			result, err := importer.ParseBrowserCSV(f)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if !reflect.DeepEqual(result.Entries, tt.want) {
+				t.Fatalf("Expected entries:\n%+v\ngot:\n%+v", tt.want, result.Entries)
+			}
+			if len(result.Errors) != len(tt.wantErrors) {
+				t.Fatalf("Expected %d errors, got %v", len(tt.wantErrors), result.Errors)
+			}
+			for i, want := range tt.wantErrors {
+				if result.Errors[i].Error() != want {
+					t.Fatalf("Expected error [%s], got [%s]", want, result.Errors[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseBrowserCSV_Invalid(t *testing.T) {
+	_, err := importer.ParseBrowserCSV(strings.NewReader("service,username,password\n"))
+	expectedErr := `not a browser password export: missing column "url"`
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("Expected error [%s], got [%v]", expectedErr, err)
+	}
+}
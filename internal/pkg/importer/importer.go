@@ -0,0 +1,110 @@
+// Package importer parses the export formats of other password managers into vault entries.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// Extras are the parts of an entry, besides its login fields, that an import format may not carry.
+type Extras int
+
+const (
+	// ExtrasKind is the kind of the entries.
+	ExtrasKind Extras = 1 << iota
+	// ExtrasOTP is the OTP secret of the entries.
+	ExtrasOTP
+	// ExtrasFields are the custom fields of the entries.
+	ExtrasFields
+	// ExtrasAll are all the extras, carried by .psst bundles.
+	ExtrasAll = ExtrasKind | ExtrasOTP | ExtrasFields
+)
+
+// Result is the outcome of parsing an import file.
+// Items that can't be imported don't prevent the others from being imported, they are reported in Errors.
+type Result struct {
+	Entries []*model.PasswordEntry
+	Errors  []*ItemError
+	// Extras are the extras the format carries: the entries have none of the others, even if the vault ones do.
+	Extras Extras
+}
+
+// ItemError reports why a single item of an import file can't be imported.
+type ItemError struct {
+	Err  error
+	Item string
+}
+
+// Error implements the error interface.
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %q: %v", e.Item, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// add appends entry to the result, making its service unique within the result.
+// An entry whose service is already used by another entry with the same username is reported as an error.
+func (r *Result) add(item string, entry *model.PasswordEntry) {
+	if strings.TrimSpace(entry.Service) == "" {
+		r.fail(item, errors.New("missing service name"))
+		return
+	}
+	if r.find(entry.Service) != nil && entry.Username != "" {
+		entry.Service = fmt.Sprintf("%s (%s)", entry.Service, entry.Username)
+	}
+	if r.find(entry.Service) != nil {
+		r.fail(item, fmt.Errorf("duplicate service %q", entry.Service))
+		return
+	}
+	r.Entries = append(r.Entries, entry)
+}
+
+// fail reports an item that can't be imported.
+func (r *Result) fail(item string, err error) {
+	r.Errors = append(r.Errors, &ItemError{Item: item, Err: err})
+}
+
+// find returns the entry with the given service, if any.
+func (r *Result) find(service string) *model.PasswordEntry {
+	for _, entry := range r.Entries {
+		if entry.Service == service {
+			return entry
+		}
+	}
+	return nil
+}
+
+// appendNote appends a line to the notes of entry.
+func appendNote(entry *model.PasswordEntry, line string) {
+	if entry.Notes != "" {
+		entry.Notes += "\n"
+	}
+	entry.Notes += line
+}
+
+// builtinFields are the names of the built-in fields of the entries, which custom fields can't take.
+var builtinFields = []string{FieldUsername, FieldPassword, FieldURL, FieldNotes, FieldOTP}
+
+// addField appends a custom field to entry. A field without a name, or whose name is taken by a built-in field or
+// another custom field, is renamed, e.g. "password (2)".
+func addField(entry *model.PasswordEntry, name, fieldType, value string) {
+	if name = strings.TrimSpace(name); name == "" {
+		name = "field"
+	}
+	taken := func(name string) bool {
+		return slices.Contains(builtinFields, name) ||
+			slices.ContainsFunc(entry.Fields, func(f model.EntryField) bool { return f.Name == name })
+	}
+	unique := name
+	for n := 2; taken(unique); n++ {
+		unique = fmt.Sprintf("%s (%d)", name, n)
+	}
+	entry.Fields = append(entry.Fields, model.EntryField{Name: unique, Type: fieldType, Value: value})
+}
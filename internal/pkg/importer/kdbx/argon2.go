@@ -0,0 +1,233 @@
+package kdbx
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// golang.org/x/crypto/argon2 only exposes Argon2i and Argon2id, while KeePass databases default to Argon2d.
+// This is a straightforward, single threaded implementation of Argon2 version 1.3 as specified by RFC 9106,
+// following the structure of the x/crypto implementation.
+
+// Argon2 variants, as encoded in the initial hash.
+const (
+	argon2d  = 0
+	argon2i  = 1
+	argon2id = 2
+)
+
+const (
+	argon2Version     = 0x13
+	argon2BlockWords  = 128
+	argon2SyncPoints  = 4
+	argon2AddressSize = argon2BlockWords
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+// argon2Key derives a keyLen bytes key with the given Argon2 variant.
+// memory is expressed in KiB, secret and data are the optional secret key and associated data.
+func argon2Key(mode int, password, salt, secret, data []byte, time, memory, threads, keyLen uint32) []byte {
+	h0 := argon2InitHash(mode, password, salt, secret, data, time, memory, threads, keyLen)
+
+	memory = memory / (argon2SyncPoints * threads) * (argon2SyncPoints * threads)
+	memory = max(memory, 2*argon2SyncPoints*threads)
+	laneLen := memory / threads
+	segmentLen := laneLen / argon2SyncPoints
+
+	blocks := make([]argon2Block, memory)
+	var buf [1024]byte
+	for lane := range threads {
+		for i := range uint32(2) {
+			binary.LittleEndian.PutUint32(h0[blake2b.Size:], i)
+			binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+			argon2Hash(buf[:], h0[:])
+			for w := range blocks[lane*laneLen+i] {
+				blocks[lane*laneLen+i][w] = binary.LittleEndian.Uint64(buf[w*8:])
+			}
+		}
+	}
+
+	for pass := range time {
+		for slice := range uint32(argon2SyncPoints) {
+			for lane := range threads {
+				dataIndependent := mode == argon2i || (mode == argon2id && pass == 0 && slice < argon2SyncPoints/2)
+
+				var address, input, zero argon2Block
+				if dataIndependent {
+					input[0], input[1], input[2] = uint64(pass), uint64(lane), uint64(slice)
+					input[3], input[4], input[5] = uint64(memory), uint64(time), uint64(mode)
+				}
+
+				index := uint32(0)
+				if pass == 0 && slice == 0 {
+					index = 2
+					if dataIndependent {
+						input[6]++
+						argon2Compress(&address, &input, &zero, false)
+						argon2Compress(&address, &address, &zero, false)
+					}
+				}
+
+				offset := lane*laneLen + slice*segmentLen + index
+				for ; index < segmentLen; index, offset = index+1, offset+1 {
+					prev := offset - 1
+					if index == 0 && slice == 0 {
+						prev += laneLen
+					}
+
+					var random uint64
+					if dataIndependent {
+						if index%argon2AddressSize == 0 {
+							input[6]++
+							argon2Compress(&address, &input, &zero, false)
+							argon2Compress(&address, &address, &zero, false)
+						}
+						random = address[index%argon2AddressSize]
+					} else {
+						random = blocks[prev][0]
+					}
+
+					ref := argon2RefIndex(random, laneLen, segmentLen, threads, pass, slice, lane, index)
+					argon2Compress(&blocks[offset], &blocks[prev], &blocks[ref], pass > 0)
+				}
+			}
+		}
+	}
+
+	final := blocks[laneLen-1]
+	for lane := uint32(1); lane < threads; lane++ {
+		for w, v := range blocks[lane*laneLen+laneLen-1] {
+			final[w] ^= v
+		}
+	}
+	for w, v := range final {
+		binary.LittleEndian.PutUint64(buf[w*8:], v)
+	}
+	key := make([]byte, keyLen)
+	argon2Hash(key, buf[:])
+	return key
+}
+
+// argon2InitHash computes H0, leaving room for the block and lane indexes used to initialize the first blocks.
+func argon2InitHash(
+	mode int, password, salt, secret, data []byte, time, memory, threads, keyLen uint32,
+) [blake2b.Size + 8]byte {
+	h, _ := blake2b.New512(nil)
+	for _, v := range []uint32{threads, keyLen, memory, time, argon2Version, uint32(mode)} {
+		_, _ = h.Write(binary.LittleEndian.AppendUint32(nil, v))
+	}
+	for _, v := range [][]byte{password, salt, secret, data} {
+		_, _ = h.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(v))))
+		_, _ = h.Write(v)
+	}
+	var h0 [blake2b.Size + 8]byte
+	h.Sum(h0[:0])
+	return h0
+}
+
+// argon2RefIndex maps the pseudo-random value to the index of the reference block.
+func argon2RefIndex(random uint64, laneLen, segmentLen, threads, pass, slice, lane, index uint32) uint32 {
+	refLane := uint32(random>>32) % threads
+	if pass == 0 && slice == 0 {
+		refLane = lane
+	}
+
+	// Size of the reference area and its start position within the lane.
+	area, start := 3*segmentLen, ((slice+1)%argon2SyncPoints)*segmentLen
+	if lane == refLane {
+		area += index
+	}
+	if pass == 0 {
+		area, start = slice*segmentLen, 0
+		if slice == 0 || lane == refLane {
+			area += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		area--
+	}
+
+	x := random & 0xffffffff
+	x = (x * x) >> 32
+	x = (x * uint64(area)) >> 32
+	return refLane*laneLen + uint32((uint64(start)+uint64(area)-(x+1))%uint64(laneLen))
+}
+
+// argon2Compress computes the compression function G(x, y) into out, XOR-ing the result with out if xor is true.
+func argon2Compress(out, x, y *argon2Block, xor bool) {
+	var r argon2Block
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	z := r
+	for i := 0; i < argon2BlockWords; i += 16 {
+		blamka(&z, i, i+1, i+2, i+3, i+4, i+5, i+6, i+7, i+8, i+9, i+10, i+11, i+12, i+13, i+14, i+15)
+	}
+	for i := 0; i < 16; i += 2 {
+		blamka(&z, i, i+1, i+16, i+17, i+32, i+33, i+48, i+49, i+64, i+65, i+80, i+81, i+96, i+97, i+112, i+113)
+	}
+	for i := range z {
+		if xor {
+			out[i] ^= z[i] ^ r[i]
+		} else {
+			out[i] = z[i] ^ r[i]
+		}
+	}
+}
+
+// blamka applies the Argon2 round function P to the 16 words of b at the given indexes.
+func blamka(b *argon2Block, i ...int) {
+	gb := func(a, bb, c, d int) {
+		b[i[a]] = fBlaMka(b[i[a]], b[i[bb]])
+		b[i[d]] = bits.RotateLeft64(b[i[d]]^b[i[a]], -32)
+		b[i[c]] = fBlaMka(b[i[c]], b[i[d]])
+		b[i[bb]] = bits.RotateLeft64(b[i[bb]]^b[i[c]], -24)
+		b[i[a]] = fBlaMka(b[i[a]], b[i[bb]])
+		b[i[d]] = bits.RotateLeft64(b[i[d]]^b[i[a]], -16)
+		b[i[c]] = fBlaMka(b[i[c]], b[i[d]])
+		b[i[bb]] = bits.RotateLeft64(b[i[bb]]^b[i[c]], -63)
+	}
+	gb(0, 4, 8, 12)
+	gb(1, 5, 9, 13)
+	gb(2, 6, 10, 14)
+	gb(3, 7, 11, 15)
+	gb(0, 5, 10, 15)
+	gb(1, 6, 11, 12)
+	gb(2, 7, 8, 13)
+	gb(3, 4, 9, 14)
+}
+
+func fBlaMka(x, y uint64) uint64 {
+	return x + y + 2*uint64(uint32(x))*uint64(uint32(y))
+}
+
+// argon2Hash computes the variable length hash function H' of in into out.
+func argon2Hash(out, in []byte) {
+	var h hash.Hash
+	if len(out) < blake2b.Size {
+		h, _ = blake2b.New(len(out), nil)
+	} else {
+		h, _ = blake2b.New512(nil)
+	}
+	_, _ = h.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(out))))
+	_, _ = h.Write(in)
+	if len(out) <= blake2b.Size {
+		h.Sum(out[:0])
+		return
+	}
+
+	var v [blake2b.Size]byte
+	h.Sum(v[:0])
+	n := copy(out, v[:32])
+	for len(out)-n > blake2b.Size {
+		v = blake2b.Sum512(v[:])
+		n += copy(out[n:], v[:32])
+	}
+	h, _ = blake2b.New(len(out)-n, nil)
+	_, _ = h.Write(v[:])
+	h.Sum(out[n:n])
+}
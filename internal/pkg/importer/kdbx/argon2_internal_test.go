@@ -0,0 +1,43 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestArgon2Key(t *testing.T) {
+	// RFC 9106, section 5.
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+	secret := bytes.Repeat([]byte{0x03}, 8)
+	data := bytes.Repeat([]byte{0x04}, 12)
+
+	tests := []struct {
+		name string
+		want string
+		mode int
+	}{
+		{name: "argon2d", mode: argon2d, want: "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{name: "argon2i", mode: argon2i, want: "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8"},
+		{name: "argon2id", mode: argon2id, want: "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" matches the RFC 9106 test vector", func(t *testing.T) {
+			got := hex.EncodeToString(argon2Key(tt.mode, password, salt, secret, data, 3, 32, 4, 32))
+			if got != tt.want {
+				t.Fatalf("Expected [%s], got [%s]", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("argon2id matches x/crypto", func(t *testing.T) {
+		want := argon2.IDKey([]byte("password"), []byte("somesaltsomesalt"), 2, 1024, 2, 64)
+		got := argon2Key(argon2id, []byte("password"), []byte("somesaltsomesalt"), nil, nil, 2, 1024, 2, 64)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Expected [%x], got [%x]", want, got)
+		}
+	})
+}
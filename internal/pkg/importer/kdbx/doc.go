@@ -0,0 +1,7 @@
+// Package kdbx reads KeePass KDBX 4 databases, as written by KeePass 2.35+ and KeePassXC.
+//
+// A KDBX 4 file is made of an unencrypted outer header, describing the key derivation function and the cipher,
+// followed by the encrypted payload split in HMAC-authenticated blocks. Once decrypted (and decompressed), the
+// payload starts with an inner header carrying the key of the stream cipher protecting sensitive values, followed
+// by the XML document holding groups and entries.
+package kdbx
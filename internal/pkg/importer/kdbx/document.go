@@ -0,0 +1,282 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20"
+)
+
+// Inner random stream IDs, the stream cipher protecting sensitive values in the XML document.
+const (
+	streamSalsa20  = 2
+	streamChaCha20 = 3
+)
+
+// unixEpochSeconds is the number of seconds between 0001-01-01 and the Unix epoch.
+const unixEpochSeconds = 62135596800
+
+// salsa20Nonce is the fixed nonce of the Salsa20 inner stream.
+var salsa20Nonce = []byte{0xE8, 0x30, 0x09, 0x4B, 0x97, 0x20, 0x5D, 0x2A}
+
+// Standard entry fields, any other field is a custom one.
+const (
+	fieldTitle    = "Title"
+	fieldUserName = "UserName"
+	fieldPassword = "Password"
+	fieldURL      = "URL"
+	fieldNotes    = "Notes"
+)
+
+// Entry is an entry of a KeePass database.
+type Entry struct {
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+	Title      string
+	UserName   string
+	Password   string
+	URL        string
+	Notes      string
+	// Groups is the path of the group holding the entry, the root group excluded.
+	Groups      []string
+	Tags        []string
+	Fields      []Field
+	Attachments []string
+}
+
+// Field is a custom string field of an entry.
+type Field struct {
+	Key       string
+	Value     string
+	Protected bool
+}
+
+// protectedStream generates the keystream XOR-ed with protected values, in document order.
+type protectedStream struct {
+	xor func(dst, src []byte)
+}
+
+func newProtectedStream(id uint32, key []byte) (*protectedStream, error) {
+	switch id {
+	case streamChaCha20:
+		h := sha512.Sum512(key)
+		stream, err := chacha20.NewUnauthenticatedCipher(h[:32], h[32:32+chacha20.NonceSize])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create inner stream: %w", err)
+		}
+		return &protectedStream{xor: stream.XORKeyStream}, nil
+	case streamSalsa20:
+		// Salsa20 is only used by databases upgraded from KDBX 3. The x/crypto package can't resume a keystream,
+		// so the keystream is generated from the start whenever it needs to grow.
+		salsaKey := sha256.Sum256(key)
+		var keystream []byte
+		var offset int
+		return &protectedStream{xor: func(dst, src []byte) {
+			if offset+len(src) > len(keystream) {
+				keystream = make([]byte, max(2*len(keystream), offset+len(src)))
+				salsa20.XORKeyStream(keystream, keystream, salsa20Nonce, &salsaKey)
+			}
+			for i := range src {
+				dst[i] = src[i] ^ keystream[offset+i]
+			}
+			offset += len(src)
+		}}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported inner stream %d", ErrInvalidFile, id)
+	}
+}
+
+// node is an element of the XML document.
+type node struct {
+	name      string
+	text      string
+	children  []*node
+	protected bool
+}
+
+// child returns the first child element with the given name, or nil.
+func (n *node) child(name string) *node {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// value returns the text of the element at the given path, or the empty string.
+func (n *node) value(path ...string) string {
+	for _, name := range path {
+		n = n.child(name)
+	}
+	if n == nil {
+		return ""
+	}
+	return n.text
+}
+
+// parseDocument parses the XML document and returns the entries outside of the recycle bin.
+// Protected values are decrypted with stream while the document is parsed, since the keystream is consumed in
+// document order, including the values of the history and of the recycle bin.
+func parseDocument(document []byte, stream *protectedStream) ([]*Entry, error) {
+	root, err := parseTree(document, stream)
+	if err != nil {
+		return nil, err
+	}
+	if root.name != "KeePassFile" {
+		return nil, fmt.Errorf("%w: unexpected root element %s", ErrCorrupted, root.name)
+	}
+
+	recycleBin := ""
+	if root.value("Meta", "RecycleBinEnabled") == "True" {
+		recycleBin = root.value("Meta", "RecycleBinUUID")
+	}
+
+	group := root.child("Root").child("Group")
+	if group == nil {
+		return nil, fmt.Errorf("%w: missing root group", ErrCorrupted)
+	}
+	var entries []*Entry
+	walkGroup(group, nil, recycleBin, &entries)
+	return entries, nil
+}
+
+func parseTree(document []byte, stream *protectedStream) (*node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	var stack []*node
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: empty XML document", ErrCorrupted)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorrupted, err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name.Local}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "Protected" && attr.Value == "True" {
+					n.protected = true
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if n.protected {
+				if err = unprotect(n, stream); err != nil {
+					return nil, err
+				}
+			}
+			if len(stack) == 0 {
+				return n, nil
+			}
+		}
+	}
+}
+
+// unprotect decrypts the text of a protected element.
+func unprotect(n *node, stream *protectedStream) error {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(n.text))
+	if err != nil {
+		return fmt.Errorf("%w: invalid protected value", ErrCorrupted)
+	}
+	stream.xor(data, data)
+	n.text = string(data)
+	return nil
+}
+
+// walkGroup appends the entries of group and of its subgroups to entries, skipping the recycle bin.
+// path is the path of the parent group, the root group is not part of it.
+func walkGroup(group *node, path []string, recycleBin string, entries *[]*Entry) {
+	for _, c := range group.children {
+		switch c.name {
+		case "Entry":
+			*entries = append(*entries, newEntry(c, path))
+		case "Group":
+			if recycleBin != "" && c.value("UUID") == recycleBin {
+				continue
+			}
+			walkGroup(c, append(slices.Clone(path), c.value("Name")), recycleBin, entries)
+		}
+	}
+}
+
+func newEntry(n *node, path []string) *Entry {
+	entry := &Entry{
+		Groups:     path,
+		CreatedAt:  parseTime(n.value("Times", "CreationTime")),
+		ModifiedAt: parseTime(n.value("Times", "LastModificationTime")),
+	}
+	for _, tag := range strings.FieldsFunc(n.value("Tags"), func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			entry.Tags = append(entry.Tags, tag)
+		}
+	}
+	for _, c := range n.children {
+		switch c.name {
+		case "String":
+			value := c.child("Value")
+			if value == nil {
+				continue
+			}
+			switch key := c.value("Key"); key {
+			case fieldTitle:
+				entry.Title = value.text
+			case fieldUserName:
+				entry.UserName = value.text
+			case fieldPassword:
+				entry.Password = value.text
+			case fieldURL:
+				entry.URL = value.text
+			case fieldNotes:
+				entry.Notes = value.text
+			default:
+				entry.Fields = append(entry.Fields, Field{Key: key, Value: value.text, Protected: value.protected})
+			}
+		case "Binary":
+			entry.Attachments = append(entry.Attachments, c.value("Key"))
+		}
+	}
+	return entry
+}
+
+// parseTime parses a KDBX 4 time, the base64 encoding of the seconds elapsed since 0001-01-01 as a little endian
+// int64. Databases upgraded from KDBX 3 may still hold times as RFC 3339 strings.
+func parseTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC()
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(data) != 8 {
+		return time.Time{}
+	}
+	seconds := int64(binary.LittleEndian.Uint64(data))
+	return time.Unix(seconds-unixEpochSeconds, 0).UTC()
+}
@@ -0,0 +1,356 @@
+package kdbx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// File signatures.
+const (
+	signature1 = 0x9AA2D903
+	signature2 = 0xB54BFB67
+)
+
+// formatVersion is the only major version of the file format supported.
+const formatVersion = 4
+
+// Outer header field IDs.
+const (
+	outerEndOfHeader      = 0
+	outerCipherID         = 2
+	outerCompression      = 3
+	outerMasterSeed       = 4
+	outerEncryptionIV     = 7
+	outerKDFParameters    = 11
+	outerPublicCustomData = 12
+)
+
+// Inner header field IDs.
+const (
+	innerEndOfHeader = 0
+	innerStreamID    = 1
+	innerStreamKey   = 2
+	innerBinary      = 3
+)
+
+// compressionGzip is the value of the compression field when the payload is gzipped.
+const compressionGzip = 1
+
+// Cipher UUIDs.
+var (
+	cipherAES256   = uuid("31c1f2e6bf714350be5805216afc5aff")
+	cipherChaCha20 = uuid("d6038a2b8b6f4cb5a524339a31dbb59a")
+)
+
+// maxFieldSize bounds the size of header fields and blocks, to fail early on corrupted files.
+const maxFieldSize = 1 << 30
+
+var (
+	// ErrInvalidFile is returned when the file is not a KDBX 4 database.
+	ErrInvalidFile = errors.New("not a KDBX 4 database")
+	// ErrInvalidKey is returned when the password or key file don't match the database.
+	ErrInvalidKey = errors.New("wrong password or key file")
+	// ErrCorrupted is returned when the database fails its integrity checks.
+	ErrCorrupted = errors.New("database is corrupted")
+)
+
+// header is the outer header of a database.
+type header struct {
+	kdf         map[string]variant
+	cipherID    []byte
+	masterSeed  []byte
+	iv          []byte
+	compression uint32
+}
+
+// Read decrypts the database read from r and returns its entries.
+// keyFile is the content of the key file protecting the database, nil if there is none.
+func Read(r io.Reader, password string, keyFile []byte) ([]*Entry, error) {
+	file, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read database: %w", err)
+	}
+	br := bytes.NewReader(file)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	headerBytes := file[:len(file)-br.Len()]
+
+	var headerHash, headerMAC [sha256.Size]byte
+	if _, err = io.ReadFull(br, headerHash[:]); err != nil {
+		return nil, ErrInvalidFile
+	}
+	if _, err = io.ReadFull(br, headerMAC[:]); err != nil {
+		return nil, ErrInvalidFile
+	}
+	if sum := sha256.Sum256(headerBytes); subtle.ConstantTimeCompare(sum[:], headerHash[:]) != 1 {
+		return nil, fmt.Errorf("%w: header checksum mismatch", ErrCorrupted)
+	}
+
+	composite, err := compositeKey(password, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	transformed, err := transformKey(h.kdf, composite)
+	if err != nil {
+		return nil, err
+	}
+
+	hmacKey := sha512.Sum512(concat(h.masterSeed, transformed, []byte{1}))
+	if !hmac.Equal(blockMAC(hmacKey[:], math.MaxUint64, headerBytes), headerMAC[:]) {
+		return nil, ErrInvalidKey
+	}
+
+	ciphertext, err := readBlocks(br, hmacKey[:])
+	if err != nil {
+		return nil, err
+	}
+	encryptionKey := sha256.Sum256(concat(h.masterSeed, transformed))
+	payload, err := decrypt(h, encryptionKey[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if h.compression == compressionGzip {
+		if payload, err = gunzip(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	stream, document, err := readInnerHeader(payload)
+	if err != nil {
+		return nil, err
+	}
+	return parseDocument(document, stream)
+}
+
+// readHeader reads the signatures, the version and the fields of the outer header.
+func readHeader(r io.Reader) (*header, error) {
+	var prefix struct {
+		Signature1 uint32
+		Signature2 uint32
+		Version    uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &prefix); err != nil {
+		return nil, ErrInvalidFile
+	}
+	if prefix.Signature1 != signature1 || prefix.Signature2 != signature2 {
+		return nil, ErrInvalidFile
+	}
+	if major := prefix.Version >> 16; major != formatVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d, only KDBX 4 is supported", ErrInvalidFile, major)
+	}
+
+	h := &header{}
+	for {
+		id, data, err := readField(r)
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case outerEndOfHeader:
+			return h, h.validate()
+		case outerCipherID:
+			h.cipherID = data
+		case outerCompression:
+			if len(data) != 4 {
+				return nil, fmt.Errorf("%w: invalid compression flag", ErrInvalidFile)
+			}
+			h.compression = binary.LittleEndian.Uint32(data)
+		case outerMasterSeed:
+			h.masterSeed = data
+		case outerEncryptionIV:
+			h.iv = data
+		case outerKDFParameters:
+			if h.kdf, err = readVariantDictionary(data); err != nil {
+				return nil, err
+			}
+		case outerPublicCustomData:
+			// Plugin data, not needed to read entries.
+		default:
+			return nil, fmt.Errorf("%w: unknown header field %d", ErrInvalidFile, id)
+		}
+	}
+}
+
+func (h *header) validate() error {
+	switch {
+	case h.cipherID == nil:
+		return fmt.Errorf("%w: missing cipher", ErrInvalidFile)
+	case len(h.masterSeed) != 32:
+		return fmt.Errorf("%w: invalid master seed", ErrInvalidFile)
+	case h.kdf == nil:
+		return fmt.Errorf("%w: missing key derivation parameters", ErrInvalidFile)
+	case h.compression > compressionGzip:
+		return fmt.Errorf("%w: unsupported compression %d", ErrInvalidFile, h.compression)
+	}
+	return nil
+}
+
+// readField reads a header field: a one byte ID followed by the size of the data and the data.
+func readField(r io.Reader) (byte, []byte, error) {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return 0, nil, fmt.Errorf("%w: truncated header", ErrInvalidFile)
+	}
+	size := binary.LittleEndian.Uint32(prefix[1:])
+	if size > maxFieldSize {
+		return 0, nil, fmt.Errorf("%w: header field too large", ErrInvalidFile)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, fmt.Errorf("%w: truncated header", ErrInvalidFile)
+	}
+	return prefix[0], data, nil
+}
+
+// readBlocks reads the HMAC block stream following the header and returns the concatenated block data.
+func readBlocks(r io.Reader, hmacKey []byte) ([]byte, error) {
+	var data []byte
+	for index := uint64(0); ; index++ {
+		var prefix struct {
+			MAC  [sha256.Size]byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &prefix); err != nil {
+			return nil, fmt.Errorf("%w: truncated block %d", ErrCorrupted, index)
+		}
+		if prefix.Size > maxFieldSize {
+			return nil, fmt.Errorf("%w: block %d too large", ErrCorrupted, index)
+		}
+		block := make([]byte, prefix.Size)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, fmt.Errorf("%w: truncated block %d", ErrCorrupted, index)
+		}
+		signed := binary.LittleEndian.AppendUint32(nil, prefix.Size)
+		if !hmac.Equal(blockMAC(hmacKey, index, append(signed, block...)), prefix.MAC[:]) {
+			return nil, fmt.Errorf("%w: block %d failed authentication", ErrCorrupted, index)
+		}
+		if prefix.Size == 0 {
+			return data, nil
+		}
+		data = append(data, block...)
+	}
+}
+
+// blockMAC computes the HMAC-SHA-256 of a block, keyed with the key derived for its index.
+// The header is authenticated as the block with index math.MaxUint64.
+func blockMAC(hmacKey []byte, index uint64, data []byte) []byte {
+	key := sha512.Sum512(concat(binary.LittleEndian.AppendUint64(nil, index), hmacKey))
+	mac := hmac.New(sha256.New, key[:])
+	if index != math.MaxUint64 {
+		_, _ = mac.Write(binary.LittleEndian.AppendUint64(nil, index))
+	}
+	_, _ = mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// decrypt decrypts the payload with the cipher declared in the header.
+func decrypt(h *header, key, ciphertext []byte) ([]byte, error) {
+	switch {
+	case bytes.Equal(h.cipherID, cipherAES256):
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		if len(h.iv) != aes.BlockSize {
+			return nil, fmt.Errorf("%w: invalid encryption IV", ErrInvalidFile)
+		}
+		if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, fmt.Errorf("%w: invalid payload size", ErrCorrupted)
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, h.iv).CryptBlocks(plaintext, ciphertext)
+		return unpad(plaintext)
+	case bytes.Equal(h.cipherID, cipherChaCha20):
+		if len(h.iv) != chacha20.NonceSize {
+			return nil, fmt.Errorf("%w: invalid encryption IV", ErrInvalidFile)
+		}
+		stream, err := chacha20.NewUnauthenticatedCipher(key, h.iv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cipher: %w", err)
+		}
+		plaintext := make([]byte, len(ciphertext))
+		stream.XORKeyStream(plaintext, ciphertext)
+		return plaintext, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported cipher %x, only AES-256 and ChaCha20 are supported",
+			ErrInvalidFile, h.cipherID)
+	}
+}
+
+// unpad removes the PKCS#7 padding of an AES-CBC payload.
+func unpad(data []byte) ([]byte, error) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > aes.BlockSize || n > len(data) {
+		return nil, fmt.Errorf("%w: invalid padding", ErrCorrupted)
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, fmt.Errorf("%w: invalid padding", ErrCorrupted)
+		}
+	}
+	return data[:len(data)-n], nil
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	return out, nil
+}
+
+// readInnerHeader reads the inner header at the start of the payload.
+// It returns the stream cipher protecting sensitive values and the XML document that follows the header.
+func readInnerHeader(payload []byte) (*protectedStream, []byte, error) {
+	r := bytes.NewReader(payload)
+	var streamID uint32
+	var streamKey []byte
+	for {
+		id, data, err := readField(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: invalid inner header", ErrCorrupted)
+		}
+		switch id {
+		case innerEndOfHeader:
+			stream, err := newProtectedStream(streamID, streamKey)
+			if err != nil {
+				return nil, nil, err
+			}
+			return stream, payload[len(payload)-r.Len():], nil
+		case innerStreamID:
+			if len(data) != 4 {
+				return nil, nil, fmt.Errorf("%w: invalid inner stream ID", ErrCorrupted)
+			}
+			streamID = binary.LittleEndian.Uint32(data)
+		case innerStreamKey:
+			streamKey = data
+		case innerBinary:
+			// Attachments are not imported.
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown inner header field %d", ErrCorrupted, id)
+		}
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
@@ -0,0 +1,264 @@
+package kdbx_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/importer/kdbx"
+)
+
+var update = flag.Bool("update", false, "regenerate the KDBX fixtures in testdata")
+
+// testPassword protects every fixture but the key file only one.
+const testPassword = "correct horse battery staple"
+
+// testDocument is the XML document of the fixtures. Protected values are written in clear text and XOR-ed with
+// the inner stream by writeKDBX, the history entry checks that the stream is consumed in document order.
+const testDocument = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+	<Meta>
+		<Generator>KeePassXC</Generator>
+		<RecycleBinEnabled>True</RecycleBinEnabled>
+		<RecycleBinUUID>3I2ViQMFTsi5tkjdkA9jTg==</RecycleBinUUID>
+	</Meta>
+	<Root>
+		<Group>
+			<UUID>bEeY7bmGQ0qWqgYcYuZ0tw==</UUID>
+			<Name>Passwords</Name>
+			<Entry>
+				<UUID>5SNnnLwjQ6aBEMCDqL9Sfg==</UUID>
+				<Tags>personal</Tags>
+				<Times>
+					<CreationTime>COdH2A4AAAA=</CreationTime>
+					<LastModificationTime>IJ5z3Q4AAAA=</LastModificationTime>
+				</Times>
+				<String><Key>Notes</Key><Value>Recovery codes in the safe</Value></String>
+				<String><Key>Password</Key><Value Protected="True">m41l-p4ss</Value></String>
+				<String><Key>Title</Key><Value>Email</Value></String>
+				<String><Key>URL</Key><Value>https://mail.example.com</Value></String>
+				<String><Key>UserName</Key><Value>me@example.com</Value></String>
+				<History>
+					<Entry>
+						<UUID>5SNnnLwjQ6aBEMCDqL9Sfg==</UUID>
+						<String><Key>Password</Key><Value Protected="True">old-p4ss</Value></String>
+						<String><Key>Title</Key><Value>Email</Value></String>
+					</Entry>
+				</History>
+			</Entry>
+			<Group>
+				<UUID>M2ZbmY8ORSqjWkxUuJwR4A==</UUID>
+				<Name>Work</Name>
+				<Group>
+					<UUID>r2E3N7RzTsmi4e2X8U9cQw==</UUID>
+					<Name>Servers</Name>
+					<Entry>
+						<UUID>0V3I1nq4Tv6D3yPX7uN4gA==</UUID>
+						<Tags>ssh;prod</Tags>
+						<String><Key>Password</Key><Value Protected="True">r00t &amp; br4nch</Value></String>
+						<String><Key>Port</Key><Value>2222</Value></String>
+						<String><Key>Title</Key><Value>Bastion</Value></String>
+						<String><Key>TOTP Seed</Key><Value Protected="True">JBSWY3DPEHPK3PXP</Value></String>
+						<String><Key>UserName</Key><Value>admin</Value></String>
+						<Binary><Key>id_ed25519</Key><Value Ref="0"/></Binary>
+					</Entry>
+				</Group>
+			</Group>
+			<Group>
+				<UUID>3I2ViQMFTsi5tkjdkA9jTg==</UUID>
+				<Name>Recycle Bin</Name>
+				<Entry>
+					<UUID>n3sB1e0xQ2y2BbQqkEo9Cw==</UUID>
+					<String><Key>Password</Key><Value Protected="True">deleted</Value></String>
+					<String><Key>Title</Key><Value>Old account</Value></String>
+				</Entry>
+			</Group>
+		</Group>
+	</Root>
+</KeePassFile>
+`
+
+var fixtures = []*fixture{
+	{name: "aes-kdf", kdf: "aes", cipher: "aes", stream: "chacha20", gzip: true},
+	{name: "argon2id", kdf: "argon2id", cipher: "chacha20", stream: "chacha20"},
+	{name: "salsa20", kdf: "aes", cipher: "aes", stream: "salsa20"},
+	{name: "keyfile", kdf: "argon2id", cipher: "aes", stream: "chacha20", gzip: true},
+}
+
+// testdataPath returns the path of the fixture file.
+func testdataPath(f *fixture) string {
+	return "testdata/" + f.name + ".kdbx"
+}
+
+// TestFixtures checks that the fixtures in testdata are up to date.
+// Run the tests with -update to regenerate them.
+func TestFixtures(t *testing.T) {
+	keyFile, err := os.ReadFile("testdata/keepass.keyx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		f.document = testDocument
+		f.password = testPassword
+		if f.name == "keyfile" {
+			f.password = ""
+			f.keyFile = keyFile
+		}
+		data := writeKDBX(t, f)
+		if *update {
+			if err := os.WriteFile(testdataPath(f), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		got, err := os.ReadFile(testdataPath(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("Fixture %s is out of date, run the tests with -update", testdataPath(f))
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	keyFile, err := os.ReadFile("testdata/keepass.keyx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*kdbx.Entry{
+		{
+			Title:      "Email",
+			UserName:   "me@example.com",
+			Password:   "m41l-p4ss",
+			URL:        "https://mail.example.com",
+			Notes:      "Recovery codes in the safe",
+			Tags:       []string{"personal"},
+			CreatedAt:  time.Date(2021, 6, 1, 8, 30, 0, 0, time.UTC),
+			ModifiedAt: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			Title:    "Bastion",
+			UserName: "admin",
+			Password: "r00t & br4nch",
+			Groups:   []string{"Work", "Servers"},
+			Tags:     []string{"ssh", "prod"},
+			Fields: []kdbx.Field{
+				{Key: "Port", Value: "2222"},
+				{Key: "TOTP Seed", Value: "JBSWY3DPEHPK3PXP", Protected: true},
+			},
+			Attachments: []string{"id_ed25519"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		password string
+		keyFile  []byte
+	}{
+		{name: "reads an AES-KDF, AES-256 and gzip database", file: "aes-kdf", password: testPassword},
+		{name: "reads an Argon2id and ChaCha20 database", file: "argon2id", password: testPassword},
+		{name: "reads a database protected by a Salsa20 inner stream", file: "salsa20", password: testPassword},
+		{name: "reads a database protected by a key file", file: "keyfile", keyFile: keyFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/" + tt.file + ".kdbx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := kdbx.Read(bytes.NewReader(data), tt.password, tt.keyFile)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if !reflect.DeepEqual(entries, want) {
+				t.Fatalf("Expected entries:\n%+v\ngot:\n%+v", want, entries)
+			}
+		})
+	}
+}
+
+func TestRead_Invalid(t *testing.T) {
+	data, err := os.ReadFile("testdata/argon2id.kdbx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamper := func(offset int) []byte {
+		tampered := bytes.Clone(data)
+		tampered[offset] ^= 1
+		return tampered
+	}
+
+	tests := []struct {
+		expectedErr error
+		name        string
+		password    string
+		data        []byte
+		keyFile     []byte
+	}{
+		{
+			name:        "fails with a wrong password",
+			data:        data,
+			password:    "wrong password",
+			expectedErr: kdbx.ErrInvalidKey,
+		},
+		{
+			name:        "fails with an unexpected key file",
+			data:        data,
+			password:    testPassword,
+			keyFile:     []byte("not the key file"),
+			expectedErr: kdbx.ErrInvalidKey,
+		},
+		{
+			name:        "fails without password nor key file",
+			data:        data,
+			expectedErr: kdbx.ErrInvalidKey,
+		},
+		{
+			name:        "fails with a tampered header",
+			data:        tamper(20),
+			password:    testPassword,
+			expectedErr: kdbx.ErrCorrupted,
+		},
+		{
+			name:        "fails with a tampered block",
+			data:        tamper(len(data) - 50),
+			password:    testPassword,
+			expectedErr: kdbx.ErrCorrupted,
+		},
+		{
+			name:        "fails with a truncated database",
+			data:        data[:len(data)-40],
+			password:    testPassword,
+			expectedErr: kdbx.ErrCorrupted,
+		},
+		{
+			name:        "fails with a KDBX 3 database",
+			data:        append([]byte{0x03, 0xD9, 0xA2, 0x9A, 0x67, 0xFB, 0x4B, 0xB5, 0x01, 0x00, 0x03, 0x00}, data[12:]...),
+			password:    testPassword,
+			expectedErr: kdbx.ErrInvalidFile,
+		},
+		{
+			name:        "fails with a file that is not a database",
+			data:        []byte("name,url,username,password\n"),
+			password:    testPassword,
+			expectedErr: kdbx.ErrInvalidFile,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := kdbx.Read(bytes.NewReader(tt.data), tt.password, tt.keyFile)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error [%v], got [%v]", tt.expectedErr, err)
+			}
+		})
+	}
+}
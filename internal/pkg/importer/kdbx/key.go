@@ -0,0 +1,290 @@
+package kdbx
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Key derivation function UUIDs.
+var (
+	kdfAES      = uuid("c9d9f39a628a4460bf740d08c18a4fea")
+	kdfArgon2d  = uuid("ef636ddf8c29444b91f7a9a403e30a0c")
+	kdfArgon2id = uuid("9e298b1956db4773b23dfc3ec6f0a1e6")
+)
+
+// Limits on the key derivation parameters, so that a crafted file can't exhaust memory or run forever.
+const (
+	maxArgon2Memory     = 4 << 30
+	maxArgon2Iterations = 1 << 16
+	maxAESRounds        = 1 << 32
+)
+
+// argon2Version13 is the only Argon2 version supported.
+const argon2Version13 = 0x13
+
+// compositeKey combines the password and the key file into the key transformed by the KDF.
+// An empty password is not part of the key, so that databases protected by a key file only can be opened.
+func compositeKey(password string, keyFile []byte) ([]byte, error) {
+	var parts []byte
+	if password != "" {
+		sum := sha256.Sum256([]byte(password))
+		parts = append(parts, sum[:]...)
+	}
+	if keyFile != nil {
+		sum, err := keyFileHash(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, sum...)
+	}
+	if parts == nil {
+		return nil, fmt.Errorf("%w: a password or a key file is required", ErrInvalidKey)
+	}
+	sum := sha256.Sum256(parts)
+	return sum[:], nil
+}
+
+// keyFile is a KeePass XML key file.
+type keyFile struct {
+	Meta struct {
+		Version string `xml:"Version"`
+	} `xml:"Meta"`
+	Key struct {
+		Data struct {
+			Hash  string `xml:"Hash,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	} `xml:"Key"`
+}
+
+// keyFileHash returns the 32 bytes key contributed by a key file.
+// XML key files (version 1.0 and 2.0), 32 bytes binary and 64 characters hex files hold the key itself,
+// any other file is hashed with SHA-256.
+func keyFileHash(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("<?xml")) || bytes.HasPrefix(trimmed, []byte("<KeyFile")) {
+		var kf keyFile
+		if err := xml.Unmarshal(trimmed, &kf); err == nil && kf.Key.Data.Value != "" {
+			return xmlKeyFileHash(&kf)
+		}
+	}
+	if len(data) == sha256.Size {
+		return data, nil
+	}
+	if len(data) == 2*sha256.Size {
+		if key, err := hex.DecodeString(string(data)); err == nil {
+			return key, nil
+		}
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+func xmlKeyFileHash(kf *keyFile) ([]byte, error) {
+	value := strings.Join(strings.Fields(kf.Key.Data.Value), "")
+	switch {
+	case strings.HasPrefix(kf.Meta.Version, "1."):
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid key file data", ErrInvalidKey)
+		}
+		return key, nil
+	case strings.HasPrefix(kf.Meta.Version, "2."):
+		key, err := hex.DecodeString(value)
+		if err != nil || len(key) != sha256.Size {
+			return nil, fmt.Errorf("%w: invalid key file data", ErrInvalidKey)
+		}
+		if kf.Key.Data.Hash != "" {
+			sum := sha256.Sum256(key)
+			if !strings.EqualFold(hex.EncodeToString(sum[:4]), kf.Key.Data.Hash) {
+				return nil, fmt.Errorf("%w: key file checksum mismatch", ErrInvalidKey)
+			}
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported key file version %q", ErrInvalidKey, kf.Meta.Version)
+	}
+}
+
+// transformKey derives the transformed key from the composite key with the KDF described by params.
+func transformKey(params map[string]variant, composite []byte) ([]byte, error) {
+	id, err := bytesParam(params, "$UUID")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(id, kdfAES):
+		return aesKDF(params, composite)
+	case bytes.Equal(id, kdfArgon2d):
+		return argon2KDF(params, composite, argon2d)
+	case bytes.Equal(id, kdfArgon2id):
+		return argon2KDF(params, composite, argon2id)
+	default:
+		return nil, fmt.Errorf("%w: unsupported key derivation function %x", ErrInvalidFile, id)
+	}
+}
+
+// aesKDF encrypts the composite key with AES-256 in ECB mode for the given number of rounds, then hashes it.
+func aesKDF(params map[string]variant, composite []byte) ([]byte, error) {
+	seed, err := bytesParam(params, "S")
+	if err != nil {
+		return nil, err
+	}
+	rounds, err := uint64Param(params, "R")
+	if err != nil {
+		return nil, err
+	}
+	if rounds > maxAESRounds {
+		return nil, fmt.Errorf("%w: too many AES-KDF rounds", ErrInvalidFile)
+	}
+	block, err := aes.NewCipher(seed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid AES-KDF seed", ErrInvalidFile)
+	}
+	key := bytes.Clone(composite)
+	for range rounds {
+		block.Encrypt(key[:aes.BlockSize], key[:aes.BlockSize])
+		block.Encrypt(key[aes.BlockSize:], key[aes.BlockSize:])
+	}
+	sum := sha256.Sum256(key)
+	return sum[:], nil
+}
+
+func argon2KDF(params map[string]variant, composite []byte, mode int) ([]byte, error) {
+	salt, err := bytesParam(params, "S")
+	if err != nil {
+		return nil, err
+	}
+	parallelism, err := uint32Param(params, "P")
+	if err != nil {
+		return nil, err
+	}
+	memory, err := uint64Param(params, "M")
+	if err != nil {
+		return nil, err
+	}
+	iterations, err := uint64Param(params, "I")
+	if err != nil {
+		return nil, err
+	}
+	version, err := uint32Param(params, "V")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case version != argon2Version13:
+		return nil, fmt.Errorf("%w: unsupported Argon2 version %#x", ErrInvalidFile, version)
+	case memory < 1024 || memory > maxArgon2Memory:
+		return nil, fmt.Errorf("%w: invalid Argon2 memory %d", ErrInvalidFile, memory)
+	case iterations == 0 || iterations > maxArgon2Iterations:
+		return nil, fmt.Errorf("%w: invalid Argon2 iterations %d", ErrInvalidFile, iterations)
+	case parallelism == 0 || parallelism > 1<<8:
+		return nil, fmt.Errorf("%w: invalid Argon2 parallelism %d", ErrInvalidFile, parallelism)
+	}
+	// The secret key and the associated data are optional.
+	secret, _ := bytesParam(params, "K")
+	data, _ := bytesParam(params, "A")
+	return argon2Key(mode, composite, salt, secret, data,
+		uint32(iterations), uint32(memory/1024), parallelism, sha256.Size), nil
+}
+
+// uuid decodes the hex representation of a UUID.
+func uuid(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Variant dictionary value types.
+const (
+	variantUInt32 = 0x04
+	variantUInt64 = 0x05
+	variantBool   = 0x08
+	variantInt32  = 0x0C
+	variantInt64  = 0x0D
+	variantString = 0x18
+	variantBytes  = 0x42
+)
+
+// variantDictionaryVersion is the version of the variant dictionary format, only its major byte is checked.
+const variantDictionaryVersion = 0x0100
+
+// variant is a typed value of a variant dictionary.
+type variant struct {
+	data []byte
+	typ  byte
+}
+
+// readVariantDictionary parses the variant dictionary holding the KDF parameters.
+func readVariantDictionary(data []byte) (map[string]variant, error) {
+	invalid := fmt.Errorf("%w: invalid key derivation parameters", ErrInvalidFile)
+	if len(data) < 2 || binary.LittleEndian.Uint16(data)>>8 != variantDictionaryVersion>>8 {
+		return nil, invalid
+	}
+	data = data[2:]
+
+	next := func() ([]byte, bool) {
+		if len(data) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return nil, false
+		}
+		value := data[4 : 4+n]
+		data = data[4+n:]
+		return value, true
+	}
+
+	dict := map[string]variant{}
+	for len(data) > 0 {
+		typ := data[0]
+		data = data[1:]
+		if typ == 0 {
+			return dict, nil
+		}
+		key, ok := next()
+		if !ok {
+			return nil, invalid
+		}
+		value, ok := next()
+		if !ok {
+			return nil, invalid
+		}
+		dict[string(key)] = variant{typ: typ, data: value}
+	}
+	return nil, invalid
+}
+
+func bytesParam(params map[string]variant, key string) ([]byte, error) {
+	v, ok := params[key]
+	if !ok || v.typ != variantBytes {
+		return nil, fmt.Errorf("%w: missing key derivation parameter %s", ErrInvalidFile, key)
+	}
+	return v.data, nil
+}
+
+func uint32Param(params map[string]variant, key string) (uint32, error) {
+	v, ok := params[key]
+	if !ok || v.typ != variantUInt32 || len(v.data) != 4 {
+		return 0, fmt.Errorf("%w: missing key derivation parameter %s", ErrInvalidFile, key)
+	}
+	return binary.LittleEndian.Uint32(v.data), nil
+}
+
+func uint64Param(params map[string]variant, key string) (uint64, error) {
+	v, ok := params[key]
+	if !ok || v.typ != variantUInt64 || len(v.data) != 8 {
+		return 0, fmt.Errorf("%w: missing key derivation parameter %s", ErrInvalidFile, key)
+	}
+	return binary.LittleEndian.Uint64(v.data), nil
+}
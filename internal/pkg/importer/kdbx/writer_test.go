@@ -0,0 +1,244 @@
+package kdbx_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"html"
+	"math"
+	"regexp"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20"
+)
+
+// fixture describes a KDBX 4 database generated by writeKDBX.
+// Seeds and IVs are derived from the fixture name, so that the generated files are reproducible.
+type fixture struct {
+	name     string
+	password string
+	keyFile  []byte
+	document string
+	kdf      string
+	cipher   string
+	stream   string
+	gzip     bool
+}
+
+// writeKDBX encodes a KDBX 4 database following the KeePass format specification.
+// It is independent from the package implementation, so that reading the fixtures checks both sides of the format.
+func writeKDBX(t *testing.T, f *fixture) []byte {
+	t.Helper()
+	seed := func(label string, n int) []byte {
+		sum := sha512.Sum512([]byte(f.name + "/" + label))
+		return sum[:n]
+	}
+
+	var composite []byte
+	if f.password != "" {
+		sum := sha256.Sum256([]byte(f.password))
+		composite = append(composite, sum[:]...)
+	}
+	if f.keyFile != nil {
+		composite = append(composite, keyFileKey(t, f.keyFile)...)
+	}
+	compositeSum := sha256.Sum256(composite)
+
+	kdfParams := &bytes.Buffer{}
+	_ = binary.Write(kdfParams, binary.LittleEndian, uint16(0x0100))
+	var transformed []byte
+	switch f.kdf {
+	case "aes":
+		salt, rounds := seed("kdf", 32), uint64(1000)
+		writeVariant(kdfParams, 0x42, "$UUID", mustHex("c9d9f39a628a4460bf740d08c18a4fea"))
+		writeVariant(kdfParams, 0x42, "S", salt)
+		writeVariant(kdfParams, 0x05, "R", binary.LittleEndian.AppendUint64(nil, rounds))
+		block, _ := aes.NewCipher(salt)
+		key := compositeSum
+		for range rounds {
+			block.Encrypt(key[:16], key[:16])
+			block.Encrypt(key[16:], key[16:])
+		}
+		sum := sha256.Sum256(key[:])
+		transformed = sum[:]
+	case "argon2id":
+		salt := seed("kdf", 32)
+		writeVariant(kdfParams, 0x42, "$UUID", mustHex("9e298b1956db4773b23dfc3ec6f0a1e6"))
+		writeVariant(kdfParams, 0x42, "S", salt)
+		writeVariant(kdfParams, 0x04, "P", binary.LittleEndian.AppendUint32(nil, 2))
+		writeVariant(kdfParams, 0x05, "M", binary.LittleEndian.AppendUint64(nil, 1<<20))
+		writeVariant(kdfParams, 0x05, "I", binary.LittleEndian.AppendUint64(nil, 2))
+		writeVariant(kdfParams, 0x04, "V", binary.LittleEndian.AppendUint32(nil, 0x13))
+		transformed = argon2.IDKey(compositeSum[:], salt, 2, 1024, 2, 32)
+	default:
+		t.Fatalf("unknown KDF %s", f.kdf)
+	}
+	kdfParams.WriteByte(0)
+
+	masterSeed := seed("master", 32)
+	encryptionKey := sha256.Sum256(append(bytes.Clone(masterSeed), transformed...))
+	hmacKey := sha512.Sum512(append(append(bytes.Clone(masterSeed), transformed...), 1))
+
+	var cipherID, iv []byte
+	switch f.cipher {
+	case "aes":
+		cipherID, iv = mustHex("31c1f2e6bf714350be5805216afc5aff"), seed("iv", 16)
+	case "chacha20":
+		cipherID, iv = mustHex("d6038a2b8b6f4cb5a524339a31dbb59a"), seed("iv", 12)
+	default:
+		t.Fatalf("unknown cipher %s", f.cipher)
+	}
+	compression := uint32(0)
+	if f.gzip {
+		compression = 1
+	}
+
+	header := &bytes.Buffer{}
+	_ = binary.Write(header, binary.LittleEndian, []uint32{0x9AA2D903, 0xB54BFB67, 0x00040001})
+	writeField(header, 2, cipherID)
+	writeField(header, 3, binary.LittleEndian.AppendUint32(nil, compression))
+	writeField(header, 4, masterSeed)
+	writeField(header, 7, iv)
+	writeField(header, 11, kdfParams.Bytes())
+	writeField(header, 0, []byte("\r\n\r\n"))
+
+	// Inner header and document, with protected values XOR-ed with the inner stream in document order.
+	streamKey := seed("stream", 64)
+	var keystream func([]byte) []byte
+	var streamID uint32
+	switch f.stream {
+	case "chacha20":
+		streamID = 3
+		h := sha512.Sum512(streamKey)
+		c, _ := chacha20.NewUnauthenticatedCipher(h[:32], h[32:44])
+		keystream = func(b []byte) []byte {
+			c.XORKeyStream(b, b)
+			return b
+		}
+	case "salsa20":
+		streamID = 2
+		key := sha256.Sum256(streamKey)
+		nonce := []byte{0xE8, 0x30, 0x09, 0x4B, 0x97, 0x20, 0x5D, 0x2A}
+		var offset int
+		keystream = func(b []byte) []byte {
+			buf := make([]byte, offset+len(b))
+			salsa20.XORKeyStream(buf, buf, nonce, &key)
+			for i := range b {
+				b[i] ^= buf[offset+i]
+			}
+			offset += len(b)
+			return b
+		}
+	default:
+		t.Fatalf("unknown inner stream %s", f.stream)
+	}
+	// Protected values are encrypted once unescaped, and their base64 encoding doesn't need to be escaped.
+	protected := regexp.MustCompile(`(<Value Protected="True">)([^<]*)(</Value>)`)
+	document := protected.ReplaceAllStringFunc(f.document, func(s string) string {
+		m := protected.FindStringSubmatch(s)
+		return m[1] + base64.StdEncoding.EncodeToString(keystream([]byte(html.UnescapeString(m[2])))) + m[3]
+	})
+
+	payload := &bytes.Buffer{}
+	writeField(payload, 1, binary.LittleEndian.AppendUint32(nil, streamID))
+	writeField(payload, 2, streamKey)
+	writeField(payload, 3, []byte("\x01attachment"))
+	writeField(payload, 0, nil)
+	payload.WriteString(document)
+
+	plaintext := payload.Bytes()
+	if f.gzip {
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		_, _ = zw.Write(plaintext)
+		_ = zw.Close()
+		plaintext = buf.Bytes()
+	}
+
+	var ciphertext []byte
+	switch f.cipher {
+	case "aes":
+		padding := 16 - len(plaintext)%16
+		plaintext = append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+		block, _ := aes.NewCipher(encryptionKey[:])
+		ciphertext = make([]byte, len(plaintext))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+	case "chacha20":
+		c, _ := chacha20.NewUnauthenticatedCipher(encryptionKey[:], iv)
+		ciphertext = make([]byte, len(plaintext))
+		c.XORKeyStream(ciphertext, plaintext)
+	}
+
+	out := &bytes.Buffer{}
+	out.Write(header.Bytes())
+	headerHash := sha256.Sum256(header.Bytes())
+	out.Write(headerHash[:])
+	out.Write(hmacSHA256(hmacKey[:], math.MaxUint64, header.Bytes()))
+
+	// Split the ciphertext in small blocks, to exercise the block stream.
+	const blockSize = 256
+	index := uint64(0)
+	for ; len(ciphertext) > 0; index++ {
+		block := ciphertext[:min(blockSize, len(ciphertext))]
+		ciphertext = ciphertext[len(block):]
+		writeBlock(out, hmacKey[:], index, block)
+	}
+	writeBlock(out, hmacKey[:], index, nil)
+	return out.Bytes()
+}
+
+func writeBlock(out *bytes.Buffer, hmacKey []byte, index uint64, block []byte) {
+	size := binary.LittleEndian.AppendUint32(nil, uint32(len(block)))
+	mac := hmacSHA256(hmacKey, index, append(binary.LittleEndian.AppendUint64(nil, index), append(size, block...)...))
+	out.Write(mac)
+	out.Write(size)
+	out.Write(block)
+}
+
+func hmacSHA256(hmacKey []byte, index uint64, data []byte) []byte {
+	key := sha512.Sum512(append(binary.LittleEndian.AppendUint64(nil, index), hmacKey...))
+	mac := hmac.New(sha256.New, key[:])
+	_, _ = mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func writeField(out *bytes.Buffer, id byte, data []byte) {
+	out.WriteByte(id)
+	_ = binary.Write(out, binary.LittleEndian, uint32(len(data)))
+	out.Write(data)
+}
+
+func writeVariant(out *bytes.Buffer, typ byte, key string, value []byte) {
+	out.WriteByte(typ)
+	_ = binary.Write(out, binary.LittleEndian, uint32(len(key)))
+	out.WriteString(key)
+	_ = binary.Write(out, binary.LittleEndian, uint32(len(value)))
+	out.Write(value)
+}
+
+// keyFileKey extracts the key of a version 2.0 XML key file.
+func keyFileKey(t *testing.T, keyFile []byte) []byte {
+	t.Helper()
+	m := regexp.MustCompile(`(?s)<Data[^>]*>(.*?)</Data>`).FindSubmatch(keyFile)
+	if m == nil {
+		t.Fatal("invalid key file")
+	}
+	return mustHex(string(regexp.MustCompile(`\s`).ReplaceAll(m[1], nil)))
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
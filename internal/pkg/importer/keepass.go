@@ -0,0 +1,54 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/importer/kdbx"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// ParseKDBX parses a KeePass KDBX 4 database protected by password, keyFile or both.
+// keyFile is the content of the key file, nil if the database isn't protected by one.
+//
+// The groups holding an entry become its tags, together with the entry's own tags. Custom string fields become
+// custom fields of the entry, protected ones of type model.FieldTypeHidden, which are stored encrypted.
+// Attachments are reported as errors. Entries in the recycle bin and the entries' history are not imported.
+func ParseKDBX(r io.Reader, password string, keyFile []byte) (*Result, error) {
+	entries, err := kdbx.Read(r, password, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KeePass database: %w", err)
+	}
+
+	result := &Result{Extras: ExtrasFields}
+	for _, e := range entries {
+		entry := &model.PasswordEntry{
+			Service:    e.Title,
+			Username:   e.UserName,
+			Password:   e.Password,
+			URL:        e.URL,
+			Notes:      e.Notes,
+			CreatedAt:  e.CreatedAt,
+			ModifiedAt: e.ModifiedAt,
+		}
+		for _, tag := range slices.Concat(e.Groups, e.Tags) {
+			if !slices.Contains(entry.Tags, tag) {
+				entry.Tags = append(entry.Tags, tag)
+			}
+		}
+		for _, field := range e.Fields {
+			fieldType := model.FieldTypeText
+			if field.Protected {
+				fieldType = model.FieldTypeHidden
+			}
+			addField(entry, field.Key, fieldType, field.Value)
+		}
+		for _, name := range e.Attachments {
+			result.fail(e.Title, fmt.Errorf("attachment %q not imported", name))
+		}
+
+		result.add(e.Title, entry)
+	}
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package importer_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/importer"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/importer/kdbx"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+func TestParseKDBX(t *testing.T) {
+	data, err := os.ReadFile("kdbx/testdata/argon2id.kdbx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := importer.ParseKDBX(bytes.NewReader(data), "correct horse battery staple", nil)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	want := []*model.PasswordEntry{
+		{
+			Service:    "Email",
+			Username:   "me@example.com",
+			Password:   "m41l-p4ss",
+			URL:        "https://mail.example.com",
+			Notes:      "Recovery codes in the safe",
+			Tags:       []string{"personal"},
+			CreatedAt:  time.Date(2021, 6, 1, 8, 30, 0, 0, time.UTC),
+			ModifiedAt: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			Service:  "Bastion",
+			Username: "admin",
+			Password: "r00t & br4nch",
+			Tags:     []string{"Work", "Servers", "ssh", "prod"},
+			Fields: []model.EntryField{
+				{Name: "Port", Type: model.FieldTypeText, Value: "2222"},
+				{Name: "TOTP Seed", Type: model.FieldTypeHidden, Value: "JBSWY3DPEHPK3PXP"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(result.Entries, want) {
+		t.Fatalf("Expected entries:\n%+v\ngot:\n%+v", want, result.Entries)
+	}
+
+	wantErrors := []string{
+		`item "Bastion": attachment "id_ed25519" not imported`,
+	}
+	if len(result.Errors) != len(wantErrors) {
+		t.Fatalf("Expected %d errors, got %v", len(wantErrors), result.Errors)
+	}
+	for i, want := range wantErrors {
+		if result.Errors[i].Error() != want {
+			t.Fatalf("Expected error [%s], got [%s]", want, result.Errors[i])
+		}
+	}
+}
+
+func TestParseKDBX_WrongPassword(t *testing.T) {
+	data, err := os.ReadFile("kdbx/testdata/argon2id.kdbx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = importer.ParseKDBX(bytes.NewReader(data), "wrong password", nil)
+	if !errors.Is(err, kdbx.ErrInvalidKey) {
+		t.Fatalf("Expected error [%v], got [%v]", kdbx.ErrInvalidKey, err)
+	}
+}
@@ -0,0 +1,133 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/otp"
+)
+
+// passExtension is the extension of the encrypted files of a password store.
+const passExtension = ".gpg"
+
+// Keys of the "key: value" lines of a pass file that are mapped to entry fields, compared case-insensitively.
+var (
+	passUsernameKeys = []string{"username", "user", "login"}
+	passURLKeys      = []string{"url", "website", "site"}
+)
+
+// Decrypter decrypts the files of a password store.
+type Decrypter interface {
+	// Decrypt returns the plaintext of the encrypted file at path.
+	Decrypt(path string) ([]byte, error)
+}
+
+// GPG decrypts files by running the gpg command, which asks for the passphrase through the gpg agent if needed.
+type GPG struct {
+	// Command is the gpg executable, "gpg" if empty.
+	Command string
+}
+
+// Decrypt implements the Decrypter interface.
+func (g *GPG) Decrypt(path string) ([]byte, error) {
+	command := g.Command
+	if command == "" {
+		command = "gpg"
+	}
+	var stdout, stderr bytes.Buffer
+	//nolint:gosec // the command and the path are provided by the user
+	cmd := exec.Command(command, "--quiet", "--batch", "--decrypt", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// ParsePass parses the password store at root, as managed by pass, decrypting its files with decrypter.
+//
+// Each .gpg file is an entry named after the file, and the directories holding it become its tags. The first line
+// of a file is the password, the following "key: value" lines with a username, user or login key set the username
+// and those with a url, website or site key set the URL. The remaining lines are the notes, except for otpauth URIs,
+// which are reported as errors since notes are not encrypted. Hidden files and directories, such as .git, are
+// skipped.
+func ParsePass(root string, decrypter Decrypter) (*Result, error) {
+	result := &Result{Extras: ExtrasOTP}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || filepath.Ext(path) != passExtension {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		item := filepath.ToSlash(strings.TrimSuffix(rel, passExtension))
+		plaintext, err := decrypter.Decrypt(path)
+		if err != nil {
+			result.fail(item, fmt.Errorf("failed to decrypt: %w", err))
+			return nil
+		}
+
+		entry := parsePassFile(result, item, string(plaintext))
+		entry.Service = filepath.Base(item)
+		if dir := filepath.Dir(rel); dir != "." {
+			entry.Tags = strings.Split(filepath.ToSlash(dir), "/")
+		}
+		result.add(item, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password store: %w", err)
+	}
+	if len(result.Entries) == 0 && len(result.Errors) == 0 {
+		return nil, errors.New("no password files found, is this a password store?")
+	}
+	return result, nil
+}
+
+// parsePassFile maps the content of a pass file to an entry.
+func parsePassFile(result *Result, item, content string) *model.PasswordEntry {
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(content, "\r\n", "\n"), "\n"), "\n")
+	entry := &model.PasswordEntry{Password: lines[0]}
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(line), otp.Scheme) && entry.OTP == "":
+			if _, err := otp.Parse(line); err != nil {
+				result.fail(item, fmt.Errorf("OTP secret not imported: %w", err))
+				continue
+			}
+			entry.OTP = strings.TrimSpace(line)
+		case ok && entry.Username == "" && slices.Contains(passUsernameKeys, key):
+			entry.Username = value
+		case ok && entry.URL == "" && slices.Contains(passURLKeys, key):
+			entry.URL = value
+		default:
+			appendNote(entry, line)
+		}
+	}
+	entry.Notes = strings.TrimSpace(entry.Notes)
+	return entry
+}
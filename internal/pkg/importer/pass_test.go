@@ -0,0 +1,163 @@
+package importer_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/export"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/importer"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// plainDecrypter stubs gpg, reading the password store files as plain text.
+type plainDecrypter struct{}
+
+func (plainDecrypter) Decrypt(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if strings.Contains(string(data), "corrupted") {
+		return nil, errors.New("decryption failed: No secret key")
+	}
+	return data, err
+}
+
+// writeStore creates a password store in a temporary directory with the given files.
+func writeStore(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestParsePass(t *testing.T) {
+	root := writeStore(t, map[string]string{
+		".gpg-id":                  "ABCDEF0123456789\n",
+		".git/objects/leaked.gpg":  "not an entry\n",
+		"email/gmail.com.gpg":      "g-secret\nlogin: me@gmail.com\nURL: https://mail.google.com\nRecovery: in the safe\n",
+		"work/servers/bastion.gpg": "r00t\nuser: admin\notpauth://totp/bastion?secret=JBSWY3DPEHPK3PXP\n",
+		"wifi.gpg":                 "hunter2\n",
+		"work/broken.gpg":          "corrupted\n",
+		"notes.txt":                "not an entry\n",
+	})
+
+	result, err := importer.ParsePass(root, plainDecrypter{})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	want := []*model.PasswordEntry{
+		{
+			Service:  "gmail.com",
+			Username: "me@gmail.com",
+			Password: "g-secret",
+			URL:      "https://mail.google.com",
+			Notes:    "Recovery: in the safe",
+			Tags:     []string{"email"},
+		},
+		{Service: "wifi", Password: "hunter2"},
+		{
+			Service:  "bastion",
+			Username: "admin",
+			Password: "r00t",
+			OTP:      "otpauth://totp/bastion?secret=JBSWY3DPEHPK3PXP",
+			Tags:     []string{"work", "servers"},
+		},
+	}
+	if !reflect.DeepEqual(result.Entries, want) {
+		t.Fatalf("Expected entries:\n%+v\ngot:\n%+v", want, result.Entries)
+	}
+
+	wantErrors := []string{
+		`item "work/broken": failed to decrypt: decryption failed: No secret key`,
+	}
+	if len(result.Errors) != len(wantErrors) {
+		t.Fatalf("Expected %d errors, got %v", len(wantErrors), result.Errors)
+	}
+	for i, want := range wantErrors {
+		if result.Errors[i].Error() != want {
+			t.Fatalf("Expected error [%s], got [%s]", want, result.Errors[i])
+		}
+	}
+}
+
+func TestParsePass_RoundTrip(t *testing.T) {
+	entries := []*model.PasswordEntry{
+		{
+			Service:  "gmail",
+			Username: "user@example.com",
+			Password: "secret123",
+			URL:      "https://mail.google.com",
+			Notes:    "personal\nmail",
+			Tags:     []string{"email", "google"},
+		},
+		{Service: "github", Password: "secret456"},
+	}
+	root := filepath.Join(t.TempDir(), "stage")
+	if err := os.Mkdir(root, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := export.WritePassTree(root, entries); err != nil {
+		t.Fatal(err)
+	}
+	// Encrypting the staging tree with pass adds the .gpg extension.
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return os.Rename(path, path+".gpg")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := importer.ParsePass(root, plainDecrypter{})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if !reflect.DeepEqual(result.Entries, entries) {
+		t.Fatalf("Expected entries:\n%+v\ngot:\n%+v", entries, result.Entries)
+	}
+}
+
+func TestParsePass_Invalid(t *testing.T) {
+	_, err := importer.ParsePass(writeStore(t, map[string]string{"notes.txt": "hello\n"}), plainDecrypter{})
+	expectedErr := "no password files found"
+	if err == nil || !strings.Contains(err.Error(), expectedErr) {
+		t.Fatalf("Expected error containing [%s], got [%v]", expectedErr, err)
+	}
+}
+
+func TestGPG_Decrypt(t *testing.T) {
+	// A fake gpg printing the file passed after --decrypt, or failing like gpg when it is missing.
+	script := filepath.Join(t.TempDir(), "gpg")
+	content := "#!/bin/sh\n" +
+		"for last; do :; done\n" +
+		"[ \"$1 $2 $3\" = \"--quiet --batch --decrypt\" ] || exit 2\n" +
+		"cat \"$last\" 2>/dev/null || { echo \"gpg: can't open '$last'\" >&2; exit 2; }\n"
+	if err := os.WriteFile(script, []byte(content), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	root := writeStore(t, map[string]string{"gmail.gpg": "g-secret\n"})
+	gpg := &importer.GPG{Command: script}
+
+	got, err := gpg.Decrypt(filepath.Join(root, "gmail.gpg"))
+	if err != nil || string(got) != "g-secret\n" {
+		t.Fatalf("Expected [g-secret], got [%s] with error [%v]", got, err)
+	}
+
+	_, err = gpg.Decrypt(filepath.Join(root, "missing.gpg"))
+	if err == nil || !strings.Contains(err.Error(), "gpg: can't open") {
+		t.Fatalf("Expected error containing gpg output, got [%v]", err)
+	}
+}
@@ -0,0 +1,290 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// Status classifies an imported entry against the entries already in the vault.
+type Status int
+
+const (
+	// StatusNew marks an entry whose service is not in the vault.
+	StatusNew Status = iota
+	// StatusIdentical marks an entry whose service is in the vault with the same fields.
+	StatusIdentical
+	// StatusConflict marks an entry whose service is in the vault with different fields.
+	StatusConflict
+)
+
+// String implements the fmt.Stringer interface.
+func (s Status) String() string {
+	switch s {
+	case StatusNew:
+		return "new"
+	case StatusIdentical:
+		return "identical"
+	case StatusConflict:
+		return "conflict"
+	default:
+		return "Status(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// Action is what applying a plan does with an imported entry.
+type Action int
+
+const (
+	// ActionSkip leaves the vault untouched.
+	ActionSkip Action = iota
+	// ActionAdd adds the entry to the vault.
+	ActionAdd
+	// ActionUpdate replaces the existing entry of the vault.
+	ActionUpdate
+)
+
+// Strategy decides how conflicting entries are handled.
+type Strategy string
+
+const (
+	// StrategySkip keeps the existing entries.
+	StrategySkip Strategy = "skip"
+	// StrategyOverwrite replaces the existing entries with the imported ones.
+	StrategyOverwrite Strategy = "overwrite"
+	// StrategyRename adds the imported entries under a new service name, e.g. "github (2)".
+	StrategyRename Strategy = "rename"
+	// StrategyNewest keeps the most recently modified entry, the existing one if the imported one has no
+	// modification time.
+	StrategyNewest Strategy = "newest"
+)
+
+// Strategies lists the supported conflict strategies.
+var Strategies = []Strategy{StrategySkip, StrategyOverwrite, StrategyRename, StrategyNewest}
+
+// ParseStrategy returns the Strategy named s.
+func ParseStrategy(s string) (Strategy, error) {
+	if slices.Contains(Strategies, Strategy(s)) {
+		return Strategy(s), nil
+	}
+	return "", fmt.Errorf("unsupported conflict strategy %q", s)
+}
+
+// Names of the fields compared between an imported entry and the existing one.
+const (
+	FieldUsername = "username"
+	FieldPassword = "password"
+	FieldURL      = "url"
+	FieldNotes    = "notes"
+	FieldTags     = "tags"
+	FieldKind     = "kind"
+	FieldOTP      = "otp"
+	FieldFields   = "fields"
+)
+
+// PlanItem is an imported entry classified against the vault.
+type PlanItem struct {
+	// Entry is the entry to save, renamed if the conflict is resolved by renaming.
+	Entry *model.PasswordEntry
+	// Existing is the entry of the vault with the same service, nil if the entry is new.
+	Existing *model.PasswordEntry
+	// Service is the service of the imported entry, before any renaming.
+	Service string
+	// Diff lists the fields that differ from the existing entry.
+	Diff   []string
+	Status Status
+	Action Action
+}
+
+// Plan describes how importing a set of entries changes the vault, before any change is made.
+type Plan struct {
+	Items []*PlanItem
+	// Errors reports the entries that failed validation and won't be imported.
+	Errors []*ItemError
+	// extras are the extras carried by the imported entries.
+	extras Extras
+}
+
+// NewPlan classifies the imported entries against the existing entries of the vault and decides their action,
+// resolving conflicts with strategy. Entries that fail validation are reported in the plan errors.
+//
+// extras are the extras carried by the format of the imported entries. The others are not compared and are kept
+// when overwriting an entry of the vault, except for its kind: an entry whose kind the format can't carry is not
+// overwritten, and the conflict is reported in the plan errors.
+func NewPlan(imported, existing []*model.PasswordEntry, strategy Strategy, extras Extras) *Plan {
+	vault := make(map[string]*model.PasswordEntry, len(existing))
+	for _, entry := range existing {
+		vault[entry.Service] = entry
+	}
+
+	plan := &Plan{extras: extras}
+	// Services taken by the vault or by the entries planned so far.
+	taken := map[string]bool{}
+	for service := range vault {
+		taken[service] = true
+	}
+	seen := map[string]bool{}
+
+	for _, entry := range imported {
+		if err := validate(entry); err != nil {
+			plan.Errors = append(plan.Errors, &ItemError{Item: entry.Service, Err: err})
+			continue
+		}
+		if seen[entry.Service] {
+			plan.Errors = append(plan.Errors, &ItemError{Item: entry.Service, Err: errors.New("duplicate service")})
+			continue
+		}
+		seen[entry.Service] = true
+
+		item := &PlanItem{Entry: entry, Service: entry.Service, Existing: vault[entry.Service]}
+		if item.Existing != nil {
+			item.Diff = diff(entry, item.Existing, extras)
+		}
+		switch {
+		case item.Existing == nil:
+			item.Status, item.Action = StatusNew, ActionAdd
+			taken[entry.Service] = true
+		case len(item.Diff) == 0:
+			item.Status, item.Action = StatusIdentical, ActionSkip
+		default:
+			item.Status = StatusConflict
+			item.Action = resolve(item, strategy, taken)
+		}
+		if item.Action == ActionUpdate && extras&ExtrasKind == 0 && item.Existing.Kind != entry.Kind {
+			item.Action = ActionSkip
+			plan.Errors = append(plan.Errors, &ItemError{
+				Item: entry.Service,
+				Err:  fmt.Errorf("the vault entry is a %s, which the import format can't overwrite", item.Existing.Kind),
+			})
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	return plan
+}
+
+// resolve decides the action for a conflicting item according to strategy.
+func resolve(item *PlanItem, strategy Strategy, taken map[string]bool) Action {
+	switch strategy {
+	case StrategyOverwrite:
+		return ActionUpdate
+	case StrategyRename:
+		renamed := *item.Entry
+		for n := 2; ; n++ {
+			renamed.Service = fmt.Sprintf("%s (%d)", item.Service, n)
+			if !taken[renamed.Service] {
+				break
+			}
+		}
+		taken[renamed.Service] = true
+		item.Entry = &renamed
+		return ActionAdd
+	case StrategyNewest:
+		if item.Entry.ModifiedAt.After(item.Existing.ModifiedAt) {
+			return ActionUpdate
+		}
+		return ActionSkip
+	default:
+		return ActionSkip
+	}
+}
+
+// Entries returns the entries to save to apply the plan at time now. Entries replacing an existing one take its ID,
+// UUID and creation time, as well as the extras the imported entries don't carry, and are modified at now so that
+// the next sync sees the change. Added entries get a new ID and UUID.
+func (p *Plan) Entries(now time.Time) []*model.PasswordEntry {
+	var entries []*model.PasswordEntry
+	for _, item := range p.Items {
+		switch item.Action {
+		case ActionAdd:
+			entry := *item.Entry
+			entry.ID, entry.UUID = 0, ""
+			entries = append(entries, &entry)
+		case ActionUpdate:
+			entry := *item.Entry
+			entry.ID, entry.UUID = item.Existing.ID, item.Existing.UUID
+			entry.CreatedAt, entry.ModifiedAt = item.Existing.CreatedAt, now
+			if p.extras&ExtrasOTP == 0 {
+				entry.OTP = item.Existing.OTP
+			}
+			if p.extras&ExtrasFields == 0 {
+				entry.Fields = item.Existing.Fields
+			}
+			entries = append(entries, &entry)
+		case ActionSkip:
+		}
+	}
+	return entries
+}
+
+// Count returns the number of items of the plan with the given action.
+func (p *Plan) Count(action Action) int {
+	n := 0
+	for _, item := range p.Items {
+		if item.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// validate checks that an imported entry can be stored in the vault.
+func validate(entry *model.PasswordEntry) error {
+	switch {
+	case strings.TrimSpace(entry.Service) == "":
+		return errors.New("missing service name")
+	case entry.Service != strings.TrimSpace(entry.Service):
+		return errors.New("service name has leading or trailing spaces")
+	case strings.ContainsFunc(entry.Service, unicode.IsControl):
+		return errors.New("service name contains control characters")
+	case strings.ContainsFunc(entry.Username, unicode.IsControl):
+		return errors.New("username contains control characters")
+	case strings.ContainsFunc(entry.URL, unicode.IsControl):
+		return errors.New("URL contains control characters")
+	}
+	for _, tag := range entry.Tags {
+		if strings.TrimSpace(tag) == "" || strings.ContainsFunc(tag, unicode.IsControl) {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+	return nil
+}
+
+// diff returns the names of the fields that differ between two entries, comparing only the given extras. Tags are
+// compared regardless of order.
+func diff(a, b *model.PasswordEntry, extras Extras) []string {
+	var fields []string
+	if a.Username != b.Username {
+		fields = append(fields, FieldUsername)
+	}
+	if a.Password != b.Password {
+		fields = append(fields, FieldPassword)
+	}
+	if a.URL != b.URL {
+		fields = append(fields, FieldURL)
+	}
+	if a.Notes != b.Notes {
+		fields = append(fields, FieldNotes)
+	}
+	tagsA, tagsB := slices.Clone(a.Tags), slices.Clone(b.Tags)
+	slices.Sort(tagsA)
+	slices.Sort(tagsB)
+	if !slices.Equal(slices.Compact(tagsA), slices.Compact(tagsB)) {
+		fields = append(fields, FieldTags)
+	}
+	if extras&ExtrasKind != 0 && a.Kind != b.Kind {
+		fields = append(fields, FieldKind)
+	}
+	if extras&ExtrasOTP != 0 && a.OTP != b.OTP {
+		fields = append(fields, FieldOTP)
+	}
+	if extras&ExtrasFields != 0 && !slices.Equal(a.Fields, b.Fields) {
+		fields = append(fields, FieldFields)
+	}
+	return fields
+}
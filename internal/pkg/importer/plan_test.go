@@ -0,0 +1,222 @@
+package importer_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/importer"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+func TestNewPlan(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	existing := []*model.PasswordEntry{
+		{ID: 1, Service: "github", Username: "dev", Password: "old", Tags: []string{"work", "code"}, CreatedAt: older},
+		{ID: 2, Service: "gmail", Username: "me", Password: "g-secret", Tags: []string{"mail"}},
+		{ID: 3, Service: "github (2)", Password: "other"},
+	}
+	imported := []*model.PasswordEntry{
+		{Service: "github", Username: "dev", Password: "new", Tags: []string{"code", "work"}, ModifiedAt: newer},
+		{Service: "gmail", Username: "me", Password: "g-secret", Tags: []string{"mail"}},
+		{Service: "gitlab", Password: "gl-secret"},
+	}
+
+	tests := []struct {
+		name        string
+		strategy    importer.Strategy
+		wantActions []importer.Action
+		wantEntries []*model.PasswordEntry
+	}{
+		{
+			name:        "skip",
+			strategy:    importer.StrategySkip,
+			wantActions: []importer.Action{importer.ActionSkip, importer.ActionSkip, importer.ActionAdd},
+			wantEntries: []*model.PasswordEntry{imported[2]},
+		},
+		{
+			name:        "overwrite",
+			strategy:    importer.StrategyOverwrite,
+			wantActions: []importer.Action{importer.ActionUpdate, importer.ActionSkip, importer.ActionAdd},
+			wantEntries: []*model.PasswordEntry{
+				{
+					ID:         1,
+					Service:    "github",
+					Username:   "dev",
+					Password:   "new",
+					Tags:       []string{"code", "work"},
+					CreatedAt:  older,
+					ModifiedAt: now,
+				},
+				imported[2],
+			},
+		},
+		{
+			name:        "rename",
+			strategy:    importer.StrategyRename,
+			wantActions: []importer.Action{importer.ActionAdd, importer.ActionSkip, importer.ActionAdd},
+			wantEntries: []*model.PasswordEntry{
+				{Service: "github (3)", Username: "dev", Password: "new", Tags: []string{"code", "work"}, ModifiedAt: newer},
+				imported[2],
+			},
+		},
+		{
+			name:        "newest",
+			strategy:    importer.StrategyNewest,
+			wantActions: []importer.Action{importer.ActionUpdate, importer.ActionSkip, importer.ActionAdd},
+			wantEntries: []*model.PasswordEntry{
+				{
+					ID:         1,
+					Service:    "github",
+					Username:   "dev",
+					Password:   "new",
+					Tags:       []string{"code", "work"},
+					CreatedAt:  older,
+					ModifiedAt: now,
+				},
+				imported[2],
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := importer.NewPlan(imported, existing, tt.strategy, importer.ExtrasAll)
+			if len(plan.Errors) != 0 {
+				t.Fatalf("Unexpected errors: %v", plan.Errors)
+			}
+
+			wantStatuses := []importer.Status{importer.StatusConflict, importer.StatusIdentical, importer.StatusNew}
+			for i, item := range plan.Items {
+				if item.Status != wantStatuses[i] || item.Action != tt.wantActions[i] {
+					t.Fatalf("Expected %s to be %s with action %d, got %s with action %d",
+						item.Service, wantStatuses[i], tt.wantActions[i], item.Status, item.Action)
+				}
+			}
+			if diff := plan.Items[0].Diff; !reflect.DeepEqual(diff, []string{importer.FieldPassword}) {
+				t.Fatalf("Expected the password to differ, got %v", diff)
+			}
+			if entries := plan.Entries(now); !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Fatalf("Expected entries:\n%+v\ngot:\n%+v", tt.wantEntries, entries)
+			}
+		})
+	}
+}
+
+func TestNewPlan_Newest(t *testing.T) {
+	existing := []*model.PasswordEntry{
+		{ID: 1, Service: "github", Password: "current", ModifiedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	imported := []*model.PasswordEntry{
+		{Service: "github", Password: "stale", ModifiedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	plan := importer.NewPlan(imported, existing, importer.StrategyNewest, importer.ExtrasAll)
+	if plan.Items[0].Action != importer.ActionSkip || len(plan.Entries(time.Now())) != 0 {
+		t.Fatalf("Expected the older imported entry to be skipped, got action %d", plan.Items[0].Action)
+	}
+}
+
+func TestNewPlan_Extras(t *testing.T) {
+	otpURI := "otpauth://totp/github?secret=JBSWY3DPEHPK3PXP"
+	fields := []model.EntryField{{Name: "pin", Type: model.FieldTypeHidden, Value: "1234"}}
+	card := []model.EntryField{{Name: "number", Type: model.FieldTypeHidden, Value: "4111111111111111"}}
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	existing := []*model.PasswordEntry{
+		{ID: 1, Service: "github", Password: "old", OTP: otpURI, Fields: fields},
+		{ID: 2, Service: "visa", Kind: model.KindCard, Fields: card},
+	}
+	imported := []*model.PasswordEntry{
+		{Service: "github", Password: "new"},
+		{Service: "visa", Password: "secret"},
+	}
+
+	tests := []struct {
+		name        string
+		extras      importer.Extras
+		wantDiff    []string
+		wantEntries []*model.PasswordEntry
+		wantErrors  int
+	}{
+		{
+			name:     "keeps the extras the format can't carry",
+			extras:   0,
+			wantDiff: []string{importer.FieldPassword},
+			wantEntries: []*model.PasswordEntry{
+				{ID: 1, Service: "github", Password: "new", OTP: otpURI, Fields: fields, ModifiedAt: now},
+			},
+			wantErrors: 1,
+		},
+		{
+			name:     "overwrites the extras the format carries",
+			extras:   importer.ExtrasAll,
+			wantDiff: []string{importer.FieldPassword, importer.FieldOTP, importer.FieldFields},
+			wantEntries: []*model.PasswordEntry{
+				{ID: 1, Service: "github", Password: "new", ModifiedAt: now},
+				{ID: 2, Service: "visa", Password: "secret", ModifiedAt: now},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := importer.NewPlan(imported, existing, importer.StrategyOverwrite, tt.extras)
+			if len(plan.Errors) != tt.wantErrors {
+				t.Fatalf("Expected %d errors, got %v", tt.wantErrors, plan.Errors)
+			}
+			if diff := plan.Items[0].Diff; !reflect.DeepEqual(diff, tt.wantDiff) {
+				t.Fatalf("Expected %v to differ, got %v", tt.wantDiff, diff)
+			}
+			if entries := plan.Entries(now); !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Fatalf("Expected entries:\n%+v\ngot:\n%+v", tt.wantEntries, entries)
+			}
+		})
+	}
+}
+
+func TestNewPlan_Invalid(t *testing.T) {
+	imported := []*model.PasswordEntry{
+		{Service: " ", Password: "secret"},
+		{Service: "github ", Password: "secret"},
+		{Service: "git\x1bhub", Password: "secret"},
+		{Service: "gitlab", Username: "dev\n", Password: "secret"},
+		{Service: "gitlab", Tags: []string{""}},
+		{Service: "gmail", Password: "secret"},
+		{Service: "gmail", Password: "other"},
+	}
+
+	plan := importer.NewPlan(imported, nil, importer.StrategySkip, importer.ExtrasAll)
+
+	wantErrors := []string{
+		`item " ": missing service name`,
+		`item "github ": service name has leading or trailing spaces`,
+		`item "git\x1bhub": service name contains control characters`,
+		`item "gitlab": username contains control characters`,
+		`item "gitlab": invalid tag ""`,
+		`item "gmail": duplicate service`,
+	}
+	if len(plan.Errors) != len(wantErrors) {
+		t.Fatalf("Expected %d errors, got %v", len(wantErrors), plan.Errors)
+	}
+	for i, want := range wantErrors {
+		if plan.Errors[i].Error() != want {
+			t.Fatalf("Expected error [%s], got [%s]", want, plan.Errors[i])
+		}
+	}
+	if len(plan.Items) != 1 || plan.Count(importer.ActionAdd) != 1 {
+		t.Fatalf("Expected only gmail to be added, got %+v", plan.Items)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, s := range []string{"skip", "overwrite", "rename", "newest"} {
+		if got, err := importer.ParseStrategy(s); err != nil || string(got) != s {
+			t.Fatalf("Expected strategy %s, got [%s] with error [%v]", s, got, err)
+		}
+	}
+	if _, err := importer.ParseStrategy("merge"); err == nil {
+		t.Fatal("Expected an error for an unsupported strategy")
+	}
+}
@@ -0,0 +1,387 @@
+// Package lansync synchronizes vaults directly between two machines of the local network.
+//
+// One machine serves its vault and shows a one-time code, the other pulls the changes of the served vault by typing
+// the code. The connection is authenticated with the code through CPace (see package pake), so no certificate is
+// needed: an eavesdropper learns nothing about the code and an active attacker can test a single guess of it.
+//
+// The handshake, started by the pulling client, is:
+//
+//	client → server  magic "PSSTSYNC", version (2 bytes, big endian), session ID (16 bytes), CPace share
+//	server → client  CPace share, server confirmation tag
+//	client → server  client confirmation tag
+//
+// The confirmation tags are HMAC-SHA256 of the transcript, keyed by the session key, so that each party proves it
+// derived the same key before anything else is sent. Then every message is a JSON object sealed with AES-256-GCM,
+// with a key and a message counter as nonce per direction, and prefixed with its length (4 bytes, big endian):
+//
+//	server → client  hello: the device ID of the served vault
+//	client → server  request: the last time the client merged the served vault
+//	server → client  changes: the entries and tombstones changed since then
+package lansync
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/merge"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/pake"
+)
+
+const (
+	// Magic starts the handshake of a client.
+	Magic = "PSSTSYNC"
+	// Version is the version of the protocol.
+	Version uint16 = 1
+	// DefaultPort is the TCP port served by default.
+	DefaultPort = 7557
+
+	// codeDigits is the number of digits of a one-time code.
+	codeDigits = 8
+	// sessionIDLen is the length of the session ID chosen by the client.
+	sessionIDLen = 16
+	// channelID binds the CPace exchange to this protocol.
+	channelID = "psst-lansync-v1"
+	// maxMessageLen bounds the messages read from the other party.
+	maxMessageLen = 64 << 20
+)
+
+var (
+	// ErrAuthentication is returned when the other party didn't prove it knows the one-time code.
+	ErrAuthentication = errors.New("authentication failed: wrong code")
+	// ErrProtocol is returned when the other party doesn't follow the protocol.
+	ErrProtocol = errors.New("invalid sync message")
+)
+
+// NewCode generates a one-time code, formatted as two groups of four digits.
+func NewCode() (string, error) {
+	b := make([]byte, codeDigits)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+	var code strings.Builder
+	for i, v := range b {
+		if i == codeDigits/2 {
+			code.WriteByte('-')
+		}
+		// The bias of the modulo is negligible for a one-time code.
+		code.WriteByte('0' + v%10)
+	}
+	return code.String(), nil
+}
+
+// normalizeCode removes the separators the user may type in a code.
+func normalizeCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// hello is the first message of the server.
+type hello struct {
+	DeviceID string `json:"device_id"`
+}
+
+// request is the message of the client asking for the changes of the served vault.
+type request struct {
+	Since time.Time `json:"since"`
+}
+
+// changes is the message of the server with the changes of the served vault.
+type changes struct {
+	Entries    []*entry           `json:"entries"`
+	Tombstones []*model.Tombstone `json:"tombstones"`
+}
+
+// entry is a password entry sent with its UUID and the last time it was merged, which aren't part of the JSON
+// encoding of model.PasswordEntry.
+type entry struct {
+	SyncedAt time.Time `json:"synced_at"`
+	UUID     string    `json:"uuid"`
+	model.PasswordEntry
+}
+
+// Serve serves the vault with the given device ID to the client connected on conn, after authenticating it with
+// code. changes returns the entries and tombstones of the vault changed after the given time.
+func Serve(conn io.ReadWriter, code, deviceID string, changes func(since time.Time) (merge.Snapshot, error)) error {
+	ch, err := accept(conn, code)
+	if err != nil {
+		return err
+	}
+
+	if err = ch.write(hello{DeviceID: deviceID}); err != nil {
+		return err
+	}
+	var req request
+	if err = ch.read(&req); err != nil {
+		return err
+	}
+	snapshot, err := changes(req.Since)
+	if err != nil {
+		return err
+	}
+	return ch.write(encodeChanges(snapshot))
+}
+
+// Pull pulls the changes of the vault served on conn, authenticating with code. lastSync returns the last time the
+// served vault, identified by its device ID, was merged. The device ID of the served vault is returned along with
+// its changes since then.
+func Pull(
+	conn io.ReadWriter, code string, lastSync func(deviceID string) (time.Time, error),
+) (string, merge.Snapshot, error) {
+	ch, err := connect(conn, code)
+	if err != nil {
+		return "", merge.Snapshot{}, err
+	}
+
+	var h hello
+	if err = ch.read(&h); err != nil {
+		return "", merge.Snapshot{}, err
+	}
+	if h.DeviceID == "" {
+		return "", merge.Snapshot{}, fmt.Errorf("%w: missing device ID", ErrProtocol)
+	}
+	since, err := lastSync(h.DeviceID)
+	if err != nil {
+		return "", merge.Snapshot{}, err
+	}
+	if err = ch.write(request{Since: since}); err != nil {
+		return "", merge.Snapshot{}, err
+	}
+	var c changes
+	if err = ch.read(&c); err != nil {
+		return "", merge.Snapshot{}, err
+	}
+	snapshot, err := decodeChanges(&c)
+	if err != nil {
+		return "", merge.Snapshot{}, err
+	}
+	return h.DeviceID, snapshot, nil
+}
+
+// encodeChanges encodes a snapshot as a changes message.
+func encodeChanges(snapshot merge.Snapshot) changes {
+	c := changes{Tombstones: snapshot.Tombstones}
+	for _, e := range snapshot.Entries {
+		sent := entry{UUID: e.UUID, SyncedAt: e.SyncedAt, PasswordEntry: *e}
+		// IDs are local to each vault.
+		sent.ID = 0
+		c.Entries = append(c.Entries, &sent)
+	}
+	return c
+}
+
+// decodeChanges decodes a changes message, checking that every entry and tombstone can be merged.
+func decodeChanges(c *changes) (merge.Snapshot, error) {
+	var snapshot merge.Snapshot
+	for _, e := range c.Entries {
+		if e == nil || e.UUID == "" || e.Service == "" {
+			return merge.Snapshot{}, fmt.Errorf("%w: entry without UUID or service", ErrProtocol)
+		}
+		received := e.PasswordEntry
+		received.UUID, received.SyncedAt, received.ID = e.UUID, e.SyncedAt, 0
+		snapshot.Entries = append(snapshot.Entries, &received)
+	}
+	for _, tombstone := range c.Tombstones {
+		if tombstone == nil || tombstone.UUID == "" {
+			return merge.Snapshot{}, fmt.Errorf("%w: tombstone without UUID", ErrProtocol)
+		}
+		snapshot.Tombstones = append(snapshot.Tombstones, tombstone)
+	}
+	return snapshot, nil
+}
+
+// connect performs the client side of the handshake.
+func connect(conn io.ReadWriter, code string) (*channel, error) {
+	sid := make([]byte, sessionIDLen)
+	if _, err := io.ReadFull(rand.Reader, sid); err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	exchange, err := pake.New([]byte(normalizeCode(code)), []byte(channelID), sid, true)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := []byte(Magic)
+	msg = binary.BigEndian.AppendUint16(msg, Version)
+	msg = append(msg, sid...)
+	msg = append(msg, exchange.Share()...)
+	if _, err = conn.Write(msg); err != nil {
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	reply := make([]byte, pake.ShareSize+sha256.Size)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+	serverShare, serverTag := reply[:pake.ShareSize], reply[pake.ShareSize:]
+	isk, err := exchange.Finish(serverShare)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	keys := deriveKeys(isk, sid, exchange.Share(), serverShare)
+	if !hmac.Equal(serverTag, keys.serverTag) {
+		return nil, ErrAuthentication
+	}
+	if _, err = conn.Write(keys.clientTag); err != nil {
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+	return newChannel(conn, keys.clientKey, keys.serverKey), nil
+}
+
+// accept performs the server side of the handshake.
+func accept(conn io.ReadWriter, code string) (*channel, error) {
+	msg := make([]byte, len(Magic)+2+sessionIDLen+pake.ShareSize)
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+	if !bytes.HasPrefix(msg, []byte(Magic)) {
+		return nil, fmt.Errorf("%w: not a psst sync client", ErrProtocol)
+	}
+	if version := binary.BigEndian.Uint16(msg[len(Magic):]); version != Version {
+		return nil, fmt.Errorf("%w: unsupported protocol version %d", ErrProtocol, version)
+	}
+	sid := msg[len(Magic)+2 : len(Magic)+2+sessionIDLen]
+	clientShare := msg[len(Magic)+2+sessionIDLen:]
+
+	exchange, err := pake.New([]byte(normalizeCode(code)), []byte(channelID), sid, false)
+	if err != nil {
+		return nil, err
+	}
+	isk, err := exchange.Finish(clientShare)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	keys := deriveKeys(isk, sid, clientShare, exchange.Share())
+	if _, err = conn.Write(append(exchange.Share(), keys.serverTag...)); err != nil {
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	clientTag := make([]byte, sha256.Size)
+	if _, err = io.ReadFull(conn, clientTag); err != nil {
+		// A client that derived a different key hangs up without sending its tag.
+		return nil, ErrAuthentication
+	}
+	if !hmac.Equal(clientTag, keys.clientTag) {
+		return nil, ErrAuthentication
+	}
+	return newChannel(conn, keys.serverKey, keys.clientKey), nil
+}
+
+// sessionKeys are the keys derived from the CPace session key.
+type sessionKeys struct {
+	clientTag []byte
+	serverTag []byte
+	clientKey []byte
+	serverKey []byte
+}
+
+// deriveKeys derives the confirmation tags and the encryption keys of each direction from the CPace session key.
+func deriveKeys(isk, sid, clientShare, serverShare []byte) sessionKeys {
+	derive := func(info string) []byte {
+		key := make([]byte, 32)
+		// Reading 32 bytes from HKDF-SHA256 can't fail.
+		_, _ = io.ReadFull(hkdf.New(sha256.New, isk, sid, []byte("psst-lansync "+info)), key)
+		return key
+	}
+	transcript := bytes.Join([][]byte{[]byte(Magic), sid, clientShare, serverShare}, nil)
+	tag := func(key []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(transcript)
+		return mac.Sum(nil)
+	}
+	return sessionKeys{
+		clientTag: tag(derive("client confirmation")),
+		serverTag: tag(derive("server confirmation")),
+		clientKey: derive("client to server"),
+		serverKey: derive("server to client"),
+	}
+}
+
+// channel exchanges JSON messages sealed with AES-256-GCM.
+type channel struct {
+	conn    io.ReadWriter
+	send    cipher.AEAD
+	recv    cipher.AEAD
+	sendSeq uint64
+	recvSeq uint64
+}
+
+// newChannel returns a channel sealing the messages it writes with sendKey and opening the ones it reads with
+// recvKey.
+func newChannel(conn io.ReadWriter, sendKey, recvKey []byte) *channel {
+	return &channel{conn: conn, send: newGCM(sendKey), recv: newGCM(recvKey)}
+}
+
+// write seals and writes the JSON encoding of v.
+func (c *channel) write(v any) error {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode sync message: %w", err)
+	}
+	sealed := c.send.Seal(nil, nonce(c.send, c.sendSeq), plaintext, nil)
+	c.sendSeq++
+
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+	if _, err = c.conn.Write(append(msg, sealed...)); err != nil {
+		return fmt.Errorf("failed to send sync message: %w", err)
+	}
+	return nil
+}
+
+// read reads, opens and decodes a message into v.
+func (c *channel) read(v any) error {
+	var header [4]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return fmt.Errorf("failed to read sync message: %w", err)
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxMessageLen {
+		return fmt.Errorf("%w: message too long", ErrProtocol)
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(c.conn, sealed); err != nil {
+		return fmt.Errorf("failed to read sync message: %w", err)
+	}
+
+	plaintext, err := c.recv.Open(nil, nonce(c.recv, c.recvSeq), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("%w: message modified in transit", ErrProtocol)
+	}
+	c.recvSeq++
+	if err = json.Unmarshal(plaintext, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+	return nil
+}
+
+// nonce returns the nonce of the message with sequence number seq.
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+	return n
+}
+
+// newGCM returns the AES-GCM cipher using a 32 bytes key.
+func newGCM(key []byte) cipher.AEAD {
+	// A 32 bytes key is always valid for AES-256 and the standard nonce size is always valid for GCM.
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	return gcm
+}
@@ -0,0 +1,135 @@
+package lansync_test
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/lansync"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/merge"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+func TestNewCode(t *testing.T) {
+	code, err := lansync.NewCode()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if !regexp.MustCompile(`^\d{4}-\d{4}$`).MatchString(code) {
+		t.Fatalf("Expected a code like 1234-5678, got [%s]", code)
+	}
+}
+
+func TestServePull(t *testing.T) {
+	lastSync := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	vault := merge.Snapshot{
+		Entries: []*model.PasswordEntry{
+			{ID: 7, UUID: "4f1c", Service: "gmail", Password: "secret123", Tags: []string{"email"},
+				ModifiedAt: lastSync.Add(time.Hour)},
+		},
+		Tombstones: []*model.Tombstone{{UUID: "9a2b", Service: "jira", DeletedAt: lastSync.Add(time.Hour)}},
+	}
+
+	tests := []struct {
+		name        string
+		serverCode  string
+		clientCode  string
+		expectedErr error
+	}{
+		{
+			name:       "pulls the changes with the right code",
+			serverCode: "1234-5678",
+			clientCode: "1234-5678",
+		},
+		{
+			name:       "ignores the separators typed in the code",
+			serverCode: "1234-5678",
+			clientCode: " 1234 5678",
+		},
+		{
+			name:        "fails with a wrong code",
+			serverCode:  "1234-5678",
+			clientCode:  "1234-5679",
+			expectedErr: lansync.ErrAuthentication,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			var since time.Time
+			done := make(chan error, 1)
+			go func() {
+				conn, acceptErr := listener.Accept()
+				if acceptErr != nil {
+					done <- acceptErr
+					return
+				}
+				defer conn.Close()
+				done <- lansync.Serve(conn, tt.serverCode, "server-device", func(t time.Time) (merge.Snapshot, error) {
+					since = t
+					return vault.Since(t), nil
+				})
+			}()
+
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			deviceID, changes, err := lansync.Pull(conn, tt.clientCode, func(string) (time.Time, error) {
+				return lastSync, nil
+			})
+			_ = conn.Close()
+			serveErr := <-done
+
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) || !errors.Is(serveErr, tt.expectedErr) {
+					t.Fatalf("Expected [%v] on both sides, got [%v] and [%v]", tt.expectedErr, err, serveErr)
+				}
+				return
+			}
+			if err != nil || serveErr != nil {
+				t.Fatalf("Expected no error, got [%v] and [%v]", err, serveErr)
+			}
+			if deviceID != "server-device" || !since.Equal(lastSync) {
+				t.Fatalf("Expected the changes since the last sync, got device [%s] and since [%v]", deviceID, since)
+			}
+			if len(changes.Entries) != 1 || len(changes.Tombstones) != 1 {
+				t.Fatalf("Expected an entry and a tombstone, got %+v", changes)
+			}
+			got := changes.Entries[0]
+			if got.UUID != "4f1c" || got.Password != "secret123" || got.ID != 0 || got.Tags[0] != "email" ||
+				!got.ModifiedAt.Equal(lastSync.Add(time.Hour)) {
+				t.Fatalf("Expected the entry with its UUID and without its ID, got %+v", got)
+			}
+			if changes.Tombstones[0].UUID != "9a2b" || changes.Tombstones[0].Service != "jira" {
+				t.Fatalf("Expected the tombstone, got %+v", changes.Tombstones[0])
+			}
+		})
+	}
+}
+
+func TestServe_InvalidClient(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		_, _ = client.Write([]byte(strings.Repeat("x", 58)))
+		_ = client.Close()
+	}()
+
+	err := lansync.Serve(server, "1234-5678", "server-device", func(time.Time) (merge.Snapshot, error) {
+		t.Fatal("Expected no changes to be served")
+		return merge.Snapshot{}, nil
+	})
+	if !errors.Is(err, lansync.ErrProtocol) {
+		t.Fatalf("Expected ErrProtocol, got [%v]", err)
+	}
+}
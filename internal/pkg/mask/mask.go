@@ -0,0 +1,84 @@
+// Package mask hides secrets from a stream of text, such as the output of a process the secrets were given to.
+package mask
+
+import (
+	"bytes"
+	"io"
+	"slices"
+)
+
+// Replacement replaces every occurrence of a secret.
+const Replacement = "******"
+
+// Writer replaces every occurrence of its secrets with Replacement before writing to the underlying writer.
+//
+// A secret may be split across several writes, so the end of a write that could be the beginning of a secret is held
+// back until the next write shows whether it is one. Close writes whatever is held back.
+type Writer struct {
+	w       io.Writer
+	secrets [][]byte
+	pending []byte
+}
+
+// NewWriter returns a Writer hiding secrets from w. Empty secrets are ignored.
+func NewWriter(w io.Writer, secrets []string) *Writer {
+	m := &Writer{w: w}
+	for _, secret := range secrets {
+		if secret != "" {
+			m.secrets = append(m.secrets, []byte(secret))
+		}
+	}
+	// The longest secret is masked first when secrets overlap.
+	slices.SortFunc(m.secrets, func(a, b []byte) int { return len(b) - len(a) })
+	return m
+}
+
+// Write masks the secrets in p and writes the result to the underlying writer.
+// It always reports len(p) bytes written, unless the underlying writer fails.
+func (m *Writer) Write(p []byte) (int, error) {
+	m.pending = append(m.pending, p...)
+	masked, rest := m.mask(m.pending)
+	m.pending = append(m.pending[:0], rest...)
+	if len(masked) == 0 {
+		return len(p), nil
+	}
+	if _, err := m.w.Write(masked); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes what is held back, which can't contain a whole secret. It doesn't close the underlying writer.
+func (m *Writer) Close() error {
+	if len(m.pending) == 0 {
+		return nil
+	}
+	_, err := m.w.Write(m.pending)
+	m.pending = nil
+	return err
+}
+
+// mask returns data with its secrets masked, up to the first byte that could begin a secret continued by the next
+// write, and the rest of data from that byte on.
+func (m *Writer) mask(data []byte) (masked, rest []byte) {
+	masked = make([]byte, 0, len(data))
+	i := 0
+scan:
+	for i < len(data) {
+		for _, secret := range m.secrets {
+			if bytes.HasPrefix(data[i:], secret) {
+				masked = append(masked, Replacement...)
+				i += len(secret)
+				continue scan
+			}
+		}
+		for _, secret := range m.secrets {
+			if bytes.HasPrefix(secret, data[i:]) {
+				break scan
+			}
+		}
+		masked = append(masked, data[i])
+		i++
+	}
+	return masked, data[i:]
+}
@@ -0,0 +1,46 @@
+package mask_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/mask"
+)
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		secrets []string
+		writes  []string
+	}{
+		{name: "no secret", secrets: nil, writes: []string{"hello\n"}, want: "hello\n"},
+		{name: "whole secret", secrets: []string{"s3cret"}, writes: []string{"pass=s3cret\n"},
+			want: "pass=" + mask.Replacement + "\n"},
+		{name: "secret split across writes", secrets: []string{"s3cret"}, writes: []string{"pass=s3", "cr", "et\n"},
+			want: "pass=" + mask.Replacement + "\n"},
+		{name: "prefix of a secret", secrets: []string{"s3cret"}, writes: []string{"pass=s3", "cr"},
+			want: "pass=s3cr"},
+		{name: "several secrets", secrets: []string{"abc", "", "xyz"}, writes: []string{"abc-xyz-abc"},
+			want: mask.Replacement + "-" + mask.Replacement + "-" + mask.Replacement},
+		{name: "overlapping secrets", secrets: []string{"key", "key-long"}, writes: []string{"key-long key"},
+			want: mask.Replacement + " " + mask.Replacement},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			w := mask.NewWriter(&out, tt.secrets)
+			for _, write := range tt.writes {
+				if n, err := w.Write([]byte(write)); err != nil || n != len(write) {
+					t.Fatalf("Expected %d bytes written, got %d [%v]", len(write), n, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if out.String() != tt.want {
+				t.Fatalf("Expected [%s], got [%s]", tt.want, out.String())
+			}
+		})
+	}
+}
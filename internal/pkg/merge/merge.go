@@ -0,0 +1,345 @@
+// Package merge merges the entries of two vaults, to synchronize vaults used on different devices.
+//
+// Entries are matched by UUID. The merge is a three-way merge: the common ancestor of both vaults is the state at
+// their last sync, and an entry changed since then if it was modified, or saved by merging a third vault, after the
+// last sync. Changes made in only one of the vaults are merged, while entries changed in both vaults, or changed in
+// one and deleted in the other, are reported as conflicts to be resolved by the user.
+package merge
+
+import (
+	"slices"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/otp"
+)
+
+// Snapshot is the state of a vault to merge.
+type Snapshot struct {
+	Entries    []*model.PasswordEntry
+	Tombstones []*model.Tombstone
+}
+
+// Since returns the entries and the tombstones changed after t, in this vault or by merging another one. Merging them
+// into a vault that last merged this one at t gives the same result as merging the whole snapshot.
+func (s Snapshot) Since(t time.Time) Snapshot {
+	var changes Snapshot
+	for _, entry := range s.Entries {
+		if changedAt(entry).After(t) {
+			changes.Entries = append(changes.Entries, entry)
+		}
+	}
+	for _, tombstone := range s.Tombstones {
+		if deletedAt(tombstone).After(t) {
+			changes.Tombstones = append(changes.Tombstones, tombstone)
+		}
+	}
+	return changes
+}
+
+// changedAt returns the last time entry changed in its vault: when it was modified there, or when it was saved by
+// merging another vault, which may have relayed a change older than the last sync from a third vault.
+func changedAt(entry *model.PasswordEntry) time.Time {
+	if entry.SyncedAt.After(entry.ModifiedAt) {
+		return entry.SyncedAt
+	}
+	return entry.ModifiedAt
+}
+
+// deletedAt returns the last time the entry of tombstone was deleted from its vault, either there or by merging
+// another vault.
+func deletedAt(tombstone *model.Tombstone) time.Time {
+	if tombstone.SyncedAt.After(tombstone.DeletedAt) {
+		return tombstone.SyncedAt
+	}
+	return tombstone.DeletedAt
+}
+
+// Reason explains why a Conflict can't be merged automatically.
+type Reason int
+
+const (
+	// ReasonModified marks an entry modified in both vaults.
+	ReasonModified Reason = iota
+	// ReasonDeletedLocally marks an entry deleted in the local vault and modified in the other vault.
+	ReasonDeletedLocally
+	// ReasonDeletedInOther marks an entry modified in the local vault and deleted in the other vault.
+	ReasonDeletedInOther
+	// ReasonServiceTaken marks different entries with the same service in the two vaults.
+	ReasonServiceTaken
+)
+
+// String implements the fmt.Stringer interface.
+func (r Reason) String() string {
+	switch r {
+	case ReasonModified:
+		return "modified in both vaults"
+	case ReasonDeletedLocally:
+		return "deleted in this vault and modified in the other vault"
+	case ReasonDeletedInOther:
+		return "modified in this vault and deleted in the other vault"
+	case ReasonServiceTaken:
+		return "different entries with the same service in both vaults"
+	default:
+		return "unknown conflict"
+	}
+}
+
+// Conflict is a change that can't be merged automatically.
+type Conflict struct {
+	// Local is the entry of the local vault, nil if it was deleted.
+	Local *model.PasswordEntry
+	// Other is the entry of the other vault, nil if it was deleted.
+	Other *model.PasswordEntry
+	// Service is the service of the conflicting entries.
+	Service string
+	// keepOther is the outcome of resolving the conflict with the other vault version.
+	keepOther Result
+	// keepLocal is the outcome of resolving the conflict with the local version.
+	keepLocal Result
+	Reason    Reason
+}
+
+// Result is the outcome of a merge, to be applied to the local vault.
+type Result struct {
+	// Save lists the entries of the other vault to save in the local vault, replacing the entries with the same UUID.
+	Save []*model.PasswordEntry
+	// Delete lists the tombstones to record in the local vault, deleting the entries with the same UUID.
+	Delete []*model.Tombstone
+	// Conflicts lists the changes that must be resolved, see Resolve.
+	Conflicts []*Conflict
+}
+
+// Resolve resolves conflict by keeping either the version of the other vault or the local one.
+func (r *Result) Resolve(conflict *Conflict, keepOther bool) {
+	outcome := conflict.keepLocal
+	if keepOther {
+		outcome = conflict.keepOther
+	}
+	r.Save = append(r.Save, outcome.Save...)
+	r.Delete = append(r.Delete, outcome.Delete...)
+}
+
+// Vaults merges the other vault into the local one. lastSync is the last time the other vault was merged,
+// the zero time if it never was. Entries with the same HOTP secret keep the highest counter of the two vaults.
+func Vaults(local, other Snapshot, lastSync time.Time) *Result {
+	m := &merger{
+		result:   &Result{},
+		lastSync: lastSync,
+		now:      time.Now().UTC(),
+		local:    byUUID(local.Entries),
+		services: map[string]*model.PasswordEntry{},
+		deleted:  map[string]*model.Tombstone{},
+	}
+	for _, entry := range local.Entries {
+		m.services[entry.Service] = entry
+	}
+	for _, tombstone := range local.Tombstones {
+		m.deleted[tombstone.UUID] = tombstone
+	}
+
+	otherEntries := byUUID(other.Entries)
+	for _, tombstone := range other.Tombstones {
+		if otherEntries[tombstone.UUID] == nil {
+			m.mergeDeletion(tombstone)
+		}
+	}
+	for _, entry := range other.Entries {
+		m.mergeEntry(entry)
+	}
+	return m.result
+}
+
+// merger holds the state of a merge.
+type merger struct {
+	lastSync time.Time
+	now      time.Time
+	result   *Result
+	// local indexes the local entries by UUID.
+	local map[string]*model.PasswordEntry
+	// services indexes the local entries by service.
+	services map[string]*model.PasswordEntry
+	// deleted indexes the local tombstones by UUID.
+	deleted map[string]*model.Tombstone
+}
+
+// mergeDeletion merges an entry deleted in the other vault.
+func (m *merger) mergeDeletion(tombstone *model.Tombstone) {
+	entry := m.local[tombstone.UUID]
+	switch {
+	case entry == nil:
+		// Keep track of the deletion, so that it is propagated to the vaults merging this one.
+		if m.deleted[tombstone.UUID] == nil {
+			m.result.Delete = append(m.result.Delete, tombstone)
+		}
+	case !m.lastSync.IsZero() && !deletedAt(tombstone).After(m.lastSync):
+		// The deletion was already merged and the entry was kept.
+	case m.changed(entry, tombstone.DeletedAt):
+		m.conflict(&Conflict{
+			Local:     entry,
+			Service:   entry.Service,
+			Reason:    ReasonDeletedInOther,
+			keepOther: Result{Delete: []*model.Tombstone{tombstone}},
+		})
+	default:
+		m.deleteEntry(entry, tombstone)
+	}
+}
+
+// mergeEntry merges an entry of the other vault.
+func (m *merger) mergeEntry(entry *model.PasswordEntry) {
+	if existing := m.local[entry.UUID]; existing != nil {
+		m.mergeExisting(existing, entry)
+		return
+	}
+
+	if tombstone := m.deleted[entry.UUID]; tombstone != nil {
+		if m.changed(entry, tombstone.DeletedAt) {
+			m.conflict(&Conflict{
+				Other:     entry,
+				Service:   entry.Service,
+				Reason:    ReasonDeletedLocally,
+				keepOther: Result{Save: []*model.PasswordEntry{entry}},
+			})
+		}
+		return
+	}
+
+	m.save(entry)
+}
+
+// mergeExisting merges an entry of the other vault with the local entry with the same UUID.
+//
+// Both entries keep the highest counter of their HOTP secret, whichever version is kept: generating a code increments
+// the counter, and a counter going back would generate codes already used.
+func (m *merger) mergeExisting(local, other *model.PasswordEntry) {
+	raisedLocal := raiseCounter(local, other)
+	other = raiseCounter(other, local)
+	if sameContent(raisedLocal, other) {
+		if raisedLocal != local {
+			// Only the counter differs, more codes were generated in the other vault.
+			m.save(other)
+		}
+		return
+	}
+	var keepLocal Result
+	if raisedLocal != local {
+		keepLocal.Save = []*model.PasswordEntry{raisedLocal}
+	}
+	localChanged, otherChanged := changedAt(local).After(m.lastSync), changedAt(other).After(m.lastSync)
+	switch {
+	case localChanged && otherChanged:
+		m.conflict(&Conflict{
+			Local:     local,
+			Other:     other,
+			Service:   local.Service,
+			Reason:    ReasonModified,
+			keepOther: Result{Save: []*model.PasswordEntry{other}},
+			keepLocal: keepLocal,
+		})
+	case otherChanged:
+		m.save(other)
+	default:
+		// Only the local entry changed, or the difference was resolved by keeping it at the last sync.
+		m.result.Save = append(m.result.Save, keepLocal.Save...)
+	}
+}
+
+// raiseCounter returns a copy of entry with the counter of its HOTP secret raised to the counter of other, if both
+// have the same HOTP secret and the counter of other is higher. Otherwise, entry itself is returned.
+func raiseCounter(entry, other *model.PasswordEntry) *model.PasswordEntry {
+	key, err := otp.Parse(entry.OTP)
+	if err != nil || key.Type != otp.TypeHOTP {
+		return entry
+	}
+	otherKey, err := otp.Parse(other.OTP)
+	if err != nil || otherKey.Counter <= key.Counter {
+		return entry
+	}
+	counter := otherKey.Counter
+	if otherKey.Counter = key.Counter; otherKey.URI() != key.URI() {
+		return entry
+	}
+	key.Counter = counter
+	raised := *entry
+	raised.OTP = key.URI()
+	return &raised
+}
+
+// save saves an entry of the other vault, unless its service is taken by a different local entry.
+func (m *merger) save(entry *model.PasswordEntry) {
+	existing := m.services[entry.Service]
+	if existing == nil || existing.UUID == entry.UUID {
+		m.result.Save = append(m.result.Save, entry)
+		return
+	}
+
+	// Either entry is deleted, so that the same entry isn't duplicated when the vaults are merged again.
+	keepLocal := Result{Delete: []*model.Tombstone{m.tombstone(entry)}}
+	if sameContent(existing, entry) {
+		// Identical entries created in each vault are merged by keeping the local one.
+		m.result.Delete = append(m.result.Delete, keepLocal.Delete...)
+		return
+	}
+	m.conflict(&Conflict{
+		Local:   existing,
+		Other:   entry,
+		Service: entry.Service,
+		Reason:  ReasonServiceTaken,
+		keepOther: Result{
+			Save:   []*model.PasswordEntry{entry},
+			Delete: []*model.Tombstone{m.tombstone(existing)},
+		},
+		keepLocal: keepLocal,
+	})
+}
+
+// deleteEntry deletes a local entry deleted in the other vault.
+func (m *merger) deleteEntry(entry *model.PasswordEntry, tombstone *model.Tombstone) {
+	m.result.Delete = append(m.result.Delete, tombstone)
+	if m.services[entry.Service] == entry {
+		delete(m.services, entry.Service)
+	}
+}
+
+// conflict reports a conflict.
+func (m *merger) conflict(conflict *Conflict) {
+	m.result.Conflicts = append(m.result.Conflicts, conflict)
+}
+
+// changed reports whether entry changed since the last sync, see changedAt. Without a last sync, an entry changed if
+// it was modified after since.
+func (m *merger) changed(entry *model.PasswordEntry, since time.Time) bool {
+	if m.lastSync.IsZero() {
+		return entry.ModifiedAt.After(since)
+	}
+	return changedAt(entry).After(m.lastSync)
+}
+
+// tombstone returns a tombstone deleting entry now.
+func (m *merger) tombstone(entry *model.PasswordEntry) *model.Tombstone {
+	return &model.Tombstone{UUID: entry.UUID, Service: entry.Service, DeletedAt: m.now}
+}
+
+// byUUID indexes entries by UUID.
+func byUUID(entries []*model.PasswordEntry) map[string]*model.PasswordEntry {
+	index := make(map[string]*model.PasswordEntry, len(entries))
+	for _, entry := range entries {
+		index[entry.UUID] = entry
+	}
+	return index
+}
+
+// sameContent reports whether two entries have the same service, kind and fields, regardless of their timestamps.
+// Tags are compared regardless of order, custom fields in order.
+func sameContent(a, b *model.PasswordEntry) bool {
+	if a.Service != b.Service || a.Username != b.Username || a.Password != b.Password || a.URL != b.URL ||
+		a.Notes != b.Notes || a.Kind != b.Kind ||
+		a.OTP != b.OTP || !slices.Equal(a.Fields, b.Fields) {
+		return false
+	}
+	tagsA, tagsB := slices.Clone(a.Tags), slices.Clone(b.Tags)
+	slices.Sort(tagsA)
+	slices.Sort(tagsB)
+	return slices.Equal(slices.Compact(tagsA), slices.Compact(tagsB))
+}
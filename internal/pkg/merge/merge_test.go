@@ -0,0 +1,453 @@
+package merge_test
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/merge"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+var (
+	before   = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	lastSync = time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	after    = time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	later    = time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// entry returns an entry with the given UUID, service, password and modification time.
+func entry(uuid, service, password string, modifiedAt time.Time) *model.PasswordEntry {
+	return &model.PasswordEntry{UUID: uuid, Service: service, Password: password, ModifiedAt: modifiedAt}
+}
+
+// tombstone returns a tombstone of the entry with the given UUID and service.
+func tombstone(uuid, service string, deletedAt time.Time) *model.Tombstone {
+	return &model.Tombstone{UUID: uuid, Service: service, DeletedAt: deletedAt}
+}
+
+func TestVaults(t *testing.T) {
+	tests := []struct {
+		lastSync      time.Time
+		name          string
+		local         merge.Snapshot
+		other         merge.Snapshot
+		wantSave      []string
+		wantDelete    []string
+		wantConflicts []merge.Reason
+	}{
+		{
+			name:     "adds new entries",
+			lastSync: lastSync,
+			local:    merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", before)}},
+			other: merge.Snapshot{Entries: []*model.PasswordEntry{
+				entry("1", "gmail", "g", before),
+				entry("2", "github", "gh", after),
+			}},
+			wantSave: []string{"2"},
+		},
+		{
+			name:     "takes entries modified only in the other vault",
+			lastSync: lastSync,
+			local:    merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "old", before)}},
+			other:    merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "new", after)}},
+			wantSave: []string{"1"},
+		},
+		{
+			name:     "keeps entries modified only in the local vault",
+			lastSync: lastSync,
+			local:    merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "new", after)}},
+			other:    merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "old", before)}},
+		},
+		{
+			name:          "reports entries modified in both vaults",
+			lastSync:      lastSync,
+			local:         merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "local", after)}},
+			other:         merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "other", later)}},
+			wantConflicts: []merge.Reason{merge.ReasonModified},
+		},
+		{
+			name:          "reports different entries on the first sync",
+			local:         merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "local", before)}},
+			other:         merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "other", before)}},
+			wantConflicts: []merge.Reason{merge.ReasonModified},
+		},
+		{
+			name:       "deletes entries deleted in the other vault",
+			lastSync:   lastSync,
+			local:      merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", before)}},
+			other:      merge.Snapshot{Tombstones: []*model.Tombstone{tombstone("1", "gmail", after)}},
+			wantDelete: []string{"1"},
+		},
+		{
+			name:       "deletes entries deleted in the other vault on the first sync",
+			local:      merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", before)}},
+			other:      merge.Snapshot{Tombstones: []*model.Tombstone{tombstone("1", "gmail", after)}},
+			wantDelete: []string{"1"},
+		},
+		{
+			name:          "reports entries modified locally and deleted in the other vault",
+			lastSync:      lastSync,
+			local:         merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", later)}},
+			other:         merge.Snapshot{Tombstones: []*model.Tombstone{tombstone("1", "gmail", after)}},
+			wantConflicts: []merge.Reason{merge.ReasonDeletedInOther},
+		},
+		{
+			name:     "keeps entries whose deletion was already merged",
+			lastSync: lastSync,
+			local:    merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", after)}},
+			other:    merge.Snapshot{Tombstones: []*model.Tombstone{tombstone("1", "gmail", before)}},
+		},
+		{
+			name:     "keeps entries deleted locally",
+			lastSync: lastSync,
+			local:    merge.Snapshot{Tombstones: []*model.Tombstone{tombstone("1", "gmail", after)}},
+			other:    merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", before)}},
+		},
+		{
+			name:          "reports entries deleted locally and modified in the other vault",
+			lastSync:      lastSync,
+			local:         merge.Snapshot{Tombstones: []*model.Tombstone{tombstone("1", "gmail", after)}},
+			other:         merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", later)}},
+			wantConflicts: []merge.Reason{merge.ReasonDeletedLocally},
+		},
+		{
+			name:     "records the deletions of entries unknown to the local vault",
+			lastSync: lastSync,
+			local:    merge.Snapshot{Tombstones: []*model.Tombstone{tombstone("1", "gmail", after)}},
+			other: merge.Snapshot{Tombstones: []*model.Tombstone{
+				tombstone("1", "gmail", after),
+				tombstone("2", "github", after),
+			}},
+			wantDelete: []string{"2"},
+		},
+		{
+			name:     "replaces entries deleted and recreated with the same service in the other vault",
+			lastSync: lastSync,
+			local:    merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "old", before)}},
+			other: merge.Snapshot{
+				Entries:    []*model.PasswordEntry{entry("2", "gmail", "new", after)},
+				Tombstones: []*model.Tombstone{tombstone("1", "gmail", after)},
+			},
+			wantSave:   []string{"2"},
+			wantDelete: []string{"1"},
+		},
+		{
+			name:       "merges identical entries created in both vaults",
+			local:      merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", before)}},
+			other:      merge.Snapshot{Entries: []*model.PasswordEntry{entry("2", "gmail", "g", after)}},
+			wantDelete: []string{"2"},
+		},
+		{
+			name:          "reports different entries created with the same service in both vaults",
+			local:         merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "local", before)}},
+			other:         merge.Snapshot{Entries: []*model.PasswordEntry{entry("2", "gmail", "other", after)}},
+			wantConflicts: []merge.Reason{merge.ReasonServiceTaken},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := merge.Vaults(tt.local, tt.other, tt.lastSync)
+
+			var save, deleted []string
+			for _, e := range result.Save {
+				save = append(save, e.UUID)
+			}
+			for _, tombstone := range result.Delete {
+				deleted = append(deleted, tombstone.UUID)
+			}
+			var conflicts []merge.Reason
+			for _, conflict := range result.Conflicts {
+				conflicts = append(conflicts, conflict.Reason)
+			}
+			if !reflect.DeepEqual(save, tt.wantSave) {
+				t.Fatalf("Expected to save %v, got %v", tt.wantSave, save)
+			}
+			if !reflect.DeepEqual(deleted, tt.wantDelete) {
+				t.Fatalf("Expected to delete %v, got %v", tt.wantDelete, deleted)
+			}
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Fatalf("Expected conflicts %v, got %v", tt.wantConflicts, conflicts)
+			}
+		})
+	}
+}
+
+func TestResult_Resolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		local      merge.Snapshot
+		other      merge.Snapshot
+		keepOther  bool
+		wantSave   []string
+		wantDelete []string
+	}{
+		{
+			name:      "keeps the other version of an entry modified in both vaults",
+			local:     merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "local", after)}},
+			other:     merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "other", later)}},
+			wantSave:  []string{"1"},
+			keepOther: true,
+		},
+		{
+			name:  "keeps the local version of an entry modified in both vaults",
+			local: merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "local", after)}},
+			other: merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "other", later)}},
+		},
+		{
+			name:       "deletes an entry modified locally and deleted in the other vault",
+			local:      merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", later)}},
+			other:      merge.Snapshot{Tombstones: []*model.Tombstone{tombstone("1", "gmail", after)}},
+			wantDelete: []string{"1"},
+			keepOther:  true,
+		},
+		{
+			name:      "restores an entry deleted locally and modified in the other vault",
+			local:     merge.Snapshot{Tombstones: []*model.Tombstone{tombstone("1", "gmail", after)}},
+			other:     merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "g", later)}},
+			wantSave:  []string{"1"},
+			keepOther: true,
+		},
+		{
+			name:       "replaces a local entry with the same service",
+			local:      merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "local", before)}},
+			other:      merge.Snapshot{Entries: []*model.PasswordEntry{entry("2", "gmail", "other", after)}},
+			wantSave:   []string{"2"},
+			wantDelete: []string{"1"},
+			keepOther:  true,
+		},
+		{
+			name:       "discards an entry of the other vault with the same service",
+			local:      merge.Snapshot{Entries: []*model.PasswordEntry{entry("1", "gmail", "local", before)}},
+			other:      merge.Snapshot{Entries: []*model.PasswordEntry{entry("2", "gmail", "other", after)}},
+			wantDelete: []string{"2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := merge.Vaults(tt.local, tt.other, lastSync)
+			if len(result.Conflicts) != 1 {
+				t.Fatalf("Expected a conflict, got %d", len(result.Conflicts))
+			}
+			result.Resolve(result.Conflicts[0], tt.keepOther)
+
+			var save, deleted []string
+			for _, e := range result.Save {
+				save = append(save, e.UUID)
+			}
+			for _, tombstone := range result.Delete {
+				deleted = append(deleted, tombstone.UUID)
+			}
+			if !reflect.DeepEqual(save, tt.wantSave) {
+				t.Fatalf("Expected to save %v, got %v", tt.wantSave, save)
+			}
+			if !reflect.DeepEqual(deleted, tt.wantDelete) {
+				t.Fatalf("Expected to delete %v, got %v", tt.wantDelete, deleted)
+			}
+		})
+	}
+}
+
+func TestSnapshot_Since(t *testing.T) {
+	local := merge.Snapshot{
+		Entries: []*model.PasswordEntry{
+			entry("1", "gmail", "local", before),
+			entry("2", "github", "local", after),
+			entry("3", "jira", "j", before),
+		},
+		// Recorded when the other vault was last merged.
+		Tombstones: []*model.Tombstone{tombstone("5", "asana", before)},
+	}
+	other := merge.Snapshot{
+		Entries: []*model.PasswordEntry{
+			entry("1", "gmail", "other", after),
+			entry("2", "github", "other", before),
+			entry("4", "slack", "s", after),
+		},
+		Tombstones: []*model.Tombstone{tombstone("3", "jira", after), tombstone("5", "asana", before)},
+	}
+
+	changes := other.Since(lastSync)
+	if len(changes.Entries) != 2 || changes.Entries[0].UUID != "1" || changes.Entries[1].UUID != "4" {
+		t.Fatalf("Expected the entries modified since the last sync, got %+v", changes.Entries)
+	}
+	if len(changes.Tombstones) != 1 || changes.Tombstones[0].UUID != "3" {
+		t.Fatalf("Expected the tombstones recorded since the last sync, got %+v", changes.Tombstones)
+	}
+
+	if full, delta := merge.Vaults(local, other, lastSync), merge.Vaults(local, changes, lastSync); !reflect.DeepEqual(
+		full, delta) {
+		t.Fatalf("Expected merging the changes to match merging the whole snapshot, got %+v and %+v", full, delta)
+	}
+}
+
+// apply applies the outcome of a merge to s at the given time, stamping the saved entries and tombstones as
+// vault.Manager.ApplySync does.
+func apply(s *merge.Snapshot, result *merge.Result, at time.Time) {
+	for _, deleted := range result.Delete {
+		s.Entries = slices.DeleteFunc(s.Entries, func(e *model.PasswordEntry) bool { return e.UUID == deleted.UUID })
+		stamped := *deleted
+		stamped.SyncedAt = at
+		s.Tombstones = append(s.Tombstones, &stamped)
+	}
+	for _, saved := range result.Save {
+		s.Entries = slices.DeleteFunc(s.Entries, func(e *model.PasswordEntry) bool { return e.UUID == saved.UUID })
+		stamped := *saved
+		stamped.SyncedAt = at
+		s.Entries = append(s.Entries, &stamped)
+	}
+}
+
+func TestVaults_ThreeVaults(t *testing.T) {
+	// B changes the entries before C last merges A, but A only merges B afterward: the changes reach C through A
+	// even though they are older than the last sync of C with A.
+	edited := lastSync.Add(-24 * time.Hour)
+	tests := []struct {
+		name          string
+		localEdit     func(c *merge.Snapshot)
+		wantSave      []string
+		wantDelete    []string
+		wantConflicts []merge.Reason
+	}{
+		{
+			name:       "merges the changes relayed by the other vault",
+			wantSave:   []string{"1"},
+			wantDelete: []string{"2"},
+		},
+		{
+			name: "reports a conflict with a local change",
+			localEdit: func(c *merge.Snapshot) {
+				c.Entries[0] = entry("1", "gmail", "c", after)
+			},
+			wantDelete:    []string{"2"},
+			wantConflicts: []merge.Reason{merge.ReasonModified},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vaults := make([]merge.Snapshot, 3)
+			for i := range vaults {
+				vaults[i] = merge.Snapshot{Entries: []*model.PasswordEntry{
+					entry("1", "gmail", "g", before),
+					entry("2", "jira", "j", before),
+				}}
+			}
+			a, b, c := &vaults[0], &vaults[1], &vaults[2]
+			b.Entries = []*model.PasswordEntry{entry("1", "gmail", "b", edited)}
+			b.Tombstones = []*model.Tombstone{tombstone("2", "jira", edited)}
+			if tt.localEdit != nil {
+				tt.localEdit(c)
+			}
+
+			// A merges B, last merged when the vaults were created.
+			apply(a, merge.Vaults(*a, *b, before), after)
+			if len(a.Entries) != 1 || a.Entries[0].Password != "b" || len(a.Tombstones) != 1 {
+				t.Fatalf("Expected A to take the changes of B, got %+v and %+v", a.Entries, a.Tombstones)
+			}
+
+			result := merge.Vaults(*c, *a, lastSync)
+			if full, delta := result, merge.Vaults(*c, a.Since(lastSync), lastSync); !reflect.DeepEqual(full, delta) {
+				t.Fatalf("Expected merging the changes to match merging the whole snapshot, got %+v and %+v", full,
+					delta)
+			}
+
+			var save, deleted []string
+			for _, e := range result.Save {
+				save = append(save, e.UUID)
+			}
+			for _, tombstone := range result.Delete {
+				deleted = append(deleted, tombstone.UUID)
+			}
+			var conflicts []merge.Reason
+			for _, conflict := range result.Conflicts {
+				conflicts = append(conflicts, conflict.Reason)
+			}
+			if !reflect.DeepEqual(save, tt.wantSave) {
+				t.Fatalf("Expected to save %v, got %v", tt.wantSave, save)
+			}
+			if !reflect.DeepEqual(deleted, tt.wantDelete) {
+				t.Fatalf("Expected to delete %v, got %v", tt.wantDelete, deleted)
+			}
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Fatalf("Expected conflicts %v, got %v", tt.wantConflicts, conflicts)
+			}
+		})
+	}
+}
+
+func TestVaults_HOTPCounter(t *testing.T) {
+	// withHOTP returns an entry using the HOTP secret of the entries at counter.
+	withHOTP := func(e *model.PasswordEntry, counter int) *model.PasswordEntry {
+		e.OTP = fmt.Sprintf("otpauth://hotp/Example:dev?counter=%d&secret=JBSWY3DPEHPK3PXP", counter)
+		return e
+	}
+	tests := []struct {
+		name          string
+		local         *model.PasswordEntry
+		other         *model.PasswordEntry
+		keepOther     bool
+		wantCounter   string
+		wantConflicts int
+	}{
+		{
+			name:        "takes the higher counter of the other vault",
+			local:       withHOTP(entry("1", "github", "g", after), 3),
+			other:       withHOTP(entry("1", "github", "g", later), 5),
+			wantCounter: "counter=5",
+		},
+		{
+			name:  "keeps the higher local counter",
+			local: withHOTP(entry("1", "github", "g", later), 5),
+			other: withHOTP(entry("1", "github", "g", after), 3),
+		},
+		{
+			name:        "raises the counter of an entry changed only locally",
+			local:       withHOTP(entry("1", "github", "new", after), 3),
+			other:       withHOTP(entry("1", "github", "g", before), 5),
+			wantCounter: "counter=5",
+		},
+		{
+			name:          "raises the counter of the other version kept in a conflict",
+			local:         withHOTP(entry("1", "github", "local", later), 5),
+			other:         withHOTP(entry("1", "github", "other", after), 3),
+			keepOther:     true,
+			wantCounter:   "counter=5",
+			wantConflicts: 1,
+		},
+		{
+			name:          "raises the counter of the local version kept in a conflict",
+			local:         withHOTP(entry("1", "github", "local", after), 3),
+			other:         withHOTP(entry("1", "github", "other", later), 5),
+			wantCounter:   "counter=5",
+			wantConflicts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := merge.Vaults(merge.Snapshot{Entries: []*model.PasswordEntry{tt.local}},
+				merge.Snapshot{Entries: []*model.PasswordEntry{tt.other}}, lastSync)
+			if len(result.Conflicts) != tt.wantConflicts {
+				t.Fatalf("Expected %d conflicts, got %d", tt.wantConflicts, len(result.Conflicts))
+			}
+			for _, conflict := range result.Conflicts {
+				result.Resolve(conflict, tt.keepOther)
+			}
+			if tt.wantCounter == "" {
+				if len(result.Save) != 0 {
+					t.Fatalf("Expected nothing to save, got %+v", result.Save)
+				}
+				return
+			}
+			if len(result.Save) != 1 || !strings.Contains(result.Save[0].OTP, tt.wantCounter) {
+				t.Fatalf("Expected to save the entry with %s, got %+v", tt.wantCounter, result.Save)
+			}
+		})
+	}
+}
@@ -8,19 +8,128 @@ type PasswordEntry struct {
 	CreatedAt  time.Time `json:"created_at"`
 	ModifiedAt time.Time `json:"modified_at"`
 	LastUsedAt time.Time `json:"last_used_at"`
-	Service    string    `json:"service"`
-	Username   string    `json:"username"`
-	Password   string    `json:"password"`
-	URL        string    `json:"url"`
-	Notes      string    `json:"notes"`
-	Tags       []string  `json:"tags"`
-	ID         int64     `json:"id"`
+	// SyncedAt is the last time the entry was saved by merging another vault, the zero time if it never was.
+	// It is local to each vault and not exported.
+	SyncedAt time.Time `json:"-"`
+	// UUID identifies the entry across the vaults it is synchronized with, even if its service changes.
+	// It is not exported, imported entries get a new one.
+	UUID    string `json:"-"`
+	Service string `json:"service"`
+	// Kind is the kind of the entry, empty for logins.
+	Kind     string `json:"kind,omitempty"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	URL      string `json:"url"`
+	Notes    string `json:"notes"`
+	// OTP is the otpauth:// URI of the TOTP or HOTP secret of the entry, if any.
+	OTP  string   `json:"otp,omitempty"`
+	Tags []string `json:"tags"`
+	// Fields are the custom fields of the entry, in order.
+	Fields []EntryField `json:"fields,omitempty"`
+	ID     int64        `json:"id"`
+}
+
+// Types of the custom fields of entries.
+const (
+	// FieldTypeText is a field shown in plain text.
+	FieldTypeText = "text"
+	// FieldTypeHidden is a field only shown when explicitly requested, such as a PIN.
+	FieldTypeHidden = "hidden"
+	// FieldTypeURL is a field holding an absolute URL.
+	FieldTypeURL = "url"
+	// FieldTypeEmail is a field holding an email address.
+	FieldTypeEmail = "email"
+	// FieldTypeDate is a field holding a date, formatted as YYYY-MM-DD.
+	FieldTypeDate = "date"
+	// FieldTypeTOTP is a field holding the otpauth:// URI of a TOTP secret, whose current code is read.
+	FieldTypeTOTP = "totp"
+)
+
+// EntryField is a custom field of a password entry, such as a security question or an account number.
+type EntryField struct {
+	Name string `json:"name"`
+	// Type is one of the FieldType constants.
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Kinds of password entries.
+const (
+	// KindSSHKey is an entry whose password is an unencrypted SSH private key, served by psst ssh-agent.
+	KindSSHKey = "ssh-key"
+	// KindNote is a secure note, whose body is a hidden field of the entry.
+	KindNote = "note"
+	// KindCard is a payment card, whose number, expiry and security code are fields of the entry.
+	KindCard = "card"
+	// KindIdentity is an identity, whose name and address are fields of the entry.
+	KindIdentity = "identity"
+	// KindWiFi is a Wi-Fi network, whose SSID and security are fields of the entry and whose password is the
+	// password of the network.
+	KindWiFi = "wifi"
+)
+
+// Tombstone records the deletion of a password entry, so that it can be propagated when merging vaults.
+type Tombstone struct {
+	DeletedAt time.Time `json:"deleted_at"`
+	// SyncedAt is the last time the tombstone was recorded by merging another vault, the zero time if it never was.
+	SyncedAt time.Time `json:"synced_at"`
+	UUID     string    `json:"uuid"`
+	Service  string    `json:"service"`
+}
+
+// Kinds of vault members.
+const (
+	// MemberPassword is a member unlocking the vault with their own password.
+	MemberPassword = "password"
+	// MemberIdentity is a member unlocking the vault with the identity key of their own vault.
+	MemberIdentity = "identity"
+)
+
+// Attachment is a file attached to a password entry, stored encrypted in chunks.
+type Attachment struct {
+	CreatedAt time.Time
+	// Name identifies the attachment among those of its entry, usually the name of the attached file.
+	Name string
+	// Key is the key encrypting the chunks of the attachment, itself encrypted with the vault data key, so that
+	// rotating the data key doesn't re-encrypt the chunks.
+	Key     string
+	ID      int64
+	EntryID int64
+	// Size is the size of the attached file, in bytes.
+	Size   int64
+	Chunks int
+}
+
+// VaultMember is someone able to unlock a vault. Every member has an X25519 keypair the vault data key is
+// wrapped for, so that the data key can be rotated without knowing the password of any member.
+type VaultMember struct {
+	AddedAt time.Time
+	Name    string
+	// Kind is either MemberPassword or MemberIdentity.
+	Kind string
+	// PublicKey is the X25519 public key of the member, encoded as text.
+	PublicKey string
+	// Salt is the salt deriving the key that seals the private key of a password member, hex encoded.
+	Salt string
+	// PrivateKey is the private key of a password member, sealed with a key derived from their password.
+	PrivateKey string
+	// WrappedKey is the vault data key, sealed for the member public key by the vault identity.
+	WrappedKey string
 }
 
 // VaultMetadata represents vault metadata.
 type VaultMetadata struct {
+	// MasterHash is the hash of the principal password of vaults created before version 0.0.7,
+	// which are unlocked by their members since.
 	MasterHash string
 	CreatedAt  time.Time
 	LastAccess time.Time
 	Version    string
+	VaultID    string
+	// DeviceID identifies the vault file among the copies of the vault synchronized across devices,
+	// which share the same VaultID.
+	DeviceID string
+	// IdentityKey is the encrypted X25519 private key identifying the vault when sharing entries.
+	IdentityKey string
+	MAC         string
 }
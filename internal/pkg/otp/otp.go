@@ -0,0 +1,209 @@
+// Package otp generates the one-time passwords of HOTP (RFC 4226) and TOTP (RFC 6238) secrets, as described by the
+// otpauth:// URIs of authenticator apps, see https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // HMAC-SHA1 is the default OTP algorithm, required by RFC 4226.
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of OTP secrets.
+const (
+	// TypeTOTP is a time-based secret, whose codes change every period.
+	TypeTOTP = "totp"
+	// TypeHOTP is a counter-based secret, whose counter is incremented for every code.
+	TypeHOTP = "hotp"
+)
+
+// Algorithms computing the HMAC of OTP codes.
+const (
+	AlgorithmSHA1   = "SHA1"
+	AlgorithmSHA256 = "SHA256"
+	AlgorithmSHA512 = "SHA512"
+)
+
+const (
+	// Scheme prefixes every OTP URI.
+	Scheme = "otpauth://"
+	// DefaultDigits is the number of digits of codes, unless the URI sets it.
+	DefaultDigits = 6
+	// DefaultPeriod is the number of seconds a TOTP code is valid for, unless the URI sets it.
+	DefaultPeriod = 30
+)
+
+// ErrInvalidURI is returned when an OTP URI is malformed.
+var ErrInvalidURI = errors.New("invalid otpauth URI")
+
+// secretEncoding decodes the base32 secrets of URIs, whose padding is optional.
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Key is an OTP secret and the parameters of its codes.
+type Key struct {
+	// Label identifies the account of the key, usually as "Issuer:account".
+	Label  string
+	Issuer string
+	// Type is either TypeTOTP or TypeHOTP.
+	Type string
+	// Algorithm is one of AlgorithmSHA1, AlgorithmSHA256 or AlgorithmSHA512.
+	Algorithm string
+	Secret    []byte
+	// Counter is the counter of the next HOTP code.
+	Counter uint64
+	Digits  int
+	// Period is the validity of TOTP codes.
+	Period time.Duration
+}
+
+// Parse parses an otpauth:// URI.
+func Parse(uri string) (*Key, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURI, err)
+	}
+	if u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("%w: missing %s scheme", ErrInvalidURI, Scheme)
+	}
+	key := &Key{
+		Type:      strings.ToLower(u.Host),
+		Label:     strings.TrimPrefix(u.Path, "/"),
+		Algorithm: AlgorithmSHA1,
+		Digits:    DefaultDigits,
+		Period:    DefaultPeriod * time.Second,
+	}
+	if key.Type != TypeTOTP && key.Type != TypeHOTP {
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidURI, u.Host)
+	}
+
+	query := u.Query()
+	key.Issuer = query.Get("issuer")
+	if key.Secret, err = DecodeSecret(query.Get("secret")); err != nil {
+		return nil, err
+	}
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		key.Algorithm = strings.ToUpper(algorithm)
+		if newHash(key.Algorithm) == nil {
+			return nil, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidURI, algorithm)
+		}
+	}
+	if digits := query.Get("digits"); digits != "" {
+		if key.Digits, err = strconv.Atoi(digits); err != nil || key.Digits < 6 || key.Digits > 10 {
+			return nil, fmt.Errorf("%w: digits must be between 6 and 10, got %q", ErrInvalidURI, digits)
+		}
+	}
+	if period := query.Get("period"); period != "" {
+		seconds, atoiErr := strconv.Atoi(period)
+		if atoiErr != nil || seconds <= 0 {
+			return nil, fmt.Errorf("%w: invalid period %q", ErrInvalidURI, period)
+		}
+		key.Period = time.Duration(seconds) * time.Second
+	}
+	if key.Type == TypeHOTP {
+		if key.Counter, err = strconv.ParseUint(query.Get("counter"), 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: invalid HOTP counter %q", ErrInvalidURI, query.Get("counter"))
+		}
+	}
+	return key, nil
+}
+
+// ParseSecret parses an otpauth:// URI or, as a TOTP key with the default parameters labelled label, a bare base32
+// secret.
+func ParseSecret(label, secret string) (*Key, error) {
+	secret = strings.TrimSpace(secret)
+	if strings.HasPrefix(secret, Scheme) {
+		return Parse(secret)
+	}
+	decoded, err := DecodeSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		Type:      TypeTOTP,
+		Label:     label,
+		Algorithm: AlgorithmSHA1,
+		Secret:    decoded,
+		Digits:    DefaultDigits,
+		Period:    DefaultPeriod * time.Second,
+	}, nil
+}
+
+// DecodeSecret decodes a base32 secret, ignoring its case, spaces and padding.
+func DecodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(secret))
+	if secret == "" {
+		return nil, fmt.Errorf("%w: missing secret", ErrInvalidURI)
+	}
+	decoded, err := secretEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: secret is not base32: %w", ErrInvalidURI, err)
+	}
+	return decoded, nil
+}
+
+// URI returns the otpauth:// URI of the key. The parameters with their default value are omitted.
+func (k *Key) URI() string {
+	query := url.Values{}
+	query.Set("secret", secretEncoding.EncodeToString(k.Secret))
+	if k.Issuer != "" {
+		query.Set("issuer", k.Issuer)
+	}
+	if k.Algorithm != AlgorithmSHA1 {
+		query.Set("algorithm", k.Algorithm)
+	}
+	if k.Digits != DefaultDigits {
+		query.Set("digits", strconv.Itoa(k.Digits))
+	}
+	if k.Type == TypeHOTP {
+		query.Set("counter", strconv.FormatUint(k.Counter, 10))
+	} else if k.Period != DefaultPeriod*time.Second {
+		query.Set("period", strconv.Itoa(int(k.Period/time.Second)))
+	}
+	u := url.URL{Scheme: "otpauth", Host: k.Type, Path: "/" + k.Label, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// HOTP returns the code of the key for counter, as defined by RFC 4226.
+func (k *Key) HOTP(counter uint64) string {
+	h := hmac.New(newHash(k.Algorithm), k.Secret)
+	_, _ = h.Write(binary.BigEndian.AppendUint64(nil, counter))
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+
+	modulo := uint64(1)
+	for range k.Digits {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", k.Digits, value%modulo)
+}
+
+// TOTP returns the code of the key at time t, as defined by RFC 6238, and how long it remains valid.
+func (k *Key) TOTP(t time.Time) (string, time.Duration) {
+	period := int64(k.Period / time.Second)
+	unix := t.Unix()
+	remaining := time.Duration(period-unix%period) * time.Second
+	return k.HOTP(uint64(unix / period)), remaining
+}
+
+// newHash returns the hash function of algorithm, or nil if unknown.
+func newHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case AlgorithmSHA1:
+		return sha1.New
+	case AlgorithmSHA256:
+		return sha256.New
+	case AlgorithmSHA512:
+		return sha512.New
+	}
+	return nil
+}
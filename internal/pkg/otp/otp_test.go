@@ -0,0 +1,133 @@
+package otp_test
+
+import (
+	"encoding/base32"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/otp"
+)
+
+// RFC 6238 test secrets, the ASCII seed repeated to the size of the algorithm.
+const (
+	seedSHA1   = "12345678901234567890"
+	seedSHA256 = "12345678901234567890123456789012"
+	seedSHA512 = "1234567890123456789012345678901234567890123456789012345678901234"
+)
+
+func TestKey_HOTP(t *testing.T) {
+	// RFC 4226, appendix D.
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	key := &otp.Key{Algorithm: otp.AlgorithmSHA1, Secret: []byte(seedSHA1), Digits: 6}
+	for counter, code := range want {
+		if got := key.HOTP(uint64(counter)); got != code {
+			t.Errorf("HOTP(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestKey_TOTP(t *testing.T) {
+	// RFC 6238, appendix B.
+	tests := []struct {
+		sha1   string
+		sha256 string
+		sha512 string
+		unix   int64
+	}{
+		{unix: 59, sha1: "94287082", sha256: "46119246", sha512: "90693936"},
+		{unix: 1111111109, sha1: "07081804", sha256: "68084774", sha512: "25091201"},
+		{unix: 1111111111, sha1: "14050471", sha256: "67062674", sha512: "99943326"},
+		{unix: 1234567890, sha1: "89005924", sha256: "91819424", sha512: "93441116"},
+		{unix: 2000000000, sha1: "69279037", sha256: "90698825", sha512: "38618901"},
+		{unix: 20000000000, sha1: "65353130", sha256: "77737706", sha512: "47863826"},
+	}
+	keys := map[string]*otp.Key{}
+	for algorithm, seed := range map[string]string{
+		otp.AlgorithmSHA1: seedSHA1, otp.AlgorithmSHA256: seedSHA256, otp.AlgorithmSHA512: seedSHA512,
+	} {
+		keys[algorithm] = &otp.Key{Algorithm: algorithm, Secret: []byte(seed), Digits: 8, Period: 30 * time.Second}
+	}
+	for _, tt := range tests {
+		for algorithm, want := range map[string]string{
+			otp.AlgorithmSHA1: tt.sha1, otp.AlgorithmSHA256: tt.sha256, otp.AlgorithmSHA512: tt.sha512,
+		} {
+			got, _ := keys[algorithm].TOTP(time.Unix(tt.unix, 0))
+			if got != want {
+				t.Errorf("TOTP(%d) with %s = %s, want %s", tt.unix, algorithm, got, want)
+			}
+		}
+	}
+
+	_, remaining := keys[otp.AlgorithmSHA1].TOTP(time.Unix(59, 0))
+	if remaining != time.Second {
+		t.Errorf("Expected the code at 59s to remain valid for 1s, got %s", remaining)
+	}
+}
+
+func TestParse(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(seedSHA1))
+	tests := []struct {
+		want    *otp.Key
+		name    string
+		uri     string
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			uri:  "otpauth://totp/Example:alice@example.com?secret=" + secret + "&issuer=Example",
+			want: &otp.Key{
+				Type: otp.TypeTOTP, Label: "Example:alice@example.com", Issuer: "Example", Secret: []byte(seedSHA1),
+				Algorithm: otp.AlgorithmSHA1, Digits: 6, Period: 30 * time.Second,
+			},
+		},
+		{
+			name: "parameters",
+			uri:  "otpauth://totp/bastion?secret=" + secret + "&algorithm=sha256&digits=8&period=60",
+			want: &otp.Key{
+				Type: otp.TypeTOTP, Label: "bastion", Secret: []byte(seedSHA1),
+				Algorithm: otp.AlgorithmSHA256, Digits: 8, Period: time.Minute,
+			},
+		},
+		{
+			name: "hotp",
+			uri:  "otpauth://hotp/vpn?secret=" + secret + "&counter=7",
+			want: &otp.Key{
+				Type: otp.TypeHOTP, Label: "vpn", Secret: []byte(seedSHA1),
+				Algorithm: otp.AlgorithmSHA1, Digits: 6, Period: 30 * time.Second, Counter: 7,
+			},
+		},
+		{name: "not otpauth", uri: "https://example.com?secret=" + secret, wantErr: true},
+		{name: "unknown type", uri: "otpauth://motp/x?secret=" + secret, wantErr: true},
+		{name: "missing secret", uri: "otpauth://totp/x", wantErr: true},
+		{name: "invalid secret", uri: "otpauth://totp/x?secret=not-base32!", wantErr: true},
+		{name: "unknown algorithm", uri: "otpauth://totp/x?secret=" + secret + "&algorithm=MD5", wantErr: true},
+		{name: "too few digits", uri: "otpauth://totp/x?secret=" + secret + "&digits=4", wantErr: true},
+		{name: "hotp without counter", uri: "otpauth://hotp/x?secret=" + secret, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := otp.Parse(tt.uri)
+			if tt.wantErr {
+				if !errors.Is(err, otp.ErrInvalidURI) {
+					t.Fatalf("Expected ErrInvalidURI, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if got.Type != tt.want.Type || got.Label != tt.want.Label || got.Issuer != tt.want.Issuer ||
+				string(got.Secret) != string(tt.want.Secret) || got.Algorithm != tt.want.Algorithm ||
+				got.Digits != tt.want.Digits || got.Period != tt.want.Period || got.Counter != tt.want.Counter {
+				t.Fatalf("Parse() = %+v, want %+v", got, tt.want)
+			}
+
+			// The URI of a key parses back to the same key.
+			again, err := otp.Parse(got.URI())
+			if err != nil || again.URI() != got.URI() {
+				t.Fatalf("Expected %s to round trip, got %v %v", got.URI(), again, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Package pake implements CPace, a balanced password-authenticated key exchange, over the ristretto255 group,
+// following draft-irtf-cfrg-cpace.
+//
+// Two parties sharing a low-entropy secret, e.g. a short one-time code shown on one machine and typed on the other,
+// derive a shared key without certificates. An eavesdropper learns nothing about the secret and an active attacker
+// can test a single guess of the secret per exchange.
+package pake
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gtank/ristretto255"
+)
+
+const (
+	// dsi is the domain separation identifier of CPace over ristretto255 with SHA-512.
+	dsi = "CPaceRistretto255"
+	// hashBlockSize is the input block size of SHA-512, used to pad the generator string.
+	hashBlockSize = 128
+
+	// ShareSize is the size of the share sent to the other party.
+	ShareSize = 32
+)
+
+// ErrInvalidShare is returned when the share of the other party is not a valid group element.
+var ErrInvalidShare = errors.New("invalid CPace share")
+
+// Exchange is the state of one party of a CPace exchange.
+type Exchange struct {
+	scalar    *ristretto255.Scalar
+	sid       []byte
+	share     []byte
+	initiator bool
+}
+
+// New starts an exchange for the shared secret prs, bound to the channel identifier ci and the session identifier
+// sid. Both parties must use the same ci and sid, and exactly one of them must be the initiator.
+func New(prs, ci, sid []byte, initiator bool) (*Exchange, error) {
+	random := make([]byte, 64)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return nil, fmt.Errorf("failed to generate CPace scalar: %w", err)
+	}
+	scalar := ristretto255.NewScalar().FromUniformBytes(random)
+	share := ristretto255.NewElement().ScalarMult(scalar, generator(prs, ci, sid)).Encode(nil)
+
+	return &Exchange{scalar: scalar, sid: sid, share: share, initiator: initiator}, nil
+}
+
+// Share returns the share to send to the other party.
+func (e *Exchange) Share() []byte {
+	return e.share
+}
+
+// Finish completes the exchange with the share of the other party and returns the intermediate session key.
+// Both parties get the same key only if they used the same secret: the key must be confirmed, e.g. with a MAC
+// over the transcript, before trusting the other party.
+func (e *Exchange) Finish(peerShare []byte) ([]byte, error) {
+	peer := ristretto255.NewElement()
+	if err := peer.Decode(peerShare); err != nil {
+		return nil, ErrInvalidShare
+	}
+	k := ristretto255.NewElement().ScalarMult(e.scalar, peer)
+	if k.Equal(ristretto255.NewElement()) == 1 {
+		return nil, ErrInvalidShare
+	}
+
+	ya, yb := e.share, peerShare
+	if !e.initiator {
+		ya, yb = yb, ya
+	}
+	h := sha512.New()
+	h.Write(lvCat([]byte(dsi+"_ISK"), e.sid, k.Encode(nil)))
+	// The transcript authenticates both shares, with empty associated data.
+	h.Write(lvCat(ya, nil))
+	h.Write(lvCat(yb, nil))
+	return h.Sum(nil), nil
+}
+
+// generator derives the generator of the exchange from the secret, the channel and the session identifiers.
+func generator(prs, ci, sid []byte) *ristretto255.Element {
+	padLen := max(0, hashBlockSize-1-len(prependLen(prs))-len(prependLen([]byte(dsi))))
+	h := sha512.Sum512(lvCat([]byte(dsi), prs, make([]byte, padLen), ci, sid))
+	return ristretto255.NewElement().FromUniformBytes(h[:])
+}
+
+// lvCat concatenates the fields, each prefixed with its length.
+func lvCat(fields ...[]byte) []byte {
+	var out []byte
+	for _, field := range fields {
+		out = append(out, prependLen(field)...)
+	}
+	return out
+}
+
+// prependLen prefixes data with its length, encoded as LEB128.
+func prependLen(data []byte) []byte {
+	var out []byte
+	n := len(data)
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			out = append(out, b)
+			break
+		}
+		out = append(out, b|0x80)
+	}
+	return append(out, data...)
+}
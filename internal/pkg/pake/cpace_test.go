@@ -0,0 +1,92 @@
+package pake_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/pake"
+)
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverCode  string
+		clientCode  string
+		serverSID   string
+		wantSameKey bool
+	}{
+		{
+			name:        "derives the same key with the same code",
+			serverCode:  "12345678",
+			clientCode:  "12345678",
+			serverSID:   "session",
+			wantSameKey: true,
+		},
+		{
+			name:       "derives different keys with different codes",
+			serverCode: "12345678",
+			clientCode: "12345679",
+			serverSID:  "session",
+		},
+		{
+			name:       "derives different keys with different sessions",
+			serverCode: "12345678",
+			clientCode: "12345678",
+			serverSID:  "other session",
+		},
+	}
+
+	ci := []byte("psst-test")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := pake.New([]byte(tt.clientCode), ci, []byte("session"), true)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			server, err := pake.New([]byte(tt.serverCode), ci, []byte(tt.serverSID), false)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if len(client.Share()) != pake.ShareSize || bytes.Equal(client.Share(), server.Share()) {
+				t.Fatalf("Expected distinct shares of %d bytes", pake.ShareSize)
+			}
+
+			clientKey, err := client.Finish(server.Share())
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			serverKey, err := server.Finish(client.Share())
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if bytes.Equal(clientKey, serverKey) != tt.wantSameKey {
+				t.Fatalf("Expected same key to be %v, got client key %x and server key %x",
+					tt.wantSameKey, clientKey, serverKey)
+			}
+		})
+	}
+}
+
+func TestExchange_InvalidShare(t *testing.T) {
+	tests := []struct {
+		name  string
+		share []byte
+	}{
+		{name: "identity element", share: make([]byte, pake.ShareSize)},
+		{name: "non canonical encoding", share: bytes.Repeat([]byte{0xff}, pake.ShareSize)},
+		{name: "short share", share: []byte{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := pake.New([]byte("12345678"), nil, nil, true)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			if _, err = e.Finish(tt.share); !errors.Is(err, pake.ErrInvalidShare) {
+				t.Fatalf("Expected ErrInvalidShare, got [%v]", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Package secretref parses and resolves references to the fields of vault entries, such as psst://github/password,
+// so that files referencing secrets can be shared or committed instead of the secrets themselves.
+//
+// A reference is the psst scheme followed by the service and, optionally, the field, which defaults to the password:
+//
+//	psst://<service>[/<field>]
+//
+// The field is either a built-in field of the entry, such as username or otp for its current two-factor code, or the
+// name of one of its custom fields, as in psst://github/otp or psst://bank/pin.
+//
+// Characters other than letters, digits and "-._~@:+" must be percent-encoded in the service and the field,
+// a service containing a "/" included.
+package secretref
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Scheme prefixes every reference.
+	Scheme = "psst://"
+	// DefaultField is the field of references without one.
+	DefaultField = "password"
+)
+
+// ErrInvalidReference is returned when a reference is malformed.
+var ErrInvalidReference = errors.New("invalid psst reference")
+
+// referencePattern matches the references in a text. A reference ends at the first character that can't be part of
+// it, such as a space or a quote.
+var referencePattern = regexp.MustCompile(`psst://[A-Za-z0-9\-._~@:+%]+(?:/[A-Za-z0-9\-._~@:+%]+)?`)
+
+// Reference references a field of the entry of a service.
+type Reference struct {
+	Service string
+	Field   string
+}
+
+// Parse parses a reference.
+func Parse(text string) (Reference, error) {
+	rest, ok := strings.CutPrefix(text, Scheme)
+	if !ok {
+		return Reference{}, fmt.Errorf("%w %s: missing %s scheme", ErrInvalidReference, text, Scheme)
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) > 2 {
+		return Reference{}, fmt.Errorf("%w %s: expected %s<service>[/<field>]", ErrInvalidReference, text, Scheme)
+	}
+	if len(parts) == 1 {
+		parts = append(parts, DefaultField)
+	}
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return Reference{}, fmt.Errorf("%w %s: %w", ErrInvalidReference, text, err)
+		}
+		if unescaped == "" {
+			return Reference{}, fmt.Errorf("%w %s: empty service or field", ErrInvalidReference, text)
+		}
+		parts[i] = unescaped
+	}
+	return Reference{Service: parts[0], Field: parts[1]}, nil
+}
+
+// String returns the reference as text, percent-encoding its service and field.
+func (r Reference) String() string {
+	return Scheme + url.PathEscape(r.Service) + "/" + url.PathEscape(r.Field)
+}
+
+// Resolver reads the value of a field of the entry of a service. It is implemented by vault.Manager.
+type Resolver interface {
+	ReadField(service, field string) (string, error)
+}
+
+// Resolve parses a reference and reads its value with r.
+func Resolve(r Resolver, text string) (string, error) {
+	ref, err := Parse(text)
+	if err != nil {
+		return "", err
+	}
+	value, err := r.ReadField(ref.Service, ref.Field)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", text, err)
+	}
+	return value, nil
+}
+
+// Find returns the distinct references found in text, in order of appearance.
+func Find(text string) []string {
+	var refs []string
+	seen := map[string]bool{}
+	for _, ref := range referencePattern.FindAllString(text, -1) {
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// Render replaces every reference in text with its value, read with r. Each distinct reference is read once.
+// Nothing is returned if any reference fails to resolve.
+func Render(r Resolver, text string) (string, error) {
+	values := map[string]string{}
+	for _, ref := range Find(text) {
+		value, err := Resolve(r, ref)
+		if err != nil {
+			return "", err
+		}
+		values[ref] = value
+	}
+	return referencePattern.ReplaceAllStringFunc(text, func(ref string) string { return values[ref] }), nil
+}
@@ -0,0 +1,83 @@
+package secretref_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/secretref"
+)
+
+var errNotFound = errors.New("not found")
+
+// fields resolves references from a map of service/field to value.
+type fields map[string]string
+
+func (f fields) ReadField(service, field string) (string, error) {
+	value, ok := f[service+"/"+field]
+	if !ok {
+		return "", errNotFound
+	}
+	return value, nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    secretref.Reference
+		wantErr bool
+	}{
+		{text: "psst://github/username", want: secretref.Reference{Service: "github", Field: "username"}},
+		{text: "psst://github", want: secretref.Reference{Service: "github", Field: "password"}},
+		{text: "psst://my%20bank%2Fsavings/pin", want: secretref.Reference{Service: "my bank/savings", Field: "pin"}},
+		{text: "github/password", wantErr: true},
+		{text: "psst://a/b/c", wantErr: true},
+		{text: "psst:///password", wantErr: true},
+		{text: "psst://github/%zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := secretref.Parse(tt.text)
+			if tt.wantErr {
+				if !errors.Is(err, secretref.ErrInvalidReference) {
+					t.Fatalf("Expected ErrInvalidReference, got [%v]", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("Expected %+v, got %+v [%v]", tt.want, got, err)
+			}
+			if parsed, _ := secretref.Parse(got.String()); parsed != got {
+				t.Fatalf("Expected %s to parse back to %+v, got %+v", got, got, parsed)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	r := fields{"prod-db/password": "db-secret", "prod-db/username": "admin", "my bank/pin": "1234"}
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "replaces every reference",
+			text: "user: psst://prod-db/username\npass: \"psst://prod-db\"\npin: psst://my%20bank/pin\n",
+			want: "user: admin\npass: \"db-secret\"\npin: 1234\n",
+		},
+		{name: "without references", text: "port: 5432\n", want: "port: 5432\n"},
+		{name: "missing entry", text: "psst://jira/password", wantErr: errNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secretref.Render(r, tt.text)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected [%v], got [%v]", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Fatalf("Expected [%s], got [%s]", tt.want, got)
+			}
+		})
+	}
+}
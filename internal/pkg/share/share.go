@@ -0,0 +1,223 @@
+// Package share seals a single password entry for one recipient with public-key encryption, so that an entry can be
+// handed to someone else without sharing a password or going through a server.
+//
+// Every vault has an X25519 identity keypair. A shared entry is sealed with a key derived from two X25519 exchanges
+// with the recipient public key: one with an ephemeral key, for forward secrecy towards the sender, and one with the
+// sender identity key, authenticating the sender to the recipient. The format is:
+//
+//	magic       8 bytes   "PSSTSHRE"
+//	version     2 bytes   big endian, currently 1
+//	sender      32 bytes  X25519 identity public key of the sender
+//	ephemeral   32 bytes  X25519 ephemeral public key
+//	nonce       12 bytes
+//	payload     AES-256-GCM ciphertext of the JSON encoded entry, or of any payload sealed by SealBytes
+//
+// Everything before the payload, followed by the recipient public key, is authenticated as additional data.
+package share
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+const (
+	// Magic identifies a shared entry.
+	Magic = "PSSTSHRE"
+	// Version is the version of the format written by Seal.
+	Version uint16 = 1
+	// Extension is the conventional file extension of a shared entry.
+	Extension = ".psst-share"
+
+	// PublicKeyPrefix prefixes the text encoding of public keys.
+	PublicKeyPrefix = "psst-pub-"
+
+	keySize   = 32
+	nonceSize = 12
+	// headerSize is the size of everything before the payload.
+	headerSize = len(Magic) + 2 + 2*keySize + nonceSize
+
+	// keyInfo is the HKDF info used to derive the payload key.
+	keyInfo = "psst-share v1"
+)
+
+var (
+	// ErrInvalidShare is returned when the data is not a well-formed shared entry.
+	ErrInvalidShare = errors.New("invalid psst shared entry")
+	// ErrDecrypt is returned when a shared entry can't be decrypted, either because it was sealed for someone else
+	// or because it has been modified.
+	ErrDecrypt = errors.New("failed to decrypt shared entry: not shared with this vault or corrupted")
+	// ErrInvalidPublicKey is returned by ParsePublicKey when the text isn't a psst public key.
+	ErrInvalidPublicKey = errors.New("invalid psst public key")
+)
+
+// EncodePublicKey encodes a public key as text, to be given to the people sharing entries with its owner.
+func EncodePublicKey(key *ecdh.PublicKey) string {
+	return PublicKeyPrefix + base64.RawURLEncoding.EncodeToString(key.Bytes())
+}
+
+// ParsePublicKey parses a public key encoded by EncodePublicKey.
+func ParsePublicKey(text string) (*ecdh.PublicKey, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(text), PublicKeyPrefix)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s prefix", ErrInvalidPublicKey, PublicKeyPrefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPublicKey, err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPublicKey, err)
+	}
+	return key, nil
+}
+
+// Seal seals entry from the owner of the identity key sender for recipient.
+// Only the plain text fields of the entry and its timestamps are shared.
+func Seal(sender *ecdh.PrivateKey, recipient *ecdh.PublicKey, entry *model.PasswordEntry) ([]byte, error) {
+	shared := *entry
+	// IDs and UUIDs are local to each vault.
+	shared.ID, shared.UUID = 0, ""
+	plaintext, err := json.Marshal(&shared)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode entry: %w", err)
+	}
+	return SealBytes(sender, recipient, plaintext)
+}
+
+// SealBytes seals an arbitrary payload from the owner of the identity key sender for recipient, in the same format
+// as a shared entry.
+func SealBytes(sender *ecdh.PrivateKey, recipient *ecdh.PublicKey, plaintext []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, Magic...)
+	header = binary.BigEndian.AppendUint16(header, Version)
+	header = append(header, sender.PublicKey().Bytes()...)
+	header = append(header, ephemeral.PublicKey().Bytes()...)
+	nonce := make([]byte, nonceSize)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header = append(header, nonce...)
+
+	secret, err := sharedSecret(ephemeral, recipient, sender, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive shared key: %w", err)
+	}
+	gcm, err := newGCM(secret, header)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(header, nonce, plaintext, additionalData(header, recipient)), nil
+}
+
+// Open opens a shared entry sealed for the owner of the identity key recipient.
+// It returns the entry and the identity public key of the sender, to be checked by the recipient.
+func Open(recipient *ecdh.PrivateKey, data []byte) (*model.PasswordEntry, *ecdh.PublicKey, error) {
+	plaintext, sender, err := OpenBytes(recipient, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entry model.PasswordEntry
+	if err = json.Unmarshal(plaintext, &entry); err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to decode entry: %w", ErrInvalidShare, err)
+	}
+	if entry.Service == "" {
+		return nil, nil, fmt.Errorf("%w: entry without service", ErrInvalidShare)
+	}
+	entry.ID, entry.UUID = 0, ""
+	return &entry, sender, nil
+}
+
+// OpenBytes opens a payload sealed by SealBytes for the owner of the identity key recipient.
+// It returns the payload and the identity public key of the sender, to be checked by the recipient.
+func OpenBytes(recipient *ecdh.PrivateKey, data []byte) ([]byte, *ecdh.PublicKey, error) {
+	if len(data) < headerSize || string(data[:len(Magic)]) != Magic {
+		return nil, nil, fmt.Errorf("%w: not a psst shared entry", ErrInvalidShare)
+	}
+	if v := binary.BigEndian.Uint16(data[len(Magic):]); v != Version {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidShare, v)
+	}
+	header := data[:headerSize]
+	keys := header[len(Magic)+2:]
+	sender, err := ecdh.X25519().NewPublicKey(keys[:keySize])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid sender key", ErrInvalidShare)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(keys[keySize : 2*keySize])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid ephemeral key", ErrInvalidShare)
+	}
+	nonce := keys[2*keySize:]
+
+	secret, err := sharedSecret(recipient, ephemeral, recipient, sender)
+	if err != nil {
+		// Low order points yield an all-zero secret
+		return nil, nil, fmt.Errorf("%w: invalid public keys", ErrInvalidShare)
+	}
+	gcm, err := newGCM(secret, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, data[headerSize:], additionalData(header, recipient.PublicKey()))
+	if err != nil {
+		return nil, nil, ErrDecrypt
+	}
+	return plaintext, sender, nil
+}
+
+// sharedSecret concatenates the results of the two X25519 exchanges of a shared entry: between the ephemeral key and
+// the recipient key, then between the sender identity key and the recipient key. The sender and the recipient each
+// pass their own private keys and the public keys of the other party.
+func sharedSecret(ephemeralPriv *ecdh.PrivateKey, ephemeralPeer *ecdh.PublicKey, identityPriv *ecdh.PrivateKey,
+	identityPeer *ecdh.PublicKey,
+) ([]byte, error) {
+	ephemeralShared, err := ephemeralPriv.ECDH(ephemeralPeer)
+	if err != nil {
+		return nil, err
+	}
+	identityShared, err := identityPriv.ECDH(identityPeer)
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeralShared, identityShared...), nil
+}
+
+// newGCM derives the payload cipher from the shared secret, bound to the header.
+func newGCM(secret, header []byte) (cipher.AEAD, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, header, []byte(keyInfo)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive payload key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData returns the additional data of the payload: the header followed by the recipient public key,
+// so that the entry only opens for the intended recipient.
+func additionalData(header []byte, recipient *ecdh.PublicKey) []byte {
+	return bytes.Join([][]byte{header, recipient.Bytes()}, nil)
+}
@@ -0,0 +1,99 @@
+package share_test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/share"
+)
+
+func newKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	return key
+}
+
+func TestSealOpen(t *testing.T) {
+	sender, recipient := newKey(t), newKey(t)
+	entry := &model.PasswordEntry{
+		ID:         42,
+		UUID:       "4f1c",
+		Service:    "github",
+		Username:   "dev@example.com",
+		Password:   "gh-secret",
+		Tags:       []string{"work"},
+		CreatedAt:  time.Date(2025, 4, 14, 10, 30, 0, 0, time.UTC),
+		ModifiedAt: time.Date(2025, 4, 15, 10, 30, 0, 0, time.UTC),
+	}
+
+	data, err := share.Seal(sender, recipient.PublicKey(), entry)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	got, from, err := share.Open(recipient, data)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if !from.Equal(sender.PublicKey()) {
+		t.Fatal("Expected the sender identity public key")
+	}
+	if got.Service != entry.Service || got.Password != entry.Password || got.Username != entry.Username ||
+		!got.ModifiedAt.Equal(entry.ModifiedAt) || len(got.Tags) != 1 {
+		t.Fatalf("Expected %+v, got %+v", entry, got)
+	}
+	if got.ID != 0 || got.UUID != "" {
+		t.Fatalf("Expected the local IDs not to be shared, got [%d] and [%s]", got.ID, got.UUID)
+	}
+
+	tamper := func(i int) []byte {
+		tampered := append([]byte(nil), data...)
+		tampered[i] ^= 0x01
+		return tampered
+	}
+	tests := []struct {
+		wantErr   error
+		name      string
+		data      []byte
+		recipient *ecdh.PrivateKey
+	}{
+		{name: "someone else", data: data, recipient: sender, wantErr: share.ErrDecrypt},
+		{name: "tampered sender", data: tamper(len(share.Magic) + 2), recipient: recipient, wantErr: share.ErrDecrypt},
+		{name: "tampered payload", data: tamper(len(data) - 1), recipient: recipient, wantErr: share.ErrDecrypt},
+		{name: "not a shared entry", data: []byte("PSSTBNDL"), recipient: recipient, wantErr: share.ErrInvalidShare},
+		{name: "unsupported version", data: tamper(len(share.Magic) + 1), recipient: recipient,
+			wantErr: share.ErrInvalidShare},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err = share.Open(tt.recipient, tt.data); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected [%v], got [%v]", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key := newKey(t).PublicKey()
+	encoded := share.EncodePublicKey(key)
+	parsed, err := share.ParsePublicKey(" " + encoded + "\n")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if !parsed.Equal(key) {
+		t.Fatal("Expected the parsed key to equal the encoded one")
+	}
+
+	for _, text := range []string{"", encoded[len(share.PublicKeyPrefix):], share.PublicKeyPrefix + "!!",
+		share.PublicKeyPrefix + "AAAA"} {
+		if _, err = share.ParsePublicKey(text); !errors.Is(err, share.ErrInvalidPublicKey) {
+			t.Fatalf("Expected ErrInvalidPublicKey parsing [%s], got [%v]", text, err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Package sshagent implements an OpenSSH agent serving the SSH keys stored in a vault, so that ssh can authenticate
+// with them without the private keys ever being written to disk in plain text.
+//
+// Keys are read from the vault, and decrypted, on every request: once the agent is locked, with 'ssh-add -x', the
+// vault is closed and no key is served until it is unlocked again with 'ssh-add -X'.
+package sshagent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+var (
+	// ErrReadOnly is returned when a client tries to add or remove keys: they are managed in the vault.
+	ErrReadOnly = errors.New("the keys of the agent are managed in the vault")
+	// ErrLocked is returned when a key is used while the agent is locked.
+	ErrLocked = errors.New("agent is locked")
+	// ErrDenied is returned when the use of a key is not confirmed.
+	ErrDenied = errors.New("use of the key was denied")
+	// ErrUnknownKey is returned when a client asks for a signature by a key the agent doesn't have.
+	ErrUnknownKey = errors.New("unknown key")
+)
+
+// Keys provides the entries holding the SSH keys served by the agent. It is implemented by vault.Manager.
+type Keys interface {
+	SSHKeys() ([]*model.PasswordEntry, error)
+}
+
+// Options configures an Agent.
+type Options struct {
+	// Confirm, if set, is asked before every signature with the entry of the key, which is only used on approval.
+	Confirm func(entry *model.PasswordEntry) bool
+	// Lock is called when the agent is locked, to close the vault.
+	Lock func()
+	// Unlock unlocks the vault with the passphrase given to unlock the agent.
+	Unlock func(passphrase []byte) (Keys, error)
+}
+
+// Agent is an agent.ExtendedAgent serving the SSH keys of a vault. Requests are handled one at a time.
+type Agent struct {
+	keys Keys
+	opts Options
+	mu   sync.Mutex
+}
+
+var _ agent.ExtendedAgent = (*Agent)(nil)
+
+// New returns an agent serving the SSH keys of the unlocked vault keys.
+func New(keys Keys, opts Options) *Agent {
+	return &Agent{keys: keys, opts: opts}
+}
+
+// ParseKey parses the SSH private key held by an entry. Keys protected by a passphrase are rejected: the vault
+// already encrypts them, and the agent couldn't ask for the passphrase.
+func ParseKey(entry *model.PasswordEntry) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(entry.Password))
+	var passphraseErr *ssh.PassphraseMissingError
+	if errors.As(err, &passphraseErr) {
+		return nil, fmt.Errorf("the SSH key of %s is protected by a passphrase, remove it first", entry.Service)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH key for service %s: %w", entry.Service, err)
+	}
+	return signer, nil
+}
+
+// signer is a key served by the agent.
+type signer struct {
+	ssh.Signer
+	entry *model.PasswordEntry
+}
+
+// signers parses the keys of the vault. Entries that don't hold a valid key are skipped.
+func (a *Agent) signers() ([]signer, error) {
+	if a.keys == nil {
+		return nil, ErrLocked
+	}
+	entries, err := a.keys.SSHKeys()
+	if err != nil {
+		return nil, err
+	}
+	signers := make([]signer, 0, len(entries))
+	for _, entry := range entries {
+		s, parseErr := ParseKey(entry)
+		if parseErr != nil {
+			log.Printf("Skipping SSH key: %s\n", parseErr)
+			continue
+		}
+		signers = append(signers, signer{Signer: s, entry: entry})
+	}
+	return signers, nil
+}
+
+// List returns the public keys of the vault, commented with their service. A locked agent has no keys.
+func (a *Agent) List() ([]*agent.Key, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.keys == nil {
+		return nil, nil
+	}
+	signers, err := a.signers()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*agent.Key, 0, len(signers))
+	for _, s := range signers {
+		pub := s.PublicKey()
+		keys = append(keys, &agent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: s.entry.Service})
+	}
+	return keys, nil
+}
+
+// Sign signs data with the key of the vault matching key.
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags signs data with the key of the vault matching key, using the RSA signature algorithm requested by
+// flags, if any. The use of the key is confirmed first if Options.Confirm is set.
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	signers, err := a.signers()
+	if err != nil {
+		return nil, err
+	}
+	wanted := key.Marshal()
+	for _, s := range signers {
+		if !bytes.Equal(s.PublicKey().Marshal(), wanted) {
+			continue
+		}
+		if a.opts.Confirm != nil && !a.opts.Confirm(s.entry) {
+			return nil, ErrDenied
+		}
+		return sign(s.Signer, data, flags)
+	}
+	return nil, ErrUnknownKey
+}
+
+// sign signs data with s, using the algorithm requested by flags.
+func sign(s ssh.Signer, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if flags == 0 {
+		return s.Sign(rand.Reader, data)
+	}
+	algorithmSigner, ok := s.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("signature flags %d not supported by %s keys", flags, s.PublicKey().Type())
+	}
+	var algorithm string
+	switch flags {
+	case agent.SignatureFlagRsaSha256:
+		algorithm = ssh.KeyAlgoRSASHA256
+	case agent.SignatureFlagRsaSha512:
+		algorithm = ssh.KeyAlgoRSASHA512
+	default:
+		return nil, fmt.Errorf("unsupported signature flags %d", flags)
+	}
+	return algorithmSigner.SignWithAlgorithm(rand.Reader, data, algorithm)
+}
+
+// Signers returns the keys of the vault.
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	signers, err := a.signers()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ssh.Signer, 0, len(signers))
+	for _, s := range signers {
+		result = append(result, s.Signer)
+	}
+	return result, nil
+}
+
+// Add fails with ErrReadOnly, keys are added to the vault.
+func (a *Agent) Add(agent.AddedKey) error {
+	return ErrReadOnly
+}
+
+// Remove fails with ErrReadOnly, keys are removed from the vault.
+func (a *Agent) Remove(ssh.PublicKey) error {
+	return ErrReadOnly
+}
+
+// RemoveAll fails with ErrReadOnly, keys are removed from the vault.
+func (a *Agent) RemoveAll() error {
+	return ErrReadOnly
+}
+
+// Lock locks the agent and closes the vault with Options.Lock. The passphrase is ignored: the agent is unlocked
+// with the principal password.
+func (a *Agent) Lock([]byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.keys == nil {
+		return ErrLocked
+	}
+	if a.opts.Lock != nil {
+		a.opts.Lock()
+	}
+	a.keys = nil
+	return nil
+}
+
+// Unlock unlocks the vault again with Options.Unlock and passphrase.
+func (a *Agent) Unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.keys != nil {
+		return errors.New("agent is not locked")
+	}
+	if a.opts.Unlock == nil {
+		return errors.New("agent can't be unlocked")
+	}
+	keys, err := a.opts.Unlock(passphrase)
+	if err != nil {
+		return err
+	}
+	a.keys = keys
+	return nil
+}
+
+// Extension fails with agent.ErrExtensionUnsupported, no extension is supported.
+func (a *Agent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
@@ -0,0 +1,174 @@
+package sshagent_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/sshagent"
+)
+
+// keys is a vault holding fixed SSH key entries.
+type keys []*model.PasswordEntry
+
+func (k keys) SSHKeys() ([]*model.PasswordEntry, error) {
+	return k, nil
+}
+
+// newKeyEntry generates a private key and returns it as the entry of service.
+func newKeyEntry(t *testing.T, service string, key crypto.PrivateKey) *model.PasswordEntry {
+	t.Helper()
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	return &model.PasswordEntry{Service: service, Kind: model.KindSSHKey, Password: string(pem.EncodeToMemory(block))}
+}
+
+// serve serves a over an in-process connection and returns a client of it.
+func serve(t *testing.T, a agent.Agent) agent.ExtendedAgent {
+	t.Helper()
+	server, client := net.Pipe()
+	go func() { _ = agent.ServeAgent(a, server) }()
+	t.Cleanup(func() { _ = client.Close() })
+	return agent.NewClient(client)
+}
+
+func TestAgent(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	vaultKeys := keys{
+		newKeyEntry(t, "deploy", edKey),
+		{Service: "broken", Kind: model.KindSSHKey, Password: "not a key"},
+		newKeyEntry(t, "legacy", rsaKey),
+	}
+
+	var confirmed []string
+	allow := true
+	unlocked := 0
+	client := serve(t, sshagent.New(vaultKeys, sshagent.Options{
+		Confirm: func(entry *model.PasswordEntry) bool {
+			confirmed = append(confirmed, entry.Service)
+			return allow
+		},
+		Unlock: func(passphrase []byte) (sshagent.Keys, error) {
+			unlocked++
+			if string(passphrase) != "principal" {
+				return nil, sshagent.ErrDenied
+			}
+			return vaultKeys, nil
+		},
+	}))
+
+	listed, err := client.List()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(listed) != 2 || listed[0].Comment != "deploy" || listed[1].Comment != "legacy" {
+		t.Fatalf("Expected the deploy and legacy keys, got %v", listed)
+	}
+
+	data := []byte("session")
+	signature, err := client.Sign(listed[0], data)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err = listed[0].Verify(data, signature); err != nil {
+		t.Fatal("Expected a valid ed25519 signature: ", err)
+	}
+	signature, err = client.SignWithFlags(listed[1], data, agent.SignatureFlagRsaSha256)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if signature.Format != ssh.KeyAlgoRSASHA256 || listed[1].Verify(data, signature) != nil {
+		t.Fatalf("Expected a valid rsa-sha2-256 signature, got %s", signature.Format)
+	}
+	if len(confirmed) != 2 {
+		t.Fatalf("Expected every signature to be confirmed, got %v", confirmed)
+	}
+
+	allow = false
+	if _, err = client.Sign(listed[0], data); err == nil {
+		t.Fatal("Expected a denied signature to fail")
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	otherKey, err := ssh.NewPublicKey(other)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, err = client.Sign(otherKey, data); err == nil {
+		t.Fatal("Expected a signature by an unknown key to fail")
+	}
+	if err = client.Add(agent.AddedKey{PrivateKey: edKey}); err == nil {
+		t.Fatal("Expected adding a key to fail")
+	}
+
+	if err = client.Lock([]byte("any")); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if listed, err = client.List(); err != nil || len(listed) != 0 {
+		t.Fatalf("Expected a locked agent to have no keys, got %v %v", listed, err)
+	}
+	if err = client.Unlock([]byte("wrong")); err == nil {
+		t.Fatal("Expected unlocking with a wrong password to fail")
+	}
+	if err = client.Unlock([]byte("principal")); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if listed, err = client.List(); err != nil || len(listed) != 2 || unlocked != 2 {
+		t.Fatalf("Expected the keys to be served again, got %v %v", listed, err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("passphrase"))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	tests := []struct {
+		entry   *model.PasswordEntry
+		name    string
+		wantErr bool
+	}{
+		{name: "unencrypted key", entry: newKeyEntry(t, "deploy", key)},
+		{
+			name:    "key with a passphrase",
+			entry:   &model.PasswordEntry{Service: "deploy", Password: string(pem.EncodeToMemory(block))},
+			wantErr: true,
+		},
+		{name: "not a key", entry: &model.PasswordEntry{Service: "deploy", Password: "hunter2"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := sshagent.ParseKey(tt.entry)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("ParseKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && signer.PublicKey().Type() != ssh.KeyAlgoED25519 {
+				t.Fatalf("Expected an ed25519 key, got %s", signer.PublicKey().Type())
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ package db
 
 import (
 	reflect "reflect"
+	time "time"
 
 	gomock "go.uber.org/mock/gomock"
 
@@ -54,6 +55,34 @@ func (mr *MockVaultMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockVault)(nil).Close))
 }
 
+// DeleteAttachment mocks base method.
+func (m *MockVault) DeleteAttachment(attachmentID int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAttachment", attachmentID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAttachment indicates an expected call of DeleteAttachment.
+func (mr *MockVaultMockRecorder) DeleteAttachment(attachmentID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAttachment", reflect.TypeOf((*MockVault)(nil).DeleteAttachment), attachmentID)
+}
+
+// DeleteMember mocks base method.
+func (m *MockVault) DeleteMember(name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMember", name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMember indicates an expected call of DeleteMember.
+func (mr *MockVaultMockRecorder) DeleteMember(name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMember", reflect.TypeOf((*MockVault)(nil).DeleteMember), name)
+}
+
 // DeletePasswordEntry mocks base method.
 func (m *MockVault) DeletePasswordEntry(service string) error {
 	m.ctrl.T.Helper()
@@ -68,6 +97,36 @@ func (mr *MockVaultMockRecorder) DeletePasswordEntry(service any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePasswordEntry", reflect.TypeOf((*MockVault)(nil).DeletePasswordEntry), service)
 }
 
+// GetAttachment mocks base method.
+func (m *MockVault) GetAttachment(entryID int64, name string) (*model.Attachment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAttachment", entryID, name)
+	ret0, _ := ret[0].(*model.Attachment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAttachment indicates an expected call of GetAttachment.
+func (mr *MockVaultMockRecorder) GetAttachment(entryID, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAttachment", reflect.TypeOf((*MockVault)(nil).GetAttachment), entryID, name)
+}
+
+// GetLastSync mocks base method.
+func (m *MockVault) GetLastSync(peerID string) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLastSync", peerID)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLastSync indicates an expected call of GetLastSync.
+func (mr *MockVaultMockRecorder) GetLastSync(peerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLastSync", reflect.TypeOf((*MockVault)(nil).GetLastSync), peerID)
+}
+
 // GetPasswordEntry mocks base method.
 func (m *MockVault) GetPasswordEntry(service string) (*model.PasswordEntry, error) {
 	m.ctrl.T.Helper()
@@ -112,6 +171,51 @@ func (mr *MockVaultMockRecorder) Initialize() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Initialize", reflect.TypeOf((*MockVault)(nil).Initialize))
 }
 
+// ListAllAttachments mocks base method.
+func (m *MockVault) ListAllAttachments() ([]*model.Attachment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAllAttachments")
+	ret0, _ := ret[0].([]*model.Attachment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAllAttachments indicates an expected call of ListAllAttachments.
+func (mr *MockVaultMockRecorder) ListAllAttachments() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAllAttachments", reflect.TypeOf((*MockVault)(nil).ListAllAttachments))
+}
+
+// ListAttachments mocks base method.
+func (m *MockVault) ListAttachments(entryID int64) ([]*model.Attachment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAttachments", entryID)
+	ret0, _ := ret[0].([]*model.Attachment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAttachments indicates an expected call of ListAttachments.
+func (mr *MockVaultMockRecorder) ListAttachments(entryID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAttachments", reflect.TypeOf((*MockVault)(nil).ListAttachments), entryID)
+}
+
+// ListMembers mocks base method.
+func (m *MockVault) ListMembers() ([]*model.VaultMember, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListMembers")
+	ret0, _ := ret[0].([]*model.VaultMember)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListMembers indicates an expected call of ListMembers.
+func (mr *MockVaultMockRecorder) ListMembers() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListMembers", reflect.TypeOf((*MockVault)(nil).ListMembers))
+}
+
 // ListPasswordEntries mocks base method.
 func (m *MockVault) ListPasswordEntries() ([]*model.PasswordEntry, error) {
 	m.ctrl.T.Helper()
@@ -127,6 +231,91 @@ func (mr *MockVaultMockRecorder) ListPasswordEntries() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPasswordEntries", reflect.TypeOf((*MockVault)(nil).ListPasswordEntries))
 }
 
+// ListTombstones mocks base method.
+func (m *MockVault) ListTombstones() ([]*model.Tombstone, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTombstones")
+	ret0, _ := ret[0].([]*model.Tombstone)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTombstones indicates an expected call of ListTombstones.
+func (mr *MockVaultMockRecorder) ListTombstones() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTombstones", reflect.TypeOf((*MockVault)(nil).ListTombstones))
+}
+
+// ReadAttachmentChunks mocks base method.
+func (m *MockVault) ReadAttachmentChunks(attachmentID int64, fn func(int, []byte) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadAttachmentChunks", attachmentID, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReadAttachmentChunks indicates an expected call of ReadAttachmentChunks.
+func (mr *MockVaultMockRecorder) ReadAttachmentChunks(attachmentID, fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadAttachmentChunks", reflect.TypeOf((*MockVault)(nil).ReadAttachmentChunks), attachmentID, fn)
+}
+
+// SaveAttachment mocks base method.
+func (m *MockVault) SaveAttachment(attachment *model.Attachment, next func() ([]byte, error)) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveAttachment", attachment, next)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveAttachment indicates an expected call of SaveAttachment.
+func (mr *MockVaultMockRecorder) SaveAttachment(attachment, next any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveAttachment", reflect.TypeOf((*MockVault)(nil).SaveAttachment), attachment, next)
+}
+
+// SaveMember mocks base method.
+func (m *MockVault) SaveMember(member *model.VaultMember) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveMember", member)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveMember indicates an expected call of SaveMember.
+func (mr *MockVaultMockRecorder) SaveMember(member any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMember", reflect.TypeOf((*MockVault)(nil).SaveMember), member)
+}
+
+// SaveMigration mocks base method.
+func (m *MockVault) SaveMigration(entries []*model.PasswordEntry, attachments []*model.Attachment, meta *model.VaultMetadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveMigration", entries, attachments, meta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveMigration indicates an expected call of SaveMigration.
+func (mr *MockVaultMockRecorder) SaveMigration(entries, attachments, meta any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveMigration", reflect.TypeOf((*MockVault)(nil).SaveMigration), entries, attachments, meta)
+}
+
+// SavePasswordEntries mocks base method.
+func (m *MockVault) SavePasswordEntries(entries []*model.PasswordEntry) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SavePasswordEntries", entries)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SavePasswordEntries indicates an expected call of SavePasswordEntries.
+func (mr *MockVaultMockRecorder) SavePasswordEntries(entries any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SavePasswordEntries", reflect.TypeOf((*MockVault)(nil).SavePasswordEntries), entries)
+}
+
 // SavePasswordEntry mocks base method.
 func (m *MockVault) SavePasswordEntry(entry *model.PasswordEntry) error {
 	m.ctrl.T.Helper()
@@ -141,6 +330,34 @@ func (mr *MockVaultMockRecorder) SavePasswordEntry(entry any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SavePasswordEntry", reflect.TypeOf((*MockVault)(nil).SavePasswordEntry), entry)
 }
 
+// SaveRekey mocks base method.
+func (m *MockVault) SaveRekey(entries []*model.PasswordEntry, attachments []*model.Attachment, members []*model.VaultMember, meta *model.VaultMetadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRekey", entries, attachments, members, meta)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRekey indicates an expected call of SaveRekey.
+func (mr *MockVaultMockRecorder) SaveRekey(entries, attachments, members, meta any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRekey", reflect.TypeOf((*MockVault)(nil).SaveRekey), entries, attachments, members, meta)
+}
+
+// SaveSync mocks base method.
+func (m *MockVault) SaveSync(entries []*model.PasswordEntry, tombstones []*model.Tombstone, peerID string, syncedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveSync", entries, tombstones, peerID, syncedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveSync indicates an expected call of SaveSync.
+func (mr *MockVaultMockRecorder) SaveSync(entries, tombstones, peerID, syncedAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveSync", reflect.TypeOf((*MockVault)(nil).SaveSync), entries, tombstones, peerID, syncedAt)
+}
+
 // SaveVaultMetadata mocks base method.
 func (m *MockVault) SaveVaultMetadata(v *model.VaultMetadata) error {
 	m.ctrl.T.Helper()
@@ -154,3 +371,17 @@ func (mr *MockVaultMockRecorder) SaveVaultMetadata(v any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveVaultMetadata", reflect.TypeOf((*MockVault)(nil).SaveVaultMetadata), v)
 }
+
+// TouchPasswordEntry mocks base method.
+func (m *MockVault) TouchPasswordEntry(id int64, usedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchPasswordEntry", id, usedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchPasswordEntry indicates an expected call of TouchPasswordEntry.
+func (mr *MockVaultMockRecorder) TouchPasswordEntry(id, usedAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchPasswordEntry", reflect.TypeOf((*MockVault)(nil).TouchPasswordEntry), id, usedAt)
+}
@@ -0,0 +1,332 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+const (
+	// MaxAttachmentSize is the maximum size of an attachment, in bytes.
+	MaxAttachmentSize = 10 << 20
+	// attachmentChunkSize is the size of the chunks attachments are encrypted in, in bytes.
+	attachmentChunkSize = 64 << 10
+	// attachmentKeySize is the size of the AES-256 keys of attachments, in bytes.
+	attachmentKeySize = 32
+
+	// attachmentsVersion is the first vault version whose attachment keys are bound to the UUID of their entry rather
+	// than to its service, and whose MAC authenticates the attachments.
+	attachmentsVersion = "0.0.9"
+)
+
+var (
+	// ErrAttachmentNotFound is returned when the requested attachment does not exist.
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	// ErrAttachmentExists is returned when adding an attachment with the name of an existing one.
+	ErrAttachmentExists = errors.New("attachment already exists")
+	// ErrAttachmentTooLarge is returned when adding an attachment larger than MaxAttachmentSize.
+	ErrAttachmentTooLarge = fmt.Errorf("attachment larger than %d MiB", MaxAttachmentSize>>20)
+	// ErrCorruptAttachment is returned when the chunks of an attachment are missing, reordered or modified.
+	ErrCorruptAttachment = errors.New("corrupt attachment")
+)
+
+// attachmentSecret is an attachment with its decrypted key and the UUID of its entry.
+type attachmentSecret struct {
+	attachment *model.Attachment
+	entryUUID  string
+	key        []byte
+}
+
+// AddAttachment encrypts the content of r, in chunks, and attaches it to the entry associated with the service.
+// The content is never fully held in memory, and nothing is stored if it is larger than MaxAttachmentSize.
+func (m *Manager) AddAttachment(service, name string, r io.Reader) (*model.Attachment, error) {
+	entry, err := m.getEntry(service)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("attachment name required")
+	}
+	existing, err := m.vault.GetAttachment(entry.ID, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	if existing != nil {
+		return nil, ErrAttachmentExists
+	}
+
+	key := make([]byte, attachmentKeySize)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate attachment key: %w", err)
+	}
+	gcm, err := newAttachmentGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := &model.Attachment{EntryID: entry.ID, Name: name, CreatedAt: time.Now().UTC()}
+	buf := make([]byte, attachmentChunkSize)
+	next := func() ([]byte, error) {
+		n, readErr := io.ReadFull(r, buf)
+		if n == 0 && errors.Is(readErr, io.EOF) {
+			attachment.Key, readErr = m.sealAttachmentKey(entry.UUID, attachment, key)
+			if readErr != nil {
+				return nil, readErr
+			}
+			return nil, io.EOF
+		}
+		if readErr != nil && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("failed to read attachment: %w", readErr)
+		}
+		if attachment.Size += int64(n); attachment.Size > MaxAttachmentSize {
+			return nil, ErrAttachmentTooLarge
+		}
+		chunk, sealErr := sealChunk(gcm, attachment.Chunks, buf[:n])
+		if sealErr != nil {
+			return nil, sealErr
+		}
+		attachment.Chunks++
+		return chunk, nil
+	}
+	if err = m.vault.SaveAttachment(attachment, next); err != nil {
+		return nil, fmt.Errorf("failed to save attachment: %w", err)
+	}
+	if err = m.seal(); err != nil {
+		return nil, fmt.Errorf("failed to update vault MAC: %w", err)
+	}
+	return attachment, nil
+}
+
+// ReadAttachment decrypts the attachment of the entry associated with the service to w, one chunk at a time.
+// If the attachment turns out to be corrupt, the chunks written to w so far are not authentic and must be discarded.
+func (m *Manager) ReadAttachment(service, name string, w io.Writer) error {
+	entry, attachment, err := m.getAttachment(service, name)
+	if err != nil {
+		return err
+	}
+	key, err := m.openAttachmentKey(entry.UUID, attachment)
+	if err != nil {
+		return err
+	}
+	gcm, err := newAttachmentGCM(key)
+	if err != nil {
+		return err
+	}
+
+	var chunks int
+	var size int64
+	err = m.vault.ReadAttachmentChunks(attachment.ID, func(seq int, chunk []byte) error {
+		if seq != chunks {
+			return fmt.Errorf("%w: missing chunk %d", ErrCorruptAttachment, chunks)
+		}
+		plaintext, openErr := openChunk(gcm, seq, chunk)
+		if openErr != nil {
+			return openErr
+		}
+		if _, openErr = w.Write(plaintext); openErr != nil {
+			return openErr
+		}
+		chunks++
+		size += int64(len(plaintext))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if chunks != attachment.Chunks || size != attachment.Size {
+		return fmt.Errorf("%w: expected %d bytes, read %d", ErrCorruptAttachment, attachment.Size, size)
+	}
+	return nil
+}
+
+// Attachments retrieves the attachments of the entry associated with the service, sorted by name.
+func (m *Manager) Attachments(service string) ([]*model.Attachment, error) {
+	entry, err := m.getEntry(service)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := m.vault.ListAttachments(entry.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+// DeleteAttachment removes an attachment from the entry associated with the service.
+func (m *Manager) DeleteAttachment(service, name string) error {
+	_, attachment, err := m.getAttachment(service, name)
+	if err != nil {
+		return err
+	}
+	if err = m.vault.DeleteAttachment(attachment.ID); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	if err = m.seal(); err != nil {
+		return fmt.Errorf("failed to update vault MAC: %w", err)
+	}
+	return nil
+}
+
+// getEntry retrieves the entry associated with the service as stored in the vault, without decrypting it.
+func (m *Manager) getEntry(service string) (*model.PasswordEntry, error) {
+	if !m.isUnlocked {
+		return nil, errors.New("vault is locked, please unlock the vault first")
+	}
+	entry, err := m.vault.GetPasswordEntry(service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get password entry: %w", err)
+	}
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+	return entry, nil
+}
+
+// getAttachment retrieves an attachment of the entry associated with the service by name, together with the entry.
+func (m *Manager) getAttachment(service, name string) (*model.PasswordEntry, *model.Attachment, error) {
+	entry, err := m.getEntry(service)
+	if err != nil {
+		return nil, nil, err
+	}
+	attachment, err := m.vault.GetAttachment(entry.ID, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+	if attachment == nil {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	return entry, attachment, nil
+}
+
+// attachmentSecrets retrieves the attachments of the entries with their decrypted key.
+func (m *Manager) attachmentSecrets(entries []*model.PasswordEntry) ([]*attachmentSecret, error) {
+	var secrets []*attachmentSecret
+	for _, entry := range entries {
+		attachments, err := m.vault.ListAttachments(entry.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list attachments: %w", err)
+		}
+		for _, attachment := range attachments {
+			key, err := m.openAttachmentKey(entry.UUID, attachment)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %w", entry.Service, err)
+			}
+			secrets = append(secrets, &attachmentSecret{attachment: attachment, entryUUID: entry.UUID, key: key})
+		}
+	}
+	return secrets, nil
+}
+
+// resealAttachmentKeys returns copies of the attachments of secrets with their key encrypted with the current data
+// key.
+func (m *Manager) resealAttachmentKeys(secrets []*attachmentSecret) ([]*model.Attachment, error) {
+	attachments := make([]*model.Attachment, len(secrets))
+	for i, secret := range secrets {
+		attachment := *secret.attachment
+		var err error
+		if attachment.Key, err = m.sealAttachmentKey(secret.entryUUID, &attachment, secret.key); err != nil {
+			return nil, err
+		}
+		attachments[i] = &attachment
+	}
+	return attachments, nil
+}
+
+// sealAttachmentKey encrypts the key of an attachment, bound to the UUID of its entry and to its name, size and number
+// of chunks, so that the chunks can't be truncated and the attachment can't be moved to another entry. Unlike the
+// service, the UUID of an entry never changes.
+func (m *Manager) sealAttachmentKey(entryUUID string, attachment *model.Attachment, key []byte) (string, error) {
+	encrypted, err := m.encryptField(entryUUID, attachmentField(attachment), hex.EncodeToString(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt attachment key: %w", err)
+	}
+	return encrypted, nil
+}
+
+// openAttachmentKey decrypts the key of an attachment encrypted with sealAttachmentKey.
+func (m *Manager) openAttachmentKey(entryUUID string, attachment *model.Attachment) ([]byte, error) {
+	encoded, err := m.decryptField(entryUUID, attachmentField(attachment), attachment.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decrypt the key of %s: %w", ErrCorruptAttachment, attachment.Name, err)
+	}
+	return hex.DecodeString(encoded)
+}
+
+// bindAttachmentKeys re-encrypts the key of every attachment created before attachmentsVersion, bound to the service
+// of its entry, binding it to the UUID of the entry instead. The keys are saved together with the new version and the
+// MAC now authenticating the attachments, see commitMigration.
+func (m *Manager) bindAttachmentKeys() error {
+	entries, err := m.vault.ListPasswordEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list password entries: %w", err)
+	}
+	attachments, err := m.vault.ListAllAttachments()
+	if err != nil {
+		return fmt.Errorf("failed to list attachments: %w", err)
+	}
+	byID := make(map[int64]*model.PasswordEntry, len(entries))
+	for _, entry := range entries {
+		byID[entry.ID] = entry
+	}
+	for _, attachment := range attachments {
+		entry := byID[attachment.EntryID]
+		if entry == nil {
+			return fmt.Errorf("%w: attachment %s of a missing entry", ErrCorruptAttachment, attachment.Name)
+		}
+		encoded, openErr := m.decryptField(entry.Service, attachmentField(attachment), attachment.Key)
+		if openErr != nil {
+			return fmt.Errorf("service %s: %w: failed to decrypt the key of %s: %w", entry.Service,
+				ErrCorruptAttachment, attachment.Name, openErr)
+		}
+		if attachment.Key, err = m.encryptField(entry.UUID, attachmentField(attachment), encoded); err != nil {
+			return fmt.Errorf("failed to encrypt attachment key: %w", err)
+		}
+	}
+	return m.commitMigration(attachmentsVersion, entries, nil, attachments)
+}
+
+// attachmentField returns the field name of the key of an attachment in the additional authenticated data.
+func attachmentField(attachment *model.Attachment) string {
+	return "attachment:" + strconv.FormatInt(attachment.Size, 10) + ":" + strconv.Itoa(attachment.Chunks) + ":" +
+		attachment.Name
+}
+
+// newAttachmentGCM returns the AES-GCM cipher of an attachment key.
+func newAttachmentGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealChunk encrypts a chunk of an attachment, prefixed by its nonce and bound to its position.
+func sealChunk(gcm cipher.AEAD, seq int, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, binary.BigEndian.AppendUint64(nil, uint64(seq))), nil
+}
+
+// openChunk decrypts a chunk of an attachment encrypted with sealChunk.
+func openChunk(gcm cipher.AEAD, seq int, chunk []byte) ([]byte, error) {
+	if len(chunk) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: chunk %d too short", ErrCorruptAttachment, seq)
+	}
+	nonce, ciphertext := chunk[:gcm.NonceSize()], chunk[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, binary.BigEndian.AppendUint64(nil, uint64(seq)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %d: %w", ErrCorruptAttachment, seq, err)
+	}
+	return plaintext, nil
+}
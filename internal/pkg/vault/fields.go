@@ -0,0 +1,133 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/otp"
+)
+
+// Names of the entry fields that can be read with ReadField.
+const (
+	FieldPassword = "password"
+	FieldUsername = "username"
+	FieldURL      = "url"
+	FieldNotes    = "notes"
+	// FieldOTP reads the current code of the OTP secret of the entry, see OTPCode.
+	FieldOTP = "otp"
+)
+
+// dateLayout is the format of the values of model.FieldTypeDate fields.
+const dateLayout = "2006-01-02"
+
+var (
+	// ErrUnknownField is returned by ReadField when the entry has no field with the requested name.
+	ErrUnknownField = errors.New("unknown entry field")
+	// ErrInvalidField is returned when saving an entry whose custom fields are malformed.
+	ErrInvalidField = errors.New("invalid entry field")
+)
+
+// ReadField retrieves the value of a single field of the entry associated with the service: either a built-in
+// field or a custom one. The current code is returned for model.FieldTypeTOTP fields, and the QR code string for the
+// FieldWiFiQR field of Wi-Fi networks.
+func (m *Manager) ReadField(service, field string) (string, error) {
+	if field == FieldOTP {
+		code, _, err := m.OTPCode(service, time.Now())
+		return code, err
+	}
+	entry, err := m.Read(service)
+	if err != nil {
+		return "", err
+	}
+	switch field {
+	case FieldPassword:
+		return entry.Password, nil
+	case FieldUsername:
+		return entry.Username, nil
+	case FieldURL:
+		return entry.URL, nil
+	case FieldNotes:
+		return entry.Notes, nil
+	}
+	for _, custom := range entry.Fields {
+		if custom.Name != field {
+			continue
+		}
+		if custom.Type != model.FieldTypeTOTP {
+			return custom.Value, nil
+		}
+		key, err := otp.Parse(custom.Value)
+		if err != nil {
+			return "", err
+		}
+		code, _ := key.TOTP(time.Now())
+		return code, nil
+	}
+	if entry.Kind == model.KindWiFi && field == FieldWiFiQR {
+		return WiFiQR(entry), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownField, field)
+}
+
+// validateFields checks the names, types and values of the custom fields of entry. The values of
+// model.FieldTypeTOTP fields are normalized to an otpauth:// URI.
+func validateFields(entry *model.PasswordEntry) error {
+	reserved := []string{FieldPassword, FieldUsername, FieldURL, FieldNotes, FieldOTP}
+	names := make(map[string]bool, len(entry.Fields))
+	for i := range entry.Fields {
+		field := &entry.Fields[i]
+		switch {
+		case field.Name == "":
+			return fmt.Errorf("%w: missing name", ErrInvalidField)
+		case slices.Contains(reserved, field.Name):
+			return fmt.Errorf("%w: %s is a built-in field", ErrInvalidField, field.Name)
+		case names[field.Name]:
+			return fmt.Errorf("%w: duplicate field %s", ErrInvalidField, field.Name)
+		}
+		names[field.Name] = true
+		if err := validateFieldValue(entry.Service, field); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidField, field.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateFieldValue checks the value of field against its type.
+func validateFieldValue(service string, field *model.EntryField) error {
+	switch field.Type {
+	case model.FieldTypeText, model.FieldTypeHidden:
+		return nil
+	case model.FieldTypeURL:
+		u, err := url.Parse(field.Value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%q is not an absolute URL", field.Value)
+		}
+		return nil
+	case model.FieldTypeEmail:
+		if _, err := mail.ParseAddress(field.Value); err != nil {
+			return fmt.Errorf("%q is not an email address", field.Value)
+		}
+		return nil
+	case model.FieldTypeDate:
+		if _, err := time.Parse(dateLayout, field.Value); err != nil {
+			return fmt.Errorf("%q is not a YYYY-MM-DD date", field.Value)
+		}
+		return nil
+	case model.FieldTypeTOTP:
+		key, err := otp.ParseSecret(service, field.Value)
+		if err != nil {
+			return err
+		}
+		if key.Type != otp.TypeTOTP {
+			return errors.New("only TOTP secrets are supported")
+		}
+		field.Value = key.URI()
+		return nil
+	}
+	return fmt.Errorf("unknown type %q", field.Type)
+}
@@ -0,0 +1,90 @@
+package vault
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/share"
+)
+
+// identityVersion is the first vault version with an identity keypair, used to share entries.
+const identityVersion = "0.0.6"
+
+// identityField is the name of the identity key in the additional authenticated data.
+// It is encrypted like an entry field with an empty service, which no entry can have.
+const identityField = "identity_key"
+
+// assignIdentity generates the X25519 identity keypair of vaults created before version 0.0.6 and stores its
+// private key encrypted in the vault metadata.
+// The metadata is saved by the caller once every migration has been applied.
+func (m *Manager) assignIdentity() error {
+	if m.meta.IdentityKey != "" {
+		return nil
+	}
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate identity key: %w", err)
+	}
+	m.meta.IdentityKey, err = m.encryptField("", identityField, hex.EncodeToString(key.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt identity key: %w", err)
+	}
+	return nil
+}
+
+// identity decrypts the identity private key of the unlocked vault.
+func (m *Manager) identity() (*ecdh.PrivateKey, error) {
+	if !m.isUnlocked {
+		return nil, errors.New("vault is locked, please unlock the vault first")
+	}
+	encoded, err := m.decryptField("", identityField, m.meta.IdentityKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt identity key: %w", err)
+	}
+	raw, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity key: %w", err)
+	}
+	return key, nil
+}
+
+// IdentityPublicKey returns the identity public key of the unlocked vault, given to the people sharing entries
+// with it.
+func (m *Manager) IdentityPublicKey() (*ecdh.PublicKey, error) {
+	key, err := m.identity()
+	if err != nil {
+		return nil, err
+	}
+	return key.PublicKey(), nil
+}
+
+// ShareEntry seals the entry of the service for the vault with the identity public key recipient, see share.Seal.
+func (m *Manager) ShareEntry(service string, recipient *ecdh.PublicKey) ([]byte, error) {
+	key, err := m.identity()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := m.Read(service)
+	if err != nil {
+		return nil, err
+	}
+	return share.Seal(key, recipient, entry)
+}
+
+// OpenSharedEntry opens an entry shared with this vault, see share.Open. The entry isn't added to the vault.
+// It returns the entry and the identity public key of the vault that shared it.
+func (m *Manager) OpenSharedEntry(data []byte) (*model.PasswordEntry, *ecdh.PublicKey, error) {
+	key, err := m.identity()
+	if err != nil {
+		return nil, nil, err
+	}
+	return share.Open(key, data)
+}
@@ -0,0 +1,215 @@
+package vault
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// macVersion is the first vault version whose metadata is authenticated with a MAC.
+const macVersion = "0.0.3"
+
+// macKeyInfo is the HKDF info used to derive the MAC key from the master key.
+const macKeyInfo = "psst-vault-mac"
+
+// ErrIntegrity is returned by Unlock when the vault metadata or its entries have been modified outside psst,
+// for example by editing the SQLite file offline to roll back or delete entries.
+var ErrIntegrity = errors.New("vault integrity check failed")
+
+// versionMarkers are the metadata introduced by the versions from macVersion on, paired with their version.
+// They are only saved by seal, together with the version they belong to, so that even an interrupted migration
+// can't leave them in a vault claiming an older version. The vault ID isn't one of them: it is saved by the
+// migration to version 0.0.2 before the version itself.
+var versionMarkers = []struct {
+	set     func(meta *model.VaultMetadata) bool
+	name    string
+	version string
+}{
+	{name: "MAC", version: macVersion, set: func(meta *model.VaultMetadata) bool { return meta.MAC != "" }},
+	{name: "device ID", version: deviceVersion, set: func(meta *model.VaultMetadata) bool { return meta.DeviceID != "" }},
+	{
+		name:    "identity key",
+		version: identityVersion,
+		set:     func(meta *model.VaultMetadata) bool { return meta.IdentityKey != "" },
+	},
+	{name: "members", version: membersVersion, set: func(meta *model.VaultMetadata) bool { return meta.MasterHash == "" }},
+}
+
+// verifyIntegrity checks the MAC stored in the vault metadata against the current metadata and entries.
+// Vaults older than macVersion don't have a MAC yet, one is computed by seal once they are migrated. A vault claiming
+// such a version while having metadata of a later one has been rolled back to skip the check, and is refused.
+func (m *Manager) verifyIntegrity() error {
+	for _, marker := range versionMarkers {
+		if versionLess(m.meta.Version, marker.version) && marker.set(m.meta) {
+			return fmt.Errorf("%w: vault version %s predates its %s", ErrIntegrity, m.meta.Version, marker.name)
+		}
+	}
+	if versionLess(m.meta.Version, macVersion) {
+		return nil
+	}
+	if m.meta.MAC == "" {
+		return fmt.Errorf("%w: missing vault MAC", ErrIntegrity)
+	}
+	expected, err := hex.DecodeString(m.meta.MAC)
+	if err != nil {
+		return fmt.Errorf("%w: invalid vault MAC", ErrIntegrity)
+	}
+
+	mac, err := m.currentMAC()
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, expected) {
+		return fmt.Errorf("%w: vault metadata or entries have been modified", ErrIntegrity)
+	}
+	return nil
+}
+
+// seal computes the MAC over the current metadata and entries and saves the vault metadata.
+// It must be called after every change to the vault.
+func (m *Manager) seal() error {
+	mac, err := m.currentMAC()
+	if err != nil {
+		return err
+	}
+	m.meta.MAC = hex.EncodeToString(mac)
+
+	return m.vault.SaveVaultMetadata(m.meta)
+}
+
+// currentMAC computes the MAC of the vault as currently stored.
+// Tombstones are only authenticated from syncVersion on, attachments from attachmentsVersion on.
+func (m *Manager) currentMAC() ([]byte, error) {
+	entries, err := m.vault.ListPasswordEntries()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list password entries: %w", err)
+	}
+	var tombstones []*model.Tombstone
+	if !versionLess(m.meta.Version, syncVersion) {
+		if tombstones, err = m.vault.ListTombstones(); err != nil {
+			return nil, fmt.Errorf("failed to list tombstones: %w", err)
+		}
+	}
+	var attachments []*model.Attachment
+	if !versionLess(m.meta.Version, attachmentsVersion) {
+		if attachments, err = m.vault.ListAllAttachments(); err != nil {
+			return nil, fmt.Errorf("failed to list attachments: %w", err)
+		}
+	}
+	return m.computeMAC(entries, attachments, tombstones)
+}
+
+// computeMAC computes an HMAC-SHA256 over every metadata key, the set of entries with their attachments and the set of
+// tombstones, as stored in the vault. Entries are authenticated through their digest, see entryDigest.
+// From syncVersion on, the digest includes the entry UUID.
+func (m *Manager) computeMAC(
+	entries []*model.PasswordEntry, attachments []*model.Attachment, tombstones []*model.Tombstone,
+) ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, m.masterKey, nil, []byte(macKeyInfo)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive MAC key: %w", err)
+	}
+
+	h := hmac.New(sha256.New, key)
+	for _, kv := range metadataFields(m.meta) {
+		writeMACField(h, kv[0])
+		writeMACField(h, kv[1])
+	}
+
+	sorted := slices.Clone(entries)
+	slices.SortFunc(sorted, func(a, b *model.PasswordEntry) int {
+		return strings.Compare(a.Service, b.Service)
+	})
+	attachmentsOf := make(map[int64][]*model.Attachment)
+	for _, attachment := range attachments {
+		attachmentsOf[attachment.EntryID] = append(attachmentsOf[attachment.EntryID], attachment)
+	}
+	withUUID := !versionLess(m.meta.Version, syncVersion)
+	for _, entry := range sorted {
+		writeMACField(h, "entry")
+		h.Write(entryDigest(entry, attachmentsOf[entry.ID], withUUID))
+	}
+
+	sortedTombstones := slices.Clone(tombstones)
+	slices.SortFunc(sortedTombstones, func(a, b *model.Tombstone) int {
+		return strings.Compare(a.UUID, b.UUID)
+	})
+	for _, tombstone := range sortedTombstones {
+		writeMACField(h, "tombstone")
+		writeMACField(h, tombstone.UUID)
+		writeMACField(h, tombstone.Service)
+		writeMACField(h, tombstone.DeletedAt.UTC().Format(time.RFC3339Nano))
+	}
+	return h.Sum(nil), nil
+}
+
+// metadataFields returns the authenticated metadata as sorted key-value pairs.
+// Empty values are skipped, so that keys introduced by later versions don't change the MAC of older vaults.
+func metadataFields(meta *model.VaultMetadata) [][2]string {
+	fields := [][2]string{
+		{"created_at", meta.CreatedAt.UTC().Format(time.RFC3339Nano)},
+		{"device_id", meta.DeviceID},
+		{"identity_key", meta.IdentityKey},
+		{"last_access", meta.LastAccess.UTC().Format(time.RFC3339Nano)},
+		{"master_hash", meta.MasterHash},
+		{"vault_id", meta.VaultID},
+		{"version", meta.Version},
+	}
+	return slices.DeleteFunc(fields, func(kv [2]string) bool { return kv[1] == "" })
+}
+
+// entryDigest returns the SHA-256 digest of the stored fields of an entry, including its password ciphertext, and of
+// its attachments: their name, size, number of chunks and sealed key, which authenticates the chunks in turn.
+// The UUID is only part of the digest if withUUID is true, and the kind, the OTP secret, the custom fields and the
+// attachments only if set, so that the digests of older vaults don't change.
+func entryDigest(entry *model.PasswordEntry, attachments []*model.Attachment, withUUID bool) []byte {
+	h := sha256.New()
+	fields := []string{entry.Service, entry.Username, entry.Password, entry.URL, entry.Notes}
+	if withUUID {
+		fields = append(fields, entry.UUID)
+	}
+	if entry.Kind != "" {
+		fields = append(fields, "kind", entry.Kind)
+	}
+	if entry.OTP != "" {
+		fields = append(fields, "otp", entry.OTP)
+	}
+	for _, field := range entry.Fields {
+		fields = append(fields, "field", field.Name, field.Type, field.Value)
+	}
+	sortedAttachments := slices.Clone(attachments)
+	slices.SortFunc(sortedAttachments, func(a, b *model.Attachment) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, attachment := range sortedAttachments {
+		fields = append(fields, "attachment", attachment.Name, strconv.FormatInt(attachment.Size, 10),
+			strconv.Itoa(attachment.Chunks), attachment.Key)
+	}
+	for _, field := range fields {
+		writeMACField(h, field)
+	}
+	tags := slices.Clone(entry.Tags)
+	slices.Sort(tags)
+	for _, tag := range tags {
+		writeMACField(h, tag)
+	}
+	return h.Sum(nil)
+}
+
+// writeMACField writes a length-prefixed field to w.
+func writeMACField(w io.Writer, field string) {
+	_, _ = w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(field))))
+	_, _ = io.WriteString(w, field)
+}
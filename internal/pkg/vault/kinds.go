@@ -0,0 +1,325 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// Names of the fields of the entries of the kinds other than logins.
+const (
+	// FieldNoteBody is the body of a model.KindNote entry, a hidden field so that it's stored encrypted.
+	FieldNoteBody       = "body"
+	FieldCardNumber     = "number"
+	FieldCardExpiry     = "expiry"
+	FieldCardCVV        = "cvv"
+	FieldCardHolder     = "holder"
+	FieldIdentityName   = "name"
+	FieldWiFiSSID       = "ssid"
+	FieldWiFiSecurity   = "security"
+	FieldWiFiHiddenSSID = "hidden-ssid"
+	// FieldWiFiQR reads the Wi-Fi QR code string of a model.KindWiFi entry, see WiFiQR.
+	FieldWiFiQR = "qr"
+)
+
+// Security of Wi-Fi networks, as encoded in Wi-Fi QR codes.
+const (
+	WiFiWPA    = "WPA"
+	WiFiWEP    = "WEP"
+	WiFiNoPass = "nopass"
+)
+
+// notesVersion is the first vault version storing the body of notes in the encrypted FieldNoteBody field rather than
+// in their plain text notes.
+const notesVersion = "0.0.8"
+
+// ErrInvalidEntry is returned when saving an entry that doesn't match the schema of its kind.
+var ErrInvalidEntry = errors.New("invalid entry")
+
+// SchemaField is a field of the entries of a kind.
+type SchemaField struct {
+	Name string
+	// Type is one of the model.FieldType constants.
+	Type     string
+	Required bool
+}
+
+// Schema describes the entries of a kind: the custom fields they are made of and whether they have a password.
+// Entries may have other custom fields than those of their schema.
+type Schema struct {
+	// validate checks, and normalizes, the entries of the kind beyond the fields of the schema.
+	validate func(entry *model.PasswordEntry) error
+	// Name is the name of the kind on the command line.
+	Name string
+	// Kind is the model.PasswordEntry Kind of the entries.
+	Kind   string
+	Fields []SchemaField
+	// Password reports whether the entries have a password.
+	Password bool
+}
+
+// schemas are the schemas of the known kinds, logins first.
+var schemas = []*Schema{
+	{Name: "login", Password: true},
+	{
+		Name:     model.KindNote,
+		Kind:     model.KindNote,
+		Fields:   []SchemaField{{Name: FieldNoteBody, Type: model.FieldTypeHidden, Required: true}},
+		validate: validateNote,
+	},
+	{
+		Name: model.KindCard,
+		Kind: model.KindCard,
+		Fields: []SchemaField{
+			{Name: FieldCardNumber, Type: model.FieldTypeHidden, Required: true},
+			{Name: FieldCardExpiry, Type: model.FieldTypeText, Required: true},
+			{Name: FieldCardCVV, Type: model.FieldTypeHidden},
+			{Name: FieldCardHolder, Type: model.FieldTypeText},
+		},
+		validate: validateCard,
+	},
+	{
+		Name: model.KindIdentity,
+		Kind: model.KindIdentity,
+		Fields: []SchemaField{
+			{Name: FieldIdentityName, Type: model.FieldTypeText, Required: true},
+			{Name: "address", Type: model.FieldTypeText},
+			{Name: "email", Type: model.FieldTypeEmail},
+			{Name: "phone", Type: model.FieldTypeText},
+			{Name: "birthdate", Type: model.FieldTypeDate},
+		},
+	},
+	{
+		Name: model.KindWiFi,
+		Kind: model.KindWiFi,
+		Fields: []SchemaField{
+			{Name: FieldWiFiSSID, Type: model.FieldTypeText, Required: true},
+			{Name: FieldWiFiSecurity, Type: model.FieldTypeText},
+			{Name: FieldWiFiHiddenSSID, Type: model.FieldTypeText},
+		},
+		Password: true,
+		validate: validateWiFi,
+	},
+	{Name: model.KindSSHKey, Kind: model.KindSSHKey, Password: true},
+}
+
+// Schemas returns the schemas of the known kinds, logins first.
+func Schemas() []*Schema {
+	return slices.Clone(schemas)
+}
+
+// SchemaOf returns the schema of the entries of kind, or nil if the kind is unknown.
+func SchemaOf(kind string) *Schema {
+	i := slices.IndexFunc(schemas, func(s *Schema) bool { return s.Kind == kind })
+	if i < 0 {
+		return nil
+	}
+	return schemas[i]
+}
+
+// SchemaNamed returns the schema of the kind with the given name on the command line, or nil if unknown.
+func SchemaNamed(name string) *Schema {
+	i := slices.IndexFunc(schemas, func(s *Schema) bool { return s.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return schemas[i]
+}
+
+// Field returns the field of the schema with the given name, or nil if the schema has none.
+func (s *Schema) Field(name string) *SchemaField {
+	i := slices.IndexFunc(s.Fields, func(f SchemaField) bool { return f.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return &s.Fields[i]
+}
+
+// NeedsPassword reports whether the entry requires a password: Wi-Fi networks without security have none.
+func (s *Schema) NeedsPassword(entry *model.PasswordEntry) bool {
+	if s.Kind == model.KindWiFi {
+		return wifiSecurity(entry) != WiFiNoPass
+	}
+	return s.Password
+}
+
+// validateKind checks that the entry matches the schema of its kind, normalizing its fields.
+func validateKind(entry *model.PasswordEntry) error {
+	schema := SchemaOf(entry.Kind)
+	if schema == nil {
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidEntry, entry.Kind)
+	}
+	if !schema.Password && entry.Password != "" {
+		return fmt.Errorf("%w: a %s has no password", ErrInvalidEntry, schema.Name)
+	}
+	for _, field := range schema.Fields {
+		value := fieldOf(entry, field.Name)
+		switch {
+		case value == nil && field.Required:
+			return fmt.Errorf("%w: a %s needs the %s field", ErrInvalidEntry, schema.Name, field.Name)
+		case value != nil && value.Type != field.Type:
+			return fmt.Errorf("%w: the %s field of a %s must be of type %s", ErrInvalidEntry, field.Name, schema.Name,
+				field.Type)
+		}
+	}
+	if schema.validate == nil {
+		return nil
+	}
+	if err := schema.validate(entry); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEntry, err)
+	}
+	return nil
+}
+
+// fieldOf returns the custom field of the entry with the given name, or nil if the entry has none.
+func fieldOf(entry *model.PasswordEntry, name string) *model.EntryField {
+	i := slices.IndexFunc(entry.Fields, func(f model.EntryField) bool { return f.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return &entry.Fields[i]
+}
+
+// validateNote checks that a secure note has a body.
+func validateNote(entry *model.PasswordEntry) error {
+	if strings.TrimSpace(fieldOf(entry, FieldNoteBody).Value) == "" {
+		return errors.New("a note needs a body")
+	}
+	return nil
+}
+
+// validateCard checks the number, with the Luhn algorithm, the expiry and the security code of a card, normalizing
+// the number to its digits and the expiry to MM/YY.
+func validateCard(entry *model.PasswordEntry) error {
+	number := fieldOf(entry, FieldCardNumber)
+	number.Value = strings.NewReplacer(" ", "", "-", "").Replace(number.Value)
+	if len(number.Value) < 12 || len(number.Value) > 19 || !isDigits(number.Value) || !luhn(number.Value) {
+		return errors.New("invalid card number")
+	}
+
+	expiry := fieldOf(entry, FieldCardExpiry)
+	parsed, err := parseExpiry(expiry.Value)
+	if err != nil {
+		return err
+	}
+	expiry.Value = parsed.Format("01/06")
+
+	if cvv := fieldOf(entry, FieldCardCVV); cvv != nil && (len(cvv.Value) < 3 || len(cvv.Value) > 4 ||
+		!isDigits(cvv.Value)) {
+		return errors.New("the security code of a card must be 3 or 4 digits")
+	}
+	return nil
+}
+
+// parseExpiry parses the expiry of a card, formatted as MM/YY, MM/YYYY or YYYY-MM.
+func parseExpiry(expiry string) (time.Time, error) {
+	for _, layout := range []string{"01/06", "1/06", "01/2006", "1/2006", "2006-01"} {
+		if t, err := time.Parse(layout, strings.ReplaceAll(expiry, " ", "")); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid card expiry %q, expected MM/YY", expiry)
+}
+
+// luhn reports whether a number of digits has a valid Luhn check digit.
+func luhn(digits string) bool {
+	var sum int
+	for i := range len(digits) {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// isDigits reports whether s only contains ASCII digits.
+func isDigits(s string) bool {
+	return strings.Trim(s, "0123456789") == ""
+}
+
+// validateWiFi checks the security of a Wi-Fi network, normalizing it, and that secured networks have a password.
+func validateWiFi(entry *model.PasswordEntry) error {
+	if security := fieldOf(entry, FieldWiFiSecurity); security != nil {
+		switch strings.ToUpper(security.Value) {
+		case "WPA", "WPA2", "WPA3":
+			security.Value = WiFiWPA
+		case WiFiWEP:
+			security.Value = WiFiWEP
+		case "NOPASS", "NONE", "OPEN":
+			security.Value = WiFiNoPass
+		default:
+			return fmt.Errorf("unknown Wi-Fi security %q, expected %s, %s or %s", security.Value, WiFiWPA, WiFiWEP,
+				WiFiNoPass)
+		}
+	}
+	if hidden := fieldOf(entry, FieldWiFiHiddenSSID); hidden != nil && hidden.Value != "true" &&
+		hidden.Value != "false" {
+		return fmt.Errorf("the %s field must be true or false", FieldWiFiHiddenSSID)
+	}
+	open := wifiSecurity(entry) == WiFiNoPass
+	if open && entry.Password != "" {
+		return errors.New("a Wi-Fi network without security has no password")
+	}
+	if !open && entry.Password == "" {
+		return errors.New("a secured Wi-Fi network needs a password")
+	}
+	return nil
+}
+
+// wifiSecurity returns the security of a Wi-Fi network, WPA unless set.
+func wifiSecurity(entry *model.PasswordEntry) string {
+	if security := fieldOf(entry, FieldWiFiSecurity); security != nil {
+		return security.Value
+	}
+	return WiFiWPA
+}
+
+// WiFiQR returns the string encoded in the QR codes phones scan to join the Wi-Fi network of a model.KindWiFi
+// entry, as in WIFI:T:WPA;S:ssid;P:password;;.
+func WiFiQR(entry *model.PasswordEntry) string {
+	escape := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `"`, `\"`, `:`, `\:`).Replace
+	var b strings.Builder
+	b.WriteString("WIFI:T:" + wifiSecurity(entry) + ";")
+	if ssid := fieldOf(entry, FieldWiFiSSID); ssid != nil {
+		b.WriteString("S:" + escape(ssid.Value) + ";")
+	}
+	if entry.Password != "" {
+		b.WriteString("P:" + escape(entry.Password) + ";")
+	}
+	if hidden := fieldOf(entry, FieldWiFiHiddenSSID); hidden != nil && hidden.Value == "true" {
+		b.WriteString("H:true;")
+	}
+	b.WriteString(";")
+	return b.String()
+}
+
+// encryptNoteBodies moves the body of the notes created before notesVersion from their plain text notes to their
+// encrypted FieldNoteBody field. The notes are saved together with the new version, see commitMigration.
+func (m *Manager) encryptNoteBodies() error {
+	entries, err := m.vault.ListPasswordEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list password entries: %w", err)
+	}
+	var notes []*model.PasswordEntry
+	for _, entry := range entries {
+		if entry.Kind != model.KindNote || entry.Notes == "" || fieldOf(entry, FieldNoteBody) != nil {
+			continue
+		}
+		body, err := m.encryptField(entry.Service, customFieldPrefix+FieldNoteBody, entry.Notes)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt the body of note %s: %w", entry.Service, err)
+		}
+		entry.Fields = append(entry.Fields, model.EntryField{Name: FieldNoteBody, Type: model.FieldTypeHidden, Value: body})
+		entry.Notes = ""
+		notes = append(notes, entry)
+	}
+	return m.commitMigration(notesVersion, entries, notes, nil)
+}
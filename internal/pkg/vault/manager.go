@@ -6,16 +6,41 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
 )
 
+const (
+	// vaultVersion is the version of newly initialized vaults.
+	// Vaults with an older version are migrated when unlocked, see migrations.
+	vaultVersion = "0.0.9"
+
+	// ciphertextV1Prefix prefixes ciphertexts bound to their entry through additional authenticated data.
+	ciphertextV1Prefix = "v1:"
+	// aadDomain separates the additional authenticated data of entry fields from any other use of the master key.
+	aadDomain = "psst-entry-field"
+
+	// passwordField is the name of the password field used in the additional authenticated data.
+	passwordField = "password"
+	// otpField is the name of the OTP secret field used in the additional authenticated data.
+	otpField = "otp"
+	// customFieldPrefix prefixes the names of the custom fields in the additional authenticated data, so that they
+	// can't collide with the built-in fields.
+	customFieldPrefix = "field:"
+)
+
+// ErrNotFound is returned when the requested password entry does not exist in the vault.
+var ErrNotFound = errors.New("password entry not found")
+
 // Manager represents a vault manager, which can be used to perform CRUD operations on a vault.
 // A vault is an abstraction of the underlying database.
 //
@@ -24,10 +49,13 @@ import (
 // The vault status can be checked by calling IsUnlocked(), the status can be changed by calling Lock() or Unlock().
 // Locking the vault manager is useful when the vault manager is no longer needed.
 type Manager struct {
-	vault      Vault
-	meta       *model.VaultMetadata
-	masterKey  []byte
-	isUnlocked bool
+	vault  Vault
+	mirror Mirror
+	meta   *model.VaultMetadata
+	// unlockPassword is the password the vault is being unlocked with, only set while migrating it.
+	unlockPassword string
+	masterKey      []byte
+	isUnlocked     bool
 }
 
 // Vault defines the methods to perform CRUD operations on the underlying database.
@@ -35,12 +63,52 @@ type Manager struct {
 type Vault interface {
 	// SavePasswordEntry creates or updates a new password entry to the vault.
 	SavePasswordEntry(entry *model.PasswordEntry) error
+	// SavePasswordEntries creates or updates the password entries in a single transaction.
+	SavePasswordEntries(entries []*model.PasswordEntry) error
 	// GetPasswordEntry retrieves a password entry from the vault.
 	GetPasswordEntry(service string) (*model.PasswordEntry, error)
+	// TouchPasswordEntry sets the last time the password entry with the given ID was used, without modifying it.
+	TouchPasswordEntry(id int64, usedAt time.Time) error
 	// ListPasswordEntries retrieves all password entries from the vault.
 	ListPasswordEntries() ([]*model.PasswordEntry, error)
-	// DeletePasswordEntry deletes a password entry from the vault.
+	// DeletePasswordEntry deletes a password entry from the vault, recording a tombstone.
 	DeletePasswordEntry(service string) error
+	// ListTombstones retrieves the tombstones of the deleted entries.
+	ListTombstones() ([]*model.Tombstone, error)
+	// SaveSync deletes the entries with the given tombstones and saves the entries, matched by UUID, in a single
+	// transaction, recording syncedAt as the last time the vault with the device ID peerID was merged.
+	SaveSync(entries []*model.PasswordEntry, tombstones []*model.Tombstone, peerID string, syncedAt time.Time) error
+	// GetLastSync retrieves the last time the vault with the device ID peerID was merged.
+	GetLastSync(peerID string) (time.Time, error)
+	// SaveMember creates or replaces a vault member, identified by its name.
+	SaveMember(member *model.VaultMember) error
+	// ListMembers retrieves the vault members, sorted by name.
+	ListMembers() ([]*model.VaultMember, error)
+	// DeleteMember deletes the vault member with the given name.
+	DeleteMember(name string) error
+	// SaveAttachment stores a new attachment in a single transaction, calling next for its encrypted chunks until
+	// it returns io.EOF, after setting the size, the number of chunks and the key of the attachment.
+	SaveAttachment(attachment *model.Attachment, next func() ([]byte, error)) error
+	// GetAttachment retrieves an attachment of an entry by name, or nil if it does not exist.
+	GetAttachment(entryID int64, name string) (*model.Attachment, error)
+	// ListAttachments retrieves the attachments of an entry, sorted by name.
+	ListAttachments(entryID int64) ([]*model.Attachment, error)
+	// ListAllAttachments retrieves the attachments of every entry, without their chunks.
+	ListAllAttachments() ([]*model.Attachment, error)
+	// ReadAttachmentChunks calls fn with the encrypted chunks of an attachment, in order, one at a time.
+	ReadAttachmentChunks(attachmentID int64, fn func(seq int, chunk []byte) error) error
+	// DeleteAttachment deletes an attachment and its chunks.
+	DeleteAttachment(attachmentID int64) error
+	// SaveRekey replaces the password, the OTP secret and the fields of every entry and the key of every attachment,
+	// matched by ID, the vault members and the vault metadata in a single transaction, after the vault data key was
+	// rotated.
+	SaveRekey(
+		entries []*model.PasswordEntry, attachments []*model.Attachment, members []*model.VaultMember,
+		meta *model.VaultMetadata,
+	) error
+	// SaveMigration updates the entries and the attachment keys rewritten by a migration and the vault metadata,
+	// holding the migrated version and its MAC, in a single transaction.
+	SaveMigration(entries []*model.PasswordEntry, attachments []*model.Attachment, meta *model.VaultMetadata) error
 	// SaveVaultMetadata creates or updates the vault metadata.
 	SaveVaultMetadata(v *model.VaultMetadata) error
 	// GetVaultMetadata retrieves the vault metadata.
@@ -71,12 +139,13 @@ func (m *Manager) Close() {
 	m.Lock()
 }
 
-// Unlock unlocks the vault using the masterPassword.
+// Unlock unlocks the vault using the masterPassword of one of its password members.
 // If the vault is already unlocked, Unlock returns true and no error.
 // The vault can be locked again using Lock.
 //
 // Unlock returns true if the vault was unlocked successfully, false otherwise.
 // If any error occurs, it is returned to the caller.
+// If the vault has been modified outside psst, the vault stays locked and an error wrapping ErrIntegrity is returned.
 func (m *Manager) Unlock(masterPassword string) (bool, error) {
 	if m.isUnlocked {
 		return true, nil
@@ -86,25 +155,60 @@ func (m *Manager) Unlock(masterPassword string) (bool, error) {
 		return false, fmt.Errorf("failed to get vault metadata: %w", err)
 	}
 
-	parts := splitHash(metadata.MasterHash)
+	var key []byte
+	if metadata.MasterHash != "" {
+		key, err = unlockLegacy(masterPassword, metadata.MasterHash)
+	} else {
+		key, err = m.unlockPasswordMember(masterPassword, metadata.VaultID)
+	}
+	if err != nil || key == nil {
+		return false, err
+	}
+
+	// The principal password of vaults created before membersVersion becomes the password of their first member.
+	m.unlockPassword = masterPassword
+	defer func() { m.unlockPassword = "" }()
+	return m.unlockWithKey(metadata, key)
+}
+
+// unlockLegacy verifies the principal password of vaults created before membersVersion against their master hash
+// and returns the key derived from it, or nil if the password is incorrect.
+func unlockLegacy(masterPassword, masterHash string) ([]byte, error) {
+	parts := splitHash(masterHash)
 	if parts == nil {
-		return false, errors.New("invalid master hash format")
+		return nil, errors.New("invalid master hash format")
 	}
 
 	// Verify password
 	_, key := hashPassword(masterPassword, parts.salt)
-	if !verifyPassword(masterPassword, metadata.MasterHash) {
-		return false, nil // Password incorrect
+	if !verifyPassword(masterPassword, masterHash) {
+		return nil, nil // Password incorrect
 	}
+	return key, nil
+}
 
+// unlockWithKey unlocks the vault with its data key, verifies its integrity and migrates it.
+func (m *Manager) unlockWithKey(metadata *model.VaultMetadata, key []byte) (bool, error) {
 	// Unlock vault
 	m.meta = metadata
-	m.meta.LastAccess = time.Now().UTC()
 	m.masterKey = key
 	m.isUnlocked = true
 
+	// Verify the vault has not been modified outside psst before trusting its content
+	if err := m.verifyIntegrity(); err != nil {
+		m.Lock()
+		return false, err
+	}
+
+	if err := m.migrate(); err != nil {
+		m.Lock()
+		return false, fmt.Errorf("failed to migrate vault: %w", err)
+	}
+
+	m.meta.LastAccess = time.Now().UTC()
+
 	// Update last access time
-	if err := m.vault.SaveVaultMetadata(m.meta); err != nil {
+	if err := m.seal(); err != nil {
 		return true, fmt.Errorf("failed to update last access time: %w", err)
 	}
 
@@ -129,32 +233,51 @@ func (m *Manager) IsUnlocked() bool {
 	return m.isUnlocked
 }
 
-// Init initializes the vault.
+// Init initializes the vault with a random data key and a first password member, named "owner", unlocking it with
+// masterPassword.
 // After initialization, the vault can be immediately used as if it was already unlocked.
 func (m *Manager) Init(masterPassword string) error {
-	salt := make([]byte, 16)
-	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-		return fmt.Errorf("failed to generate salt: %w", err)
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return fmt.Errorf("failed to generate vault key: %w", err)
+	}
+	vaultID, err := newUUID()
+	if err != nil {
+		return fmt.Errorf("failed to generate vault ID: %w", err)
+	}
+	deviceID, err := newUUID()
+	if err != nil {
+		return fmt.Errorf("failed to generate device ID: %w", err)
 	}
-	hash, key := hashPassword(masterPassword, salt)
 
 	// Initialize the vault
 	m.meta = &model.VaultMetadata{
-		MasterHash: hash,
 		CreatedAt:  time.Now().UTC(),
 		LastAccess: time.Now().UTC(),
-		Version:    "0.0.1",
+		Version:    vaultVersion,
+		VaultID:    vaultID,
+		DeviceID:   deviceID,
 	}
 	m.masterKey = key
 	m.isUnlocked = true
+	if err = m.assignIdentity(); err != nil {
+		return err
+	}
+	owner, err := m.newPasswordMember(OwnerMember, masterPassword)
+	if err != nil {
+		return err
+	}
 
 	// Initialize database schema
-	if err := m.vault.Initialize(); err != nil {
+	if err = m.vault.Initialize(); err != nil {
 		return fmt.Errorf("failed to initialize database schema: %w", err)
 	}
+	if err = m.vault.SaveMember(owner); err != nil {
+		return fmt.Errorf("failed to save vault member: %w", err)
+	}
 
 	// Save vault metadata
-	if err := m.vault.SaveVaultMetadata(m.meta); err != nil {
+	if err = m.seal(); err != nil {
 		return fmt.Errorf("failed to save vault metadata: %w", err)
 	}
 
@@ -170,19 +293,92 @@ func (m *Manager) Create(entry *model.PasswordEntry) error {
 	entry.ModifiedAt = time.Now().UTC()
 	entry.LastUsedAt = time.Time{}
 
-	var err error
-	entry.Password, err = m.encryptPassword(entry.Password)
+	if err := m.save(entry); err != nil {
+		return err
+	}
+	m.updateMirror("Add an entry")
+	return nil
+}
+
+// validateEntry checks the custom fields of entry and that it matches the schema of its kind.
+func validateEntry(entry *model.PasswordEntry) error {
+	if err := validateFields(entry); err != nil {
+		return err
+	}
+	return validateKind(entry)
+}
+
+// save encrypts and stores entry, then updates the vault MAC.
+// The plain text password of entry is left untouched.
+func (m *Manager) save(entry *model.PasswordEntry) error {
+	return m.saveAll([]*model.PasswordEntry{entry})
+}
+
+// saveAll encrypts and stores the entries in a single transaction, then updates the vault MAC.
+// New entries without a UUID get one. The plain text passwords of the entries are left untouched.
+func (m *Manager) saveAll(entries []*model.PasswordEntry) error {
+	for _, entry := range entries {
+		if err := validateEntry(entry); err != nil {
+			return fmt.Errorf("service %s: %w", entry.Service, err)
+		}
+	}
+	encrypted, err := m.encryptEntries(entries)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt password: %w", err)
+		return err
 	}
 
-	err = m.vault.SavePasswordEntry(entry)
+	// Saving a single entry is atomic on its own.
+	if len(encrypted) == 1 {
+		err = m.vault.SavePasswordEntry(encrypted[0])
+	} else {
+		err = m.vault.SavePasswordEntries(encrypted)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to save password entry: %w", err)
 	}
+	for i, entry := range entries {
+		entry.ID = encrypted[i].ID
+	}
+	if err = m.seal(); err != nil {
+		return fmt.Errorf("failed to update vault MAC: %w", err)
+	}
 	return nil
 }
 
+// encryptEntries returns copies of the entries with their password, OTP secret and custom field values encrypted,
+// assigning a UUID to the new entries without one.
+func (m *Manager) encryptEntries(entries []*model.PasswordEntry) ([]*model.PasswordEntry, error) {
+	encrypted := make([]*model.PasswordEntry, len(entries))
+	for i, entry := range entries {
+		if entry.ID == 0 && entry.UUID == "" {
+			var err error
+			if entry.UUID, err = newUUID(); err != nil {
+				return nil, fmt.Errorf("failed to generate entry UUID: %w", err)
+			}
+		}
+		e := *entry
+		var err error
+		e.Password, err = m.encryptField(entry.Service, passwordField, entry.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt password: %w", err)
+		}
+		if entry.OTP != "" {
+			if e.OTP, err = m.encryptField(entry.Service, otpField, entry.OTP); err != nil {
+				return nil, fmt.Errorf("failed to encrypt OTP secret: %w", err)
+			}
+		}
+		e.Fields = slices.Clone(entry.Fields)
+		for j := range e.Fields {
+			field := &e.Fields[j]
+			if field.Value, err = m.encryptField(entry.Service, customFieldPrefix+field.Name, field.Value); err != nil {
+				return nil, fmt.Errorf("failed to encrypt field %s: %w", field.Name, err)
+			}
+		}
+		encrypted[i] = &e
+	}
+	return encrypted, nil
+}
+
 // Read retrieves the model.PasswordEntry associated with the service from the vault.
 func (m *Manager) Read(service string) (*model.PasswordEntry, error) {
 	if !m.isUnlocked {
@@ -193,37 +389,200 @@ func (m *Manager) Read(service string) (*model.PasswordEntry, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get password entry: %w", err)
 	}
+	if entry == nil {
+		return nil, ErrNotFound
+	}
 
-	entry.Password, err = m.decryptPassword(entry.Password)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt password: %w", err)
+	if err = m.decryptEntry(entry); err != nil {
+		return nil, err
 	}
 
 	return entry, nil
 }
 
-// List retrieves all model.PasswordEntry from the vault.
-func (*Manager) List() ([]*model.PasswordEntry, error) {
-	return nil, errors.New("not implemented")
+// MarkUsed records that the user read a secret of the entries of the services, which ranks them higher in Search.
+// Each entry is stamped once, even if its service is given more than once. Only the last use time of the entries is
+// updated, not their modification time, so that merging the vault into others doesn't see the use as a change.
+func (m *Manager) MarkUsed(services ...string) error {
+	if !m.isUnlocked {
+		return errors.New("vault is locked, please unlock the vault first")
+	}
+
+	now := time.Now().UTC()
+	used := make(map[string]bool, len(services))
+	for _, service := range services {
+		if used[service] {
+			continue
+		}
+		used[service] = true
+		entry, err := m.vault.GetPasswordEntry(service)
+		if err != nil {
+			return fmt.Errorf("failed to get password entry: %w", err)
+		}
+		if entry == nil {
+			return ErrNotFound
+		}
+		if err = m.vault.TouchPasswordEntry(entry.ID, now); err != nil {
+			return fmt.Errorf("failed to update the last use of service %s: %w", service, err)
+		}
+	}
+	return nil
+}
+
+// List retrieves all model.PasswordEntry from the vault, sorted by service.
+func (m *Manager) List() ([]*model.PasswordEntry, error) {
+	if !m.isUnlocked {
+		return nil, errors.New("vault is locked, please unlock the vault first")
+	}
+
+	entries, err := m.vault.ListPasswordEntries()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list password entries: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err = m.decryptEntry(entry); err != nil {
+			return nil, fmt.Errorf("service %s: %w", entry.Service, err)
+		}
+	}
+
+	return entries, nil
+}
+
+// decryptEntry decrypts, in place, the password, the OTP secret and the custom field values of an entry as stored in
+// the vault.
+func (m *Manager) decryptEntry(entry *model.PasswordEntry) error {
+	var err error
+	entry.Password, err = m.decryptField(entry.Service, passwordField, entry.Password)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt password: %w", err)
+	}
+	if entry.OTP != "" {
+		if entry.OTP, err = m.decryptField(entry.Service, otpField, entry.OTP); err != nil {
+			return fmt.Errorf("failed to decrypt OTP secret: %w", err)
+		}
+	}
+	for i := range entry.Fields {
+		field := &entry.Fields[i]
+		if field.Value, err = m.decryptField(entry.Service, customFieldPrefix+field.Name, field.Value); err != nil {
+			return fmt.Errorf("failed to decrypt field %s: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+// SSHKeys retrieves and decrypts the entries holding an SSH private key, see model.KindSSHKey.
+func (m *Manager) SSHKeys() ([]*model.PasswordEntry, error) {
+	entries, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(entries, func(e *model.PasswordEntry) bool { return e.Kind != model.KindSSHKey }), nil
 }
 
-// Update updates a model.PasswordEntry.
-func (*Manager) Update(_ *model.PasswordEntry) error {
-	return errors.New("not implemented")
+// Import adds a model.PasswordEntry created outside this vault, preserving its timestamps.
+// Entries without a creation time are considered created now.
+func (m *Manager) Import(entry *model.PasswordEntry) error {
+	if !m.isUnlocked {
+		return errors.New("vault is locked, please unlock the vault first")
+	}
+	now := time.Now().UTC()
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = now
+	}
+	if entry.ModifiedAt.IsZero() {
+		entry.ModifiedAt = entry.CreatedAt
+	}
+	entry.ID = 0
+
+	if err := m.save(entry); err != nil {
+		return err
+	}
+	m.updateMirror("Import an entry")
+	return nil
 }
 
-// Delete removes a model.PasswordEntry from the vault.
-func (*Manager) Delete(_ *model.PasswordEntry) error {
-	return errors.New("not implemented")
+// ImportAll adds or updates entries created outside this vault in a single transaction, preserving their
+// timestamps: either all the entries are saved or none is. Entries with an ID replace the existing entry with that
+// ID, the others are added. Entries without a creation time are considered created now.
+func (m *Manager) ImportAll(entries []*model.PasswordEntry) error {
+	if !m.isUnlocked {
+		return errors.New("vault is locked, please unlock the vault first")
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	for _, entry := range entries {
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = now
+		}
+		if entry.ModifiedAt.IsZero() {
+			entry.ModifiedAt = now
+		}
+	}
+
+	if err := m.saveAll(entries); err != nil {
+		return err
+	}
+	m.updateMirror(fmt.Sprintf("Import %d entries", len(entries)))
+	return nil
 }
 
-// encryptPassword encrypts a password using AES-GCM.
-func (m *Manager) encryptPassword(password string) (string, error) {
-	block, err := aes.NewCipher(m.masterKey)
+// Update updates a model.PasswordEntry, identified by its service.
+// The entry must already exist in the vault.
+func (m *Manager) Update(entry *model.PasswordEntry) error {
+	if !m.isUnlocked {
+		return errors.New("vault is locked, please unlock the vault first")
+	}
+
+	existing, err := m.vault.GetPasswordEntry(entry.Service)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to get password entry: %w", err)
+	}
+	if existing == nil {
+		return ErrNotFound
+	}
+	entry.ID = existing.ID
+	entry.CreatedAt = existing.CreatedAt
+	entry.ModifiedAt = time.Now().UTC()
+
+	if err = m.save(entry); err != nil {
+		return err
+	}
+	m.updateMirror("Update an entry")
+	return nil
+}
+
+// Delete removes a model.PasswordEntry, identified by its service, from the vault.
+// A tombstone is kept, so that the deletion is propagated when merging other vaults.
+func (m *Manager) Delete(entry *model.PasswordEntry) error {
+	if !m.isUnlocked {
+		return errors.New("vault is locked, please unlock the vault first")
 	}
-	gcm, err := cipher.NewGCM(block)
+
+	existing, err := m.vault.GetPasswordEntry(entry.Service)
+	if err != nil {
+		return fmt.Errorf("failed to get password entry: %w", err)
+	}
+	if existing == nil {
+		return ErrNotFound
+	}
+	if err = m.vault.DeletePasswordEntry(entry.Service); err != nil {
+		return fmt.Errorf("failed to delete password entry: %w", err)
+	}
+	if err = m.seal(); err != nil {
+		return fmt.Errorf("failed to update vault MAC: %w", err)
+	}
+	m.updateMirror("Delete an entry")
+	return nil
+}
+
+// encryptField encrypts the value of an entry field using AES-GCM.
+// The ciphertext is bound to the vault, the service and the field name through the additional authenticated data,
+// so that it can't be moved to a different entry or field without failing to decrypt.
+func (m *Manager) encryptField(service, field, plaintext string) (string, error) {
+	gcm, err := m.newGCM()
 	if err != nil {
 		return "", err
 	}
@@ -232,21 +591,32 @@ func (m *Manager) encryptPassword(password string) (string, error) {
 		return "", err
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, []byte(password), nil)
-	return hex.EncodeToString(ciphertext), nil
+	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), m.additionalData(service, field))
+	return ciphertextV1Prefix + hex.EncodeToString(ciphertext), nil
 }
 
-// decryptPassword decrypts a password using AES-GCM.
-func (m *Manager) decryptPassword(encryptedPassword string) (string, error) {
-	ciphertext, err := hex.DecodeString(encryptedPassword)
-	if err != nil {
-		return "", err
+// decryptField decrypts the value of an entry field encrypted with encryptField.
+func (m *Manager) decryptField(service, field, encrypted string) (string, error) {
+	encoded, ok := strings.CutPrefix(encrypted, ciphertextV1Prefix)
+	if !ok {
+		return "", errors.New("unsupported ciphertext format")
 	}
-	block, err := aes.NewCipher(m.masterKey)
+	return m.open(encoded, m.additionalData(service, field))
+}
+
+// decryptLegacy decrypts a ciphertext sealed before version 0.0.2, without additional authenticated data.
+// It is only meant to be used while migrating existing entries.
+func (m *Manager) decryptLegacy(encrypted string) (string, error) {
+	return m.open(encrypted, nil)
+}
+
+// open decrypts an hex encoded AES-GCM ciphertext prefixed by its nonce.
+func (m *Manager) open(encoded string, additionalData []byte) (string, error) {
+	ciphertext, err := hex.DecodeString(encoded)
 	if err != nil {
 		return "", err
 	}
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := m.newGCM()
 	if err != nil {
 		return "", err
 	}
@@ -257,10 +627,30 @@ func (m *Manager) decryptPassword(encryptedPassword string) (string, error) {
 	nonce := ciphertext[:gcm.NonceSize()]
 	ciphertext = ciphertext[gcm.NonceSize():]
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return "", err
 	}
 
 	return string(plaintext), nil
 }
+
+// newGCM creates an AES-GCM cipher using the master key.
+func (m *Manager) newGCM() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(m.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData returns the AES-GCM additional authenticated data for a field of an entry.
+// Every part is length-prefixed so that different (service, field) pairs never share the same encoding.
+func (m *Manager) additionalData(service, field string) []byte {
+	var aad []byte
+	for _, part := range []string{aadDomain, m.meta.VaultID, service, field} {
+		aad = binary.BigEndian.AppendUint32(aad, uint32(len(part)))
+		aad = append(aad, part...)
+	}
+	return aad
+}
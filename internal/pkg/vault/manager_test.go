@@ -1,7 +1,13 @@
 package vault_test
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
 	"errors"
+	"io"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -58,6 +64,18 @@ func TestManager_Close(t *testing.T) {
 	}
 }
 
+// expectMembers records the members saved to mockVault, so that the vault can be unlocked again.
+func expectMembers(mockVault *mockdb.MockVault) {
+	var members []*model.VaultMember
+	mockVault.EXPECT().SaveMember(gomock.Any()).DoAndReturn(func(m *model.VaultMember) error {
+		members = append(members, m)
+		return nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListMembers().DoAndReturn(func() ([]*model.VaultMember, error) {
+		return members, nil
+	}).AnyTimes()
+}
+
 func TestManager_Unlock(t *testing.T) {
 	var (
 		mockVault *mockdb.MockVault
@@ -158,12 +176,19 @@ func TestManager_Unlock(t *testing.T) {
 			MasterHash: "$argon2id$v=19$m=65536,t=3,p=4$6c89d7fbb2e90bbe9e91509fc4d5b546$67b53292ba1f9c1c6c9193c48404d8c9fdfeb93041d5affcd08181241e284cdd", //nolint:lll
 			CreatedAt:  time.Now().Add(-24 * time.Hour).UTC(),
 			LastAccess: time.Now().UTC(),
-			Version:    "1.0.0",
+			Version:    "0.0.2",
 		}
 		mockVault.EXPECT().
 			GetVaultMetadata().
 			Return(meta, nil)
 
+		// Listed to assign UUIDs to the entries, to encrypt the body of notes, to bind the attachment keys to the
+		// entries and to compute the MAC.
+		mockVault.EXPECT().ListPasswordEntries().Return(nil, nil).Times(4)
+		mockVault.EXPECT().ListAllAttachments().Return(nil, nil).Times(2)
+		mockVault.EXPECT().ListTombstones().Return(nil, nil)
+		// The principal password becomes the password of the first member.
+		mockVault.EXPECT().SaveMember(gomock.Any()).Return(nil)
 		mockVault.EXPECT().
 			SaveVaultMetadata(gomock.Eq(meta)).
 			Return(errors.New("db error"))
@@ -188,12 +213,19 @@ func TestManager_Unlock(t *testing.T) {
 			MasterHash: "$argon2id$v=19$m=65536,t=3,p=4$6c89d7fbb2e90bbe9e91509fc4d5b546$67b53292ba1f9c1c6c9193c48404d8c9fdfeb93041d5affcd08181241e284cdd", //nolint:lll
 			CreatedAt:  time.Now().Add(-24 * time.Hour).UTC(),
 			LastAccess: time.Now().UTC(),
-			Version:    "1.0.0",
+			Version:    "0.0.2",
 		}
 		mockVault.EXPECT().
 			GetVaultMetadata().
 			Return(meta, nil)
 
+		// Listed to assign UUIDs to the entries, to encrypt the body of notes, to bind the attachment keys to the
+		// entries and to compute the MAC.
+		mockVault.EXPECT().ListPasswordEntries().Return(nil, nil).Times(4)
+		mockVault.EXPECT().ListAllAttachments().Return(nil, nil).Times(2)
+		mockVault.EXPECT().ListTombstones().Return(nil, nil)
+		// The principal password becomes the password of the first member.
+		mockVault.EXPECT().SaveMember(gomock.Any()).Return(nil)
 		mockVault.EXPECT().
 			SaveVaultMetadata(gomock.Eq(meta)).
 			Return(nil)
@@ -214,12 +246,19 @@ func TestManager_Unlock(t *testing.T) {
 			MasterHash: "$argon2id$v=19$m=65536,t=3,p=4$6c89d7fbb2e90bbe9e91509fc4d5b546$67b53292ba1f9c1c6c9193c48404d8c9fdfeb93041d5affcd08181241e284cdd", //nolint:lll
 			CreatedAt:  time.Now().Add(-24 * time.Hour).UTC(),
 			LastAccess: time.Now().UTC(),
-			Version:    "1.0.0",
+			Version:    "0.0.2",
 		}
 		mockVault.EXPECT().
 			GetVaultMetadata().
 			Return(meta, nil)
 
+		// Listed to assign UUIDs to the entries, to encrypt the body of notes, to bind the attachment keys to the
+		// entries and to compute the MAC.
+		mockVault.EXPECT().ListPasswordEntries().Return(nil, nil).Times(4)
+		mockVault.EXPECT().ListAllAttachments().Return(nil, nil).Times(2)
+		mockVault.EXPECT().ListTombstones().Return(nil, nil)
+		// The principal password becomes the password of the first member.
+		mockVault.EXPECT().SaveMember(gomock.Any()).Return(nil)
 		mockVault.EXPECT().
 			SaveVaultMetadata(gomock.Eq(meta)).
 			Return(nil)
@@ -243,4 +282,1140 @@ func TestManager_Unlock(t *testing.T) {
 	})
 }
 
+// legacyMasterHash is the master hash of "password123456" in vaults older than version 0.0.2.
+const legacyMasterHash = "$argon2id$v=19$m=65536,t=3,p=4$6c89d7fbb2e90bbe9e91509fc4d5b546$67b53292ba1f9c1c6c9193c48404d8c9fdfeb93041d5affcd08181241e284cdd" //nolint:lll
+
+// legacyCiphertext seals plaintext as vaults older than version 0.0.2 did, with the master key of legacyMasterHash.
+func legacyCiphertext(t *testing.T, plaintext string) string {
+	t.Helper()
+	// The hash stores the derived master key.
+	key, err := hex.DecodeString(legacyMasterHash[strings.LastIndex(legacyMasterHash, "$")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	return hex.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil))
+}
+
+func TestManager_Migrate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+	manager := vault.NewManager(mockVault)
+	legacy, legacyEmpty := legacyCiphertext(t, "secret"), legacyCiphertext(t, "")
+
+	meta := &model.VaultMetadata{
+		MasterHash: legacyMasterHash,
+		CreatedAt:  time.Now().Add(-24 * time.Hour).UTC(),
+		LastAccess: time.Now().UTC(),
+		Version:    "0.0.1",
+	}
+	var owner *model.VaultMember
+	migrated := map[string]*model.PasswordEntry{}
+	save := func(e *model.PasswordEntry) error {
+		migrated[e.Service] = e
+		return nil
+	}
+	list := func() ([]*model.PasswordEntry, error) {
+		return []*model.PasswordEntry{migrated["gmail"], migrated["recovery"]}, nil
+	}
+	gomock.InOrder(
+		mockVault.EXPECT().GetVaultMetadata().Return(meta, nil),
+		mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).Return(nil),
+		mockVault.EXPECT().ListPasswordEntries().Return([]*model.PasswordEntry{
+			{ID: 1, Service: "gmail", Password: legacy},
+			{ID: 2, Service: "recovery", Kind: model.KindNote, Password: legacyEmpty, Notes: "codes"},
+		}, nil),
+		mockVault.EXPECT().SavePasswordEntry(gomock.Any()).DoAndReturn(save).Times(2),
+		mockVault.EXPECT().ListPasswordEntries().DoAndReturn(list),
+		mockVault.EXPECT().SavePasswordEntry(gomock.Any()).DoAndReturn(save).Times(2),
+		mockVault.EXPECT().SaveMember(gomock.Any()).DoAndReturn(func(m *model.VaultMember) error {
+			owner = m
+			return nil
+		}),
+		mockVault.EXPECT().ListPasswordEntries().DoAndReturn(list),
+		mockVault.EXPECT().ListTombstones().Return(nil, nil),
+		mockVault.EXPECT().SaveMigration(gomock.Len(1), gomock.Nil(), gomock.Any()).DoAndReturn(
+			func(entries []*model.PasswordEntry, _ []*model.Attachment, _ *model.VaultMetadata) error {
+				return save(entries[0])
+			}),
+		mockVault.EXPECT().ListPasswordEntries().DoAndReturn(list),
+		mockVault.EXPECT().ListAllAttachments().Return(nil, nil),
+		mockVault.EXPECT().ListPasswordEntries().DoAndReturn(list),
+		mockVault.EXPECT().ListTombstones().Return(nil, nil),
+		mockVault.EXPECT().ListAllAttachments().Return(nil, nil),
+		mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).Return(nil),
+	)
+
+	unlocked, err := manager.Unlock("password123456")
+	if !unlocked || err != nil {
+		t.Fatalf("Expected vault to be unlocked, got [%v] [%v]", unlocked, err)
+	}
+	if meta.Version != "0.0.9" {
+		t.Fatalf("Expected version 0.0.9, got [%s]", meta.Version)
+	}
+	if meta.MasterHash != "" {
+		t.Fatal("Expected the master hash to be dropped")
+	}
+	if owner == nil || owner.Name != vault.OwnerMember || owner.Kind != model.MemberPassword {
+		t.Fatalf("Expected the principal password to become the password of the owner, got %+v", owner)
+	}
+	if meta.MAC == "" {
+		t.Fatal("Expected vault MAC to be computed")
+	}
+	if meta.VaultID == "" {
+		t.Fatal("Expected vault ID to be assigned")
+	}
+	if meta.DeviceID == "" || meta.DeviceID == meta.VaultID {
+		t.Fatalf("Expected a device ID to be assigned, got [%s]", meta.DeviceID)
+	}
+	if !strings.HasPrefix(meta.IdentityKey, "v1:") {
+		t.Fatalf("Expected an encrypted identity key to be assigned, got [%s]", meta.IdentityKey)
+	}
+	gmail := migrated["gmail"]
+	if gmail == nil || !strings.HasPrefix(gmail.Password, "v1:") {
+		t.Fatalf("Expected password to be re-encrypted, got [%v]", gmail)
+	}
+	if gmail.UUID == "" {
+		t.Fatal("Expected entry UUID to be assigned")
+	}
+	if note := migrated["recovery"]; note.Notes != "" || len(note.Fields) != 1 ||
+		!strings.HasPrefix(note.Fields[0].Value, "v1:") {
+		t.Fatalf("Expected the body of the note to be encrypted, got %+v", note)
+	}
+
+	mockVault.EXPECT().GetPasswordEntry("gmail").Return(gmail, nil)
+	entry, err := manager.Read("gmail")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if entry.Password != "secret" {
+		t.Fatalf("Expected password [secret], got [%s]", entry.Password)
+	}
+	mockVault.EXPECT().GetPasswordEntry("recovery").Return(migrated["recovery"], nil)
+	body, err := manager.ReadField("recovery", vault.FieldNoteBody)
+	if err != nil || body != "codes" {
+		t.Fatalf("Expected the body [codes], got [%s] [%v]", body, err)
+	}
+}
+
+func TestManager_Migrate_Interrupted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+
+	// The mock stores the vault as the database would, applying the migration transaction as a whole or not at all.
+	clone := func(e *model.PasswordEntry) *model.PasswordEntry {
+		c := *e
+		c.Fields = slices.Clone(e.Fields)
+		return &c
+	}
+	stored := model.VaultMetadata{MasterHash: legacyMasterHash, CreatedAt: time.Now().UTC(), Version: "0.0.1"}
+	entries := map[int64]*model.PasswordEntry{
+		1: {ID: 1, Service: "recovery", Kind: model.KindNote, Password: legacyCiphertext(t, ""), Notes: "codes"},
+		2: {ID: 2, Service: "wifi-keys", Kind: model.KindNote, Password: legacyCiphertext(t, ""), Notes: "k3ys"},
+	}
+	save := func(e *model.PasswordEntry) error {
+		entries[e.ID] = clone(e)
+		return nil
+	}
+	mockVault.EXPECT().GetVaultMetadata().DoAndReturn(func() (*model.VaultMetadata, error) {
+		meta := stored
+		return &meta, nil
+	}).AnyTimes()
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).DoAndReturn(func(meta *model.VaultMetadata) error {
+		stored = *meta
+		return nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListPasswordEntries().DoAndReturn(func() ([]*model.PasswordEntry, error) {
+		return []*model.PasswordEntry{clone(entries[1]), clone(entries[2])}, nil
+	}).AnyTimes()
+	mockVault.EXPECT().GetPasswordEntry(gomock.Any()).DoAndReturn(func(service string) (*model.PasswordEntry, error) {
+		for _, e := range entries {
+			if e.Service == service {
+				return clone(e), nil
+			}
+		}
+		return nil, nil
+	}).AnyTimes()
+	mockVault.EXPECT().SavePasswordEntry(gomock.Any()).DoAndReturn(save).AnyTimes()
+	mockVault.EXPECT().ListTombstones().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	expectMembers(mockVault)
+	gomock.InOrder(
+		mockVault.EXPECT().SaveMigration(gomock.Len(2), gomock.Nil(), gomock.Any()).Return(errors.New("disk I/O error")),
+		mockVault.EXPECT().SaveMigration(gomock.Len(2), gomock.Nil(), gomock.Any()).DoAndReturn(
+			func(migrated []*model.PasswordEntry, _ []*model.Attachment, meta *model.VaultMetadata) error {
+				for _, e := range migrated {
+					_ = save(e)
+				}
+				stored = *meta
+				return nil
+			}),
+	)
+
+	// The migration of the notes fails partway through, after the earlier migrations were applied.
+	unlocked, err := vault.NewManager(mockVault).Unlock("password123456")
+	if unlocked || err == nil {
+		t.Fatalf("Expected the interrupted migration to fail the unlock, got [%v] [%v]", unlocked, err)
+	}
+	if stored.Version == "0.0.9" || entries[1].Notes != "codes" || entries[2].Notes != "k3ys" {
+		t.Fatalf("Expected the notes to be left as they were, got version %s and %+v %+v", stored.Version,
+			entries[1], entries[2])
+	}
+
+	// Unlocking again resumes the migration, and the migrated vault passes the integrity check of later unlocks.
+	for range 2 {
+		manager := vault.NewManager(mockVault)
+		if unlocked, err = manager.Unlock("password123456"); !unlocked || err != nil {
+			t.Fatalf("Expected vault to be unlocked, got [%v] [%v]", unlocked, err)
+		}
+		if body, readErr := manager.ReadField("wifi-keys", vault.FieldNoteBody); readErr != nil || body != "k3ys" {
+			t.Fatalf("Expected the body [k3ys], got [%s] [%v]", body, readErr)
+		}
+	}
+	if stored.Version != "0.0.9" || stored.MasterHash != "" {
+		t.Fatalf("Expected the vault to be migrated, got %+v", stored)
+	}
+}
+
+func TestManager_CiphertextBinding(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+	manager := vault.NewManager(mockVault)
+
+	mockVault.EXPECT().Initialize().Return(nil)
+	expectMembers(mockVault)
+	mockVault.EXPECT().ListPasswordEntries().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListTombstones().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).Return(nil).AnyTimes()
+	if err := manager.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	saved, savedOTP := map[string]string{}, map[string]string{}
+	mockVault.EXPECT().SavePasswordEntry(gomock.Any()).DoAndReturn(func(e *model.PasswordEntry) error {
+		saved[e.Service], savedOTP[e.Service] = e.Password, e.OTP
+		return nil
+	}).Times(2)
+	const otpURI = "otpauth://totp/gmail?secret=JBSWY3DPEHPK3PXP"
+	for _, service := range []string{"gmail", "github"} {
+		entry := &model.PasswordEntry{Service: service, Password: service + "-secret", OTP: otpURI}
+		if err := manager.Create(entry); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	}
+	if savedOTP["gmail"] == otpURI {
+		t.Fatal("Expected the OTP secret to be encrypted")
+	}
+
+	t.Run("decrypts a ciphertext in its own entry", func(t *testing.T) {
+		mockVault.EXPECT().GetPasswordEntry("gmail").
+			Return(&model.PasswordEntry{Service: "gmail", Password: saved["gmail"], OTP: savedOTP["gmail"]}, nil)
+		entry, err := manager.Read("gmail")
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if entry.Password != "gmail-secret" || entry.OTP != otpURI {
+			t.Fatalf("Expected password [gmail-secret] and OTP [%s], got [%s] [%s]", otpURI, entry.Password, entry.OTP)
+		}
+	})
+
+	t.Run("fails to decrypt an OTP secret copied to the password", func(t *testing.T) {
+		mockVault.EXPECT().GetPasswordEntry("gmail").
+			Return(&model.PasswordEntry{Service: "gmail", Password: savedOTP["gmail"]}, nil)
+		if _, err := manager.Read("gmail"); err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+
+	t.Run("fails to decrypt a ciphertext copied from another entry", func(t *testing.T) {
+		mockVault.EXPECT().GetPasswordEntry("gmail").
+			Return(&model.PasswordEntry{Service: "gmail", Password: saved["github"]}, nil)
+		if _, err := manager.Read("gmail"); err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+
+	t.Run("rejects ciphertexts without version prefix", func(t *testing.T) {
+		mockVault.EXPECT().GetPasswordEntry("gmail").
+			Return(&model.PasswordEntry{Service: "gmail", Password: strings.TrimPrefix(saved["gmail"], "v1:")}, nil)
+		if _, err := manager.Read("gmail"); err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	})
+}
+
+func TestManager_Integrity(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+
+	// Record the vault state as persisted by the manager.
+	var (
+		meta    model.VaultMetadata
+		entries []*model.PasswordEntry
+	)
+	mockVault.EXPECT().Initialize().Return(nil)
+	expectMembers(mockVault)
+	mockVault.EXPECT().ListTombstones().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).DoAndReturn(func(v *model.VaultMetadata) error {
+		meta = *v
+		return nil
+	}).AnyTimes()
+	mockVault.EXPECT().SavePasswordEntry(gomock.Any()).DoAndReturn(func(e *model.PasswordEntry) error {
+		stored := *e
+		entries = append(entries, &stored)
+		return nil
+	}).AnyTimes()
+
+	var stored func() []*model.PasswordEntry
+	mockVault.EXPECT().ListPasswordEntries().DoAndReturn(func() ([]*model.PasswordEntry, error) {
+		return stored(), nil
+	}).AnyTimes()
+	stored = func() []*model.PasswordEntry { return entries }
+
+	manager := vault.NewManager(mockVault)
+	if err := manager.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, service := range []string{"gmail", "github"} {
+		if err := manager.Create(&model.PasswordEntry{Service: service, Password: "secret"}); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(*model.VaultMetadata, []*model.PasswordEntry) []*model.PasswordEntry
+		wantErr bool
+	}{
+		{
+			name: "unlocks an untouched vault",
+			tamper: func(_ *model.VaultMetadata, e []*model.PasswordEntry) []*model.PasswordEntry {
+				return e
+			},
+		},
+		{
+			name: "detects a deleted entry",
+			tamper: func(_ *model.VaultMetadata, e []*model.PasswordEntry) []*model.PasswordEntry {
+				return e[:1]
+			},
+			wantErr: true,
+		},
+		{
+			name: "detects a rolled back password",
+			tamper: func(_ *model.VaultMetadata, e []*model.PasswordEntry) []*model.PasswordEntry {
+				rolledBack := *e[0]
+				rolledBack.Password = e[1].Password
+				return []*model.PasswordEntry{&rolledBack, e[1]}
+			},
+			wantErr: true,
+		},
+		{
+			name: "detects a changed kind",
+			tamper: func(_ *model.VaultMetadata, e []*model.PasswordEntry) []*model.PasswordEntry {
+				changed := *e[0]
+				changed.Kind = model.KindSSHKey
+				return []*model.PasswordEntry{&changed, e[1]}
+			},
+			wantErr: true,
+		},
+		{
+			name: "detects a changed field",
+			tamper: func(_ *model.VaultMetadata, e []*model.PasswordEntry) []*model.PasswordEntry {
+				changed := *e[0]
+				changed.Fields = []model.EntryField{{Name: "pin", Type: model.FieldTypeHidden, Value: "1234"}}
+				return []*model.PasswordEntry{&changed, e[1]}
+			},
+			wantErr: true,
+		},
+		{
+			name: "detects modified metadata",
+			tamper: func(m *model.VaultMetadata, e []*model.PasswordEntry) []*model.PasswordEntry {
+				m.CreatedAt = m.CreatedAt.Add(-time.Hour)
+				return e
+			},
+			wantErr: true,
+		},
+		{
+			name: "detects a removed MAC",
+			tamper: func(m *model.VaultMetadata, e []*model.PasswordEntry) []*model.PasswordEntry {
+				m.MAC = ""
+				return e
+			},
+			wantErr: true,
+		},
+		{
+			name: "detects a downgraded version",
+			tamper: func(m *model.VaultMetadata, e []*model.PasswordEntry) []*model.PasswordEntry {
+				m.Version = "0.0.2"
+				rolledBack := *e[0]
+				rolledBack.Password = e[1].Password
+				return []*model.PasswordEntry{&rolledBack, e[1]}
+			},
+			wantErr: true,
+		},
+		{
+			name: "detects a downgraded version without MAC",
+			tamper: func(m *model.VaultMetadata, e []*model.PasswordEntry) []*model.PasswordEntry {
+				m.Version = "0.0.2"
+				m.MAC = ""
+				m.DeviceID = ""
+				m.IdentityKey = ""
+				return e[:1]
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot := meta
+			tampered := tt.tamper(&snapshot, slices.Clone(entries))
+			stored = func() []*model.PasswordEntry { return tampered }
+			mockVault.EXPECT().GetVaultMetadata().Return(&snapshot, nil)
+
+			m := vault.NewManager(mockVault)
+			unlocked, err := m.Unlock("password123456")
+			if !tt.wantErr {
+				if !unlocked || err != nil {
+					t.Fatalf("Expected vault to be unlocked, got [%v] [%v]", unlocked, err)
+				}
+				return
+			}
+			if unlocked {
+				t.Fatal("Expected vault to be locked")
+			}
+			if !errors.Is(err, vault.ErrIntegrity) {
+				t.Fatalf("Expected integrity error, got [%v]", err)
+			}
+		})
+	}
+}
+
 // TODO: more tests
+
+func TestManager_ImportAll(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+
+	var (
+		meta    model.VaultMetadata
+		entries []*model.PasswordEntry
+	)
+	mockVault.EXPECT().Initialize().Return(nil)
+	expectMembers(mockVault)
+	mockVault.EXPECT().ListTombstones().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).DoAndReturn(func(v *model.VaultMetadata) error {
+		meta = *v
+		return nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListPasswordEntries().DoAndReturn(func() ([]*model.PasswordEntry, error) {
+		return entries, nil
+	}).AnyTimes()
+
+	manager := vault.NewManager(mockVault)
+	if err := manager.ImportAll([]*model.PasswordEntry{{Service: "gmail"}}); err == nil {
+		t.Fatal("Expected error importing into a locked vault, got nil")
+	}
+	if err := manager.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// Both entries are saved in a single call, without a save per entry.
+	mockVault.EXPECT().SavePasswordEntries(gomock.Len(2)).DoAndReturn(func(e []*model.PasswordEntry) error {
+		for i, entry := range e {
+			stored := *entry
+			stored.ID = int64(i + 1)
+			entries = append(entries, &stored)
+			entry.ID = stored.ID
+		}
+		return nil
+	})
+	modified := time.Date(2024, 11, 2, 9, 15, 0, 0, time.UTC)
+	imported := []*model.PasswordEntry{
+		{Service: "gmail", Password: "g-secret", ModifiedAt: modified},
+		{Service: "github", Password: "gh-secret"},
+	}
+	if err := manager.ImportAll(imported); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if imported[0].ID != 1 || imported[1].ID != 2 {
+		t.Fatalf("Expected the entries IDs to be set, got %d and %d", imported[0].ID, imported[1].ID)
+	}
+	if !entries[0].ModifiedAt.Equal(modified) || entries[1].CreatedAt.IsZero() || entries[1].ModifiedAt.IsZero() {
+		t.Fatalf("Expected imported timestamps to be kept and missing ones set, got %+v", entries)
+	}
+	if entries[0].Password == "g-secret" {
+		t.Fatal("Expected the password to be stored encrypted")
+	}
+
+	// The vault is sealed after the import, so it unlocks with the new entries.
+	mockVault.EXPECT().GetVaultMetadata().Return(&meta, nil)
+	if unlocked, err := vault.NewManager(mockVault).Unlock("password123456"); !unlocked || err != nil {
+		t.Fatalf("Expected the vault to unlock, got [%v] with error [%v]", unlocked, err)
+	}
+}
+
+func TestManager_ApplySync(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+
+	var (
+		meta       model.VaultMetadata
+		entries    []*model.PasswordEntry
+		tombstones []*model.Tombstone
+	)
+	mockVault.EXPECT().Initialize().Return(nil)
+	expectMembers(mockVault)
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).DoAndReturn(func(v *model.VaultMetadata) error {
+		meta = *v
+		return nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListPasswordEntries().DoAndReturn(func() ([]*model.PasswordEntry, error) {
+		return entries, nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListTombstones().DoAndReturn(func() ([]*model.Tombstone, error) {
+		return tombstones, nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+
+	manager := vault.NewManager(mockVault)
+	synced := []*model.PasswordEntry{{UUID: "4f1c", Service: "gmail", Password: "g-secret"}}
+	deleted := []*model.Tombstone{{UUID: "9a2b", Service: "jira", DeletedAt: time.Now().UTC()}}
+	if err := manager.ApplySync(synced, deleted, "peer"); err == nil {
+		t.Fatal("Expected error applying a sync to a locked vault, got nil")
+	}
+	if err := manager.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err := manager.ApplySync([]*model.PasswordEntry{{Service: "gmail"}}, nil, "peer"); err == nil {
+		t.Fatal("Expected error applying a sync of an entry without UUID, got nil")
+	}
+	invalid := []*model.PasswordEntry{
+		{UUID: "7d3e", Service: "visa", Kind: "coupon"},
+		synced[0],
+		{UUID: "c8f0", Service: "bank", Fields: []model.EntryField{{Name: "password", Value: "1234"}}},
+	}
+	err := manager.ApplySync(invalid, deleted, "peer")
+	if !errors.Is(err, vault.ErrInvalidEntry) || !errors.Is(err, vault.ErrInvalidField) ||
+		!strings.Contains(err.Error(), "service visa") || !strings.Contains(err.Error(), "service bank") {
+		t.Fatalf("Expected the invalid entries to be rejected, got [%v]", err)
+	}
+
+	var syncedAt time.Time
+	mockVault.EXPECT().SaveSync(gomock.Len(1), gomock.Len(1), "peer", gomock.Any()).DoAndReturn(
+		func(e []*model.PasswordEntry, d []*model.Tombstone, _ string, at time.Time) error {
+			entries, tombstones, syncedAt = e, d, at
+			return nil
+		})
+	if err := manager.ApplySync(synced, deleted, "peer"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if entries[0].UUID != "4f1c" || entries[0].Password == "g-secret" {
+		t.Fatalf("Expected the entry to be stored encrypted with its UUID, got %+v", entries[0])
+	}
+	// Stamping the merged entries and tombstones lets the vaults merging this one see the changes it relays.
+	if !entries[0].SyncedAt.Equal(syncedAt) || !tombstones[0].SyncedAt.Equal(syncedAt) {
+		t.Fatalf("Expected the entry and the tombstone to be stamped with the sync time %v, got %v and %v", syncedAt,
+			entries[0].SyncedAt, tombstones[0].SyncedAt)
+	}
+	if tombstones[0].UUID != "9a2b" || !deleted[0].SyncedAt.IsZero() {
+		t.Fatalf("Expected a stamped copy of the tombstone, got %+v", tombstones[0])
+	}
+
+	// The vault is sealed after the sync, so it only unlocks with the synced tombstones.
+	mockVault.EXPECT().GetVaultMetadata().Return(&meta, nil).Times(2)
+	if unlocked, err := vault.NewManager(mockVault).Unlock("password123456"); !unlocked || err != nil {
+		t.Fatalf("Expected the vault to unlock, got [%v] with error [%v]", unlocked, err)
+	}
+	tombstones = nil
+	if _, err := vault.NewManager(mockVault).Unlock("password123456"); !errors.Is(err, vault.ErrIntegrity) {
+		t.Fatalf("Expected an integrity error after removing a tombstone, got [%v]", err)
+	}
+}
+
+func TestManager_ResetDeviceID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+
+	var meta model.VaultMetadata
+	mockVault.EXPECT().Initialize().Return(nil)
+	expectMembers(mockVault)
+	mockVault.EXPECT().ListPasswordEntries().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListTombstones().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).DoAndReturn(func(v *model.VaultMetadata) error {
+		meta = *v
+		return nil
+	}).AnyTimes()
+
+	manager := vault.NewManager(mockVault)
+	if err := manager.ResetDeviceID(); err == nil {
+		t.Fatal("Expected error resetting the device ID of a locked vault, got nil")
+	}
+	if err := manager.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	deviceID := manager.DeviceID()
+	if deviceID == "" {
+		t.Fatal("Expected a device ID to be assigned on init")
+	}
+	if err := manager.ResetDeviceID(); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if manager.DeviceID() == deviceID || meta.DeviceID != manager.DeviceID() {
+		t.Fatalf("Expected a new device ID to be saved, got [%s] and [%s]", manager.DeviceID(), meta.DeviceID)
+	}
+
+	// The device ID is authenticated, so the vault unlocks with the new one only.
+	mockVault.EXPECT().GetVaultMetadata().Return(&meta, nil)
+	if unlocked, err := vault.NewManager(mockVault).Unlock("password123456"); !unlocked || err != nil {
+		t.Fatalf("Expected the vault to unlock, got [%v] with error [%v]", unlocked, err)
+	}
+	meta.DeviceID = deviceID
+	mockVault.EXPECT().GetVaultMetadata().Return(&meta, nil)
+	if _, err := vault.NewManager(mockVault).Unlock("password123456"); !errors.Is(err, vault.ErrIntegrity) {
+		t.Fatalf("Expected an integrity error after changing the device ID, got [%v]", err)
+	}
+}
+
+// recordingMirror records the updates of the vault mirror.
+type recordingMirror struct {
+	files    map[string][]byte
+	messages []string
+}
+
+func (r *recordingMirror) Update(files map[string][]byte, message string) error {
+	r.files = files
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func TestManager_Mirror(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+
+	var (
+		entries    []*model.PasswordEntry
+		tombstones []*model.Tombstone
+	)
+	mockVault.EXPECT().Initialize().Return(nil).Times(2)
+	expectMembers(mockVault)
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).Return(nil).AnyTimes()
+	mockVault.EXPECT().ListPasswordEntries().DoAndReturn(func() ([]*model.PasswordEntry, error) {
+		// The manager decrypts the listed entries in place.
+		listed := make([]*model.PasswordEntry, len(entries))
+		for i, entry := range entries {
+			e := *entry
+			listed[i] = &e
+		}
+		return listed, nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListTombstones().DoAndReturn(func() ([]*model.Tombstone, error) {
+		return tombstones, nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().SaveSync(gomock.Any(), gomock.Any(), "peer", gomock.Any()).DoAndReturn(
+		func(e []*model.PasswordEntry, d []*model.Tombstone, _ string, _ time.Time) error {
+			entries, tombstones = e, d
+			return nil
+		})
+
+	manager := vault.NewManager(mockVault)
+	if _, err := manager.MirrorFiles(); err == nil {
+		t.Fatal("Expected error mirroring a locked vault, got nil")
+	}
+	if err := manager.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	mirror := &recordingMirror{}
+	manager.SetMirror(mirror)
+
+	synced := []*model.PasswordEntry{{UUID: "4f1c", Service: "gmail", Password: "g-secret"}}
+	deleted := []*model.Tombstone{{UUID: "9a2b", Service: "jira", DeletedAt: time.Now().UTC()}}
+	if err := manager.ApplySync(synced, deleted, "peer"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(mirror.messages) != 1 || len(mirror.files) != 3 {
+		t.Fatalf("Expected the mirror to be updated once with 3 files, got %v and %d files",
+			mirror.messages, len(mirror.files))
+	}
+	entryFile := mirror.files["entries/4f1c"]
+	if entryFile == nil || mirror.files["deleted/9a2b"] == nil || strings.Contains(string(entryFile), "g-secret") {
+		t.Fatalf("Expected sealed files named after the UUIDs, got %v", mirror.files)
+	}
+
+	// Sealing is deterministic, so that unchanged entries don't change the mirror.
+	files, err := manager.MirrorFiles()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if string(files["entries/4f1c"]) != string(entryFile) {
+		t.Fatal("Expected the same entry to produce the same file")
+	}
+
+	gotEntries, gotTombstones, err := manager.ReadMirrorFiles(files)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(gotEntries) != 1 || gotEntries[0].UUID != "4f1c" || gotEntries[0].Password != "g-secret" ||
+		len(gotTombstones) != 1 || gotTombstones[0].Service != "jira" {
+		t.Fatalf("Expected the mirrored entry and tombstone, got %+v and %+v", gotEntries, gotTombstones)
+	}
+
+	// Files are bound to their name.
+	files["entries/77aa"] = files["entries/4f1c"]
+	if _, _, err = manager.ReadMirrorFiles(files); err == nil {
+		t.Fatal("Expected error reading a file moved to another name, got nil")
+	}
+	delete(files, "entries/77aa")
+
+	other := vault.NewManager(mockVault)
+	if err = other.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if _, _, err = other.ReadMirrorFiles(files); !errors.Is(err, vault.ErrMirrorVault) {
+		t.Fatalf("Expected ErrMirrorVault reading the mirror of another vault, got [%v]", err)
+	}
+}
+
+func TestManager_Members(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+
+	var (
+		meta    model.VaultMetadata
+		entries []*model.PasswordEntry
+		members []*model.VaultMember
+	)
+	mockVault.EXPECT().Initialize().Return(nil)
+	mockVault.EXPECT().ListTombstones().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).DoAndReturn(func(v *model.VaultMetadata) error {
+		meta = *v
+		return nil
+	}).AnyTimes()
+	mockVault.EXPECT().SavePasswordEntry(gomock.Any()).DoAndReturn(func(e *model.PasswordEntry) error {
+		stored := *e
+		stored.ID = int64(len(entries) + 1)
+		entries = append(entries, &stored)
+		e.ID = stored.ID
+		return nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListPasswordEntries().DoAndReturn(func() ([]*model.PasswordEntry, error) {
+		// The manager decrypts the listed entries in place.
+		listed := make([]*model.PasswordEntry, len(entries))
+		for i, entry := range entries {
+			e := *entry
+			listed[i] = &e
+		}
+		return listed, nil
+	}).AnyTimes()
+	mockVault.EXPECT().GetPasswordEntry("gmail").DoAndReturn(func(string) (*model.PasswordEntry, error) {
+		e := *entries[0]
+		return &e, nil
+	}).AnyTimes()
+	mockVault.EXPECT().SaveMember(gomock.Any()).DoAndReturn(func(m *model.VaultMember) error {
+		members = append(members, m)
+		return nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListMembers().DoAndReturn(func() ([]*model.VaultMember, error) {
+		return slices.Clone(members), nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListAttachments(gomock.Any()).Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().SaveRekey(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(e []*model.PasswordEntry, _ []*model.Attachment, m []*model.VaultMember, v *model.VaultMetadata) error {
+			entries, members, meta = e, m, *v
+			return nil
+		}).AnyTimes()
+	mockVault.EXPECT().GetVaultMetadata().DoAndReturn(func() (*model.VaultMetadata, error) {
+		snapshot := meta
+		return &snapshot, nil
+	}).AnyTimes()
+
+	personalVault := mockdb.NewMockVault(ctrl)
+	personalVault.EXPECT().Initialize().Return(nil)
+	personalVault.EXPECT().ListPasswordEntries().Return(nil, nil).AnyTimes()
+	personalVault.EXPECT().ListTombstones().Return(nil, nil).AnyTimes()
+	personalVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	personalVault.EXPECT().SaveVaultMetadata(gomock.Any()).Return(nil).AnyTimes()
+	expectMembers(personalVault)
+	personal := vault.NewManager(personalVault)
+	if err := personal.Init("personal-password"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	personalKey, err := personal.IdentityPublicKey()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	manager := vault.NewManager(mockVault)
+	if err = manager.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err = manager.Create(&model.PasswordEntry{Service: "gmail", Password: "g-secret"}); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err = manager.AddPasswordMember("alice", "alice-password"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if err = manager.AddPasswordMember("alice", "other-password"); !errors.Is(err, vault.ErrMemberExists) {
+		t.Fatalf("Expected ErrMemberExists, got [%v]", err)
+	}
+	if err = manager.AddIdentityMember("bob", personalKey); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(members) != 3 || members[0].Name != vault.OwnerMember {
+		t.Fatalf("Expected the owner and two members, got %+v", members)
+	}
+
+	// unlock unlocks the vault as a member and reads its entry.
+	unlock := func(t *testing.T, password string) (bool, error) {
+		t.Helper()
+		m := vault.NewManager(mockVault)
+		var unlocked bool
+		if password == "" {
+			unlocked, err = m.UnlockWithIdentity(personal)
+		} else {
+			unlocked, err = m.Unlock(password)
+		}
+		if !unlocked || err != nil {
+			return unlocked, err
+		}
+		entry, readErr := m.Read("gmail")
+		if readErr != nil || entry.Password != "g-secret" {
+			t.Fatalf("Expected to read the entry as a member, got %+v [%v]", entry, readErr)
+		}
+		return true, nil
+	}
+	for _, password := range []string{"password123456", "alice-password", ""} {
+		if unlocked, unlockErr := unlock(t, password); !unlocked || unlockErr != nil {
+			t.Fatalf("Expected every member to unlock the vault, got [%v] [%v]", unlocked, unlockErr)
+		}
+	}
+
+	passwordBefore := entries[0].Password
+	err = manager.RemoveMember(vault.OwnerMember, map[string]string{"alice": "wrong-password"})
+	if !errors.Is(err, vault.ErrMemberPassword) || entries[0].Password != passwordBefore {
+		t.Fatalf("Expected ErrMemberPassword and the vault left untouched, got [%v]", err)
+	}
+	identityBefore, err := manager.IdentityPublicKey()
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	// The removed member keeps a copy of the vault, whose identity wraps the previous key for alice.
+	copiedEntries, copiedMeta, copiedWrapped := entries, meta, members[1].WrappedKey
+	if err = manager.RemoveMember(vault.OwnerMember, map[string]string{"alice": "alice-password"}); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if entries[0].Password == passwordBefore {
+		t.Fatal("Expected the entry to be re-encrypted with a new key")
+	}
+	if identity, identityErr := manager.IdentityPublicKey(); identityErr != nil || identity.Equal(identityBefore) {
+		t.Fatalf("Expected the identity keypair to be rotated, got [%v]", identityErr)
+	}
+	if unlocked, unlockErr := unlock(t, "password123456"); unlocked || unlockErr != nil {
+		t.Fatalf("Expected the removed member not to unlock the vault, got [%v] [%v]", unlocked, unlockErr)
+	}
+	for _, password := range []string{"alice-password", ""} {
+		if unlocked, unlockErr := unlock(t, password); !unlocked || unlockErr != nil {
+			t.Fatalf("Expected the remaining members to unlock the vault, got [%v] [%v]", unlocked, unlockErr)
+		}
+	}
+
+	// A wrapped key replaced outside psst is detected.
+	wrapped := members[0].WrappedKey
+	members[0].WrappedKey = members[1].WrappedKey
+	if _, err = unlock(t, "alice-password"); err == nil {
+		t.Fatal("Expected error unlocking with a replaced wrapped key, got nil")
+	}
+	members[0].WrappedKey = wrapped
+
+	// A wrapped key forged by the removed member with the previous identity is rejected, even with the vault
+	// replaced by their copy: alice is pinned to the new identity.
+	rotatedEntries, rotatedMeta := entries, meta
+	entries, meta, members[0].WrappedKey = copiedEntries, copiedMeta, copiedWrapped
+	if _, err = unlock(t, "alice-password"); !errors.Is(err, vault.ErrIntegrity) {
+		t.Fatalf("Expected ErrIntegrity unlocking with a forged wrapped key, got [%v]", err)
+	}
+	entries, meta, members[0].WrappedKey = rotatedEntries, rotatedMeta, wrapped
+
+	if err = manager.RemoveMember("carol", nil); !errors.Is(err, vault.ErrMemberNotFound) {
+		t.Fatalf("Expected ErrMemberNotFound, got [%v]", err)
+	}
+	if err = manager.RemoveMember("bob", map[string]string{"alice": "alice-password"}); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if unlocked, unlockErr := unlock(t, "alice-password"); !unlocked || unlockErr != nil {
+		t.Fatalf("Expected alice to unlock the vault, got [%v] [%v]", unlocked, unlockErr)
+	}
+	if err = manager.RemoveMember("alice", nil); !errors.Is(err, vault.ErrLastMember) {
+		t.Fatalf("Expected ErrLastMember, got [%v]", err)
+	}
+}
+
+func TestManager_Attachments(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+	manager := vault.NewManager(mockVault)
+
+	mockVault.EXPECT().Initialize().Return(nil)
+	expectMembers(mockVault)
+	mockVault.EXPECT().ListPasswordEntries().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListTombstones().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).Return(nil).AnyTimes()
+	if err := manager.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	mockVault.EXPECT().GetPasswordEntry("gmail").
+		Return(&model.PasswordEntry{ID: 1, UUID: "4f1c", Service: "gmail"}, nil).AnyTimes()
+	var stored *model.Attachment
+	var chunks [][]byte
+	mockVault.EXPECT().GetAttachment(int64(1), gomock.Any()).DoAndReturn(
+		func(_ int64, name string) (*model.Attachment, error) {
+			if stored == nil || stored.Name != name {
+				return nil, nil
+			}
+			a := *stored
+			return &a, nil
+		}).AnyTimes()
+	mockVault.EXPECT().SaveAttachment(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(a *model.Attachment, next func() ([]byte, error)) error {
+			var saved [][]byte
+			for {
+				chunk, err := next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				saved = append(saved, chunk)
+			}
+			a.ID, stored, chunks = 7, a, saved
+			return nil
+		}).AnyTimes()
+	mockVault.EXPECT().ReadAttachmentChunks(int64(7), gomock.Any()).DoAndReturn(
+		func(_ int64, fn func(int, []byte) error) error {
+			for seq, chunk := range chunks {
+				if err := fn(seq, chunk); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).AnyTimes()
+
+	content := bytes.Repeat([]byte("recovery codes "), 20000)
+	attachment, err := manager.AddAttachment("gmail", "codes.txt", bytes.NewReader(content))
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if attachment.Size != int64(len(content)) || attachment.Chunks != 5 || len(chunks) != 5 {
+		t.Fatalf("Expected %d bytes in 5 chunks, got %d bytes in %d chunks", len(content), attachment.Size,
+			attachment.Chunks)
+	}
+	if bytes.Contains(chunks[0], content[:64]) {
+		t.Fatal("Expected the chunks to be encrypted")
+	}
+
+	var out bytes.Buffer
+	if err = manager.ReadAttachment("gmail", "codes.txt", &out); err != nil || !bytes.Equal(out.Bytes(), content) {
+		t.Fatalf("Expected the attachment to decrypt to its content, got %d bytes and error %v", out.Len(), err)
+	}
+	_, err = manager.AddAttachment("gmail", "codes.txt", strings.NewReader("x"))
+	if !errors.Is(err, vault.ErrAttachmentExists) {
+		t.Fatalf("Expected ErrAttachmentExists, got %v", err)
+	}
+	if err = manager.ReadAttachment("gmail", "other.txt", &out); !errors.Is(err, vault.ErrAttachmentNotFound) {
+		t.Fatalf("Expected ErrAttachmentNotFound, got %v", err)
+	}
+
+	original := slices.Clone(chunks)
+	tamper := map[string]func(){
+		"modified chunk":   func() { chunks[1] = slices.Clone(chunks[1]); chunks[1][20] ^= 1 },
+		"truncated chunks": func() { chunks = chunks[:4] },
+		"reordered chunks": func() { chunks[1], chunks[2] = chunks[2], chunks[1] },
+		"changed size":     func() { stored.Size-- },
+	}
+	for name, fn := range tamper {
+		t.Run("detects "+name, func(t *testing.T) {
+			size := stored.Size
+			fn()
+			defer func() { chunks, stored.Size = slices.Clone(original), size }()
+			readErr := manager.ReadAttachment("gmail", "codes.txt", io.Discard)
+			if !errors.Is(readErr, vault.ErrCorruptAttachment) {
+				t.Fatalf("Expected ErrCorruptAttachment, got %v", readErr)
+			}
+		})
+	}
+
+	// The key of an attachment is bound to the UUID of its entry: it follows the entry when renamed, but can't be
+	// moved to another entry.
+	mockVault.EXPECT().GetPasswordEntry("google").
+		Return(&model.PasswordEntry{ID: 1, UUID: "4f1c", Service: "google"}, nil)
+	if err = manager.ReadAttachment("google", "codes.txt", io.Discard); err != nil {
+		t.Fatalf("Expected the attachment of a renamed entry to be read, got %v", err)
+	}
+	mockVault.EXPECT().GetPasswordEntry("github").
+		Return(&model.PasswordEntry{ID: 2, UUID: "9a2b", Service: "github"}, nil)
+	mockVault.EXPECT().GetAttachment(int64(2), "codes.txt").DoAndReturn(
+		func(entryID int64, _ string) (*model.Attachment, error) {
+			a := *stored
+			a.EntryID = entryID
+			return &a, nil
+		})
+	if err = manager.ReadAttachment("github", "codes.txt", io.Discard); !errors.Is(err, vault.ErrCorruptAttachment) {
+		t.Fatalf("Expected ErrCorruptAttachment reading an attachment moved to another entry, got %v", err)
+	}
+
+	large := io.LimitReader(zeroReader{}, vault.MaxAttachmentSize+1)
+	if _, err = manager.AddAttachment("gmail", "large.bin", large); !errors.Is(err, vault.ErrAttachmentTooLarge) {
+		t.Fatalf("Expected ErrAttachmentTooLarge, got %v", err)
+	}
+}
+
+// zeroReader reads an endless stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestManager_Search(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockVault := mockdb.NewMockVault(ctrl)
+	manager := vault.NewManager(mockVault)
+
+	var stored []*model.PasswordEntry
+	mockVault.EXPECT().Initialize().Return(nil)
+	expectMembers(mockVault)
+	mockVault.EXPECT().ListPasswordEntries().DoAndReturn(func() ([]*model.PasswordEntry, error) {
+		listed := make([]*model.PasswordEntry, len(stored))
+		for i, entry := range stored {
+			e := *entry
+			listed[i] = &e
+		}
+		return listed, nil
+	}).AnyTimes()
+	mockVault.EXPECT().ListTombstones().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().ListAllAttachments().Return(nil, nil).AnyTimes()
+	mockVault.EXPECT().SaveVaultMetadata(gomock.Any()).Return(nil).AnyTimes()
+	mockVault.EXPECT().SavePasswordEntry(gomock.Any()).DoAndReturn(func(e *model.PasswordEntry) error {
+		stored = append(stored, e)
+		return nil
+	}).AnyTimes()
+	if err := manager.Init("password123456"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	now := time.Now()
+	for _, entry := range []*model.PasswordEntry{
+		{Service: "github", Username: "alice", URL: "https://github.com", Tags: []string{"work"}},
+		{Service: "gitlab", Username: "alice", Tags: []string{"work"}, LastUsedAt: now.Add(-time.Hour)},
+		{Service: "gmail", Username: "alice@gmail.com", URL: "https://www.google.com/mail", Tags: []string{"email"}},
+		{Service: "bank", Username: "a.smith", Notes: "branch in github street"},
+		{Service: "digital-ocean", Username: "ops", Tags: []string{"Work"}},
+	} {
+		entry.Password = "secret"
+		if err := manager.Import(entry); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		opts  vault.SearchOptions
+		want  []string
+	}{
+		{name: "ranks exact matches first", query: "github", want: []string{"github"}},
+		{name: "ranks recently used entries higher", query: "git", want: []string{"gitlab", "github", "digital-ocean"}},
+		{name: "matches subsequences", query: "gml", want: []string{"gmail"}},
+		{name: "matches usernames and URLs", query: "google", want: []string{"gmail"}},
+		{name: "requires every word to match", query: "git alice", want: []string{"gitlab", "github"}},
+		{name: "matches notes if asked", query: "street", opts: vault.SearchOptions{Notes: true}, want: []string{"bank"}},
+		{name: "ignores notes by default", query: "street"},
+		{
+			name: "filters by tags", query: "", opts: vault.SearchOptions{Tags: []string{"work"}},
+			want: []string{"gitlab", "digital-ocean", "github"},
+		},
+		{name: "limits the results", query: "git", opts: vault.SearchOptions{Limit: 1}, want: []string{"gitlab"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := manager.Search(tt.query, tt.opts)
+			if err != nil {
+				t.Fatal("Unexpected error: ", err)
+			}
+			var got []string
+			for _, result := range results {
+				got = append(got, result.Entry.Service)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+	t.Run("ranks used entries higher", func(t *testing.T) {
+		github := stored[0]
+		modified := github.ModifiedAt
+		mockVault.EXPECT().GetPasswordEntry("github").DoAndReturn(func(string) (*model.PasswordEntry, error) {
+			e := *github
+			return &e, nil
+		}).Times(2)
+		if _, err := manager.Read("github"); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if !github.LastUsedAt.IsZero() {
+			t.Fatalf("Expected reading the entry not to mark it used, got %s", github.LastUsedAt)
+		}
+
+		// The entry is stamped once, however many of its secrets were read.
+		mockVault.EXPECT().TouchPasswordEntry(github.ID, gomock.Any()).DoAndReturn(func(_ int64, usedAt time.Time) error {
+			github.LastUsedAt = usedAt
+			return nil
+		})
+		if err := manager.MarkUsed("github", "github"); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if github.ModifiedAt != modified {
+			t.Fatalf("Expected the modification time to be kept, got %s", github.ModifiedAt)
+		}
+
+		results, err := manager.Search("", vault.SearchOptions{Tags: []string{"work"}})
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Entry.Service)
+		}
+		if want := []string{"github", "gitlab", "digital-ocean"}; !slices.Equal(got, want) {
+			t.Fatalf("Expected the read entry first, got %v, want %v", got, want)
+		}
+	})
+}
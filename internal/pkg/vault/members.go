@@ -0,0 +1,461 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/share"
+)
+
+// membersVersion is the first vault version unlocked by its members rather than by a single principal password.
+//
+// The entries of these vaults are encrypted with a data key that is wrapped separately for every member: each member
+// has an X25519 keypair and the data key is sealed for its public key by the vault identity, see share.SealBytes.
+// The private key of a password member is stored sealed with a key derived from their password, together with the
+// vault identity public key, so that a wrapped key replaced outside psst is detected. The private key of an identity
+// member is the identity key of their own vault.
+//
+// Members aren't authenticated by the vault MAC: adding a member requires the data key, and a member can only unlock
+// the vault with the data key the MAC is computed with.
+const membersVersion = "0.0.7"
+
+const (
+	// OwnerMember is the name of the password member created with the vault, or when migrating a vault created
+	// before version 0.0.7 with its principal password.
+	OwnerMember = "owner"
+
+	// dataKeySize is the size of the AES-256 data key.
+	dataKeySize = 32
+	// memberDomain separates the additional authenticated data of member private keys from any other use.
+	memberDomain = "psst-member"
+)
+
+var (
+	// ErrMemberExists is returned when adding a member with the name of an existing one.
+	ErrMemberExists = errors.New("vault member already exists")
+	// ErrMemberNotFound is returned when the requested member does not exist in the vault.
+	ErrMemberNotFound = errors.New("vault member not found")
+	// ErrLastMember is returned when removing the only member of a vault, which could no longer be unlocked.
+	ErrLastMember = errors.New("the last member of a vault can't be removed")
+	// ErrMemberPassword is returned when the password given for a password member doesn't open its private key.
+	ErrMemberPassword = errors.New("wrong password of vault member")
+)
+
+// assignOwner makes the principal password of vaults created before version 0.0.7 the password of their first
+// member, named OwnerMember, and drops their master hash. The data key stays the one derived from the principal
+// password, so that copies of the vault and its mirror can still be merged.
+// The metadata is saved by the caller once every migration has been applied.
+func (m *Manager) assignOwner() error {
+	if m.meta.MasterHash == "" {
+		return nil
+	}
+	if m.unlockPassword == "" {
+		return errors.New("the principal password is required to add the first member")
+	}
+	owner, err := m.newPasswordMember(OwnerMember, m.unlockPassword)
+	if err != nil {
+		return err
+	}
+	if err = m.vault.SaveMember(owner); err != nil {
+		return fmt.Errorf("failed to save vault member: %w", err)
+	}
+	m.meta.MasterHash = ""
+	return nil
+}
+
+// UnlockWithIdentity unlocks the vault as the identity member whose public key is the identity of the unlocked
+// vault personal, see AddIdentityMember. It behaves like Unlock otherwise.
+//
+// Unlike the wrapped key of a password member, the wrapped key of an identity member isn't pinned to the vault
+// identity: someone able to modify the vault file could replace it with a key of their own.
+func (m *Manager) UnlockWithIdentity(personal *Manager) (bool, error) {
+	if m.isUnlocked {
+		return true, nil
+	}
+	identity, err := personal.identity()
+	if err != nil {
+		return false, err
+	}
+	metadata, err := m.vault.GetVaultMetadata()
+	if err != nil {
+		return false, fmt.Errorf("failed to get vault metadata: %w", err)
+	}
+	members, err := m.vault.ListMembers()
+	if err != nil {
+		return false, fmt.Errorf("failed to list vault members: %w", err)
+	}
+
+	publicKey := share.EncodePublicKey(identity.PublicKey())
+	for _, member := range members {
+		if member.Kind != model.MemberIdentity || member.PublicKey != publicKey {
+			continue
+		}
+		key, _, unwrapErr := unwrapKey(identity, member.WrappedKey)
+		if unwrapErr != nil {
+			return false, fmt.Errorf("failed to unwrap the vault key of member %s: %w", member.Name, unwrapErr)
+		}
+		return m.unlockWithKey(metadata, key)
+	}
+	return false, nil
+}
+
+// unlockPasswordMember returns the data key of the vault with ID vaultID, unwrapped by the password member whose
+// password is password, or nil if no password member has that password.
+func (m *Manager) unlockPasswordMember(password, vaultID string) ([]byte, error) {
+	members, err := m.vault.ListMembers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vault members: %w", err)
+	}
+	for _, member := range members {
+		if member.Kind != model.MemberPassword {
+			continue
+		}
+		key, pinned, openErr := openMemberKey(password, vaultID, member)
+		if openErr != nil {
+			// Not the password of this member
+			continue
+		}
+		var dataKey []byte
+		var sender *ecdh.PublicKey
+		if dataKey, sender, err = unwrapKey(key, member.WrappedKey); err != nil {
+			return nil, fmt.Errorf("failed to unwrap the vault key of member %s: %w", member.Name, err)
+		}
+		if !sender.Equal(pinned) {
+			return nil, fmt.Errorf("%w: the vault key of member %s wasn't wrapped by this vault", ErrIntegrity,
+				member.Name)
+		}
+		return dataKey, nil
+	}
+	return nil, nil
+}
+
+// Members lists the members of the unlocked vault, sorted by name.
+func (m *Manager) Members() ([]*model.VaultMember, error) {
+	if !m.isUnlocked {
+		return nil, errors.New("vault is locked, please unlock the vault first")
+	}
+	members, err := m.vault.ListMembers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vault members: %w", err)
+	}
+	return members, nil
+}
+
+// AddPasswordMember adds a member unlocking the vault with their own password.
+func (m *Manager) AddPasswordMember(name, password string) error {
+	if err := m.checkNewMember(name); err != nil {
+		return err
+	}
+	member, err := m.newPasswordMember(name, password)
+	if err != nil {
+		return err
+	}
+	if err = m.vault.SaveMember(member); err != nil {
+		return fmt.Errorf("failed to save vault member: %w", err)
+	}
+	return nil
+}
+
+// AddIdentityMember adds a member unlocking the vault with the identity key of their own vault, whose public key is
+// publicKey, see UnlockWithIdentity.
+func (m *Manager) AddIdentityMember(name string, publicKey *ecdh.PublicKey) error {
+	if err := m.checkNewMember(name); err != nil {
+		return err
+	}
+	identity, err := m.identity()
+	if err != nil {
+		return err
+	}
+	wrapped, err := m.wrapKey(identity, publicKey)
+	if err != nil {
+		return err
+	}
+	member := &model.VaultMember{
+		AddedAt:    time.Now().UTC(),
+		Name:       name,
+		Kind:       model.MemberIdentity,
+		PublicKey:  share.EncodePublicKey(publicKey),
+		WrappedKey: wrapped,
+	}
+	if err = m.vault.SaveMember(member); err != nil {
+		return fmt.Errorf("failed to save vault member: %w", err)
+	}
+	return nil
+}
+
+// checkNewMember checks the vault is unlocked and has no member with the given name.
+func (m *Manager) checkNewMember(name string) error {
+	members, err := m.Members()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("member name is required")
+	}
+	if slices.ContainsFunc(members, func(member *model.VaultMember) bool { return member.Name == name }) {
+		return fmt.Errorf("%w: %s", ErrMemberExists, name)
+	}
+	return nil
+}
+
+// RemoveMember removes a member from the vault, then rotates its keys: every password is re-encrypted with a new
+// data key and a new identity keypair wraps it for the remaining members, in a single transaction. The private key
+// of every remaining password member is pinned to the new identity, which requires their password: passwords holds
+// the password of each of them by name.
+//
+// The removed member can no longer open the entries shared with the vault, nor share entries on its behalf, and a
+// wrapped key forged with the previous identity is detected. They can still read the copies of the vault made before,
+// including its git mirror, and any password they have seen: rotate the passwords they had access to.
+func (m *Manager) RemoveMember(name string, passwords map[string]string) error {
+	members, err := m.Members()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(members, func(member *model.VaultMember) bool { return member.Name == name })
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrMemberNotFound, name)
+	}
+	if len(members) == 1 {
+		return ErrLastMember
+	}
+	remaining := slices.Delete(members, i, i+1)
+
+	if err = m.rotateKey(remaining, passwords); err != nil {
+		return err
+	}
+	m.updateMirror("Rotate the vault key")
+	return nil
+}
+
+// rotateKey re-encrypts the vault with a new data key and a new identity keypair, wrapping the data key for the given
+// members and pinning the password members, whose password is in passwords, to the new identity. The vault is left
+// untouched, with its current keys, if any step fails.
+func (m *Manager) rotateKey(members []*model.VaultMember, passwords map[string]string) error {
+	memberKeys := make(map[string]*ecdh.PrivateKey)
+	for _, member := range members {
+		if member.Kind != model.MemberPassword {
+			continue
+		}
+		key, _, err := openMemberKey(passwords[member.Name], m.meta.VaultID, member)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrMemberPassword, member.Name)
+		}
+		memberKeys[member.Name] = key
+	}
+	entries, err := m.List()
+	if err != nil {
+		return err
+	}
+	secrets, err := m.attachmentSecrets(entries)
+	if err != nil {
+		return err
+	}
+	tombstones, err := m.vault.ListTombstones()
+	if err != nil {
+		return fmt.Errorf("failed to list tombstones: %w", err)
+	}
+	key := make([]byte, dataKeySize)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return fmt.Errorf("failed to generate vault key: %w", err)
+	}
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate identity key: %w", err)
+	}
+
+	oldKey, oldMeta := m.masterKey, *m.meta
+	restore := func(err error) error {
+		m.masterKey, *m.meta = oldKey, oldMeta
+		return err
+	}
+	m.masterKey = key
+
+	encrypted, err := m.encryptEntries(entries)
+	if err != nil {
+		return restore(err)
+	}
+	attachments, err := m.resealAttachmentKeys(secrets)
+	if err != nil {
+		return restore(err)
+	}
+	if m.meta.IdentityKey, err = m.encryptField("", identityField, hex.EncodeToString(identity.Bytes())); err != nil {
+		return restore(fmt.Errorf("failed to encrypt identity key: %w", err))
+	}
+	for _, member := range members {
+		var publicKey *ecdh.PublicKey
+		if publicKey, err = share.ParsePublicKey(member.PublicKey); err != nil {
+			return restore(fmt.Errorf("invalid public key of member %s: %w", member.Name, err))
+		}
+		if member.WrappedKey, err = m.wrapKey(identity, publicKey); err != nil {
+			return restore(err)
+		}
+		if member.Kind != model.MemberPassword {
+			continue
+		}
+		var salt, sealed []byte
+		if salt, err = hex.DecodeString(member.Salt); err != nil {
+			return restore(fmt.Errorf("invalid salt of member %s: %w", member.Name, err))
+		}
+		sealed, err = sealMemberKey(passwords[member.Name], salt, memberKeys[member.Name], identity.PublicKey(),
+			memberAdditionalData(m.meta.VaultID, member.Name))
+		if err != nil {
+			return restore(err)
+		}
+		member.PrivateKey = hex.EncodeToString(sealed)
+	}
+	mac, err := m.computeMAC(encrypted, attachments, tombstones)
+	if err != nil {
+		return restore(err)
+	}
+	m.meta.MAC = hex.EncodeToString(mac)
+
+	if err = m.vault.SaveRekey(encrypted, attachments, members, m.meta); err != nil {
+		return restore(fmt.Errorf("failed to save the rotated vault key: %w", err))
+	}
+	return nil
+}
+
+// newPasswordMember creates a password member with a new keypair, its private key sealed with password.
+func (m *Manager) newPasswordMember(name, password string) (*model.VaultMember, error) {
+	identity, err := m.identity()
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate member key: %w", err)
+	}
+	salt := make([]byte, 16)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	sealed, err := sealMemberKey(password, salt, key, identity.PublicKey(),
+		memberAdditionalData(m.meta.VaultID, name))
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := m.wrapKey(identity, key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return &model.VaultMember{
+		AddedAt:    time.Now().UTC(),
+		Name:       name,
+		Kind:       model.MemberPassword,
+		PublicKey:  share.EncodePublicKey(key.PublicKey()),
+		Salt:       hex.EncodeToString(salt),
+		PrivateKey: hex.EncodeToString(sealed),
+		WrappedKey: wrapped,
+	}, nil
+}
+
+// sealMemberKey seals the private key of a password member, pinned to the vault identity public key, with a key
+// derived from password and salt.
+func sealMemberKey(
+	password string, salt []byte, key *ecdh.PrivateKey, identity *ecdh.PublicKey, additionalData []byte,
+) ([]byte, error) {
+	gcm, err := memberGCM(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	plaintext := append(key.Bytes(), identity.Bytes()...)
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openMemberKey opens the private key of a password member of the vault with ID vaultID with password.
+// It returns the private key and the identity public key of the vault pinned with it.
+func openMemberKey(password, vaultID string, member *model.VaultMember) (*ecdh.PrivateKey, *ecdh.PublicKey, error) {
+	salt, err := hex.DecodeString(member.Salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid salt: %w", err)
+	}
+	sealed, err := hex.DecodeString(member.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	gcm, err := memberGCM(password, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, nil, errors.New("private key too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():],
+		memberAdditionalData(vaultID, member.Name))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(plaintext) != 2*dataKeySize {
+		return nil, nil, errors.New("invalid private key length")
+	}
+	key, err := ecdh.X25519().NewPrivateKey(plaintext[:dataKeySize])
+	if err != nil {
+		return nil, nil, err
+	}
+	pinned, err := ecdh.X25519().NewPublicKey(plaintext[dataKeySize:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pinned, nil
+}
+
+// memberGCM creates the AES-GCM cipher sealing the private key of a password member, keyed by its password.
+func memberGCM(password string, salt []byte) (cipher.AEAD, error) {
+	_, key := hashPassword(password, salt)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// memberAdditionalData binds the private key of a password member to the vault and to the member name.
+func memberAdditionalData(vaultID, name string) []byte {
+	var aad []byte
+	for _, part := range []string{memberDomain, vaultID, name} {
+		aad = binary.BigEndian.AppendUint32(aad, uint32(len(part)))
+		aad = append(aad, part...)
+	}
+	return aad
+}
+
+// wrapKey seals the data key of the unlocked vault for the member public key recipient.
+func (m *Manager) wrapKey(identity *ecdh.PrivateKey, recipient *ecdh.PublicKey) (string, error) {
+	wrapped, err := share.SealBytes(identity, recipient, m.masterKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap vault key: %w", err)
+	}
+	return hex.EncodeToString(wrapped), nil
+}
+
+// unwrapKey opens a data key wrapped by wrapKey with the member private key.
+// It returns the data key and the identity public key of the vault that wrapped it.
+func unwrapKey(key *ecdh.PrivateKey, wrapped string) ([]byte, *ecdh.PublicKey, error) {
+	data, err := hex.DecodeString(wrapped)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid wrapped key: %w", err)
+	}
+	dataKey, sender, err := share.OpenBytes(key, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(dataKey) != dataKeySize {
+		return nil, nil, errors.New("invalid wrapped key length")
+	}
+	return dataKey, sender, nil
+}
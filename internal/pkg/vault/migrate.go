@@ -0,0 +1,188 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// migration upgrades an unlocked vault to the given version.
+type migration struct {
+	apply   func(m *Manager) error
+	version string
+}
+
+// migrations lists, in ascending order, the migrations applied to vaults older than vaultVersion.
+var migrations = []migration{
+	{version: "0.0.2", apply: (*Manager).bindCiphertexts},
+	// The MAC is computed by Unlock once every migration has been applied.
+	{version: macVersion, apply: func(*Manager) error { return nil }},
+	{version: syncVersion, apply: (*Manager).assignUUIDs},
+	{version: deviceVersion, apply: (*Manager).assignDeviceID},
+	{version: identityVersion, apply: (*Manager).assignIdentity},
+	{version: membersVersion, apply: (*Manager).assignOwner},
+	{version: notesVersion, apply: (*Manager).encryptNoteBodies},
+	{version: attachmentsVersion, apply: (*Manager).bindAttachmentKeys},
+}
+
+// migrate applies, in order, every migration newer than the version of the unlocked vault.
+// The vault metadata is updated with the new version by the caller.
+func (m *Manager) migrate() error {
+	for _, mig := range migrations {
+		if !versionLess(m.meta.Version, mig.version) {
+			continue
+		}
+		if err := mig.apply(m); err != nil {
+			return fmt.Errorf("failed to migrate to version %s: %w", mig.version, err)
+		}
+		m.meta.Version = mig.version
+	}
+	return nil
+}
+
+// commitMigration saves the entries and the attachments rewritten by the migration to version in a single
+// transaction, together with the vault metadata claiming version and its MAC over entries, every entry of the vault
+// once migrated. Attachments are only authenticated from attachmentsVersion on, when attachments must hold every
+// attachment of the vault. An interrupted migration then leaves the vault as it was: still sealed with its previous
+// MAC, it can be unlocked and migrated again. Nothing is saved if nothing was rewritten, the version is saved by
+// Unlock.
+func (m *Manager) commitMigration(
+	version string, entries, migrated []*model.PasswordEntry, attachments []*model.Attachment,
+) error {
+	if len(migrated) == 0 && len(attachments) == 0 {
+		return nil
+	}
+	var tombstones []*model.Tombstone
+	if !versionLess(version, syncVersion) {
+		var err error
+		if tombstones, err = m.vault.ListTombstones(); err != nil {
+			return fmt.Errorf("failed to list tombstones: %w", err)
+		}
+	}
+
+	oldMeta := *m.meta
+	m.meta.Version = version
+	authenticated := attachments
+	if versionLess(version, attachmentsVersion) {
+		authenticated = nil
+	}
+	mac, err := m.computeMAC(entries, authenticated, tombstones)
+	if err != nil {
+		*m.meta = oldMeta
+		return err
+	}
+	m.meta.MAC = hex.EncodeToString(mac)
+	if err = m.vault.SaveMigration(migrated, attachments, m.meta); err != nil {
+		*m.meta = oldMeta
+		return fmt.Errorf("failed to save the migrated entries: %w", err)
+	}
+	return nil
+}
+
+// bindCiphertexts assigns an ID to the vault and re-encrypts every password created before version 0.0.2,
+// binding it to its entry through the additional authenticated data.
+func (m *Manager) bindCiphertexts() error {
+	if m.meta.VaultID == "" {
+		vaultID, err := newUUID()
+		if err != nil {
+			return fmt.Errorf("failed to generate vault ID: %w", err)
+		}
+		m.meta.VaultID = vaultID
+		// Persist the vault ID right away: re-encrypted entries can't be read without it,
+		// and an interrupted migration resumes from the entries still in the legacy format.
+		if err = m.vault.SaveVaultMetadata(m.meta); err != nil {
+			return fmt.Errorf("failed to save vault metadata: %w", err)
+		}
+	}
+
+	entries, err := m.vault.ListPasswordEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list password entries: %w", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Password, ciphertextV1Prefix) {
+			continue
+		}
+		password, err := m.decryptLegacy(entry.Password)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt password for service %s: %w", entry.Service, err)
+		}
+		entry.Password, err = m.encryptField(entry.Service, passwordField, password)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt password for service %s: %w", entry.Service, err)
+		}
+		if err = m.vault.SavePasswordEntry(entry); err != nil {
+			return fmt.Errorf("failed to save password entry for service %s: %w", entry.Service, err)
+		}
+	}
+	return nil
+}
+
+// assignUUIDs assigns a UUID to every entry created before version 0.0.4, so that it can be merged with other vaults.
+func (m *Manager) assignUUIDs() error {
+	entries, err := m.vault.ListPasswordEntries()
+	if err != nil {
+		return fmt.Errorf("failed to list password entries: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.UUID != "" {
+			continue
+		}
+		if entry.UUID, err = newUUID(); err != nil {
+			return fmt.Errorf("failed to generate UUID for service %s: %w", entry.Service, err)
+		}
+		if err = m.vault.SavePasswordEntry(entry); err != nil {
+			return fmt.Errorf("failed to save password entry for service %s: %w", entry.Service, err)
+		}
+	}
+	return nil
+}
+
+// assignDeviceID assigns a device ID to vaults created before version 0.0.5.
+// The metadata is saved by the caller once every migration has been applied.
+func (m *Manager) assignDeviceID() error {
+	if m.meta.DeviceID != "" {
+		return nil
+	}
+	deviceID, err := newUUID()
+	if err != nil {
+		return fmt.Errorf("failed to generate device ID: %w", err)
+	}
+	m.meta.DeviceID = deviceID
+	return nil
+}
+
+// versionLess reports whether the dot-separated version a is older than b.
+// Missing or non-numeric components count as zero.
+func versionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range max(len(as), len(bs)) {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// newUUID generates a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,233 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+const (
+	// mirrorKeyInfo is the HKDF info used to derive the mirror keys from the master key.
+	mirrorKeyInfo = "psst-vault-mirror"
+
+	// MirrorVaultFile is the name of the mirror file identifying the vault the mirror belongs to.
+	MirrorVaultFile = "VAULT"
+	// MirrorEntriesDir is the directory of the mirror files of the entries, named after their UUID.
+	MirrorEntriesDir = "entries"
+	// MirrorDeletedDir is the directory of the mirror files of the tombstones, named after their UUID.
+	MirrorDeletedDir = "deleted"
+
+	mirrorVaultHeader     = "psst-vault 1"
+	mirrorEntryHeader     = "psst-entry 1"
+	mirrorTombstoneHeader = "psst-tombstone 1"
+)
+
+// ErrMirrorVault is returned by ReadMirrorFiles when the mirror files belong to another vault.
+var ErrMirrorVault = errors.New("the mirror belongs to another vault")
+
+// Mirror keeps a copy of the vault outside of the database, e.g. in a git repository.
+type Mirror interface {
+	// Update replaces the content of the mirror with files, keyed by their slash-separated path,
+	// recording the change with message.
+	Update(files map[string][]byte, message string) error
+}
+
+// mirrorEntry is a password entry mirrored with its UUID and the last time it was merged, which aren't part of the
+// JSON encoding of model.PasswordEntry.
+type mirrorEntry struct {
+	SyncedAt time.Time `json:"synced_at"`
+	UUID     string    `json:"uuid"`
+	model.PasswordEntry
+}
+
+// SetMirror sets the mirror updated after every change to the vault entries. A nil mirror disables mirroring.
+func (m *Manager) SetMirror(mirror Mirror) {
+	m.mirror = mirror
+}
+
+// updateMirror updates the mirror, if any, after a change to the vault entries.
+// The vault is already changed at this point, so a failure is only logged: the mirror catches up on the next change.
+func (m *Manager) updateMirror(message string) {
+	if m.mirror == nil {
+		return
+	}
+	files, err := m.MirrorFiles()
+	if err == nil {
+		err = m.mirror.Update(files, message)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to update the vault mirror: %s\n", err)
+	}
+}
+
+// MirrorFiles returns the content of the unlocked vault as mirror files, keyed by their slash-separated path:
+// a file identifying the vault and one file per entry and tombstone, named after its UUID.
+// Each entry and tombstone is sealed with a key derived from the master key. Sealing is deterministic, so that
+// unchanged entries produce the same files.
+func (m *Manager) MirrorFiles() (map[string][]byte, error) {
+	if !m.isUnlocked {
+		return nil, errors.New("vault is locked, please unlock the vault first")
+	}
+	entries, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	tombstones, err := m.Tombstones()
+	if err != nil {
+		return nil, err
+	}
+	aead, nonceKey, err := m.mirrorKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{
+		MirrorVaultFile: []byte(mirrorVaultHeader + "\n" + m.meta.VaultID + "\n"),
+	}
+	for _, entry := range entries {
+		e := mirrorEntry{UUID: entry.UUID, SyncedAt: entry.SyncedAt, PasswordEntry: *entry}
+		// IDs are local to each vault.
+		e.ID = 0
+		name := path.Join(MirrorEntriesDir, entry.UUID)
+		if files[name], err = m.sealMirrorFile(aead, nonceKey, name, mirrorEntryHeader, e); err != nil {
+			return nil, err
+		}
+	}
+	for _, tombstone := range tombstones {
+		name := path.Join(MirrorDeletedDir, tombstone.UUID)
+		if files[name], err = m.sealMirrorFile(aead, nonceKey, name, mirrorTombstoneHeader, tombstone); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// ReadMirrorFiles opens mirror files produced by MirrorFiles for a copy of the unlocked vault,
+// returning the entries and tombstones they contain. Files outside of the mirror layout are ignored.
+// If the files belong to another vault, an error wrapping ErrMirrorVault is returned.
+func (m *Manager) ReadMirrorFiles(files map[string][]byte) ([]*model.PasswordEntry, []*model.Tombstone, error) {
+	if !m.isUnlocked {
+		return nil, nil, errors.New("vault is locked, please unlock the vault first")
+	}
+	lines := strings.Split(string(files[MirrorVaultFile]), "\n")
+	if len(lines) < 2 || lines[0] != mirrorVaultHeader {
+		return nil, nil, fmt.Errorf("invalid mirror: missing or malformed %s file", MirrorVaultFile)
+	}
+	if lines[1] != m.meta.VaultID {
+		return nil, nil, ErrMirrorVault
+	}
+	aead, _, err := m.mirrorKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []*model.PasswordEntry
+	var tombstones []*model.Tombstone
+	for name, content := range files {
+		switch dir, uuid := path.Split(name); dir {
+		case MirrorEntriesDir + "/":
+			var e mirrorEntry
+			if err = m.openMirrorFile(aead, name, mirrorEntryHeader, content, &e); err != nil {
+				return nil, nil, err
+			}
+			if e.UUID != uuid || e.Service == "" {
+				return nil, nil, fmt.Errorf("invalid mirror file %s: UUID or service mismatch", name)
+			}
+			entry := e.PasswordEntry
+			entry.UUID, entry.SyncedAt, entry.ID = e.UUID, e.SyncedAt, 0
+			entries = append(entries, &entry)
+		case MirrorDeletedDir + "/":
+			var tombstone model.Tombstone
+			if err = m.openMirrorFile(aead, name, mirrorTombstoneHeader, content, &tombstone); err != nil {
+				return nil, nil, err
+			}
+			if tombstone.UUID != uuid {
+				return nil, nil, fmt.Errorf("invalid mirror file %s: UUID mismatch", name)
+			}
+			tombstones = append(tombstones, &tombstone)
+		}
+	}
+	return entries, tombstones, nil
+}
+
+// sealMirrorFile encodes v as JSON and seals it as the mirror file name, made of the header line and the
+// base64 encoded ciphertext line. The nonce is derived from the file name and its content, so that the same content
+// always produces the same file while different contents never share a nonce.
+func (m *Manager) sealMirrorFile(aead cipher.AEAD, nonceKey []byte, name, header string, v any) ([]byte, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode mirror file %s: %w", name, err)
+	}
+	aad := m.mirrorAdditionalData(name)
+	mac := hmac.New(sha256.New, nonceKey)
+	mac.Write(aad)
+	mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+
+	ciphertext := aead.Seal(nonce, nonce, plaintext, aad)
+	return []byte(header + "\n" + base64.StdEncoding.EncodeToString(ciphertext) + "\n"), nil
+}
+
+// openMirrorFile opens the mirror file name sealed by sealMirrorFile and decodes its content into v.
+func (m *Manager) openMirrorFile(aead cipher.AEAD, name, header string, content []byte, v any) error {
+	lines := strings.Split(string(content), "\n")
+	if len(lines) < 2 || lines[0] != header {
+		return fmt.Errorf("invalid mirror file %s: unexpected header", name)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(ciphertext) < aead.NonceSize() {
+		return fmt.Errorf("invalid mirror file %s: malformed ciphertext", name)
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, ciphertext[aead.NonceSize():], m.mirrorAdditionalData(name))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt mirror file %s: %w", name, err)
+	}
+	if err = json.Unmarshal(plaintext, v); err != nil {
+		return fmt.Errorf("failed to decode mirror file %s: %w", name, err)
+	}
+	return nil
+}
+
+// mirrorKeys derives from the master key the AES-GCM cipher sealing the mirror files and the key deriving
+// their nonces.
+func (m *Manager) mirrorKeys() (cipher.AEAD, []byte, error) {
+	keys := make([]byte, 2*sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, m.masterKey, nil, []byte(mirrorKeyInfo)), keys); err != nil {
+		return nil, nil, fmt.Errorf("failed to derive mirror keys: %w", err)
+	}
+	block, err := aes.NewCipher(keys[:sha256.Size])
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, keys[sha256.Size:], nil
+}
+
+// mirrorAdditionalData binds a mirror file to the vault and to its name, so that it can't be moved to another file.
+func (m *Manager) mirrorAdditionalData(name string) []byte {
+	var aad []byte
+	for _, part := range []string{mirrorKeyInfo, m.meta.VaultID, name} {
+		aad = binary.BigEndian.AppendUint32(aad, uint32(len(part)))
+		aad = append(aad, part...)
+	}
+	return aad
+}
@@ -0,0 +1,41 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/otp"
+)
+
+// ErrNoOTP is returned when generating a code for an entry without an OTP secret.
+var ErrNoOTP = errors.New("entry has no OTP secret")
+
+// OTPCode generates the code of the OTP secret of the entry associated with the service at time now, and how long
+// it remains valid. HOTP codes remain valid until used: the counter of the secret is incremented and saved instead,
+// updating the modification time of the entry. Merging vaults keeps the highest counter, see merge.Vaults.
+func (m *Manager) OTPCode(service string, now time.Time) (string, time.Duration, error) {
+	entry, err := m.Read(service)
+	if err != nil {
+		return "", 0, err
+	}
+	if entry.OTP == "" {
+		return "", 0, ErrNoOTP
+	}
+	key, err := otp.Parse(entry.OTP)
+	if err != nil {
+		return "", 0, err
+	}
+	if key.Type == otp.TypeTOTP {
+		code, remaining := key.TOTP(now)
+		return code, remaining, nil
+	}
+
+	code := key.HOTP(key.Counter)
+	key.Counter++
+	entry.OTP = key.URI()
+	if err = m.Update(entry); err != nil {
+		return "", 0, fmt.Errorf("failed to save the HOTP counter: %w", err)
+	}
+	return code, 0, nil
+}
@@ -0,0 +1,148 @@
+package vault
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/fuzzy"
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// Weights of the entry fields matched by Search: a match in the service counts more than one in the notes.
+const (
+	serviceWeight  = 3
+	usernameWeight = 2
+	tagWeight      = 2
+	urlWeight      = 1
+	notesWeight    = 1
+)
+
+// SearchOptions configures Search.
+type SearchOptions struct {
+	// Tags only returns the entries with all the tags, compared regardless of case.
+	Tags []string
+	// Limit is the maximum number of results, all of them if zero.
+	Limit int
+	// Notes also matches the query against the notes of the entries.
+	Notes bool
+}
+
+// SearchResult is an entry matching a search.
+type SearchResult struct {
+	Entry *model.PasswordEntry
+	// Score is how well the entry matches, including the bonus of recently used entries.
+	Score int
+}
+
+// Search retrieves the entries matching the query, best first. Every word of the query must fuzzy match the service,
+// the username, the URL or a tag of an entry, see fuzzy.Score, or its notes if opts.Notes is set. Entries rank by
+// score, with a bonus for those used in the last month, then by the last time they were used.
+//
+// Entries are searched in memory once decrypted, rather than through an index of the database, so that searching
+// doesn't depend on which fields are stored encrypted. An empty query returns all the entries matching opts, the
+// most recently used first.
+func (m *Manager) Search(query string, opts SearchOptions) ([]*SearchResult, error) {
+	entries, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := strings.Fields(query)
+	now := time.Now()
+	var results []*SearchResult
+	for _, entry := range entries {
+		if !hasTags(entry, opts.Tags) {
+			continue
+		}
+		score, ok := matchEntry(entry, terms, opts.Notes)
+		if !ok {
+			continue
+		}
+		results = append(results, &SearchResult{Entry: entry, Score: score + recencyBonus(entry.LastUsedAt, now)})
+	}
+
+	slices.SortStableFunc(results, func(a, b *SearchResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		if c := b.Entry.LastUsedAt.Compare(a.Entry.LastUsedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Entry.Service, b.Entry.Service)
+	})
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	return results, nil
+}
+
+// matchEntry returns the sum of the best weighted score of every term among the fields of the entry, and whether
+// every term matches.
+func matchEntry(entry *model.PasswordEntry, terms []string, notes bool) (int, bool) {
+	type weighted struct {
+		text   string
+		weight int
+	}
+	fields := []weighted{
+		{entry.Service, serviceWeight},
+		{entry.Username, usernameWeight},
+		{trimURL(entry.URL), urlWeight},
+	}
+	for _, tag := range entry.Tags {
+		fields = append(fields, weighted{tag, tagWeight})
+	}
+	if notes {
+		fields = append(fields, weighted{entry.Notes, notesWeight})
+	}
+
+	var total int
+	for _, term := range terms {
+		best := -1
+		for _, field := range fields {
+			if score, ok := fuzzy.Score(term, field.text); ok {
+				best = max(best, score*field.weight)
+			}
+		}
+		if best < 0 {
+			return 0, false
+		}
+		total += best
+	}
+	return total, true
+}
+
+// trimURL trims the scheme and the www. prefix of a URL, which would otherwise match most queries.
+func trimURL(u string) string {
+	if _, rest, ok := strings.Cut(u, "://"); ok {
+		u = rest
+	}
+	return strings.TrimPrefix(u, "www.")
+}
+
+// hasTags reports whether the entry has all the tags, compared regardless of case.
+func hasTags(entry *model.PasswordEntry, tags []string) bool {
+	for _, tag := range tags {
+		if !slices.ContainsFunc(entry.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// recencyBonus returns the bonus score of an entry last used at lastUsed: the more recently used, the higher.
+func recencyBonus(lastUsed, now time.Time) int {
+	if lastUsed.IsZero() {
+		return 0
+	}
+	switch age := now.Sub(lastUsed); {
+	case age <= 24*time.Hour:
+		return 30
+	case age <= 7*24*time.Hour:
+		return 20
+	case age <= 30*24*time.Hour:
+		return 10
+	}
+	return 0
+}
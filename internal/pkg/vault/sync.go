@@ -0,0 +1,118 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CanobbioE/please-safely-store-this/internal/pkg/model"
+)
+
+// syncVersion is the first vault version whose entries have a UUID and whose deletions are recorded as tombstones,
+// so that the vault can be merged with other vaults.
+const syncVersion = "0.0.4"
+
+// deviceVersion is the first vault version with a device ID, telling apart the copies of a vault.
+const deviceVersion = "0.0.5"
+
+// DeviceID returns the device ID of the unlocked vault, identifying it when merged into other vaults.
+func (m *Manager) DeviceID() string {
+	if !m.isUnlocked {
+		return ""
+	}
+	return m.meta.DeviceID
+}
+
+// ResetDeviceID assigns a new device ID to the unlocked vault. It must be called on a copy of a vault, so that the
+// copy and the original vault are told apart when merged.
+func (m *Manager) ResetDeviceID() error {
+	if !m.isUnlocked {
+		return errors.New("vault is locked, please unlock the vault first")
+	}
+
+	deviceID, err := newUUID()
+	if err != nil {
+		return fmt.Errorf("failed to generate device ID: %w", err)
+	}
+	m.meta.DeviceID = deviceID
+	if err = m.seal(); err != nil {
+		return fmt.Errorf("failed to update vault MAC: %w", err)
+	}
+	return nil
+}
+
+// Tombstones retrieves the tombstones of the entries deleted from the vault.
+func (m *Manager) Tombstones() ([]*model.Tombstone, error) {
+	if !m.isUnlocked {
+		return nil, errors.New("vault is locked, please unlock the vault first")
+	}
+
+	tombstones, err := m.vault.ListTombstones()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tombstones: %w", err)
+	}
+	return tombstones, nil
+}
+
+// LastSync returns the last time the vault with the device ID peerID was merged into this vault.
+// The zero time is returned if it was never merged.
+func (m *Manager) LastSync(peerID string) (time.Time, error) {
+	if !m.isUnlocked {
+		return time.Time{}, errors.New("vault is locked, please unlock the vault first")
+	}
+
+	lastSync, err := m.vault.GetLastSync(peerID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last sync: %w", err)
+	}
+	return lastSync, nil
+}
+
+// ApplySync applies the outcome of merging the vault with the device ID peerID into this vault, in a single
+// transaction: the entries with the given tombstones are deleted and the entries, coming from the other vault,
+// are added or replace the entries with the same UUID, preserving their timestamps.
+// The merge is recorded as the last sync with peerID, and the saved entries and tombstones are stamped with it as
+// their SyncedAt: changes relayed by the other vault are then seen as changes of this vault by the vaults merging it.
+//
+// The entries are validated like the ones saved by the other operations of the vault. If any is invalid nothing is
+// merged, and the returned error lists every rejected entry.
+func (m *Manager) ApplySync(entries []*model.PasswordEntry, tombstones []*model.Tombstone, peerID string) error {
+	if !m.isUnlocked {
+		return errors.New("vault is locked, please unlock the vault first")
+	}
+	var rejected []error
+	for _, entry := range entries {
+		if entry.UUID == "" {
+			return fmt.Errorf("missing UUID for service %s", entry.Service)
+		}
+		if err := validateEntry(entry); err != nil {
+			rejected = append(rejected, fmt.Errorf("service %s: %w", entry.Service, err))
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("rejected %d invalid entries, nothing was merged: %w", len(rejected), errors.Join(rejected...))
+	}
+
+	now := time.Now().UTC()
+	encrypted, err := m.encryptEntries(entries)
+	if err != nil {
+		return err
+	}
+	for _, entry := range encrypted {
+		entry.SyncedAt = now
+	}
+	stamped := make([]*model.Tombstone, len(tombstones))
+	for i, tombstone := range tombstones {
+		t := *tombstone
+		t.SyncedAt = now
+		stamped[i] = &t
+	}
+	if err = m.vault.SaveSync(encrypted, stamped, peerID, now); err != nil {
+		return fmt.Errorf("failed to save merged entries: %w", err)
+	}
+	if err = m.seal(); err != nil {
+		return fmt.Errorf("failed to update vault MAC: %w", err)
+	}
+	m.updateMirror("Merge the changes of another vault")
+	return nil
+}
@@ -2,14 +2,27 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/CanobbioE/please-safely-store-this/cmd/psst"
 )
 
 func main() {
-	if err := psst.RootCmd().Execute(); err != nil {
+	root := psst.RootCmd()
+	// Run through a docker-credential-psst link, psst is the credential helper docker expects.
+	if name := filepath.Base(os.Args[0]); strings.TrimSuffix(name, filepath.Ext(name)) == psst.DockerCredentialHelper {
+		root.SetArgs(append([]string{"docker-credential"}, os.Args[1:]...))
+	}
+	if err := root.Execute(); err != nil {
+		// The exit code of a command run by psst is passed on, it already reported its own errors.
+		var exitErr *psst.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}